@@ -0,0 +1,149 @@
+/*
+ppdb-inspect decodes the pages of relation fork and prints them (see storage/inspect).
+
+The pages can be read from the segment files directly, or via disk manager from the data directory.
+Via data directory, the compressed and encrypted relations can be inspected,
+but the cluster must be stopped because the data directory is locked.
+
+usage:
+
+	ppdb-inspect [-page <page id>] [-items=false] <segment file>...
+	ppdb-inspect -D <data directory> [-tablespace <oid>] [-database <oid>] -rel <relfilenumber> [-fork main|fsm|vm] [-key-file <path>] [-pages-per-segment <n>] [-page <page id>] [-items=false]
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/disk"
+	"github.com/HayatoShiba/ppdb/storage/encryption"
+	"github.com/HayatoShiba/ppdb/storage/inspect"
+	"github.com/pkg/errors"
+)
+
+// config is the command line options
+type config struct {
+	dataDir         string
+	tablespace      uint
+	database        uint
+	rel             uint
+	fork            string
+	keyFile         string
+	pagesPerSegment int
+	page            int
+	items           bool
+}
+
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.dataDir, "D", "", "data directory. if not given, the segment files in arguments are inspected")
+	flag.UintVar(&cfg.tablespace, "tablespace", 0, "tablespace oid of the relation")
+	flag.UintVar(&cfg.database, "database", 0, "database oid of the relation")
+	flag.UintVar(&cfg.rel, "rel", 0, "relfilenumber of the relation")
+	flag.StringVar(&cfg.fork, "fork", "main", "fork of the relation: main, fsm or vm")
+	flag.StringVar(&cfg.keyFile, "key-file", "", "the file of key encryption key when the cluster is encrypted")
+	flag.IntVar(&cfg.pagesPerSegment, "pages-per-segment", 0, "the number of pages per segment. 0 means default")
+	flag.IntVar(&cfg.page, "page", -1, "page id to inspect. all pages are inspected by default")
+	flag.BoolVar(&cfg.items, "items", true, "print hex dumps of items")
+	flag.Parse()
+
+	if err := run(cfg, flag.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "ppdb-inspect: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run inspects the pages and prints them
+func run(cfg config, files []string) error {
+	var infos []*inspect.PageInfo
+	if cfg.dataDir != "" {
+		if len(files) != 0 {
+			return errors.New("the segment files cannot be given with data directory")
+		}
+		var err error
+		infos, err = inspectDataDir(cfg)
+		if err != nil {
+			return errors.Wrap(err, "inspectDataDir failed")
+		}
+	} else {
+		if len(files) == 0 {
+			return errors.New("data directory or segment files must be given")
+		}
+		for _, file := range files {
+			fi, err := inspect.InspectFile(file)
+			if err != nil {
+				return errors.Wrapf(err, "inspect.InspectFile failed: %s", file)
+			}
+			infos = append(infos, fi...)
+		}
+	}
+	for _, pi := range infos {
+		if cfg.page >= 0 && int(pi.PageID) != cfg.page {
+			continue
+		}
+		if err := inspect.Print(os.Stdout, pi, cfg.items); err != nil {
+			return errors.Wrap(err, "inspect.Print failed")
+		}
+	}
+	return nil
+}
+
+// inspectDataDir inspects the relation fork via disk manager
+func inspectDataDir(cfg config) ([]*inspect.PageInfo, error) {
+	forkNum, err := parseFork(cfg.fork)
+	if err != nil {
+		return nil, errors.Wrap(err, "parseFork failed")
+	}
+	if _, err := os.Stat(cfg.dataDir); err != nil {
+		return nil, errors.Wrap(err, "os.Stat failed")
+	}
+	dd, err := common.OpenDataDir(cfg.dataDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "common.OpenDataDir failed")
+	}
+	defer dd.Close()
+
+	opts := disk.Options{PagesPerSegment: cfg.pagesPerSegment}
+	enabled, err := encryption.Enabled(dd)
+	if err != nil {
+		return nil, errors.Wrap(err, "encryption.Enabled failed")
+	}
+	if enabled {
+		if cfg.keyFile == "" {
+			return nil, errors.New("the cluster is encrypted. key file must be given")
+		}
+		opts.Cipher, err = encryption.Open(dd, encryption.FileKeyProvider(cfg.keyFile))
+		if err != nil {
+			return nil, errors.Wrap(err, "encryption.Open failed")
+		}
+	}
+	dm, err := disk.NewManagerWithOptions(dd, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "disk.NewManagerWithOptions failed")
+	}
+	defer dm.Close()
+
+	rel := common.Relation{
+		Tablespace:    common.Tablespace(cfg.tablespace),
+		Database:      common.Database(cfg.database),
+		RelFileNumber: common.RelFileNumber(cfg.rel),
+	}
+	infos, err := inspect.InspectFork(dm, rel, forkNum)
+	if err != nil {
+		return nil, errors.Wrap(err, "inspect.InspectFork failed")
+	}
+	return infos, nil
+}
+
+// parseFork parses the name of fork
+func parseFork(name string) (disk.ForkNumber, error) {
+	for _, f := range []disk.ForkNumber{disk.ForkNumberMain, disk.ForkNumberFSM, disk.ForkNumberVM} {
+		if f.String() == name {
+			return f, nil
+		}
+	}
+	return 0, errors.Errorf("unknown fork: %s", name)
+}
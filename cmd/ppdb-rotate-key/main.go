@@ -0,0 +1,58 @@
+/*
+ppdb-rotate-key rotates the key encryption key (KEK) of the cluster offline.
+
+The data encryption key stored in the data directory is re-wrapped with the new KEK,
+so the relation files and clog files are not rewritten (see storage/encryption).
+The cluster must be stopped: the data directory is locked during rotation, so this fails while the cluster is running.
+
+usage:
+
+	ppdb-rotate-key -D <data directory> -old-key-file <path> -new-key-file <path>
+
+the key files have the 32 bytes key as hex string.
+this is similar to pg_alterckey in the transparent data encryption proposals for postgres.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/encryption"
+	"github.com/pkg/errors"
+)
+
+func main() {
+	dataDir := flag.String("D", "", "data directory")
+	oldKeyFile := flag.String("old-key-file", "", "the file of the current key encryption key")
+	newKeyFile := flag.String("new-key-file", "", "the file of the new key encryption key")
+	flag.Parse()
+	if *dataDir == "" || *oldKeyFile == "" || *newKeyFile == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(*dataDir, *oldKeyFile, *newKeyFile); err != nil {
+		fmt.Fprintf(os.Stderr, "ppdb-rotate-key: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("the key encryption key has been rotated")
+}
+
+// run rotates the key of the cluster in the data directory
+func run(dataDir, oldKeyFile, newKeyFile string) error {
+	if _, err := os.Stat(dataDir); err != nil {
+		return errors.Wrap(err, "os.Stat failed")
+	}
+	// the lock of data directory guarantees that the cluster is stopped
+	dd, err := common.OpenDataDir(dataDir)
+	if err != nil {
+		return errors.Wrap(err, "common.OpenDataDir failed")
+	}
+	defer dd.Close()
+	if err := encryption.RotateKey(dd, encryption.FileKeyProvider(oldKeyFile), encryption.FileKeyProvider(newKeyFile)); err != nil {
+		return errors.Wrap(err, "encryption.RotateKey failed")
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+/*
+Data directory is the directory where all files of the database cluster are stored.
+
+the layout under data directory (cited from postgres) is described below
+  - base/database oid: relation files (see /storage/disk)
+  - pg_tblspc: links to tablespace locations (see /storage/disk/tablespace.go)
+  - pg_xact: clog files (see /transaction/clog)
+  - pg_cryptokeys: the wrapped key for encryption (see /storage/encryption)
+  - pg_wal: wal files (not implemented yet)
+  - ppdb.pid: lock file
+
+DataDir is the handle of data directory. the managers receive it instead of using the fixed path,
+so multiple clusters can be opened in one process (ex: parallel tests).
+
+while the handle is open, the lock file is locked so that the other process cannot open the same cluster.
+otherwise two processes write the same files without coordination and the cluster is corrupted.
+postgres writes the pid into postmaster.pid and checks whether the process is still alive.
+ppdb uses advisory file lock (flock) instead, so the lock is released by OS even when the process crashes.
+the pid is written into the lock file just for information. the lock file is not removed on close (see Close()).
+see CreateLockFile() https://github.com/postgres/postgres/blob/20432f8731404d2cef2a155144aca5ab3ae98e95/src/backend/utils/init/miscinit.c
+*/
+package common
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// lockFileName is the name of lock file under data directory
+const lockFileName = "ppdb.pid"
+
+// DataDir is the handle of data directory
+type DataDir struct {
+	path string
+	// lockFile is locked while the handle is open
+	lockFile *os.File
+}
+
+// OpenDataDir opens the data directory and locks it
+// if the directory doesn't exist, it is created.
+// if the directory is locked by other handle (in this process or other process), return error
+func OpenDataDir(path string) (*DataDir, error) {
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, errors.Wrap(err, "os.MkdirAll failed")
+	}
+	lockPath := filepath.Join(path, lockFileName)
+	f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "os.OpenFile failed")
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "data directory is used by other process: %s", path)
+	}
+	// the lock file is written only after the lock is acquired not to overwrite the pid of other process
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "Truncate failed")
+	}
+	if _, err := f.WriteAt([]byte(fmt.Sprintf("%d\n", os.Getpid())), 0); err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "WriteAt failed")
+	}
+	return &DataDir{path: path, lockFile: f}, nil
+}
+
+// Path returns the path of data directory
+func (dd *DataDir) Path() string {
+	return dd.path
+}
+
+// Join returns the path under data directory
+func (dd *DataDir) Join(elem ...string) string {
+	return filepath.Join(append([]string{dd.path}, elem...)...)
+}
+
+// Close clears the pid in the lock file and releases the lock
+// the lock file itself is kept. if it was removed, other process which has opened it and waits for the lock
+// would lock the removed file, while another process creates and locks the new one. then both open the cluster.
+// the managers using the data directory must be closed before this
+func (dd *DataDir) Close() error {
+	if dd.lockFile == nil {
+		return nil
+	}
+	// the pid is cleared while holding the lock not to clear the pid of other process
+	if err := dd.lockFile.Truncate(0); err != nil {
+		return errors.Wrap(err, "Truncate failed")
+	}
+	// closing the file releases the lock
+	if err := dd.lockFile.Close(); err != nil {
+		return errors.Wrap(err, "Close failed")
+	}
+	dd.lockFile = nil
+	return nil
+}
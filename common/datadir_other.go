@@ -0,0 +1,11 @@
+//go:build !unix
+
+package common
+
+import "os"
+
+// lockFile does nothing because flock is not available
+// the data directory is not protected from the other process
+func lockFile(f *os.File) error {
+	return nil
+}
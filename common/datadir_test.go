@@ -0,0 +1,47 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenDataDir(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data")
+	dd, err := OpenDataDir(path)
+	assert.Nil(t, err)
+	assert.Equal(t, path, dd.Path())
+	assert.Equal(t, filepath.Join(path, "base", "database"), dd.Join("base", "database"))
+
+	// the lock file has the pid
+	b, err := os.ReadFile(filepath.Join(path, lockFileName))
+	assert.Nil(t, err)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	assert.Nil(t, err)
+	assert.Equal(t, os.Getpid(), pid)
+
+	// the data directory is locked
+	_, err = OpenDataDir(path)
+	assert.NotNil(t, err)
+	// the pid is not overwritten by the failed open
+	b2, err := os.ReadFile(filepath.Join(path, lockFileName))
+	assert.Nil(t, err)
+	assert.Equal(t, b, b2)
+
+	// the data directory can be opened again after closed
+	// the lock file is kept, but the pid is cleared
+	err = dd.Close()
+	assert.Nil(t, err)
+	b3, err := os.ReadFile(filepath.Join(path, lockFileName))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(b3))
+	dd2, err := OpenDataDir(path)
+	assert.Nil(t, err)
+	assert.Nil(t, dd2.Close())
+	// close twice does nothing
+	assert.Nil(t, dd2.Close())
+}
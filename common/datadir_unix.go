@@ -0,0 +1,14 @@
+//go:build unix
+
+package common
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile locks the file exclusively without blocking
+// the lock belongs to the open file, so the other open of the same file (even in the same process) fails to lock
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
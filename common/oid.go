@@ -1,14 +1,55 @@
 package common
 
+import "fmt"
+
 // oid is object id
 // in ppdb, this is expected to be used as table identifier
 // see https://github.com/postgres/postgres/blob/2f47715cc8649f854b1df28dfc338af9801db217/src/include/postgres_ext.h#L28-L31
 type oid uint32
 
-// Relation is table oid
+// Tablespace is tablespace oid
+// tablespace is the directory where relation files are located. this lets us place relations on different disks
+// (ex: hot tables on fast disk and archival tables on bulk disk)
+// see https://www.postgresql.org/docs/current/manage-ag-tablespaces.html
+type Tablespace oid
+
+// DefaultTablespace is the tablespace where relation files are located when tablespace is not specified.
+// in postgres, the oid of default tablespace (pg_default) is 1663,
+// but in ppdb zero value is used so that the relation without tablespace is located under base directory
+const DefaultTablespace Tablespace = 0
+
+// Database is database oid
+// one cluster (data directory) can have multiple databases
+// see https://www.postgresql.org/docs/current/manage-ag-overview.html
+type Database oid
+
+// DefaultDatabase is the database which is created when the cluster is initialized.
+// this is used as the template of new database by default (like template1 in postgres).
+// zero value is used so that the relation without database is located in this database
+const DefaultDatabase Database = 0
+
+// RelFileNumber is the number which identifies relation files in the database
+// in postgres, this is called relfilenode (or relfilenumber) and can differ from table oid after rewriting table.
+// ppdb doesn't rewrite table, so this is the same as table oid
+type RelFileNumber oid
+
+// Relation locates relation files (this is RelFileLocator in postgres)
 // table information is stored in system catalog (pg_class table)
 // the oid is uniquely allocated to each table when created
 // the logic to access table is described below
-// - get the table oid from pg_class table (the table is specified in sql)
-// - identify the file path with table oid
-type Relation oid
+// - get the table oid and tablespace from pg_class table (the table is specified in sql)
+// - identify the file path with tablespace, database and relfilenumber
+// see https://github.com/postgres/postgres/blob/20432f8731404d2cef2a155144aca5ab3ae98e95/src/include/storage/relfilelocator.h
+type Relation struct {
+	// Tablespace is the tablespace where the relation files are located
+	Tablespace Tablespace
+	// Database is the database which the relation belongs to
+	Database Database
+	// RelFileNumber identifies the relation files in the database
+	RelFileNumber RelFileNumber
+}
+
+// String returns the relation for logging
+func (r Relation) String() string {
+	return fmt.Sprintf("%d/%d/%d", r.Tablespace, r.Database, r.RelFileNumber)
+}
@@ -0,0 +1,16 @@
+package common
+
+import "testing"
+
+// TestingNewDataDir opens data directory under t.TempDir()
+// the data directory is closed when the test completes
+func TestingNewDataDir(t *testing.T) *DataDir {
+	dd, err := OpenDataDir(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenDataDir failed: %v", err)
+	}
+	t.Cleanup(func() {
+		dd.Close()
+	})
+	return dd
+}
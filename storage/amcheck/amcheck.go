@@ -0,0 +1,199 @@
+/*
+Package amcheck verifies the consistency of the pages of relation.
+This is like amcheck extension of postgres (verify_heapam()). see https://www.postgresql.org/docs/current/amcheck.html
+
+The main fork pages are checked as below:
+  - lower <= upper <= special (and the header and page size bound them)
+  - the extent of each slot stays inside the page
+  - the extents of the slots don't overlap each other
+  - the normal slot doesn't point into the page header or slot array
+
+fsm is checked against the free space of the main fork pages (see /storage/fsm/verify.go),
+and it can be rebuilt from the main fork pages (RebuildFSM).
+
+The violations are reported with their locations instead of stopping at the first one.
+The pages are read via shared buffer with shared content lock, so this can be executed while the relation is used.
+*/
+package amcheck
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/buffer"
+	"github.com/HayatoShiba/ppdb/storage/disk"
+	"github.com/HayatoShiba/ppdb/storage/fsm"
+	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/pkg/errors"
+)
+
+// Violation is the violation of the invariant found
+type Violation struct {
+	Fork   disk.ForkNumber
+	PageID page.PageID
+	// Index is the slot index (main fork) or node index (fsm fork). -1 when it is about the whole page
+	Index   int
+	Message string
+}
+
+// String returns the violation with its location
+func (v Violation) String() string {
+	if v.Index < 0 {
+		return fmt.Sprintf("%s page %d: %s", v.Fork, v.PageID, v.Message)
+	}
+	if v.Fork == disk.ForkNumberFSM {
+		return fmt.Sprintf("%s page %d node %d: %s", v.Fork, v.PageID, v.Index, v.Message)
+	}
+	return fmt.Sprintf("%s page %d slot %d: %s", v.Fork, v.PageID, v.Index, v.Message)
+}
+
+// extent is the range of the item within page
+type extent struct {
+	index  page.SlotIndex
+	offset int
+	size   int
+}
+
+// VerifyPage checks the main fork page and returns the violations found
+// the zero-filled page is valid because it is the page extended but not initialized yet
+func VerifyPage(pageID page.PageID, p page.PagePtr) []Violation {
+	if *p == [page.PageSize]byte{} {
+		return nil
+	}
+	var found []Violation
+	add := func(index int, format string, args ...interface{}) {
+		found = append(found, Violation{
+			Fork:    disk.ForkNumberMain,
+			PageID:  pageID,
+			Index:   index,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	lower := int(page.GetLowerOffset(p))
+	upper := int(page.GetUpperOffset(p))
+	special := int(page.GetSpecialSpaceOffset(p))
+	if lower < page.HeaderSize || lower > upper || upper > special || special > page.PageSize {
+		// the slots cannot be located, so the following checks are skipped
+		add(-1, "the offsets in page header are out of order: lower %d, upper %d, special %d", lower, upper, special)
+		return found
+	}
+
+	var extents []extent
+	nidx := page.GetNSlotIndex(p)
+	for i := page.FirstSlotIndex; nidx != page.InvalidSlotIndex && i <= nidx; i++ {
+		slot, err := page.GetSlot(p, i)
+		if err != nil {
+			add(int(i), "%v", err)
+			continue
+		}
+		// the redirected slot stores the slot index redirected to, not the extent of item
+		if page.IsUnused(slot) || page.IsRedirected(slot) {
+			continue
+		}
+		e := extent{index: i, offset: page.GetSlotItemOffset(slot), size: page.GetSlotItemSize(slot)}
+		// the dead slot may have no storage
+		if page.IsDead(slot) && e.size == 0 {
+			continue
+		}
+		if e.offset+e.size > page.PageSize {
+			add(int(i), "the item is out of page: offset %d, size %d", e.offset, e.size)
+			continue
+		}
+		if page.IsNormal(slot) && e.offset < lower {
+			add(int(i), "the item points into the slot array: offset %d, lower %d", e.offset, lower)
+			continue
+		}
+		extents = append(extents, e)
+	}
+
+	// sort the extents by offset and check the adjacent ones
+	sort.Slice(extents, func(i, j int) bool {
+		return extents[i].offset < extents[j].offset
+	})
+	for i := 1; i < len(extents); i++ {
+		prev, cur := extents[i-1], extents[i]
+		if prev.offset+prev.size > cur.offset {
+			add(int(cur.index), "the item overlaps the item of slot %d: offset %d, size %d", prev.index, cur.offset, cur.size)
+		}
+	}
+	return found
+}
+
+// freeSpace returns the free space of the page recorded in fsm
+// the page whose header is broken has no free space to be used
+func freeSpace(p page.PagePtr) int {
+	lower := page.GetLowerOffset(p)
+	upper := page.GetUpperOffset(p)
+	if lower > upper || int(upper) > page.PageSize {
+		return 0
+	}
+	return page.CalculateFreeSpace(p)
+}
+
+// readMainFork checks all pages of the main fork and returns the free space of each page
+func readMainFork(bm *buffer.Manager, rel common.Relation, verify bool) ([]Violation, []int, error) {
+	last, err := bm.GetNPageID(rel, disk.ForkNumberMain)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "GetNPageID failed")
+	}
+	if last == page.InvalidPageID {
+		return nil, nil, nil
+	}
+	var found []Violation
+	freeSpaces := make([]int, 0, int(last)+1)
+	p := page.NewPagePtr()
+	for pageID := page.FirstPageID; pageID <= last; pageID++ {
+		bufID, err := bm.ReadBuffer(rel, disk.ForkNumberMain, pageID)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "ReadBuffer failed")
+		}
+		// copy the page so that the content lock is held as short as possible
+		bm.AcquireContentLock(bufID, false)
+		*p = *bm.GetPage(bufID)
+		bm.ReleaseContentLock(bufID, false)
+		bm.ReleaseBuffer(bufID)
+
+		if verify {
+			found = append(found, VerifyPage(pageID, p)...)
+		}
+		freeSpaces = append(freeSpaces, freeSpace(p))
+	}
+	return found, freeSpaces, nil
+}
+
+// VerifyRelation checks all pages of the main fork and fsm of the relation, and returns the violations found
+func VerifyRelation(bm *buffer.Manager, rel common.Relation) ([]Violation, error) {
+	found, freeSpaces, err := readMainFork(bm, rel, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "readMainFork failed")
+	}
+	inconsistencies, err := fsm.Verify(bm, rel, freeSpaces)
+	if err != nil {
+		return nil, errors.Wrap(err, "fsm.Verify failed")
+	}
+	for _, inc := range inconsistencies {
+		found = append(found, Violation{
+			Fork:    disk.ForkNumberFSM,
+			PageID:  inc.PageID,
+			Index:   inc.Node,
+			Message: inc.Message,
+		})
+	}
+	return found, nil
+}
+
+// RebuildFSM rebuilds fsm of the relation from the free space of the main fork pages
+// the caller must prevent the other goroutines from updating the relation during rebuild,
+// otherwise the free space updated in the meantime is lost
+func RebuildFSM(bm *buffer.Manager, rel common.Relation) error {
+	_, freeSpaces, err := readMainFork(bm, rel, false)
+	if err != nil {
+		return errors.Wrap(err, "readMainFork failed")
+	}
+	if err := fsm.Rebuild(bm, rel, freeSpaces); err != nil {
+		return errors.Wrap(err, "fsm.Rebuild failed")
+	}
+	return nil
+}
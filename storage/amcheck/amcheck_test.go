@@ -0,0 +1,177 @@
+package amcheck
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/buffer"
+	"github.com/HayatoShiba/ppdb/storage/disk"
+	"github.com/HayatoShiba/ppdb/storage/fsm"
+	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/stretchr/testify/assert"
+)
+
+// testingPage returns the page which has the items
+func testingPage(t *testing.T, items ...string) page.PagePtr {
+	p := page.NewPagePtr()
+	page.InitializePage(p, 0)
+	for _, item := range items {
+		err := page.AddItem(p, page.ItemPtr(item), page.InvalidSlotIndex)
+		assert.Nil(t, err)
+	}
+	return p
+}
+
+// testingSetSlot overwrites the slot with the offset and size
+func testingSetSlot(t *testing.T, p page.PagePtr, idx page.SlotIndex, offset, size int) {
+	slot, err := page.GetSlot(p, idx)
+	assert.Nil(t, err)
+	v := uint32(offset)<<17 | uint32(1)<<15 | uint32(size)
+	slot[0], slot[1], slot[2], slot[3] = byte(v), byte(v>>8), byte(v>>16), byte(v>>24)
+	assert.Equal(t, offset, page.GetSlotItemOffset(slot))
+	assert.Equal(t, size, page.GetSlotItemSize(slot))
+	assert.True(t, page.IsNormal(slot))
+}
+
+func TestVerifyPage(t *testing.T) {
+	tests := []struct {
+		name string
+		// modify breaks the page which has 3 items
+		modify func(p page.PagePtr)
+		// indexes are the indexes of the violations expected
+		indexes []int
+	}{
+		{
+			name:   "valid page",
+			modify: func(p page.PagePtr) {},
+		},
+		{
+			name: "dead slot without storage",
+			modify: func(p page.PagePtr) {
+				testingSetSlot(t, p, 1, 0, 0)
+				slot, _ := page.GetSlot(p, 1)
+				page.SetDead(slot)
+			},
+		},
+		{
+			name: "unused slot is ignored",
+			modify: func(p page.PagePtr) {
+				// the extent of unused slot overlaps the item of slot 0
+				testingSetSlot(t, p, 1, page.PageSize-3, 3)
+				slot, _ := page.GetSlot(p, 1)
+				page.SetUnused(p, slot)
+			},
+		},
+		{
+			name: "lower is larger than upper",
+			modify: func(p page.PagePtr) {
+				page.SetLowerOffset(p, page.GetUpperOffset(p)+1)
+			},
+			indexes: []int{-1},
+		},
+		{
+			name: "upper is larger than special",
+			modify: func(p page.PagePtr) {
+				page.SetSpecialSpaceOffset(p, page.GetUpperOffset(p)-1)
+			},
+			indexes: []int{-1},
+		},
+		{
+			name: "item is out of page",
+			modify: func(p page.PagePtr) {
+				testingSetSlot(t, p, 2, page.PageSize-2, 5)
+			},
+			indexes: []int{2},
+		},
+		{
+			name: "item points into slot array",
+			modify: func(p page.PagePtr) {
+				testingSetSlot(t, p, 1, page.HeaderSize, 4)
+			},
+			indexes: []int{1},
+		},
+		{
+			name: "items overlap",
+			modify: func(p page.PagePtr) {
+				// the item of slot 0 is at the end of page
+				testingSetSlot(t, p, 2, page.PageSize-4, 2)
+			},
+			indexes: []int{2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := testingPage(t, "first", "second", "third")
+			tt.modify(p)
+			found := VerifyPage(page.PageID(3), p)
+			if !assert.Equal(t, len(tt.indexes), len(found), "%v", found) {
+				return
+			}
+			for i, v := range found {
+				assert.Equal(t, tt.indexes[i], v.Index)
+				assert.Equal(t, page.PageID(3), v.PageID)
+				assert.Equal(t, disk.ForkNumberMain, v.Fork)
+			}
+		})
+	}
+	t.Run("zero-filled page", func(t *testing.T) {
+		assert.Nil(t, VerifyPage(page.FirstPageID, page.NewPagePtr()))
+	})
+}
+
+// testingWritePages writes the pages into the main fork of the relation via shared buffer
+func testingWritePages(t *testing.T, bm *buffer.Manager, rel common.Relation, pages []page.PagePtr) {
+	for _, p := range pages {
+		bufID, err := bm.ReadBuffer(rel, disk.ForkNumberMain, page.NewPageID)
+		assert.Nil(t, err)
+		bm.AcquireContentLock(bufID, true)
+		*bm.GetPage(bufID) = *p
+		bm.MarkDirty(bufID)
+		bm.ReleaseContentLock(bufID, true)
+		bm.ReleaseBuffer(bufID)
+	}
+}
+
+func TestVerifyRelation(t *testing.T) {
+	bm, err := buffer.TestingNewManager()
+	assert.Nil(t, err)
+	rel := common.Relation{RelFileNumber: 1}
+	err = bm.CreateRelation(rel)
+	assert.Nil(t, err)
+
+	broken := testingPage(t, "a", strings.Repeat("b", 100))
+	testingSetSlot(t, broken, 1, page.PageSize-1, 1)
+	pages := []page.PagePtr{testingPage(t, "a"), broken, testingPage(t, strings.Repeat("c", 100))}
+	testingWritePages(t, bm, rel, pages)
+
+	// fsm has not been built yet, so the free space is not recorded
+	found, err := VerifyRelation(bm, rel)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(found), "%v", found)
+	assert.Equal(t, Violation{Fork: disk.ForkNumberMain, PageID: 1, Index: 1, Message: found[0].Message}, found[0])
+	assert.Equal(t, disk.ForkNumberFSM, found[1].Fork)
+	assert.Contains(t, found[0].String(), "main page 1 slot 1")
+
+	err = RebuildFSM(bm, rel)
+	assert.Nil(t, err)
+	found, err = VerifyRelation(bm, rel)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(found), "%v", found)
+	assert.Equal(t, disk.ForkNumberMain, found[0].Fork)
+
+	// the free space recorded is used by search
+	fm := fsm.NewManager(bm)
+	pageID, err := fm.SearchPageIDWithFreeSpaceSize(rel, page.CalculateFreeSpace(pages[0]))
+	assert.Nil(t, err)
+	assert.Equal(t, page.FirstPageID, pageID)
+
+	// the fsm updated without the main fork is found
+	err = fm.UpdateFSM(rel, page.PageID(2), 0)
+	assert.Nil(t, err)
+	found, err = VerifyRelation(bm, rel)
+	assert.Nil(t, err)
+	assert.Less(t, 1, len(found), "%v", found)
+	assert.Equal(t, disk.ForkNumberFSM, found[1].Fork)
+	assert.Contains(t, found[1].String(), "fsm page 2 node")
+}
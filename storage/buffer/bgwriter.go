@@ -5,12 +5,24 @@ So background writing is introduced.
 Background writer periodically checks whether buffer is dirty, and
 if it's dirty, the writer writes out the dirty buffer to disk ahead of time.
 
+Background writer writes out only the buffers which clock-sweep is likely to evict soon.
+- the buffers just ahead of the clock hand (nextVictimBuffer)
+- and whose reference count and usage count are 0 (reusable)
+Writing out the recently used buffer is wasteful because it is likely to be dirtied again before evicted.
+
+How many buffers to clean is estimated from how fast clock-sweep advances:
+  - count buffer allocations since the last round and smooth it (moving average)
+  - the next allocations are estimated as smoothed allocations * lru multiplier
+  - scan ahead of the clock hand until enough reusable buffers for the estimated allocations are found
+    (or the max pages are written in the round)
+
 for parameters defined in postgres, see 20.4.5 in the link below.
 https://www.postgresql.org/docs/current/runtime-config-resource.html#RUNTIME-CONFIG-RESOURCE-BACKGROUND-WRITER
 */
 package buffer
 
 import (
+	"context"
 	"sync/atomic"
 	"time"
 
@@ -20,77 +32,218 @@ import (
 const (
 	// delay between active rounds
 	// default is 200ms in postgres
-	bgWriterDelay = 10000
+	bgWriterDelay = 10000 * time.Millisecond
 	// in each round, 100 buffers are flushed at most
 	// see https://www.postgresql.org/docs/current/runtime-config-resource.html
 	bgWriterMaxPages = 100
+	// the estimated allocations are multiplied by this
+	// default is 2.0 in postgres
+	bgWriterLRUMultiplier = 2.0
+
+	// the number of samples for moving average
+	// see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/buffer/bufmgr.c#L2275-L2287
+	smoothingSamples = 16
+	// background writer scans the whole buffer pool in this time at least even when no buffer is allocated
+	scanWholePoolTime = 120 * time.Second
+	// initial value of smoothed density (the number of buffers scanned per reusable buffer)
+	initialSmoothedDensity = 10.0
 )
 
+// BackgroundWriter writes out dirty buffers ahead of clock-sweep
+// the settings (Delay, MaxPages, LRUMultiplier) can be changed before Run() is called
 type BackgroundWriter struct {
 	m *Manager
+	// Delay is delay between rounds. bgwriter_delay in postgres
+	Delay time.Duration
+	// MaxPages is the max number of buffers written out in each round. bgwriter_lru_maxpages in postgres
+	MaxPages int
+	// LRUMultiplier is multiplier of the estimated allocations. bgwriter_lru_multiplier in postgres
+	LRUMultiplier float64
+
+	// the state saved across rounds
+	// savedInfoValid indicates prevStrategyBufID and prevStrategyPasses are valid
+	savedInfoValid     bool
+	prevStrategyBufID  BufferID
+	prevStrategyPasses uint32
+	// nextToClean is the buffer the writer inspects next, and nextPasses is the complete passes of the writer
+	nextToClean BufferID
+	nextPasses  uint32
+	// smoothedAlloc is moving average of buffer allocations per round
+	smoothedAlloc float64
+	// smoothedDensity is moving average of the number of buffers scanned per reusable buffer
+	smoothedDensity float64
 }
 
-// BackgroundWrite is background writing
-// this function flushes dirty buffer on background periodically
-// postgres implements in more complicated way
-// see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/buffer/bufmgr.c#L2224
-func (bw *BackgroundWriter) Run() error {
-	writtenPages := 0
+// NewBackgroundWriter initializes background writer with default settings
+func NewBackgroundWriter(m *Manager) *BackgroundWriter {
+	return &BackgroundWriter{
+		m:               m,
+		Delay:           bgWriterDelay,
+		MaxPages:        bgWriterMaxPages,
+		LRUMultiplier:   bgWriterLRUMultiplier,
+		smoothedDensity: initialSmoothedDensity,
+	}
+}
+
+// Run runs background writer until ctx is done
+// this flushes dirty buffers on background periodically
+// when ctx is done, return nil. when error happens, return it.
+// see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/postmaster/bgwriter.c#L99
+func (bw *BackgroundWriter) Run(ctx context.Context) error {
 	for {
-		// check starts from the next victim buffer
-		nextVictimBuffer := atomic.LoadInt32((*int32)(&bw.m.nextVictimBuffer))
-		victimID := BufferID(nextVictimBuffer % bufferNum)
-		// check all buffers by default
-		for i := 0; i < bufferNum; i++ {
-			// syncOneBuffer checks whether the buffer is dirty, and if dirty, just return.
-			written, err := bw.m.syncOneBuffer(victimID)
-			if err != nil {
-				return errors.Wrap(err, "syncOneBuffer failed")
-			}
-			// if flushed, increment writtenPages
-			if written {
-				writtenPages++
-				// if write max pages, stop bgwriter
-				if writtenPages >= bgWriterMaxPages {
-					break
-				}
-			}
-			// check next buffer
-			victimID++
-			victimID = victimID % bufferNum
+		if _, err := bw.bufferSync(); err != nil {
+			return errors.Wrap(err, "bufferSync failed")
 		}
 		// sleep in each round
-		time.Sleep(bgWriterDelay * time.Millisecond)
+		// postgres hibernates (sleeps longer) when there is nothing to do, but ppdb doesn't for simplicity
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(bw.Delay):
+		}
 	}
 }
 
+// bufferSync writes out dirty buffers ahead of clock-sweep. this is called in each round
+// this returns the number of buffers written out
+// see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/buffer/bufmgr.c#L2224
+func (bw *BackgroundWriter) bufferSync() (int, error) {
+	strategyBufID, strategyPasses, recentAlloc := bw.m.strategySyncStart()
+
+	// compute how many buffers have been scanned by clock-sweep since last round,
+	// and how far ahead of the clock hand the writer is (bufsToLap)
+	var strategyDelta int
+	var bufsToLap int
+	if bw.savedInfoValid {
+		passesDelta := int32(strategyPasses - bw.prevStrategyPasses)
+		strategyDelta = int(strategyBufID-bw.prevStrategyBufID) + int(passesDelta)*bufferNum
+
+		if int32(bw.nextPasses-strategyPasses) > 0 {
+			// the writer is one pass ahead of the clock hand
+			bufsToLap = int(strategyBufID - bw.nextToClean)
+		} else if bw.nextPasses == strategyPasses && bw.nextToClean >= strategyBufID {
+			// on the same pass, and the writer is ahead of the clock hand
+			bufsToLap = bufferNum - int(bw.nextToClean-strategyBufID)
+		} else {
+			// the writer is behind the clock hand. start from the clock hand
+			bw.nextToClean = strategyBufID
+			bw.nextPasses = strategyPasses
+			bufsToLap = bufferNum
+		}
+	} else {
+		// first round. start from the clock hand
+		bw.nextToClean = strategyBufID
+		bw.nextPasses = strategyPasses
+		bufsToLap = bufferNum
+	}
+	bw.prevStrategyBufID = strategyBufID
+	bw.prevStrategyPasses = strategyPasses
+	bw.savedInfoValid = true
+
+	// update the density of reusable buffers from the clock-sweep progress
+	if strategyDelta > 0 && recentAlloc > 0 {
+		scansPerAlloc := float64(strategyDelta) / float64(recentAlloc)
+		bw.smoothedDensity += (scansPerAlloc - bw.smoothedDensity) / smoothingSamples
+	}
+	// the buffers between the clock hand and the writer have been cleaned already
+	bufsAhead := bufferNum - bufsToLap
+	reusableBuffersEst := float64(bufsAhead) / bw.smoothedDensity
+
+	// track the allocations with moving average. when the allocations increase, follow it at once
+	if bw.smoothedAlloc <= float64(recentAlloc) {
+		bw.smoothedAlloc = float64(recentAlloc)
+	} else {
+		bw.smoothedAlloc += (float64(recentAlloc) - bw.smoothedAlloc) / smoothingSamples
+	}
+	upcomingAllocEst := bw.smoothedAlloc * bw.LRUMultiplier
+	// scan the whole buffer pool in scanWholePoolTime at least, even when no buffer is allocated
+	minScanBuffers := float64(bufferNum) * float64(bw.Delay) / float64(scanWholePoolTime)
+	if upcomingAllocEst < minScanBuffers+reusableBuffersEst {
+		upcomingAllocEst = minScanBuffers + reusableBuffersEst
+	}
+
+	// scan ahead of the clock hand until enough reusable buffers are found
+	numToScan := bufsToLap
+	numWritten := 0
+	reusableBuffers := reusableBuffersEst
+	for numToScan > 0 && reusableBuffers < upcomingAllocEst {
+		result, err := bw.m.syncOneBuffer(bw.nextToClean, true)
+		if err != nil {
+			return numWritten, errors.Wrap(err, "syncOneBuffer failed")
+		}
+		bw.nextToClean++
+		if bw.nextToClean >= bufferNum {
+			bw.nextToClean = FirstBufferID
+			bw.nextPasses++
+		}
+		numToScan--
+
+		if result&syncResultWritten != 0 {
+			reusableBuffers++
+			numWritten++
+			if numWritten >= bw.MaxPages {
+				break
+			}
+		} else if result&syncResultReusable != 0 {
+			reusableBuffers++
+		}
+	}
+
+	// update the density with the result of this round too
+	newStrategyDelta := bufsToLap - numToScan
+	newRecentAlloc := reusableBuffers - reusableBuffersEst
+	if newStrategyDelta > 0 && newRecentAlloc > 0 {
+		scansPerAlloc := float64(newStrategyDelta) / newRecentAlloc
+		bw.smoothedDensity += (scansPerAlloc - bw.smoothedDensity) / smoothingSamples
+	}
+	return numWritten, nil
+}
+
+// syncResult is the result of syncOneBuffer
+type syncResult uint8
+
+const (
+	// syncResultWritten indicates the buffer is written out
+	syncResultWritten syncResult = 1 << iota
+	// syncResultReusable indicates the buffer is reusable (reference count and usage count are 0)
+	syncResultReusable
+)
+
 // syncOneBuffer flushes the buffer into disk
 // this is called by checkpointer, bgwriter
-// ppdb returns simple result, the buffer is flushed or not
-// postgres returns additionally whether the buffer is reusable or not
+// if skipRecentlyUsed is true, the buffer which is not reusable is not written out
+// this returns whether the buffer is written out and whether the buffer is reusable
 // see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/buffer/bufmgr.c#L2528
-func (m *Manager) syncOneBuffer(bufID BufferID) (bool, error) {
+func (m *Manager) syncOneBuffer(bufID BufferID, skipRecentlyUsed bool) (syncResult, error) {
+	var result syncResult
 	desc := m.descriptors[bufID]
 	desc.acquireHeaderLock()
-	if !desc.isDirty() {
-		// if the buffer is not dirty, don't have to do anything
-		return false, nil
+	if desc.referenceCount() == 0 && desc.usageCount() == 0 {
+		result |= syncResultReusable
+	} else if skipRecentlyUsed {
+		// the buffer is used recently, so it is likely to be dirtied again before evicted
+		desc.releaseHeaderLock()
+		return result, nil
 	}
 
-	// check whether the buffer is reusable
-	// if (desc.referenceCount() == 0) && (desc.usageCount() == 0) {
-	// 	result |= syncResultReusable
-	// }
+	state := atomic.LoadUint32(&desc.state)
+	if state&bmValid == 0 || state&bmDirty == 0 {
+		// if the buffer is not dirty, don't have to do anything
+		desc.releaseHeaderLock()
+		return result, nil
+	}
 
 	// when flushBuffer is called, the caller has to hold pin and shared content lock
 	// here, pin() cannot be called because of holding header lock
-	desc.pinWithHeaderLock()
+	// usage count is not incremented because the page is not used actually
+	desc.pinWithHeaderLockWithoutUsage()
 	desc.contentLock.RLock()
-	if err := m.flushBuffer(bufID); err != nil {
-		return false, errors.Wrap(err, "m.flushBuffer failed")
-	}
+	err := m.flushBuffer(bufID, writeSourceBgWriter)
 	desc.contentLock.RUnlock()
 	desc.unpin()
+	if err != nil {
+		return result, errors.Wrap(err, "m.flushBuffer failed")
+	}
 
-	return true, nil
+	return result | syncResultWritten, nil
 }
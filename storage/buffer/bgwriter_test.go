@@ -0,0 +1,100 @@
+package buffer
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/disk"
+	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackgroundWriterRun(t *testing.T) {
+	m, err := TestingNewManager()
+	assert.Nil(t, err)
+	bw := NewBackgroundWriter(m)
+	bw.Delay = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- bw.Run(ctx)
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("background writer did not stop after ctx is cancelled")
+	}
+}
+
+func TestBufferSync(t *testing.T) {
+	m, err := TestingNewManager()
+	assert.Nil(t, err)
+	forkNum := disk.ForkNumberMain
+
+	// the buffers are allocated from free list: buffer 0 and 1, which are just ahead of clock hand
+	bufID1, err := m.ReadBuffer(common.Relation{RelFileNumber: 1}, forkNum, page.FirstPageID)
+	assert.Nil(t, err)
+	m.MarkDirty(bufID1)
+	m.ReleaseBuffer(bufID1)
+	bufID2, err := m.ReadBuffer(common.Relation{RelFileNumber: 2}, forkNum, page.FirstPageID)
+	assert.Nil(t, err)
+	m.MarkDirty(bufID2)
+	m.ReleaseBuffer(bufID2)
+	// the first buffer is likely to be evicted soon, and the second is used recently
+	m.descriptors[bufID1].decrementUsageCount()
+
+	bw := NewBackgroundWriter(m)
+	written, err := bw.bufferSync()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, written)
+	assert.False(t, m.descriptors[bufID1].isDirty())
+	assert.True(t, m.descriptors[bufID2].isDirty())
+	assert.Equal(t, uint64(1), m.Stats().BgWriterWrites)
+	// the writer has scanned ahead of clock hand
+	assert.Greater(t, bw.nextToClean, bufID2)
+
+	// the buffers already scanned are not scanned again in the next round
+	m.descriptors[bufID2].decrementUsageCount()
+	written, err = bw.bufferSync()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, written)
+	assert.True(t, m.descriptors[bufID2].isDirty())
+}
+
+func TestSyncOneBuffer(t *testing.T) {
+	m, err := TestingNewManager()
+	assert.Nil(t, err)
+
+	bufID, err := m.ReadBuffer(common.Relation{RelFileNumber: 1}, disk.ForkNumberMain, page.FirstPageID)
+	assert.Nil(t, err)
+	m.ReleaseBuffer(bufID)
+
+	t.Run("when the buffer is used recently", func(t *testing.T) {
+		result, err := m.syncOneBuffer(bufID, true)
+		assert.Nil(t, err)
+		assert.Equal(t, syncResult(0), result)
+		assert.Zero(t, atomic.LoadUint32(&m.descriptors[bufID].state)&bmLocked)
+	})
+	t.Run("when the buffer is not dirty", func(t *testing.T) {
+		result, err := m.syncOneBuffer(bufID, false)
+		assert.Nil(t, err)
+		assert.Equal(t, syncResult(0), result)
+		assert.Zero(t, atomic.LoadUint32(&m.descriptors[bufID].state)&bmLocked)
+	})
+	t.Run("when the buffer is dirty", func(t *testing.T) {
+		m.MarkDirty(bufID)
+		m.descriptors[bufID].decrementUsageCount()
+		result, err := m.syncOneBuffer(bufID, true)
+		assert.Nil(t, err)
+		assert.Equal(t, syncResultWritten|syncResultReusable, result)
+		assert.False(t, m.descriptors[bufID].isDirty())
+		assert.Equal(t, uint32(0), m.descriptors[bufID].referenceCount())
+		assert.Equal(t, uint32(0), m.descriptors[bufID].usageCount())
+	})
+}
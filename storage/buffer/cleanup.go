@@ -0,0 +1,79 @@
+/*
+Cleanup lock is the lock necessary to physically remove tuples or compact free space on a page.
+
+Tuples on the page are referred by pointer after the content lock is released as long as the buffer is pinned.
+(ex: the goroutine scanning the page gets the item with page.GetItem(), releases the content lock and uses the item)
+So moving tuples within the page with only the exclusive content lock may break the item the other goroutines are reading.
+Cleanup lock is exclusive content lock + the caller holds the only pin of the buffer.
+When the caller acquires cleanup lock, no other goroutine holds pin, and no goroutine can access the page until
+the content lock is released (pin can be acquired, but the content cannot be read without content lock).
+So the caller can move tuples safely (ex: page.CompactPage()).
+
+the flow of vacuum with cleanup lock:
+- pin the buffer (ReadBuffer) -> acquire cleanup lock (LockBufferForCleanup)
+- -> remove dead tuples and compact the page -> release content lock (ReleaseContentLock) -> unpin the buffer
+
+see https://github.com/postgres/postgres/blob/d87251048a0f293ad20cc1fe26ce9f542de105e6/src/backend/storage/buffer/README#L72-L97
+*/
+package buffer
+
+import (
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// LockBufferForCleanup acquires cleanup lock
+// this waits until the caller holds the only pin, then returns with exclusive content lock held.
+// the caller must hold pin, and release content lock by ReleaseContentLock(bufID, true) after the cleanup.
+// only one goroutine can wait for cleanup lock of the same buffer at one time. if there is another waiter, return error.
+// see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/buffer/bufmgr.c#L4080
+func (m *Manager) LockBufferForCleanup(bufID BufferID) error {
+	desc := m.descriptors[bufID]
+	for {
+		// acquire exclusive content lock at first, then check the pin count
+		// new pins can be acquired after this, but the pinners cannot read the content until the lock is released
+		desc.contentLock.Lock()
+		desc.acquireHeaderLock()
+		if desc.referenceCount() == 1 {
+			// the caller holds the only pin. got cleanup lock
+			desc.releaseHeaderLock()
+			return nil
+		}
+		state := atomic.LoadUint32(&desc.state)
+		if state&bmPinCountWaiter != 0 {
+			desc.releaseHeaderLock()
+			desc.contentLock.Unlock()
+			return errors.New("multiple goroutines attempting to wait for pin count 1")
+		}
+		// register the waiter and wait for the other pins to be released
+		// the content lock is released during wait because the other pinners may need it to finish their work
+		waiter := make(chan struct{})
+		desc.pinCountWaiter = waiter
+		desc.releaseHeaderLockWithState(state | bmPinCountWaiter)
+		desc.contentLock.Unlock()
+
+		// unpin() closes the channel when the pin count decreases to 1
+		<-waiter
+		// the buffer may be pinned again by other goroutine here, so re-check from the beginning
+	}
+}
+
+// ConditionalLockBufferForCleanup acquires cleanup lock if it can be acquired without waiting
+// return true when cleanup lock is acquired. in that case, the caller has to release the content lock after the cleanup.
+// the caller must hold pin.
+// see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/buffer/bufmgr.c#L4247
+func (m *Manager) ConditionalLockBufferForCleanup(bufID BufferID) bool {
+	desc := m.descriptors[bufID]
+	if !desc.contentLock.TryLock() {
+		return false
+	}
+	desc.acquireHeaderLock()
+	if desc.referenceCount() == 1 {
+		desc.releaseHeaderLock()
+		return true
+	}
+	desc.releaseHeaderLock()
+	desc.contentLock.Unlock()
+	return false
+}
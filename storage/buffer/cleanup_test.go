@@ -0,0 +1,134 @@
+package buffer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/disk"
+	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockBufferForCleanup(t *testing.T) {
+	t.Run("when the caller holds the only pin", func(t *testing.T) {
+		m, err := TestingNewManager()
+		assert.Nil(t, err)
+		bufID, err := m.ReadBuffer(common.Relation{RelFileNumber: 1}, disk.ForkNumberMain, page.NewPageID)
+		assert.Nil(t, err)
+
+		err = m.LockBufferForCleanup(bufID)
+		assert.Nil(t, err)
+		// exclusive content lock must be held
+		assert.False(t, m.descriptors[bufID].contentLock.TryRLock())
+		m.ReleaseContentLock(bufID, true)
+		m.ReleaseBuffer(bufID)
+	})
+	t.Run("when other goroutine holds pin", func(t *testing.T) {
+		m, err := TestingNewManager()
+		assert.Nil(t, err)
+		bufID, err := m.ReadBuffer(common.Relation{RelFileNumber: 1}, disk.ForkNumberMain, page.NewPageID)
+		assert.Nil(t, err)
+		// other goroutine pins the buffer and reads the page
+		otherID, err := m.ReadBuffer(common.Relation{RelFileNumber: 1}, disk.ForkNumberMain, m.descriptors[bufID].tag.pageID)
+		assert.Nil(t, err)
+		assert.Equal(t, bufID, otherID)
+
+		locked := make(chan error)
+		go func() {
+			locked <- m.LockBufferForCleanup(bufID)
+		}()
+		// cleanup lock must not be acquired while other goroutine holds pin
+		select {
+		case <-locked:
+			t.Fatal("cleanup lock is acquired while other goroutine holds pin")
+		case <-time.After(10 * time.Millisecond):
+		}
+		// the waiter releases content lock during wait, so other goroutine can read the page
+		m.AcquireContentLock(otherID, false)
+		m.ReleaseContentLock(otherID, false)
+
+		// cleanup lock is acquired after other goroutine unpins
+		m.ReleaseBuffer(otherID)
+		assert.Nil(t, <-locked)
+		m.ReleaseContentLock(bufID, true)
+		m.ReleaseBuffer(bufID)
+	})
+	t.Run("when multiple goroutines wait for cleanup lock", func(t *testing.T) {
+		m, err := TestingNewManager()
+		assert.Nil(t, err)
+		bufID, err := m.ReadBuffer(common.Relation{RelFileNumber: 1}, disk.ForkNumberMain, page.NewPageID)
+		assert.Nil(t, err)
+		m.descriptors[bufID].pin()
+
+		locked := make(chan error)
+		go func() {
+			locked <- m.LockBufferForCleanup(bufID)
+		}()
+		// wait for the first waiter to be registered
+		for !m.descriptors[bufID].hasPinCountWaiter() {
+			time.Sleep(time.Millisecond)
+		}
+		err = m.LockBufferForCleanup(bufID)
+		assert.NotNil(t, err)
+
+		m.ReleaseBuffer(bufID)
+		assert.Nil(t, <-locked)
+		m.ReleaseContentLock(bufID, true)
+	})
+}
+
+func TestConditionalLockBufferForCleanup(t *testing.T) {
+	m, err := TestingNewManager()
+	assert.Nil(t, err)
+	bufID, err := m.ReadBuffer(common.Relation{RelFileNumber: 1}, disk.ForkNumberMain, page.NewPageID)
+	assert.Nil(t, err)
+
+	// other goroutine holds pin
+	m.descriptors[bufID].pin()
+	assert.False(t, m.ConditionalLockBufferForCleanup(bufID))
+	// content lock must not be held when failed
+	assert.True(t, m.descriptors[bufID].contentLock.TryLock())
+	m.ReleaseContentLock(bufID, true)
+
+	// other goroutine holds content lock
+	m.ReleaseBuffer(bufID)
+	m.AcquireContentLock(bufID, false)
+	assert.False(t, m.ConditionalLockBufferForCleanup(bufID))
+	m.ReleaseContentLock(bufID, false)
+
+	assert.True(t, m.ConditionalLockBufferForCleanup(bufID))
+	m.ReleaseContentLock(bufID, true)
+}
+
+func TestResourceOwnerConditionalLockBufferForCleanup(t *testing.T) {
+	m, err := TestingNewManager()
+	assert.Nil(t, err)
+	ro := m.NewResourceOwner()
+	bufID, err := ro.ReadBuffer(common.Relation{RelFileNumber: 1}, disk.ForkNumberMain, page.NewPageID)
+	assert.Nil(t, err)
+
+	// nothing is remembered when failed
+	m.descriptors[bufID].pin()
+	assert.False(t, ro.ConditionalLockBufferForCleanup(bufID))
+	assert.Equal(t, 0, len(ro.locks))
+	m.ReleaseBuffer(bufID)
+
+	// the lock is released on abort
+	assert.True(t, ro.ConditionalLockBufferForCleanup(bufID))
+	ro.Abort()
+	assert.True(t, m.descriptors[bufID].contentLock.TryLock())
+	m.ReleaseContentLock(bufID, true)
+
+	// the lock not released is reported as leak on commit
+	bufID, err = ro.ReadBuffer(common.Relation{RelFileNumber: 1}, disk.ForkNumberMain, m.descriptors[bufID].tag.pageID)
+	assert.Nil(t, err)
+	assert.True(t, ro.ConditionalLockBufferForCleanup(bufID))
+	err = ro.Commit()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "content lock leak")
+	assert.Contains(t, err.Error(), "cleanup_test.go")
+	assert.True(t, m.descriptors[bufID].contentLock.TryLock())
+	m.ReleaseContentLock(bufID, true)
+	assert.Equal(t, uint32(0), m.descriptors[bufID].referenceCount())
+}
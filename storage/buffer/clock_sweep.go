@@ -24,6 +24,8 @@ func (m *Manager) clockSweepTick() BufferID {
 			for {
 				wrapped := nextVictimBuffer % bufferNum
 				if ok := atomic.CompareAndSwapInt32((*int32)(&m.nextVictimBuffer), nextVictimBuffer, wrapped); ok {
+					// clock hand has completed one cycle. this is used by background writer
+					atomic.AddUint32(&m.completePasses, 1)
 					break
 				}
 				nextVictimBuffer++
@@ -74,3 +76,18 @@ func (m *Manager) allocateWithClockSweep() BufferID {
 	}
 	return InvalidBufferID
 }
+
+// strategySyncStart returns the buffer id clock-sweep will inspect next, the number of complete passes of clock hand,
+// and the number of buffer allocations since last call. the number of allocations is reset.
+// this is expected to be called by background writer to know how fast clock-sweep advances.
+// postgres reads them with buffer strategy lock for consistency, while ppdb reads them with atomic operations
+// so the result may be slightly inconsistent (although it is not critical for background writer)
+// see https://github.com/postgres/postgres/blob/24d2b2680a8d0e01b30ce8a41c4eb3b47aca5031/src/backend/storage/buffer/freelist.c#L394
+func (m *Manager) strategySyncStart() (BufferID, uint32, uint32) {
+	nextVictimBuffer := atomic.LoadInt32((*int32)(&m.nextVictimBuffer))
+	passes := atomic.LoadUint32(&m.completePasses)
+	// nextVictimBuffer can exceed bufferNum until it is wrapped
+	passes += uint32(nextVictimBuffer / bufferNum)
+	numAllocs := atomic.SwapUint32(&m.numBufferAllocs, 0)
+	return BufferID(nextVictimBuffer % bufferNum), passes, numAllocs
+}
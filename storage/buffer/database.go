@@ -0,0 +1,69 @@
+package buffer
+
+import (
+	"sync/atomic"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/pkg/errors"
+)
+
+// CreateDatabase creates the database by copying the files of the template database
+// the dirty buffers of the template database are written out before copy, otherwise the copy misses the changes
+// the caller must prevent the other goroutines from modifying the template database
+// see createdb() https://github.com/postgres/postgres/blob/20432f8731404d2cef2a155144aca5ab3ae98e95/src/backend/commands/dbcommands.c
+func (m *Manager) CreateDatabase(db, template common.Database) error {
+	err := m.flushBuffers(func(t tag) bool {
+		return t.rel.Database == template
+	})
+	if err != nil {
+		return errors.Wrap(err, "flushBuffers failed")
+	}
+	if err := m.dm.CreateDatabase(db, template); err != nil {
+		return errors.Wrap(err, "dm.CreateDatabase failed")
+	}
+	return nil
+}
+
+// DropDatabase removes all relation files of the database after dropping all buffers of the database
+// the dirty buffers are not written out because the files are removed anyway
+// the caller must prevent the other goroutines from accessing the database
+// see dropdb() https://github.com/postgres/postgres/blob/20432f8731404d2cef2a155144aca5ab3ae98e95/src/backend/commands/dbcommands.c
+func (m *Manager) DropDatabase(db common.Database) error {
+	m.dropBuffers(func(t tag) bool {
+		return t.rel.Database == db
+	})
+	m.prefetcher.forgetDatabase(db)
+
+	if err := m.dm.DropDatabase(db); err != nil {
+		return errors.Wrap(err, "dm.DropDatabase failed")
+	}
+	return nil
+}
+
+// flushBuffers writes out the dirty pages whose tag matches
+// this is FlushDatabaseBuffers() in postgres
+// see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/buffer/bufmgr.c
+func (m *Manager) flushBuffers(match func(t tag) bool) error {
+	for bufID := FirstBufferID; bufID < bufferNum; bufID++ {
+		desc := m.descriptors[bufID]
+		if atomic.LoadUint32(&desc.state)&bmTagValid == 0 {
+			continue
+		}
+		desc.acquireHeaderLock()
+		state := atomic.LoadUint32(&desc.state)
+		if state&bmValid == 0 || state&bmDirty == 0 || !match(desc.tag) {
+			desc.releaseHeaderLock()
+			continue
+		}
+		// see syncOneBuffer() about pin and content lock
+		desc.pinWithHeaderLockWithoutUsage()
+		desc.contentLock.RLock()
+		err := m.flushBuffer(bufID, writeSourceBackend)
+		desc.contentLock.RUnlock()
+		desc.unpin()
+		if err != nil {
+			return errors.Wrap(err, "flushBuffer failed")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package buffer
+
+import (
+	"testing"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/disk"
+	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateDropDatabase(t *testing.T) {
+	m, err := TestingNewManager()
+	assert.Nil(t, err)
+	rel := common.Relation{RelFileNumber: 1}
+	err = m.CreateRelation(rel)
+	assert.Nil(t, err)
+
+	// the dirty page of template database is not written out yet
+	bufID, err := m.ReadBuffer(rel, disk.ForkNumberMain, page.NewPageID)
+	assert.Nil(t, err)
+	m.GetPage(bufID)[100] = 'a'
+	m.MarkDirty(bufID)
+	m.ReleaseBuffer(bufID)
+
+	db := common.Database(1)
+	err = m.CreateDatabase(db, common.DefaultDatabase)
+	assert.Nil(t, err)
+	// the template buffer has been written out
+	assert.False(t, m.descriptors[bufID].isDirty())
+
+	// the page is copied into the new database
+	copied := common.Relation{Database: db, RelFileNumber: 1}
+	copiedBufID, err := m.ReadBuffer(copied, disk.ForkNumberMain, page.FirstPageID)
+	assert.Nil(t, err)
+	assert.Equal(t, byte('a'), m.GetPage(copiedBufID)[100])
+	m.GetPage(copiedBufID)[100] = 'b'
+	m.MarkDirty(copiedBufID)
+	m.ReleaseBuffer(copiedBufID)
+
+	err = m.DropDatabase(db)
+	assert.Nil(t, err)
+	// all buffers of the database are dropped
+	for _, desc := range m.descriptors {
+		if desc.tag.rel.Database == db {
+			assert.False(t, desc.isValid())
+		}
+	}
+	_, ok := m.table.table[tag{rel: rel, forkNum: disk.ForkNumberMain, pageID: page.FirstPageID}]
+	assert.True(t, ok)
+
+	// the database is created again from template, and the dropped page is not seen
+	err = m.CreateDatabase(db, common.DefaultDatabase)
+	assert.Nil(t, err)
+	copiedBufID, err = m.ReadBuffer(copied, disk.ForkNumberMain, page.FirstPageID)
+	assert.Nil(t, err)
+	assert.Equal(t, byte('a'), m.GetPage(copiedBufID)[100])
+	m.ReleaseBuffer(copiedBufID)
+}
@@ -46,6 +46,12 @@ to summarize,
 - acquire header lock or use cas operation to update state field
 - when header lock is held by other goroutines, cas operation must not be executed
 
+Header lock is spin lock, so the waiter spins with backoff (see spin.go).
+On the other hand, io can take a long time, so the goroutine waiting for io in progress
+sleeps on condition variable per buffer instead of spinning. this is the same as WaitIO() in postgres.
+- startIO(): wait for other io to complete, then set io in progress (if the io is still necessary)
+- terminateIO(): clear io in progress and wake up the waiters
+
 -----
 when buffer is pinned by other goroutines, tag must not be updated
 
@@ -76,6 +82,15 @@ type descriptor struct {
 	// in postgres, content lock is defined with LWLock
 	// for more details, see the comment at the head of /storage/buffer/manager.go
 	contentLock sync.RWMutex
+	// ioCond is condition variable to wait for io in progress to complete
+	// ioLock is the lock associated with ioCond
+	// in postgres, this is called io_in_progress_lock (ConditionVariable in recent version)
+	ioLock sync.Mutex
+	ioCond sync.Cond
+	// pinCountWaiter is closed when the pin count of the buffer decreases to 1
+	// this is used by the goroutine waiting for cleanup lock and protected by header lock
+	// see cleanup.go
+	pinCountWaiter chan struct{}
 }
 
 // newDescriptors initializes descriptors for manager
@@ -86,6 +101,7 @@ func newDescriptors() [bufferNum]*descriptor {
 		descs[i] = &descriptor{
 			nextFreeID: BufferID(i + 1),
 		}
+		descs[i].ioCond.L = &descs[i].ioLock
 	}
 	descs[bufferNum-1].nextFreeID = freeListInvalidID
 	return descs
@@ -102,6 +118,17 @@ const (
 	// this is kind of lock for disk io
 	// see https://github.com/postgres/postgres/blob/d87251048a0f293ad20cc1fe26ce9f542de105e6/src/backend/storage/buffer/README#L148-L152
 	bmIOInProgress uint32 = (1 << 7)
+	// bmValid indicates the buffer contains valid page
+	// this is off while the page is being read into the buffer
+	bmValid uint32 = (1 << 6)
+	// bmJustDirtied indicates the buffer has been dirtied since write started
+	// when write completes, the dirty bit must not be cleared if this is on
+	bmJustDirtied uint32 = (1 << 5)
+	// bmTagValid indicates the tag is valid and the buffer table has the entry for the tag
+	// this is off when the buffer has never been used (ex: the buffer is allocated from free list)
+	bmTagValid uint32 = (1 << 4)
+	// bmPinCountWaiter indicates some goroutine waits for the pin count to be 1 (cleanup lock)
+	bmPinCountWaiter uint32 = (1 << 3)
 
 	// other bits will be defined when necessary
 )
@@ -110,14 +137,14 @@ const (
 // to change state/tag field in descriptor
 // see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/buffer/bufmgr.c#L4755
 func (desc *descriptor) acquireHeaderLock() {
+	var sd spinDelay
 	for {
 		oldState := atomic.LoadUint32(&desc.state)
 		if oldState&bmLocked != 0 {
-			// if header lock is held by other goroutines, just continue
-			// in postgres, delay spin lock here
+			// if header lock is held by other goroutines, spin and continue
+			// the lock is expected to be released soon, but sleep with backoff after spinning some times
 			// see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/buffer/bufmgr.c#L4769
-			// maybe runtime.Gosched() should be called here
-			// re-scheduling of goroutine may be controversial because the lock is expected to be released soon
+			sd.perform()
 			continue
 		}
 		newState := oldState | bmLocked
@@ -142,19 +169,26 @@ func (desc *descriptor) releaseHeaderLock() {
 	atomic.SwapUint32(&desc.state, state & ^bmLocked)
 }
 
+// releaseHeaderLockWithState updates state field and releases buffer header spin lock at once
+// the caller is expected to hold header lock, so other goroutines cannot update state field
+// see https://github.com/postgres/postgres/blob/a448e49bcbe40fb72e1ed85af910dd216d45bad8/src/include/storage/buf_internals.h#L359
+func (desc *descriptor) releaseHeaderLockWithState(state uint32) {
+	atomic.StoreUint32(&desc.state, state & ^bmLocked)
+}
+
 // waitHeaderLockReleased waits for buffer header spin lock to be released
 // this function is expected to be called when using CAS loops
 // see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/buffer/bufmgr.c#L4784
 func (desc *descriptor) waitHeaderLockReleased() uint32 {
 	var state uint32
+	var sd spinDelay
 	for {
 		state = atomic.LoadUint32(&desc.state)
 		// if lock is not held by other goroutine, return
 		if state&bmLocked == 0 {
 			break
 		}
-		// maybe runtime.Gosched() should be called here
-		// re-scheduling of goroutine may be controversial because the lock is expected to be released soon
+		sd.perform()
 	}
 	return state
 }
@@ -163,8 +197,8 @@ func (desc *descriptor) waitHeaderLockReleased() uint32 {
 // this can be called without holding header lock
 // see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/buffer/bufmgr.c#L1583
 func (desc *descriptor) setDirty() {
-	// if the buffer is already dirty, just return
-	if desc.isDirty() {
+	// if the buffer is already dirty (and just dirtied), just return
+	if atomic.LoadUint32(&desc.state)&(bmDirty|bmJustDirtied) == (bmDirty | bmJustDirtied) {
 		return
 	}
 	for {
@@ -177,7 +211,8 @@ func (desc *descriptor) setDirty() {
 			// then update oldState with the state when header lock is released
 			oldState = desc.waitHeaderLockReleased()
 		}
-		newState := oldState | bmDirty
+		// bmJustDirtied is for the goroutine writing out the buffer at the same time. see terminateIO()
+		newState := oldState | bmDirty | bmJustDirtied
 		if atomic.CompareAndSwapUint32(&desc.state, oldState, newState) {
 			// if swapped, return
 			break
@@ -219,52 +254,76 @@ func (desc *descriptor) isDirty() bool {
 	return false
 }
 
-// setIOInProgress sets buffer io in progress
-func (desc *descriptor) setIOInProgress() {
+// startIO starts buffer io
+// if io is in progress by other goroutine, wait for it to complete.
+// then, if the io is not necessary anymore, return false. otherwise set io in progress and return true.
+// - forInput is true: reading page into buffer. not necessary when the buffer is already valid.
+// - forInput is false: writing buffer out to disk. not necessary when the buffer is not dirty.
+// the caller who receives true has to call terminateIO() after the io completes (or fails).
+// see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/buffer/bufmgr.c#L4368
+func (desc *descriptor) startIO(forInput bool) bool {
+	var state uint32
 	for {
-		oldState := atomic.LoadUint32(&desc.state)
-		// wait buffer header unlocked if it is locked
-		if oldState&bmLocked != 0 {
-			// wait header lock to be released
-			// because cas operation must not be executed when header lock held by other goroutine
-			// see the comment at the head of /storage/buffer/manager.go
-			// then update oldState with the state when header lock is released
-			oldState = desc.waitHeaderLockReleased()
-		}
-		// if the buffer io is in progress by other goroutine, it has to be waited
-		if desc.isIOInProgress() {
-			// is this released soon in most cases?
-			continue
-		}
-		newState := oldState | bmIOInProgress
-		if atomic.CompareAndSwapUint32(&desc.state, oldState, newState) {
-			// if swapped, return
+		desc.acquireHeaderLock()
+		state = atomic.LoadUint32(&desc.state)
+		if state&bmIOInProgress == 0 {
 			break
 		}
+		// io is in progress by other goroutine, so release header lock and wait for it
+		desc.releaseHeaderLock()
+		desc.waitIO()
+	}
+
+	// here, header lock is held and no io is in progress
+	// check whether other goroutine has done the io we want
+	if forInput && state&bmValid != 0 {
+		desc.releaseHeaderLock()
+		return false
+	}
+	if !forInput && state&bmDirty == 0 {
+		desc.releaseHeaderLock()
+		return false
 	}
+
+	state |= bmIOInProgress
+	if !forInput {
+		// from here, if the buffer is dirtied again, the dirty bit must not be cleared at terminateIO()
+		state &^= bmJustDirtied
+	}
+	desc.releaseHeaderLockWithState(state)
+	return true
 }
 
-// clearIOInProgress clears buffer io in progress
-func (desc *descriptor) clearIOInProgress() {
-	if !desc.isIOInProgress() {
-		return
+// terminateIO clears buffer io in progress and wakes up the goroutines waiting for the io
+// if clearDirty is true and the buffer has not been dirtied during the io, clear dirty bit.
+// setFlags is set to the state field (ex: bmValid when read completes)
+// see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/buffer/bufmgr.c#L4437
+func (desc *descriptor) terminateIO(clearDirty bool, setFlags uint32) {
+	desc.acquireHeaderLock()
+	state := atomic.LoadUint32(&desc.state)
+	state &^= bmIOInProgress
+	if clearDirty && state&bmJustDirtied == 0 {
+		state &^= bmDirty
 	}
-	for {
-		oldState := atomic.LoadUint32(&desc.state)
-		// wait buffer header unlocked if it is locked
-		if oldState&bmLocked != 0 {
-			// wait header lock to be released
-			// because cas operation must not be executed when header lock held by other goroutine
-			// see the comment at the head of /storage/buffer/manager.go
-			// then update oldState with the state when header lock is released
-			oldState = desc.waitHeaderLockReleased()
-		}
-		newState := oldState & ^bmIOInProgress
-		if atomic.CompareAndSwapUint32(&desc.state, oldState, newState) {
-			// if swapped, return
-			break
-		}
+	state |= setFlags
+	desc.releaseHeaderLockWithState(state)
+
+	// wake up all goroutines waiting for the io
+	// ioLock has to be acquired so that waiter cannot miss the wakeup between checking the flag and sleeping
+	desc.ioLock.Lock()
+	desc.ioCond.Broadcast()
+	desc.ioLock.Unlock()
+}
+
+// waitIO waits for the io in progress on the buffer to complete
+// the caller must not hold header lock
+// see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/buffer/bufmgr.c#L4320
+func (desc *descriptor) waitIO() {
+	desc.ioLock.Lock()
+	for desc.isIOInProgress() {
+		desc.ioCond.Wait()
 	}
+	desc.ioLock.Unlock()
 }
 
 // isIOInProgress checks whether the buffer io is in progress
@@ -276,6 +335,24 @@ func (desc *descriptor) isIOInProgress() bool {
 	return false
 }
 
+// hasPinCountWaiter checks whether some goroutine waits for cleanup lock
+func (desc *descriptor) hasPinCountWaiter() bool {
+	state := atomic.LoadUint32(&desc.state)
+	if state&bmPinCountWaiter != 0 {
+		return true
+	}
+	return false
+}
+
+// isValid checks whether the buffer contains valid page
+func (desc *descriptor) isValid() bool {
+	state := atomic.LoadUint32(&desc.state)
+	if state&bmValid != 0 {
+		return true
+	}
+	return false
+}
+
 const (
 	// refCountOne is for increment of ref count
 	refCountOne uint32 = (1 << 14)
@@ -342,9 +419,21 @@ func (desc *descriptor) pinWithHeaderLock() error {
 	return nil
 }
 
+// pinWithHeaderLockWithoutUsage pins the buffer and releases header lock without incrementing usage count
+// this is for the goroutine which pins the buffer not to use the page (ex: background writer)
+// if usage count is incremented, the buffer cleaned by background writer becomes hard to be reused.
+// the caller is expected to hold header lock
+// see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/buffer/bufmgr.c#L1807
+func (desc *descriptor) pinWithHeaderLockWithoutUsage() {
+	state := atomic.LoadUint32(&desc.state)
+	desc.releaseHeaderLockWithState(state + refCountOne)
+}
+
 // unpin decrements reference count
 // note: usage count is not decremented here. it is decremented by clock-sweep
+// if the goroutine waiting for cleanup lock holds the only pin after unpin, wake it up
 func (desc *descriptor) unpin() {
+	var newState uint32
 	for {
 		oldState := atomic.LoadUint32(&desc.state)
 		// wait buffer header unlocked if it is locked
@@ -355,25 +444,41 @@ func (desc *descriptor) unpin() {
 			// then update oldState with the state when header lock is released
 			oldState = desc.waitHeaderLockReleased()
 		}
-		state := oldState - refCountOne
-		if ok := atomic.CompareAndSwapUint32(&desc.state, oldState, state); ok {
+		newState = oldState - refCountOne
+		if ok := atomic.CompareAndSwapUint32(&desc.state, oldState, newState); ok {
 			break
 		}
 	}
+
+	// see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/buffer/bufmgr.c#L1900-L1925
+	if newState&bmPinCountWaiter == 0 {
+		return
+	}
+	desc.acquireHeaderLock()
+	state := atomic.LoadUint32(&desc.state)
+	// re-check with header lock because other goroutine may have pinned the buffer or woken up the waiter
+	if state&bmPinCountWaiter == 0 || desc.referenceCount() != 1 {
+		desc.releaseHeaderLock()
+		return
+	}
+	waiter := desc.pinCountWaiter
+	desc.pinCountWaiter = nil
+	desc.releaseHeaderLockWithState(state & ^bmPinCountWaiter)
+	close(waiter)
 }
 
 // referenceCount returns reference count
 // the caller usually has to hold header lock?(probably)
 func (desc *descriptor) referenceCount() uint32 {
 	var mask uint32 = (1 << 14) - 1
-	refCount := desc.state & ^mask
+	refCount := atomic.LoadUint32(&desc.state) & ^mask
 	return refCount >> 14
 }
 
 // usageCount returns usage count
 // the caller usually has to hold header lock?(probably)
 func (desc *descriptor) usageCount() uint32 {
-	state := desc.state << 18
+	state := atomic.LoadUint32(&desc.state) << 18
 	var mask uint32 = (1 << 28) - 1
 	usageCount := state & ^mask
 	return usageCount >> 28
@@ -389,7 +494,7 @@ func (desc *descriptor) decrementUsageCount() {
 		return
 	}
 	for {
-		oldState := desc.state
+		oldState := atomic.LoadUint32(&desc.state)
 		state := oldState - usageCountOne
 		if ok := atomic.CompareAndSwapUint32(&desc.state, oldState, state); ok {
 			break
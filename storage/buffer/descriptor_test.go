@@ -2,6 +2,7 @@ package buffer
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -74,10 +75,10 @@ func TestIOInProgress(t *testing.T) {
 		state: inProgressState,
 	}
 	assert.False(t, desc.state&bmIOInProgress == 0)
-	desc.clearIOInProgress()
+	desc.terminateIO(false, 0)
 	assert.True(t, desc.state&bmIOInProgress == 0)
-	// check clearIOInProgress is no problem when the bit is off
-	desc.clearIOInProgress()
+	// check terminateIO is no problem when the bit is off
+	desc.terminateIO(false, 0)
 }
 
 func TestPin(t *testing.T) {
@@ -149,3 +150,95 @@ func TestDecrementUsageCount(t *testing.T) {
 	assert.Equal(t, 0, int(desc.usageCount()))
 	assert.Equal(t, 1, int(desc.referenceCount()))
 }
+
+func TestStartIO(t *testing.T) {
+	tests := []struct {
+		name     string
+		state    uint32
+		forInput bool
+		expected bool
+	}{
+		{
+			name:     "read into invalid buffer",
+			state:    0x0,
+			forInput: true,
+			expected: true,
+		},
+		{
+			name:     "read into valid buffer",
+			state:    bmValid,
+			forInput: true,
+			expected: false,
+		},
+		{
+			name:     "write out dirty buffer",
+			state:    bmValid | bmDirty,
+			forInput: false,
+			expected: true,
+		},
+		{
+			name:     "write out clean buffer",
+			state:    bmValid,
+			forInput: false,
+			expected: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			desc := newDescriptors()[FirstBufferID]
+			desc.state = tt.state
+			got := desc.startIO(tt.forInput)
+			assert.Equal(t, tt.expected, got)
+			assert.Equal(t, tt.expected, desc.isIOInProgress())
+		})
+	}
+}
+
+func TestTerminateIO(t *testing.T) {
+	t.Run("read completes", func(t *testing.T) {
+		desc := newDescriptors()[FirstBufferID]
+		assert.True(t, desc.startIO(true))
+		desc.terminateIO(false, bmValid)
+		assert.False(t, desc.isIOInProgress())
+		assert.True(t, desc.isValid())
+	})
+	t.Run("write completes", func(t *testing.T) {
+		desc := newDescriptors()[FirstBufferID]
+		desc.setDirty()
+		assert.True(t, desc.startIO(false))
+		desc.terminateIO(true, 0)
+		assert.False(t, desc.isIOInProgress())
+		assert.False(t, desc.isDirty())
+	})
+	t.Run("dirtied during write", func(t *testing.T) {
+		desc := newDescriptors()[FirstBufferID]
+		desc.setDirty()
+		assert.True(t, desc.startIO(false))
+		// other goroutine updates the page during write
+		desc.setDirty()
+		desc.terminateIO(true, 0)
+		// dirty bit must not be cleared because the update is not written out
+		assert.True(t, desc.isDirty())
+	})
+}
+
+func TestWaitIO(t *testing.T) {
+	desc := newDescriptors()[FirstBufferID]
+	assert.True(t, desc.startIO(true))
+
+	// the goroutines reading the same buffer must wait for the io to complete, and must not start io
+	n := 10
+	results := make(chan bool, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			results <- desc.startIO(true)
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 0, len(results))
+
+	desc.terminateIO(false, bmValid)
+	for i := 0; i < n; i++ {
+		assert.False(t, <-results)
+	}
+}
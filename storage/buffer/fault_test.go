@@ -0,0 +1,161 @@
+package buffer
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/disk"
+	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// testingAssertNoPinNorIO checks that no buffer is left pinned or io in progress
+func testingAssertNoPinNorIO(t *testing.T, m *Manager) {
+	t.Helper()
+	for bufID, desc := range m.descriptors {
+		assert.Zero(t, desc.referenceCount(), "buffer %d is pinned", bufID)
+		assert.False(t, desc.isIOInProgress(), "buffer %d is io in progress", bufID)
+	}
+}
+
+func TestReadBufferWithFault(t *testing.T) {
+	rel := common.Relation{RelFileNumber: 1}
+	tests := []struct {
+		name   string
+		inject func(fi *disk.FaultInjector)
+	}{
+		{
+			name:   "when read fails",
+			inject: func(fi *disk.FaultInjector) { fi.FailRead(1) },
+		},
+		{
+			name:   "when read is short",
+			inject: func(fi *disk.FaultInjector) { fi.ShortRead(1, 100) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fi := disk.NewFaultInjector()
+			m, err := TestingNewManagerWithFaults(fi)
+			assert.Nil(t, err)
+
+			tt.inject(fi)
+			_, err = m.ReadBuffer(rel, disk.ForkNumberMain, page.FirstPageID)
+			assert.NotNil(t, err)
+			testingAssertNoPinNorIO(t, m)
+
+			// the buffer is still invalid, so the page is read again
+			bufID, err := m.ReadBuffer(rel, disk.ForkNumberMain, page.FirstPageID)
+			assert.Nil(t, err)
+			assert.True(t, m.descriptors[bufID].isValid())
+			m.ReleaseBuffer(bufID)
+			testingAssertNoPinNorIO(t, m)
+		})
+	}
+}
+
+func TestBufferAllocWithFlushFault(t *testing.T) {
+	fi := disk.NewFaultInjector()
+	m, err := TestingNewManagerWithFaults(fi)
+	assert.Nil(t, err)
+
+	// the buffer in free list is dirty, so it has to be written out before eviction
+	var bufID BufferID = FirstBufferID
+	m.freeList = bufID
+	for _, desc := range m.descriptors {
+		desc.nextFreeID = freeListNotInList
+	}
+	m.descriptors[bufID] = &descriptor{
+		tag:        *newTag(common.Relation{RelFileNumber: 1}, disk.ForkNumberMain, page.FirstPageID),
+		state:      bmTagValid | bmValid | bmDirty,
+		nextFreeID: freeListInvalidID,
+	}
+	m.table.table[m.descriptors[bufID].tag] = bufID
+
+	fi.FailWrite(1)
+	_, err = m.ReadBuffer(common.Relation{RelFileNumber: 2}, disk.ForkNumberMain, page.FirstPageID)
+	assert.Equal(t, disk.ErrInjected, errors.Cause(err))
+	testingAssertNoPinNorIO(t, m)
+	// the page has not been written out, so the buffer must be still dirty
+	assert.True(t, m.descriptors[bufID].isDirty())
+
+	newBufID, err := m.ReadBuffer(common.Relation{RelFileNumber: 2}, disk.ForkNumberMain, page.FirstPageID)
+	assert.Nil(t, err)
+	m.ReleaseBuffer(newBufID)
+	testingAssertNoPinNorIO(t, m)
+}
+
+func TestSyncOneBufferWithFault(t *testing.T) {
+	rel := common.Relation{RelFileNumber: 1}
+	setup := func(t *testing.T) (*Manager, *disk.FaultInjector, BufferID, page.PagePtr) {
+		fi := disk.NewFaultInjector()
+		m, err := TestingNewManagerWithFaults(fi)
+		assert.Nil(t, err)
+
+		bufID, err := m.ReadBuffer(rel, disk.ForkNumberMain, page.FirstPageID)
+		assert.Nil(t, err)
+		rp, err := page.TestingNewRandomPage()
+		assert.Nil(t, err)
+		m.AcquireContentLock(bufID, true)
+		copy(m.GetPage(bufID)[:], rp[:])
+		m.MarkDirty(bufID)
+		m.ReleaseContentLock(bufID, true)
+		m.ReleaseBuffer(bufID)
+		return m, fi, bufID, rp
+	}
+
+	t.Run("when write fails", func(t *testing.T) {
+		m, fi, bufID, rp := setup(t)
+
+		fi.FailWrite(1)
+		result, err := m.syncOneBuffer(bufID, false)
+		assert.Equal(t, disk.ErrInjected, errors.Cause(err))
+		assert.Zero(t, result&syncResultWritten)
+		assert.True(t, m.descriptors[bufID].isDirty())
+		testingAssertNoPinNorIO(t, m)
+
+		// retry succeeds
+		result, err = m.syncOneBuffer(bufID, false)
+		assert.Nil(t, err)
+		assert.NotZero(t, result&syncResultWritten)
+		assert.False(t, m.descriptors[bufID].isDirty())
+
+		flushed := page.NewPagePtr()
+		assert.Nil(t, m.dm.ReadPage(rel, disk.ForkNumberMain, page.FirstPageID, flushed))
+		assert.True(t, bytes.Equal(rp[:], flushed[:]))
+	})
+	t.Run("when sync fails", func(t *testing.T) {
+		m, fi, bufID, _ := setup(t)
+
+		fi.FailSync(1)
+		_, err := m.syncOneBuffer(bufID, false)
+		assert.Equal(t, disk.ErrInjected, errors.Cause(err))
+		// the page may not be persisted, so the buffer must be still dirty
+		assert.True(t, m.descriptors[bufID].isDirty())
+		testingAssertNoPinNorIO(t, m)
+	})
+	t.Run("when write is torn", func(t *testing.T) {
+		m, fi, bufID, rp := setup(t)
+
+		fi.TearWrite(1, 100)
+		_, err := m.syncOneBuffer(bufID, false)
+		assert.Equal(t, disk.ErrInjected, errors.Cause(err))
+		assert.True(t, m.descriptors[bufID].isDirty())
+		testingAssertNoPinNorIO(t, m)
+
+		// the torn page is not synced, so crash restores the page before the write
+		assert.Nil(t, fi.Crash())
+		onDisk := page.NewPagePtr()
+		assert.Nil(t, m.dm.ReadPage(rel, disk.ForkNumberMain, page.FirstPageID, onDisk))
+		assert.True(t, bytes.Equal(page.NewPagePtr()[:], onDisk[:]))
+
+		// writing out the buffer again repairs the page, and it survives crash
+		_, err = m.syncOneBuffer(bufID, false)
+		assert.Nil(t, err)
+		assert.Nil(t, fi.Crash())
+		assert.Nil(t, m.dm.ReadPage(rel, disk.ForkNumberMain, page.FirstPageID, onDisk))
+		assert.True(t, bytes.Equal(rp[:], onDisk[:]))
+	})
+}
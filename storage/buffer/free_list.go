@@ -1,14 +1,18 @@
 /*
 the implementation of free list
 
-In ppdb, if buffer is removed from free list, the buffer will be never added to free list.
-So this free list thing doesn't make much sense in ppdb.
+Initially all buffers are on free list. Once buffer is removed from free list, it is added to free list again
+only when the page is dropped from the buffer (ex: the relation is truncated. see truncate.go).
 */
 package buffer
 
+import "sync/atomic"
+
 const (
 	// this indicates the end of the free list
 	freeListInvalidID BufferID = -1
+	// this indicates the buffer is not on the free list
+	freeListNotInList BufferID = -2
 )
 
 // allocateFromFreeList returns buffer from free list.
@@ -18,7 +22,8 @@ func (m *Manager) allocateFromFreeList() BufferID {
 	// check the first node without acquiring buffer strategy lock.
 	// if it isn't invalid id, then acquire lock and re-check the first node and return it.
 	// this is kind of optimistic locking.
-	if m.freeList == freeListInvalidID {
+	// the first node is read with atomic operation because it may be updated by other goroutine holding the lock
+	if BufferID(atomic.LoadInt32((*int32)(&m.freeList))) == freeListInvalidID {
 		return freeListInvalidID
 	}
 
@@ -27,12 +32,28 @@ func (m *Manager) allocateFromFreeList() BufferID {
 	bufID := m.freeList
 	// re-check after acquire lock
 	if bufID == freeListInvalidID {
+		m.strategyLock.Unlock()
 		return freeListInvalidID
 	}
 
 	desc := m.descriptors[bufID]
 	// remove first buffer from free list
-	m.freeList = desc.nextFreeID
+	atomic.StoreInt32((*int32)(&m.freeList), int32(desc.nextFreeID))
+	desc.nextFreeID = freeListNotInList
 	m.strategyLock.Unlock()
 	return bufID
 }
+
+// freeBuffer puts the buffer at the head of free list
+// the buffer is expected to be invalidated (the tag is not valid). if it is already on free list, do nothing
+// see https://github.com/postgres/postgres/blob/24d2b2680a8d0e01b30ce8a41c4eb3b47aca5031/src/backend/storage/buffer/freelist.c#L360
+func (m *Manager) freeBuffer(bufID BufferID) {
+	m.strategyLock.Lock()
+	defer m.strategyLock.Unlock()
+	desc := m.descriptors[bufID]
+	if desc.nextFreeID != freeListNotInList {
+		return
+	}
+	desc.nextFreeID = m.freeList
+	atomic.StoreInt32((*int32)(&m.freeList), int32(bufID))
+}
@@ -43,3 +43,17 @@ func TestAllocateBufferFromFreeList_OneElementInFreeList(t *testing.T) {
 		})
 	}
 }
+
+func TestFreeBuffer(t *testing.T) {
+	m, err := TestingNewManagerWithNoFreeList()
+	assert.Nil(t, err)
+
+	m.freeBuffer(BufferID(3))
+	// the buffer already on free list is not added twice
+	m.freeBuffer(BufferID(3))
+	m.freeBuffer(BufferID(5))
+
+	assert.Equal(t, BufferID(5), m.allocateFromFreeList())
+	assert.Equal(t, BufferID(3), m.allocateFromFreeList())
+	assert.Equal(t, freeListInvalidID, m.allocateFromFreeList())
+}
@@ -18,13 +18,11 @@ func (m *Manager) ReadBufferFSM(rel common.Relation, pageID page.PageID, exclusi
 		return InvalidBufferID, errors.Wrap(err, "GetNPageID failed")
 	}
 
-	if pageID > npid {
-		// has to extend the fsm page until pageID
-		// maybe this logic can be optimized
-		for i := npid; i < pageID; i++ {
-			if _, err = m.dm.ExtendPage(rel, disk.ForkNumberFSM, false); err != nil {
-				return InvalidBufferID, errors.Wrap(err, "dm.ExtendPage failed")
-			}
+	// has to extend the fsm page until pageID. the fsm file may be empty (ex: after truncated to 0)
+	// maybe this logic can be optimized
+	for npid == page.InvalidPageID || pageID > npid {
+		if npid, err = m.dm.ExtendPage(rel, disk.ForkNumberFSM, false); err != nil {
+			return InvalidBufferID, errors.Wrap(err, "dm.ExtendPage failed")
 		}
 	}
 
@@ -16,15 +16,31 @@ func TestReadBufferFSM(t *testing.T) {
 	// extend page
 	// the buffer must be allocated from free list and the buffer id must be FirstBufferID
 	expected := page.PageID(10)
-	_, err = m.ReadBufferFSM(common.Relation(1), expected, false)
+	_, err = m.ReadBufferFSM(common.Relation{RelFileNumber: 1}, expected, false)
 	assert.Nil(t, err)
 
 	// confirm page has been extended
-	npid, err := m.dm.GetNPageID(common.Relation(1), disk.ForkNumberFSM)
+	npid, err := m.dm.GetNPageID(common.Relation{RelFileNumber: 1}, disk.ForkNumberFSM)
 	assert.Nil(t, err)
 	assert.Equal(t, expected, npid)
 }
 
+func TestReadBufferFSMWhenEmpty(t *testing.T) {
+	m, err := TestingNewManager()
+	assert.Nil(t, err)
+	rel := common.Relation{RelFileNumber: 1}
+	err = m.Truncate(rel, disk.ForkNumberFSM, 0)
+	assert.Nil(t, err)
+
+	// the empty fsm file is extended from the first page
+	bufID, err := m.ReadBufferFSM(rel, page.FirstPageID, false)
+	assert.Nil(t, err)
+	m.ReleaseBufferFSM(bufID, false)
+	npid, err := m.dm.GetNPageID(rel, disk.ForkNumberFSM)
+	assert.Nil(t, err)
+	assert.Equal(t, page.FirstPageID, npid)
+}
+
 func TestReleaseBufferFSM(t *testing.T) {
 	t.Run("when holding shared content lock", func(t *testing.T) {
 		m, err := TestingNewManager()
@@ -32,7 +48,7 @@ func TestReleaseBufferFSM(t *testing.T) {
 
 		expected := page.PageID(10)
 		exclusive := false
-		bufID, err := m.ReadBufferFSM(common.Relation(1), expected, exclusive)
+		bufID, err := m.ReadBufferFSM(common.Relation{RelFileNumber: 1}, expected, exclusive)
 		assert.Nil(t, err)
 		m.ReleaseBufferFSM(bufID, exclusive)
 	})
@@ -42,7 +58,7 @@ func TestReleaseBufferFSM(t *testing.T) {
 
 		expected := page.PageID(10)
 		exclusive := true
-		bufID, err := m.ReadBufferFSM(common.Relation(1), expected, exclusive)
+		bufID, err := m.ReadBufferFSM(common.Relation{RelFileNumber: 1}, expected, exclusive)
 		assert.Nil(t, err)
 		m.ReleaseBufferFSM(bufID, exclusive)
 	})
@@ -52,13 +52,14 @@ the flow when update/insert the tuples is described below:
 - this can prevent other goroutine from seeing partially updated data
 - which means doing anything with the buffer is atomic to any other goroutines
 
+the flow when physically remove the tuples or compact free space on the page is described below:
+- pin the buffer -> acquire cleanup lock (exclusive content lock + the only pin) -> remove the tuples / compact the page
+- -> release content lock -> unpin the buffer
+- for more details, see /storage/buffer/cleanup.go
+
 TO READ(I haven't read yet):
 update tuple commit status bits
 https://github.com/postgres/postgres/blob/d87251048a0f293ad20cc1fe26ce9f542de105e6/src/backend/storage/buffer/README#L58-L70
-physically remove a tuple or compact free space on a page
-https://github.com/postgres/postgres/blob/d87251048a0f293ad20cc1fe26ce9f542de105e6/src/backend/storage/buffer/README#L72-L81
-LockBufferForCleanup
-https://github.com/postgres/postgres/blob/d87251048a0f293ad20cc1fe26ce9f542de105e6/src/backend/storage/buffer/README#L84-L97
 
 see for more details: https://github.com/postgres/postgres/blob/d87251048a0f293ad20cc1fe26ce9f542de105e6/src/backend/storage/buffer/README#L37-L97
 
@@ -76,6 +77,7 @@ see for more details: https://github.com/postgres/postgres/blob/d87251048a0f293a
 
 - BM_IO_IN_PROGRESS flag:
   - this is kind of lock for each buffer IO
+  - the goroutine waiting for the IO sleeps on condition variable per buffer (see descriptor.go)
 
 - buffer strategy lock (system-wide lock):
   - this protects free-list / select victim buffer for replacement
@@ -117,6 +119,7 @@ package buffer
 
 import (
 	"sync"
+	"sync/atomic"
 
 	"github.com/pkg/errors"
 
@@ -146,6 +149,15 @@ type Manager struct {
 	// strategyLock is buffer strategy lock for free list
 	// in postgres, this also protects clock-sweep algorithm, but ppdb protects only free list(probably)
 	strategyLock sync.Mutex
+	// completePasses is the number of cycles of clock hand
+	// numBufferAllocs is the number of buffer allocations since background writer checked last time
+	// these are used by background writer to estimate how many buffers will be allocated. see bgwriter.go
+	completePasses  uint32
+	numBufferAllocs uint32
+	// stats is statistics counters. see stats.go
+	stats counters
+	// prefetcher reads pages ahead asynchronously. see prefetch.go
+	prefetcher *prefetcher
 }
 
 // NewManager initializes the shared buffer pool manager
@@ -159,6 +171,7 @@ func NewManager(dm *disk.Manager) *Manager {
 		descriptors:      newDescriptors(),
 		freeList:         FirstBufferID,
 		nextVictimBuffer: FirstBufferID,
+		prefetcher:       newPrefetcher(),
 	}
 }
 
@@ -180,7 +193,8 @@ func (m *Manager) ReadBuffer(rel common.Relation, forkNum disk.ForkNumber, pageI
 	}
 	var err error
 	// if pageID passed is NewPageID, extend page and return it
-	if pageID == page.NewPageID {
+	extend := pageID == page.NewPageID
+	if extend {
 		pageID, err = m.dm.ExtendPage(newTag.rel, newTag.forkNum, false)
 		if err != nil {
 			return InvalidBufferID, errors.Wrap(err, "dm.ExtendPage failed")
@@ -189,6 +203,46 @@ func (m *Manager) ReadBuffer(rel common.Relation, forkNum disk.ForkNumber, pageI
 		newTag.pageID = pageID
 	}
 
+	bufID, found, err := m.bufferAlloc(newTag)
+	if err != nil {
+		return InvalidBufferID, errors.Wrap(err, "bufferAlloc failed")
+	}
+	if found {
+		m.stats.countHit(rel)
+		// the page may be being read into the buffer by other goroutine (or prefetch worker)
+		// so wait for the buffer to be valid
+		if err := m.waitBufferValid(bufID); err != nil {
+			m.ReleaseBuffer(bufID)
+			return InvalidBufferID, errors.Wrap(err, "waitBufferValid failed")
+		}
+	} else {
+		// probably here, content lock doesn't have to be acquired because no problem with the update of page? (I've read somewhere like this, but I'm not sure...)
+		// this goroutine has marked io in progress, so read the page without startIO()
+		m.stats.countMiss(rel)
+		if err := m.readPageIntoBuffer(bufID); err != nil {
+			m.ReleaseBuffer(bufID)
+			return InvalidBufferID, errors.Wrap(err, "readPageIntoBuffer failed")
+		}
+	}
+
+	// when the caller reads the pages sequentially, read the following pages ahead asynchronously.
+	// this is issued after the io of the requested page completes. otherwise the other goroutines waiting for
+	// the requested page also wait for read-ahead (it may get the number of pages from disk and allocate victim buffers)
+	// the extended page is not the target because the following pages don't exist
+	if !extend {
+		m.readAhead(newTag)
+	}
+
+	// here, the buffer has been pinned
+	return bufID, nil
+}
+
+// bufferAlloc returns the pinned buffer for the tag
+// when the tag already exists in buffer table, return the buffer with found=true. the page may not be valid yet.
+// when not, allocate the victim buffer and map the tag to it, then return the buffer with found=false.
+// in this case, io in progress is marked on the buffer and the caller is responsible for reading the page into the buffer.
+// see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/buffer/bufmgr.c#L1110
+func (m *Manager) bufferAlloc(newTag tag) (BufferID, bool, error) {
 	m.table.RLock()
 	// check whether the tag already exists in the buffer table. if it exists, just return it
 	if bufID, ok := m.table.table[newTag]; ok {
@@ -197,19 +251,20 @@ func (m *Manager) ReadBuffer(rel common.Relation, forkNum disk.ForkNumber, pageI
 		// TODO: before pin() is called, can be the buffer evicted?
 		m.descriptors[bufID].pin()
 		m.table.RUnlock()
-		return bufID, nil
+		return bufID, true, nil
 	}
 	// unlock the buffer table lock. we don't need it anymore
 	m.table.RUnlock()
 
 	var desc *descriptor
 	var bufID BufferID
+	var err error
 	for {
 		// allocateBuffer() searches free list at first, then if not found, it uses clock sweep
 		// header lock of allocated buffer is held for preventing pinned by other goroutine
 		bufID, err = m.allocateBuffer()
 		if err != nil {
-			return InvalidBufferID, errors.New("allocateBuffer failed")
+			return InvalidBufferID, false, errors.Wrap(err, "allocateBuffer failed")
 		}
 		desc = m.descriptors[bufID]
 		// pin() cannot be used here because the caller holds header lock
@@ -218,7 +273,7 @@ func (m *Manager) ReadBuffer(rel common.Relation, forkNum disk.ForkNumber, pageI
 		// later, re-acquire header lock and check ref count and dirty bit for other goroutines to hold pin or update the content after here
 		// but holding header lock and not releasing it is more efficient? probably I miss something
 		if err := desc.pinWithHeaderLock(); err != nil {
-			return InvalidBufferID, errors.Wrap(err, "pinWithHeaderLock failed")
+			return InvalidBufferID, false, errors.Wrap(err, "pinWithHeaderLock failed")
 		}
 
 		// see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/buffer/bufmgr.c#L1213-L1292
@@ -231,10 +286,13 @@ func (m *Manager) ReadBuffer(rel common.Relation, forkNum disk.ForkNumber, pageI
 
 			// for preventing update of the page by other goroutine, acquire shared content lock
 			m.AcquireContentLock(bufID, false)
-			m.flushBuffer(bufID)
+			err := m.flushBuffer(bufID, writeSourceBackend)
 			m.ReleaseContentLock(bufID, false)
-
-			// postgres doesn't clear dirty bit:
+			if err != nil {
+				desc.unpin()
+				return InvalidBufferID, false, errors.Wrap(err, "flushBuffer failed")
+			}
+			// flushBuffer clears dirty bit unless the buffer is dirtied again during write
 		}
 
 		// postgres acquires lower-numberd partition lock first to avoid deadlocks
@@ -243,45 +301,88 @@ func (m *Manager) ReadBuffer(rel common.Relation, forkNum disk.ForkNumber, pageI
 
 		// acquire global lock for buffer table
 		m.table.Lock()
-		// insert new entry
-		m.table.table[newTag] = bufID
+		// other goroutine may have read the same page into another buffer while this goroutine allocates the victim buffer.
+		// in that case, give up the victim buffer and use the buffer found, otherwise the page is read twice.
+		// see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/buffer/bufmgr.c#L1329-L1370
+		if foundID, ok := m.table.table[newTag]; ok {
+			m.descriptors[foundID].pin()
+			m.table.Unlock()
+			// the victim buffer has not been changed, so just unpin it
+			desc.unpin()
+			// the page may be still being read by other goroutine, so the caller has to wait for it
+			return foundID, true, nil
+		}
 
 		desc.acquireHeaderLock()
 		// if pin is held by other goroutines or the buffer has been updated, resume to select next victim buffer
-		if (desc.referenceCount() == 1) || !(desc.isDirty()) {
+		if (desc.referenceCount() == 1) && !(desc.isDirty()) {
 			// good! this buffer can be evicted and now holding the header lock so other goroutines cannot do anything including pin
 			break
 		}
 		// give up the buffer and continue
 		desc.releaseHeaderLock()
-		// delete the inserted entry
-		delete(m.table.table, newTag)
 		m.table.Unlock()
 		desc.unpin()
 	}
 
 	// here, buffer table is locking and the buffer header lock is held and the buffer is pinned
 	// delete the old buffer tag entry from buffer table
-	delete(m.table.table, desc.tag)
+	// if the buffer has never been used, the tag is not valid and there is no entry to delete
+	state := atomic.LoadUint32(&desc.state)
+	if state&bmTagValid != 0 {
+		delete(m.table.table, desc.tag)
+		atomic.AddUint64(&m.stats.evictions, 1)
+	}
+	// insert new entry
+	m.table.table[newTag] = bufID
+
+	// reset descriptor tag and mark io in progress while holding header lock.
+	// this entry works as placeholder: the page is not read into the buffer yet,
+	// so other goroutines which find this buffer through the new entry wait for this goroutine to read the page
+	desc.tag = newTag
+	state &^= (bmValid | bmDirty | bmJustDirtied)
+	state |= (bmTagValid | bmIOInProgress)
 
 	// postgres releases buffer header lock then deletes the old entry from buffer table
 	// but is it correct? do we have to delete the old entry at first to prevent other goroutines from entering this buffer for old entry? I'm not sure....
-	desc.releaseHeaderLock()
+	desc.releaseHeaderLockWithState(state)
 	m.table.Unlock()
+	return bufID, false, nil
+}
 
-	// probably here, content lock doesn't have to be acquired because no problem with the update of page? (I've read somewhere like this, but I'm not sure...)
-	desc.setIOInProgress()
-	// read page into the buffer
-	if err := m.dm.ReadPage(newTag.rel, newTag.forkNum, pageID, page.PagePtr(m.buffers[bufID][:])); err != nil {
-		return InvalidBufferID, errors.Wrap(err, "dm.ReadPage failed")
+// waitBufferValid waits for the page to be read into the buffer
+// if no goroutine is reading the page, the caller reads the page into the buffer by itself.
+// so only one goroutine executes disk io for the same page and the others wait for it to complete.
+// the caller has to hold pin
+// see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/buffer/bufmgr.c#L1011-L1050
+func (m *Manager) waitBufferValid(bufID BufferID) error {
+	desc := m.descriptors[bufID]
+	if desc.isValid() {
+		return nil
 	}
-	desc.clearIOInProgress()
-
-	// reset descriptor tag
-	m.descriptors[bufID].tag = newTag
+	// startIO waits for the io by other goroutine, and returns false when the page has been read
+	if !desc.startIO(true) {
+		return nil
+	}
+	// the io by other goroutine failed (or nobody has started it), so read the page by itself
+	if err := m.readPageIntoBuffer(bufID); err != nil {
+		return errors.Wrap(err, "readPageIntoBuffer failed")
+	}
+	return nil
+}
 
-	// here, the buffer has been pinned
-	return bufID, nil
+// readPageIntoBuffer reads the page into the buffer and terminates io
+// the caller has to hold pin and mark io in progress
+func (m *Manager) readPageIntoBuffer(bufID BufferID) error {
+	desc := m.descriptors[bufID]
+	// tag is not changed while the buffer is pinned so it can be read without header lock
+	if err := m.dm.ReadPage(desc.tag.rel, desc.tag.forkNum, desc.tag.pageID, page.PagePtr(m.buffers[bufID][:])); err != nil {
+		// wake up the waiters. the buffer is still invalid so one of them retries to read the page
+		desc.terminateIO(false, 0)
+		return errors.Wrap(err, "dm.ReadPage failed")
+	}
+	desc.terminateIO(false, bmValid)
+	return nil
 }
 
 // ReleaseBuffer unpins the buffer
@@ -297,23 +398,42 @@ func (m *Manager) ReleaseBuffer(bufID BufferID) {
 // the caller must hold a pin for preventing eviction
 // and also must hold shared content lock for preventing the content updated during flush.
 // Additionaly, in this function, bmIOInProgress (kind of lock for io) is held during IO
+// source is who writes out the buffer. this is used for statistics
 // see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/buffer/bufmgr.c#L2823
-func (m *Manager) flushBuffer(bufID BufferID) error {
+func (m *Manager) flushBuffer(bufID BufferID, source writeSource) error {
 	desc := m.descriptors[bufID]
 	// TODO: check lsn stored in the page and flush wal if needed
 	// note: buffer policy in postgres is `steal` so commit is not necessary before dirty page is written out to disk.
 	// see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/buffer/bufmgr.c#L2863-L2887
 
-	desc.setIOInProgress()
+	// startIO waits for the write by other goroutine, and returns false when the buffer is not dirty anymore
+	if !desc.startIO(false) {
+		return nil
+	}
 	// write page. fsync don't have to be used since we have WAL (probably)
 	if err := m.dm.WritePage(desc.tag.rel, desc.tag.forkNum, desc.tag.pageID,
 		page.PagePtr(m.buffers[bufID][:]), false); err != nil {
+		// the buffer is still dirty
+		desc.terminateIO(false, 0)
 		return errors.Wrap(err, "dm.WritePage failed")
 	}
-	desc.clearIOInProgress()
+	// clear dirty bit unless the buffer has been dirtied during the write
+	desc.terminateIO(true, 0)
+	m.stats.countWrite(source)
 	return nil
 }
 
+// GetNPageID returns the last page id of the relation fork
+// this is RelationGetNumberOfBlocksInFork() in postgres
+// see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/buffer/bufmgr.c
+func (m *Manager) GetNPageID(rel common.Relation, forkNum disk.ForkNumber) (page.PageID, error) {
+	pageID, err := m.dm.GetNPageID(rel, forkNum)
+	if err != nil {
+		return page.InvalidPageID, errors.Wrap(err, "dm.GetNPageID failed")
+	}
+	return pageID, nil
+}
+
 // GetPage returns page stored at the buffer
 func (m *Manager) GetPage(bufID BufferID) page.PagePtr {
 	buffer := m.buffers[bufID]
@@ -323,6 +443,7 @@ func (m *Manager) GetPage(bufID BufferID) page.PagePtr {
 // allocateBuffer returns victim buffer id where the data will be read into.
 // IMPORTANT: the header lock of the buffer is held
 func (m *Manager) allocateBuffer() (BufferID, error) {
+	atomic.AddUint32(&m.numBufferAllocs, 1)
 	// at first, search free list.
 	// if free buffer exists on the list, remove it from free list and return it
 	if bufferID := m.allocateFromFreeList(); bufferID != InvalidBufferID {
@@ -2,6 +2,7 @@ package buffer
 
 import (
 	"bytes"
+	"sync"
 	"testing"
 
 	"github.com/HayatoShiba/ppdb/common"
@@ -16,22 +17,26 @@ func TestFlushBuffer(t *testing.T) {
 
 	var bufID BufferID = 3
 	// set descriptor for test
+	// the buffer must be dirty to be flushed
 	m.descriptors[bufID] = &descriptor{
-		tag: *newTag(common.Relation(1), disk.ForkNumberMain, page.PageID(3)),
+		tag:   *newTag(common.Relation{RelFileNumber: 1}, disk.ForkNumberMain, page.PageID(3)),
+		state: bmDirty,
 	}
 	// set buffer content for test
 	rp, err := page.TestingNewRandomPage()
 	assert.Nil(t, err)
 	m.buffers[bufID] = buffer(rp)
-	err = m.flushBuffer(bufID)
+	err = m.flushBuffer(bufID, writeSourceBackend)
 	assert.Nil(t, err)
 
 	// check whether the content is flushed to disk
 	flushed := page.NewPagePtr()
-	err = m.dm.ReadPage(common.Relation(1), disk.ForkNumberMain, page.PageID(3), flushed)
+	err = m.dm.ReadPage(common.Relation{RelFileNumber: 1}, disk.ForkNumberMain, page.PageID(3), flushed)
 	assert.Nil(t, err)
 
 	assert.True(t, bytes.Equal(flushed[:], rp[:]))
+	// dirty bit must be cleared after flushed
+	assert.False(t, m.descriptors[bufID].isDirty())
 }
 
 func TestReadBuffer(t *testing.T) {
@@ -39,7 +44,7 @@ func TestReadBuffer(t *testing.T) {
 		m, err := TestingNewManager()
 		assert.Nil(t, err)
 
-		rel := common.Relation(1)
+		rel := common.Relation{RelFileNumber: 1}
 		forkNum := disk.ForkNumberMain
 		npid, err := m.dm.GetNPageID(rel, forkNum)
 		assert.Nil(t, err)
@@ -56,7 +61,7 @@ func TestReadBuffer(t *testing.T) {
 		m, err := TestingNewManager()
 		assert.Nil(t, err)
 
-		rel := common.Relation(1)
+		rel := common.Relation{RelFileNumber: 1}
 		forkNum := disk.ForkNumberMain
 
 		// extend, and write the random contents to the page. (to check whether the contents is fetched into buffer)
@@ -85,7 +90,7 @@ func TestReadBuffer(t *testing.T) {
 		m, err := TestingNewManager()
 		assert.Nil(t, err)
 
-		rel := common.Relation(1)
+		rel := common.Relation{RelFileNumber: 1}
 		forkNum := disk.ForkNumberMain
 
 		// この辺りとかmock allocator作って、dirtyにしたbuffer idを返せるようにしたりすると良いのかもしれない
@@ -141,6 +146,50 @@ func TestReadBuffer(t *testing.T) {
 	})
 }
 
+func TestReadBufferConcurrently(t *testing.T) {
+	m, err := TestingNewManager()
+	assert.Nil(t, err)
+
+	// each relation has one page, so the number of reads of the relation is the number of reads of the page
+	// the number of pages is smaller than bufferNum so that no page is evicted
+	relNum := 50
+	forkNum := disk.ForkNumberMain
+	for i := 0; i < relNum; i++ {
+		// open storage in advance
+		_, err := m.dm.GetNPageID(common.Relation{RelFileNumber: common.RelFileNumber(i)}, forkNum)
+		assert.Nil(t, err)
+	}
+
+	// many goroutines read the same pages at the same time
+	// start channel makes the goroutines start at once to make them miss the same page at the same time
+	goroutineNum := 20
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for g := 0; g < goroutineNum; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			for i := 0; i < relNum; i++ {
+				bufID, err := m.ReadBuffer(common.Relation{RelFileNumber: common.RelFileNumber(i)}, forkNum, page.FirstPageID)
+				assert.Nil(t, err)
+				// the buffer returned must be valid
+				assert.True(t, m.descriptors[bufID].isValid())
+				m.ReleaseBuffer(bufID)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	// each page must be read from disk only once
+	for i := 0; i < relNum; i++ {
+		nread, err := disk.TestingReadCount(m.dm, common.Relation{RelFileNumber: common.RelFileNumber(i)}, forkNum)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, nread, "relation %d", i)
+	}
+}
+
 func TestAllocateBuffer(t *testing.T) {
 	m, err := TestingNewManagerWithOneElementInFreeList()
 	assert.Nil(t, err)
@@ -176,7 +225,7 @@ func TestMarkDirty(t *testing.T) {
 	m, err := TestingNewManager()
 	assert.Nil(t, err)
 
-	bufID, err := m.ReadBuffer(common.Relation(1), disk.ForkNumberMain, page.NewPageID)
+	bufID, err := m.ReadBuffer(common.Relation{RelFileNumber: 1}, disk.ForkNumberMain, page.NewPageID)
 	assert.Nil(t, err)
 
 	assert.False(t, m.descriptors[bufID].isDirty())
@@ -0,0 +1,244 @@
+/*
+Prefetch (read-ahead) reads pages into buffers asynchronously before they are requested.
+
+When a relation is scanned on cold cache, each ReadBuffer() waits for disk io one by one,
+so the scan is bound by io latency. If the following pages are read in background,
+ReadBuffer() finds the pages already resident (or being read) and the latency overlaps.
+
+  - PrefetchBuffer(): the caller knows which page will be read soon, and hints it explicitly.
+  - read-ahead: when the pages of a relation fork are requested sequentially (page id n, n+1, ...),
+    ReadBuffer() prefetches the following pages automatically.
+
+postgres issues posix_fadvise(POSIX_FADV_WILLNEED) and lets the kernel read the page into OS page cache.
+ppdb reads the page into shared buffer on the worker goroutine through disk manager instead,
+because the storage is not always a file (see /storage/disk/storage.go).
+the number of the workers is limited. when all workers are busy, the prefetch is just skipped
+because prefetch is only a hint and the page is read by ReadBuffer() later anyway.
+
+the prefetched buffer is pinned and marked io in progress until the worker completes reading.
+so ReadBuffer() for the page finds the buffer and waits for the io (see waitBufferValid()).
+
+see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/buffer/bufmgr.c#L592-L640
+*/
+package buffer
+
+import (
+	"sync"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/disk"
+	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/pkg/errors"
+)
+
+const (
+	// the max number of the pages read by workers at the same time
+	// this is similar to effective_io_concurrency in postgres
+	prefetchWorkerNum = 4
+	// read-ahead starts when the pages are requested sequentially this number of times
+	readAheadTrigger = 2
+	// read-ahead prefetches the pages up to this distance ahead of the requested page
+	readAheadDistance = 8
+)
+
+// prefetcher manages prefetch workers and sequential access detection for read-ahead
+type prefetcher struct {
+	// workers works as semaphore which limits the number of workers
+	workers chan struct{}
+	// scans is the sequential access state per relation fork. this is protected by mu
+	mu    sync.Mutex
+	scans map[relFork]*scanState
+}
+
+// relFork identifies relation fork
+type relFork struct {
+	rel     common.Relation
+	forkNum disk.ForkNumber
+}
+
+// scanState is the state of sequential access to relation fork
+type scanState struct {
+	// the page requested last time
+	lastPageID page.PageID
+	// how many times the pages have been requested sequentially
+	seqCount int
+	// the pages up to this have been prefetched already
+	prefetchedUpTo page.PageID
+	// the last page id of the relation fork known. InvalidPageID means unknown
+	// this is refreshed only when read-ahead reaches it, because getting it needs disk io (see readAhead())
+	knownLastPageID page.PageID
+}
+
+// newPrefetcher initializes prefetcher
+func newPrefetcher() *prefetcher {
+	return &prefetcher{
+		workers: make(chan struct{}, prefetchWorkerNum),
+		scans:   make(map[relFork]*scanState),
+	}
+}
+
+// PrefetchBuffer reads the page into buffer asynchronously
+// this returns without waiting for the read. later ReadBuffer() finds the page resident.
+// when the page is already stored within a buffer or all workers are busy, do nothing.
+// see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/buffer/bufmgr.c#L592
+func (m *Manager) PrefetchBuffer(rel common.Relation, forkNum disk.ForkNumber, pageID page.PageID) error {
+	if pageID == page.NewPageID {
+		return errors.New("new page cannot be prefetched")
+	}
+	newTag := tag{
+		rel:     rel,
+		forkNum: forkNum,
+		pageID:  pageID,
+	}
+	if _, err := m.prefetch(newTag); err != nil {
+		return errors.Wrap(err, "prefetch failed")
+	}
+	return nil
+}
+
+// prefetch starts reading the page into buffer on worker
+// this returns false when all workers are busy and the prefetch is skipped
+func (m *Manager) prefetch(newTag tag) (bool, error) {
+	// check whether the page already exists in buffer without pin
+	// pin() increments usage count, but the page is not used actually
+	m.table.RLock()
+	_, ok := m.table.table[newTag]
+	m.table.RUnlock()
+	if ok {
+		return true, nil
+	}
+
+	pf := m.prefetcher
+	select {
+	case pf.workers <- struct{}{}:
+	default:
+		// all workers are busy. skip prefetch
+		return false, nil
+	}
+
+	bufID, found, err := m.bufferAlloc(newTag)
+	if err != nil {
+		<-pf.workers
+		return false, errors.Wrap(err, "bufferAlloc failed")
+	}
+	if found {
+		// other goroutine has read the page in the meantime
+		m.ReleaseBuffer(bufID)
+		<-pf.workers
+		return true, nil
+	}
+
+	// the buffer is pinned and marked io in progress by bufferAlloc()
+	// the worker keeps the pin until the read completes, then unpins it
+	go func() {
+		// if the read fails, the buffer remains invalid and ReadBuffer() retries to read the page
+		if err := m.readPageIntoBuffer(bufID); err != nil {
+			m.stats.countPrefetchError()
+		} else {
+			m.stats.countPrefetched()
+		}
+		m.ReleaseBuffer(bufID)
+		<-pf.workers
+	}()
+	return true, nil
+}
+
+// forget discards the sequential access state of the relation fork
+// this is called when the pages of the relation fork are removed (ex: truncation)
+func (pf *prefetcher) forget(rel common.Relation, forkNum disk.ForkNumber) {
+	pf.mu.Lock()
+	delete(pf.scans, relFork{rel: rel, forkNum: forkNum})
+	pf.mu.Unlock()
+}
+
+// forgetDatabase discards the sequential access state of all relation forks of the database
+func (pf *prefetcher) forgetDatabase(db common.Database) {
+	pf.mu.Lock()
+	for key := range pf.scans {
+		if key.rel.Database == db {
+			delete(pf.scans, key)
+		}
+	}
+	pf.mu.Unlock()
+}
+
+// readAhead prefetches the following pages when the pages of the relation fork are requested sequentially
+// this is called by ReadBuffer()
+// pf.mu is not held during disk io (GetNPageID and prefetch), otherwise the scans of unrelated relations are serialized
+// see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/access/heap/heapam.c#L410-L420
+func (m *Manager) readAhead(t tag) {
+	pf := m.prefetcher
+	key := relFork{rel: t.rel, forkNum: t.forkNum}
+
+	pf.mu.Lock()
+	sc, ok := pf.scans[key]
+	if !ok {
+		sc = &scanState{knownLastPageID: page.InvalidPageID}
+		pf.scans[key] = sc
+	}
+	if ok && t.pageID == sc.lastPageID+1 {
+		sc.seqCount++
+	} else {
+		// the sequential access starts (again) from this page
+		sc.seqCount = 1
+		sc.prefetchedUpTo = t.pageID
+	}
+	sc.lastPageID = t.pageID
+	if sc.seqCount < readAheadTrigger {
+		pf.mu.Unlock()
+		return
+	}
+
+	start := t.pageID + 1
+	if sc.prefetchedUpTo >= start {
+		start = sc.prefetchedUpTo + 1
+	}
+	end := t.pageID + readAheadDistance
+	lastPageID := sc.knownLastPageID
+	pf.mu.Unlock()
+
+	// don't prefetch the pages beyond the end of the relation fork
+	// the relation fork may have been extended since the last page id was got, so get it again when it is reached
+	if lastPageID == page.InvalidPageID || start > lastPageID {
+		var err error
+		lastPageID, err = m.dm.GetNPageID(t.rel, t.forkNum)
+		if err != nil {
+			m.stats.countPrefetchError()
+			return
+		}
+		pf.mu.Lock()
+		sc.knownLastPageID = lastPageID
+		pf.mu.Unlock()
+		if lastPageID == page.InvalidPageID {
+			return
+		}
+	}
+	if end > lastPageID {
+		end = lastPageID
+	}
+	if start > end {
+		return
+	}
+
+	// prefetch the pages until all workers are busy
+	// the pages skipped are prefetched next time
+	prefetched := start - 1
+	for pageID := start; pageID <= end; pageID++ {
+		// read-ahead is only a hint, so stop on error. ReadBuffer() reports it later
+		ok, err := m.prefetch(tag{rel: t.rel, forkNum: t.forkNum, pageID: pageID})
+		if err != nil {
+			m.stats.countPrefetchError()
+			break
+		}
+		if !ok {
+			break
+		}
+		prefetched = pageID
+	}
+
+	pf.mu.Lock()
+	if prefetched > sc.prefetchedUpTo {
+		sc.prefetchedUpTo = prefetched
+	}
+	pf.mu.Unlock()
+}
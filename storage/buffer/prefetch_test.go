@@ -0,0 +1,171 @@
+package buffer
+
+import (
+	"testing"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/disk"
+	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/stretchr/testify/assert"
+)
+
+// testingExtendRelation extends the relation fork to have n pages
+func testingExtendRelation(t *testing.T, m *Manager, rel common.Relation, forkNum disk.ForkNumber, n int) {
+	// the storage has one page initially
+	for i := 1; i < n; i++ {
+		_, err := m.dm.ExtendPage(rel, forkNum, true)
+		assert.Nil(t, err)
+	}
+}
+
+// waitPrefetchWorkers waits for all prefetch workers to complete
+// the worker releases its slot after the read completes, so all slots are taken only when no prefetch is in progress
+func waitPrefetchWorkers(m *Manager) {
+	for i := 0; i < prefetchWorkerNum; i++ {
+		m.prefetcher.workers <- struct{}{}
+	}
+	for i := 0; i < prefetchWorkerNum; i++ {
+		<-m.prefetcher.workers
+	}
+}
+
+func TestPrefetchBuffer(t *testing.T) {
+	m, err := TestingNewManager()
+	assert.Nil(t, err)
+	rel := common.Relation{RelFileNumber: 1}
+	forkNum := disk.ForkNumberMain
+
+	err = m.PrefetchBuffer(rel, forkNum, page.FirstPageID)
+	assert.Nil(t, err)
+	waitPrefetchWorkers(m)
+
+	// the page has been read by prefetch and the worker has released the pin
+	// the read by prefetch is not counted as miss
+	stats := m.Stats()
+	assert.Equal(t, uint64(1), stats.Prefetched)
+	assert.Equal(t, uint64(0), stats.Misses)
+	info := m.Snapshot()[FirstBufferID]
+	assert.True(t, info.IsValid)
+	assert.Equal(t, uint32(0), info.PinCount)
+
+	// ReadBuffer finds the page resident
+	bufID, err := m.ReadBuffer(rel, forkNum, page.FirstPageID)
+	assert.Nil(t, err)
+	m.ReleaseBuffer(bufID)
+	cnt, err := disk.TestingReadCount(m.dm, rel, forkNum)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, cnt)
+	stats = m.Stats()
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(0), stats.Misses)
+
+	// prefetch of the resident page does nothing
+	err = m.PrefetchBuffer(rel, forkNum, page.FirstPageID)
+	assert.Nil(t, err)
+	waitPrefetchWorkers(m)
+	cnt, err = disk.TestingReadCount(m.dm, rel, forkNum)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, cnt)
+
+	// new page cannot be prefetched
+	err = m.PrefetchBuffer(rel, forkNum, page.NewPageID)
+	assert.NotNil(t, err)
+}
+
+func TestReadAhead(t *testing.T) {
+	m, err := TestingNewManager()
+	assert.Nil(t, err)
+	rel := common.Relation{RelFileNumber: 1}
+	forkNum := disk.ForkNumberMain
+	pageNum := 20
+	testingExtendRelation(t, m, rel, forkNum, pageNum)
+
+	t.Run("when the pages are read sequentially", func(t *testing.T) {
+		for i := 0; i < pageNum; i++ {
+			bufID, err := m.ReadBuffer(rel, forkNum, page.PageID(i))
+			assert.Nil(t, err)
+			m.ReleaseBuffer(bufID)
+			// wait for the read-ahead so that the next ReadBuffer finds the page resident
+			waitPrefetchWorkers(m)
+		}
+		// each page is read once, and the pages after the trigger are read ahead
+		cnt, err := disk.TestingReadCount(m.dm, rel, forkNum)
+		assert.Nil(t, err)
+		assert.Equal(t, pageNum, cnt)
+		// the pages before the trigger are missed, and the others are read ahead then hit.
+		// each ReadBuffer is counted once as hit or miss
+		stats := m.Stats()
+		assert.Equal(t, uint64(readAheadTrigger), stats.Misses)
+		assert.Equal(t, uint64(pageNum-readAheadTrigger), stats.Hits)
+		assert.Equal(t, uint64(pageNum-readAheadTrigger), stats.Prefetched)
+		assert.Equal(t, uint64(pageNum), stats.Hits+stats.Misses)
+		assert.Equal(t, stats.Hits, stats.Relations[rel].Hits)
+		assert.Equal(t, stats.Misses, stats.Relations[rel].Misses)
+	})
+
+	t.Run("when the pages are read randomly", func(t *testing.T) {
+		rel := common.Relation{RelFileNumber: 2}
+		testingExtendRelation(t, m, rel, forkNum, pageNum)
+		for _, i := range []int{5, 0, 10, 3} {
+			bufID, err := m.ReadBuffer(rel, forkNum, page.PageID(i))
+			assert.Nil(t, err)
+			m.ReleaseBuffer(bufID)
+			waitPrefetchWorkers(m)
+		}
+		// nothing is read ahead
+		cnt, err := disk.TestingReadCount(m.dm, rel, forkNum)
+		assert.Nil(t, err)
+		assert.Equal(t, 4, cnt)
+	})
+}
+
+func TestReadAheadRelationExtended(t *testing.T) {
+	m, err := TestingNewManager()
+	assert.Nil(t, err)
+	rel := common.Relation{RelFileNumber: 1}
+	forkNum := disk.ForkNumberMain
+	pageNum := 20
+	testingExtendRelation(t, m, rel, forkNum, pageNum)
+
+	read := func(from, to int) {
+		for i := from; i < to; i++ {
+			bufID, err := m.ReadBuffer(rel, forkNum, page.PageID(i))
+			assert.Nil(t, err)
+			m.ReleaseBuffer(bufID)
+			waitPrefetchWorkers(m)
+		}
+	}
+	read(0, readAheadTrigger)
+	// the last page id is cached, so it is not got from disk manager for each read-ahead
+	sc := m.prefetcher.scans[relFork{rel: rel, forkNum: forkNum}]
+	assert.Equal(t, page.PageID(pageNum-1), sc.knownLastPageID)
+
+	// the pages extended during the scan are read ahead after the cached last page is reached
+	read(readAheadTrigger, pageNum/2)
+	testingExtendRelation(t, m, rel, forkNum, 11)
+	read(pageNum/2, pageNum+10)
+	assert.Equal(t, page.PageID(pageNum+9), sc.knownLastPageID)
+	cnt, err := disk.TestingReadCount(m.dm, rel, forkNum)
+	assert.Nil(t, err)
+	assert.Equal(t, pageNum+10, cnt)
+	assert.Equal(t, uint64(pageNum+10-readAheadTrigger), m.Stats().Hits)
+}
+
+func TestPrefetchError(t *testing.T) {
+	fi := disk.NewFaultInjector()
+	m, err := TestingNewManagerWithFaults(fi)
+	assert.Nil(t, err)
+	rel := common.Relation{RelFileNumber: 1}
+	forkNum := disk.ForkNumberMain
+
+	fi.FailRead(1)
+	err = m.PrefetchBuffer(rel, forkNum, page.FirstPageID)
+	assert.Nil(t, err)
+	waitPrefetchWorkers(m)
+	// the failure is counted, and the page is read by ReadBuffer
+	assert.Equal(t, uint64(1), m.Stats().PrefetchErrors)
+	assert.Equal(t, uint64(0), m.Stats().Prefetched)
+	bufID, err := m.ReadBuffer(rel, forkNum, page.FirstPageID)
+	assert.Nil(t, err)
+	m.ReleaseBuffer(bufID)
+}
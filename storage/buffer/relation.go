@@ -0,0 +1,36 @@
+package buffer
+
+import (
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/disk"
+	"github.com/pkg/errors"
+)
+
+// CreateRelation creates the relation file
+// this is expected to be called with the transaction (see transaction.Manager.CreateRelation)
+// so that the file is removed when the transaction is aborted
+// see smgrcreate() https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/smgr/smgr.c
+func (m *Manager) CreateRelation(rel common.Relation) error {
+	if err := m.dm.CreateRelation(rel); err != nil {
+		return errors.Wrap(err, "dm.CreateRelation failed")
+	}
+	return nil
+}
+
+// UnlinkRelation removes all fork files of the relation after dropping all buffers of the relation
+// the dirty buffers are not written out because the files are removed anyway
+// the caller must prevent the other goroutines from accessing the relation
+// see smgrdounlinkall() https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/smgr/smgr.c
+func (m *Manager) UnlinkRelation(rel common.Relation) error {
+	m.dropBuffers(func(t tag) bool {
+		return t.rel == rel
+	})
+	for _, forkNum := range []disk.ForkNumber{disk.ForkNumberMain, disk.ForkNumberFSM, disk.ForkNumberVM} {
+		m.prefetcher.forget(rel, forkNum)
+	}
+
+	if err := m.dm.UnlinkRelation(rel); err != nil {
+		return errors.Wrap(err, "dm.UnlinkRelation failed")
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package buffer
+
+import (
+	"testing"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/disk"
+	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateUnlinkRelation(t *testing.T) {
+	m, err := TestingNewManager()
+	assert.Nil(t, err)
+	rel := common.Relation{RelFileNumber: 1}
+
+	err = m.CreateRelation(rel)
+	assert.Nil(t, err)
+	err = m.CreateRelation(rel)
+	assert.NotNil(t, err)
+
+	// the created relation is empty
+	bufID, err := m.ReadBuffer(rel, disk.ForkNumberMain, page.NewPageID)
+	assert.Nil(t, err)
+	assert.Equal(t, page.FirstPageID, m.descriptors[bufID].tag.pageID)
+	m.GetPage(bufID)[100] = 'a'
+	m.MarkDirty(bufID)
+	m.ReleaseBuffer(bufID)
+	fsmBufID, err := m.ReadBufferFSM(rel, page.FirstPageID, false)
+	assert.Nil(t, err)
+	m.ReleaseBufferFSM(fsmBufID, false)
+	other, err := m.ReadBuffer(common.Relation{RelFileNumber: 2}, disk.ForkNumberMain, page.FirstPageID)
+	assert.Nil(t, err)
+	m.ReleaseBuffer(other)
+
+	err = m.UnlinkRelation(rel)
+	assert.Nil(t, err)
+	// all buffers of the relation are dropped
+	for _, desc := range m.descriptors {
+		if desc.tag.rel == rel {
+			assert.False(t, desc.isValid())
+		}
+	}
+	_, ok := m.table.table[tag{rel: common.Relation{RelFileNumber: 2}, forkNum: disk.ForkNumberMain, pageID: page.FirstPageID}]
+	assert.True(t, ok)
+
+	// the relation can be created again, and the old page is not seen
+	err = m.CreateRelation(rel)
+	assert.Nil(t, err)
+	bufID, err = m.ReadBuffer(rel, disk.ForkNumberMain, page.NewPageID)
+	assert.Nil(t, err)
+	assert.Equal(t, page.FirstPageID, m.descriptors[bufID].tag.pageID)
+	assert.Equal(t, byte(0), m.GetPage(bufID)[100])
+	m.ReleaseBuffer(bufID)
+}
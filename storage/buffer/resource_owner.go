@@ -0,0 +1,193 @@
+/*
+Resource owner tracks the pins and content locks held by a unit of work (ex: transaction, query).
+
+ReadBuffer() returns pinned buffer and the caller is responsible for ReleaseBuffer().
+When the caller forgets to unpin the buffer (ex: returns early on error), the buffer cannot be evicted forever
+and finally clock-sweep fails with `all buffers cannot be evicted`.
+Resource owner prevents this:
+  - on abort (or error), release all pins and content locks the unit of work holds
+  - on commit, all pins and content locks are expected to be released already. if not, they are leaked.
+    resource owner releases them and reports the leaks with the location where each one was taken.
+
+the flow with resource owner is described below:
+- ro := m.NewResourceOwner()
+- ro.ReadBuffer() -> ro.AcquireContentLock() -> do anything with the buffer -> ro.ReleaseContentLock() -> ro.ReleaseBuffer()
+- ro.Commit() (or ro.Abort() on error)
+
+postgres implements resource owner for more kinds of resources (relcache, snapshot, file, ...).
+ppdb implements only for buffer pins and content locks.
+(in postgres, content locks are LWLocks and released all at once on abort by LWLockReleaseAll(), not by resource owner)
+see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/utils/resowner/README#L1
+*/
+package buffer
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/disk"
+	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/pkg/errors"
+)
+
+// ResourceOwner tracks pins and content locks held by a unit of work
+// see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/utils/resowner/resowner.c#L50
+type ResourceOwner struct {
+	m *Manager
+	// pins held. the same buffer can be pinned multiple times
+	pins []resource
+	// content locks held
+	locks []resource
+	// resource owner is expected to be used by one goroutine, but lock for safety
+	sync.Mutex
+}
+
+// resource is a pin or a content lock held by the resource owner
+type resource struct {
+	bufID BufferID
+	// exclusive is whether the content lock is exclusive. this is not used for pin
+	exclusive bool
+	// the location where the resource is taken
+	file string
+	line int
+}
+
+// NewResourceOwner initializes resource owner
+func (m *Manager) NewResourceOwner() *ResourceOwner {
+	return &ResourceOwner{
+		m: m,
+	}
+}
+
+// ReadBuffer reads the page into buffer with ReadBuffer() and remembers the pin
+func (ro *ResourceOwner) ReadBuffer(rel common.Relation, forkNum disk.ForkNumber, pageID page.PageID) (BufferID, error) {
+	bufID, err := ro.m.ReadBuffer(rel, forkNum, pageID)
+	if err != nil {
+		return InvalidBufferID, errors.Wrap(err, "ReadBuffer failed")
+	}
+	_, file, line, _ := runtime.Caller(1)
+	ro.Lock()
+	ro.pins = append(ro.pins, resource{bufID: bufID, file: file, line: line})
+	ro.Unlock()
+	return bufID, nil
+}
+
+// ReleaseBuffer unpins the buffer and forgets the pin
+// if the resource owner does not hold pin of the buffer, return error
+func (ro *ResourceOwner) ReleaseBuffer(bufID BufferID) error {
+	ro.Lock()
+	defer ro.Unlock()
+	var ok bool
+	if ro.pins, ok = forget(ro.pins, bufID); !ok {
+		return errors.Errorf("buffer %d is not pinned by the resource owner", bufID)
+	}
+	ro.m.ReleaseBuffer(bufID)
+	return nil
+}
+
+// AcquireContentLock acquires content lock with AcquireContentLock() and remembers the lock
+func (ro *ResourceOwner) AcquireContentLock(bufID BufferID, exclusive bool) {
+	ro.m.AcquireContentLock(bufID, exclusive)
+	_, file, line, _ := runtime.Caller(1)
+	ro.Lock()
+	ro.locks = append(ro.locks, resource{bufID: bufID, exclusive: exclusive, file: file, line: line})
+	ro.Unlock()
+}
+
+// LockBufferForCleanup acquires cleanup lock with LockBufferForCleanup() and remembers the exclusive content lock
+func (ro *ResourceOwner) LockBufferForCleanup(bufID BufferID) error {
+	if err := ro.m.LockBufferForCleanup(bufID); err != nil {
+		return errors.Wrap(err, "LockBufferForCleanup failed")
+	}
+	_, file, line, _ := runtime.Caller(1)
+	ro.Lock()
+	ro.locks = append(ro.locks, resource{bufID: bufID, exclusive: true, file: file, line: line})
+	ro.Unlock()
+	return nil
+}
+
+// ConditionalLockBufferForCleanup acquires cleanup lock with ConditionalLockBufferForCleanup()
+// when it is acquired, remember the exclusive content lock and return true
+func (ro *ResourceOwner) ConditionalLockBufferForCleanup(bufID BufferID) bool {
+	if !ro.m.ConditionalLockBufferForCleanup(bufID) {
+		return false
+	}
+	_, file, line, _ := runtime.Caller(1)
+	ro.Lock()
+	ro.locks = append(ro.locks, resource{bufID: bufID, exclusive: true, file: file, line: line})
+	ro.Unlock()
+	return true
+}
+
+// ReleaseContentLock releases content lock and forgets the lock
+// if the resource owner does not hold the content lock, return error
+func (ro *ResourceOwner) ReleaseContentLock(bufID BufferID, exclusive bool) error {
+	ro.Lock()
+	defer ro.Unlock()
+	for i := len(ro.locks) - 1; i >= 0; i-- {
+		if ro.locks[i].bufID == bufID && ro.locks[i].exclusive == exclusive {
+			ro.locks = append(ro.locks[:i], ro.locks[i+1:]...)
+			ro.m.ReleaseContentLock(bufID, exclusive)
+			return nil
+		}
+	}
+	return errors.Errorf("content lock of buffer %d is not held by the resource owner", bufID)
+}
+
+// Abort releases all pins and content locks held by the resource owner
+// this is expected to be called when the unit of work is aborted or fails with error
+func (ro *ResourceOwner) Abort() {
+	ro.Lock()
+	ro.releaseAll()
+	ro.Unlock()
+}
+
+// Commit checks whether all pins and content locks have been released
+// if not, they are leaked. release them and return error which reports the location where each one was taken.
+// see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/utils/resowner/resowner.c#L538-L553
+func (ro *ResourceOwner) Commit() error {
+	ro.Lock()
+	defer ro.Unlock()
+	if len(ro.pins) == 0 && len(ro.locks) == 0 {
+		return nil
+	}
+	var leaks []string
+	for _, l := range ro.locks {
+		leaks = append(leaks, fmt.Sprintf("content lock leak: buffer %d (%s) locked at %s:%d",
+			l.bufID, ro.m.descriptors[l.bufID].tag, l.file, l.line))
+	}
+	for _, p := range ro.pins {
+		leaks = append(leaks, fmt.Sprintf("buffer refcount leak: buffer %d (%s) pinned at %s:%d",
+			p.bufID, ro.m.descriptors[p.bufID].tag, p.file, p.line))
+	}
+	ro.releaseAll()
+	return errors.New(strings.Join(leaks, "\n"))
+}
+
+// releaseAll releases all content locks and pins
+// content locks are released before pins because content lock is expected to be held with pin
+// the caller must hold the resource owner lock
+func (ro *ResourceOwner) releaseAll() {
+	for i := len(ro.locks) - 1; i >= 0; i-- {
+		ro.m.ReleaseContentLock(ro.locks[i].bufID, ro.locks[i].exclusive)
+	}
+	ro.locks = nil
+	for i := len(ro.pins) - 1; i >= 0; i-- {
+		ro.m.ReleaseBuffer(ro.pins[i].bufID)
+	}
+	ro.pins = nil
+}
+
+// forget removes the last resource of the buffer from resources
+// return false when no resource of the buffer exists
+func forget(resources []resource, bufID BufferID) ([]resource, bool) {
+	for i := len(resources) - 1; i >= 0; i-- {
+		if resources[i].bufID == bufID {
+			return append(resources[:i], resources[i+1:]...), true
+		}
+	}
+	return resources, false
+}
@@ -0,0 +1,81 @@
+package buffer
+
+import (
+	"testing"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/disk"
+	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResourceOwnerCommit(t *testing.T) {
+	t.Run("when all resources are released", func(t *testing.T) {
+		m, err := TestingNewManager()
+		assert.Nil(t, err)
+		ro := m.NewResourceOwner()
+
+		bufID, err := ro.ReadBuffer(common.Relation{RelFileNumber: 1}, disk.ForkNumberMain, page.NewPageID)
+		assert.Nil(t, err)
+		ro.AcquireContentLock(bufID, true)
+		assert.Nil(t, ro.ReleaseContentLock(bufID, true))
+		assert.Nil(t, ro.ReleaseBuffer(bufID))
+
+		assert.Nil(t, ro.Commit())
+		assert.Equal(t, uint32(0), m.descriptors[bufID].referenceCount())
+	})
+	t.Run("when pin and content lock are leaked", func(t *testing.T) {
+		m, err := TestingNewManager()
+		assert.Nil(t, err)
+		ro := m.NewResourceOwner()
+
+		bufID, err := ro.ReadBuffer(common.Relation{RelFileNumber: 1}, disk.ForkNumberMain, page.NewPageID)
+		assert.Nil(t, err)
+		ro.AcquireContentLock(bufID, false)
+
+		err = ro.Commit()
+		assert.NotNil(t, err)
+		// the leaks are reported with the location where they were taken
+		assert.Contains(t, err.Error(), "buffer refcount leak")
+		assert.Contains(t, err.Error(), "content lock leak")
+		assert.Contains(t, err.Error(), "resource_owner_test.go")
+
+		// the leaked resources are released
+		assert.Equal(t, uint32(0), m.descriptors[bufID].referenceCount())
+		assert.True(t, m.descriptors[bufID].contentLock.TryLock())
+	})
+}
+
+func TestResourceOwnerAbort(t *testing.T) {
+	m, err := TestingNewManager()
+	assert.Nil(t, err)
+	ro := m.NewResourceOwner()
+
+	// pin the same buffer twice and lock it
+	bufID, err := ro.ReadBuffer(common.Relation{RelFileNumber: 1}, disk.ForkNumberMain, page.NewPageID)
+	assert.Nil(t, err)
+	bufID2, err := ro.ReadBuffer(common.Relation{RelFileNumber: 1}, disk.ForkNumberMain, m.descriptors[bufID].tag.pageID)
+	assert.Nil(t, err)
+	assert.Equal(t, bufID, bufID2)
+	ro.AcquireContentLock(bufID, true)
+	assert.Equal(t, uint32(2), m.descriptors[bufID].referenceCount())
+
+	ro.Abort()
+	assert.Equal(t, uint32(0), m.descriptors[bufID].referenceCount())
+	assert.True(t, m.descriptors[bufID].contentLock.TryLock())
+	// nothing is reported after abort
+	assert.Nil(t, ro.Commit())
+}
+
+func TestResourceOwnerRelease(t *testing.T) {
+	m, err := TestingNewManager()
+	assert.Nil(t, err)
+	ro := m.NewResourceOwner()
+
+	// the buffer not pinned by the resource owner cannot be released
+	bufID, err := m.ReadBuffer(common.Relation{RelFileNumber: 1}, disk.ForkNumberMain, page.NewPageID)
+	assert.Nil(t, err)
+	assert.NotNil(t, ro.ReleaseBuffer(bufID))
+	assert.NotNil(t, ro.ReleaseContentLock(bufID, false))
+	assert.Equal(t, uint32(1), m.descriptors[bufID].referenceCount())
+}
@@ -0,0 +1,59 @@
+/*
+Spin delay for buffer header lock.
+
+Buffer header lock is spin lock, and in most cases it is expected to be released soon.
+So the goroutine waiting for the lock just spins for a while without yielding.
+But when the lock is held for a long time (for example, the holder has been descheduled),
+spinning forever just burns CPU. So after spinning some times, the waiter sleeps with backoff.
+
+postgres adjusts spins_per_delay dynamically, but ppdb uses fixed value for simplicity.
+see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/lmgr/s_lock.c#L1-L47
+*/
+package buffer
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	// the number of spins before sleep
+	// default is 100 in postgres
+	spinsPerDelay = 100
+	// min delay of sleep
+	// postgres uses 1ms, but goroutine is cheaper than process so start with smaller value
+	minSpinDelay = 10 * time.Microsecond
+	// max delay of sleep
+	// when delay exceeds this, delay is reset to minSpinDelay
+	maxSpinDelay = time.Millisecond
+)
+
+// spinDelay is the status of spin
+// this is called SpinDelayStatus in postgres
+type spinDelay struct {
+	// the number of spins since last sleep
+	spins int
+	// current delay. 0 means the waiter has never slept
+	delay time.Duration
+}
+
+// perform is called after each failed attempt to acquire spin lock.
+// this just returns while the number of spins is small, and sleeps after that.
+// see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/lmgr/s_lock.c#L130
+func (sd *spinDelay) perform() {
+	sd.spins++
+	if sd.spins < spinsPerDelay {
+		return
+	}
+	if sd.delay == 0 {
+		sd.delay = minSpinDelay
+	}
+	time.Sleep(sd.delay)
+	// increase delay by a random fraction between 1X and 2X
+	// the randomness avoids that the waiters wake up at the same time
+	sd.delay += time.Duration(float64(sd.delay) * rand.Float64())
+	if sd.delay > maxSpinDelay {
+		sd.delay = minSpinDelay
+	}
+	sd.spins = 0
+}
@@ -0,0 +1,23 @@
+package buffer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpinDelayPerform(t *testing.T) {
+	var sd spinDelay
+	// it doesn't sleep until spinsPerDelay
+	for i := 0; i < spinsPerDelay-1; i++ {
+		sd.perform()
+	}
+	assert.Equal(t, spinsPerDelay-1, sd.spins)
+	assert.Equal(t, 0, int(sd.delay))
+
+	// sleep and increase delay
+	sd.perform()
+	assert.Equal(t, 0, sd.spins)
+	assert.GreaterOrEqual(t, sd.delay, minSpinDelay)
+	assert.LessOrEqual(t, sd.delay, maxSpinDelay)
+}
@@ -0,0 +1,233 @@
+/*
+Buffer cache introspection.
+
+This provides two kinds of information about shared buffer pool:
+  - snapshot of each buffer: which page the buffer stores, dirty bit, pin count, usage count, io state.
+    this is decoded from the state field of descriptor. (pg_buffercache extension in postgres)
+  - statistics: hits/misses, evictions, dirty writes by backends/background writer and the breakdown per relation.
+    (pg_stat_bgwriter/pg_statio_user_tables in postgres)
+
+This is useful to know why cache hit rate is bad. ex:
+- hit rate is low and evictions are many: buffer pool is too small for the working set
+- backend writes are many: background writer doesn't write out dirty buffers enough ahead of clock-sweep
+
+see https://www.postgresql.org/docs/current/pgbuffercache.html
+see https://www.postgresql.org/docs/current/monitoring-stats.html#MONITORING-PG-STAT-BGWRITER-VIEW
+*/
+package buffer
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/disk"
+	"github.com/HayatoShiba/ppdb/storage/page"
+)
+
+// BufferInfo is the snapshot of each buffer
+// see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/contrib/pg_buffercache/pg_buffercache_pages.c#L35-L55
+type BufferInfo struct {
+	BufferID BufferID
+	// IsUsed indicates the buffer stores a page. if false, the fields about the page are meaningless
+	IsUsed bool
+	// the location of the page stored in the buffer
+	Relation common.Relation
+	ForkNum  disk.ForkNumber
+	PageID   page.PageID
+	// IsValid indicates the page has been read into the buffer
+	IsValid bool
+	IsDirty bool
+	// PinCount is reference count
+	PinCount   uint32
+	UsageCount uint32
+	// IOInProgress indicates the page is being read into the buffer or written out to disk
+	IOInProgress bool
+}
+
+// Snapshot returns the snapshot of all buffers
+// each buffer is inspected with header lock, so the snapshot is consistent per buffer but not across buffers.
+// see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/contrib/pg_buffercache/pg_buffercache_pages.c#L150-L186
+func (m *Manager) Snapshot() []BufferInfo {
+	infos := make([]BufferInfo, bufferNum)
+	for i := FirstBufferID; i <= MaxBufferID; i++ {
+		desc := m.descriptors[i]
+		desc.acquireHeaderLock()
+		state := atomic.LoadUint32(&desc.state)
+		t := desc.tag
+		desc.releaseHeaderLock()
+
+		infos[i] = decodeBufferInfo(i, t, state)
+	}
+	return infos
+}
+
+// decodeBufferInfo decodes state field of descriptor into BufferInfo
+func decodeBufferInfo(bufID BufferID, t tag, state uint32) BufferInfo {
+	// state is already loaded, so decode it with temporary descriptor
+	d := &descriptor{state: state}
+	info := BufferInfo{
+		BufferID:     bufID,
+		IsUsed:       state&bmTagValid != 0,
+		IsValid:      state&bmValid != 0,
+		IsDirty:      state&bmDirty != 0,
+		PinCount:     d.referenceCount(),
+		UsageCount:   d.usageCount(),
+		IOInProgress: state&bmIOInProgress != 0,
+	}
+	if info.IsUsed {
+		info.Relation = t.rel
+		info.ForkNum = t.forkNum
+		info.PageID = t.pageID
+	}
+	return info
+}
+
+// writeSource is who writes out the dirty buffer
+type writeSource int
+
+const (
+	// backend writes out the dirty buffer when it is evicted
+	writeSourceBackend writeSource = iota
+	// background writer writes out the dirty buffer ahead of time
+	writeSourceBgWriter
+)
+
+// counters is statistics counters of buffer manager
+// each counter is updated with atomic operation
+type counters struct {
+	// the number of times the page is found in buffer
+	hits uint64
+	// the number of times the page is read from disk
+	misses uint64
+	// the number of times the valid page is evicted from buffer
+	evictions uint64
+	// the number of dirty buffers written out by each source
+	backendWrites  uint64
+	bgWriterWrites uint64
+	// the number of pages read from disk by prefetch. they are not counted as misses
+	prefetched uint64
+	// the number of prefetches which failed. the page is read by ReadBuffer() later
+	prefetchErrors uint64
+	// per relation counters. common.Relation -> *relationCounters
+	relations sync.Map
+}
+
+// relationCounters is statistics counters per relation
+type relationCounters struct {
+	hits   uint64
+	misses uint64
+}
+
+// countHit counts hit of the page of the relation
+func (c *counters) countHit(rel common.Relation) {
+	atomic.AddUint64(&c.hits, 1)
+	atomic.AddUint64(&c.relation(rel).hits, 1)
+}
+
+// countMiss counts miss of the page of the relation
+func (c *counters) countMiss(rel common.Relation) {
+	atomic.AddUint64(&c.misses, 1)
+	atomic.AddUint64(&c.relation(rel).misses, 1)
+}
+
+// countWrite counts dirty write by the source
+func (c *counters) countWrite(source writeSource) {
+	if source == writeSourceBgWriter {
+		atomic.AddUint64(&c.bgWriterWrites, 1)
+		return
+	}
+	atomic.AddUint64(&c.backendWrites, 1)
+}
+
+// countPrefetched counts the page read by prefetch
+func (c *counters) countPrefetched() {
+	atomic.AddUint64(&c.prefetched, 1)
+}
+
+// countPrefetchError counts the failure of prefetch
+func (c *counters) countPrefetchError() {
+	atomic.AddUint64(&c.prefetchErrors, 1)
+}
+
+// relation returns the counters of the relation
+func (c *counters) relation(rel common.Relation) *relationCounters {
+	if rc, ok := c.relations.Load(rel); ok {
+		return rc.(*relationCounters)
+	}
+	rc, _ := c.relations.LoadOrStore(rel, &relationCounters{})
+	return rc.(*relationCounters)
+}
+
+// Stats is statistics of buffer manager
+// Hits and Misses are counted by ReadBuffer(), so each read of the page is counted once.
+// the page read by prefetch is counted as Prefetched, and then as hit when ReadBuffer() finds it
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	// dirty buffers written out by backends (when evicted) and background writer
+	BackendWrites  uint64
+	BgWriterWrites uint64
+	// Prefetched is the number of pages read from disk by prefetch (PrefetchBuffer and read-ahead)
+	Prefetched uint64
+	// PrefetchErrors is the number of prefetches (PrefetchBuffer and read-ahead) which failed
+	// the failing prefetch doesn't break reads, but the latency is not hidden
+	PrefetchErrors uint64
+	// the breakdown per relation
+	Relations map[common.Relation]RelationStats
+}
+
+// RelationStats is statistics per relation
+type RelationStats struct {
+	Hits   uint64
+	Misses uint64
+	// the number of buffers which store the pages of the relation currently
+	Buffers int
+	// the number of dirty buffers of the relation currently
+	DirtyBuffers int
+}
+
+// HitRate returns cache hit rate. if no page has been read, return 0
+func (s Stats) HitRate() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+// Stats returns statistics of buffer manager
+// the number of buffers per relation is calculated from the snapshot of buffers
+func (m *Manager) Stats() Stats {
+	stats := Stats{
+		Hits:           atomic.LoadUint64(&m.stats.hits),
+		Misses:         atomic.LoadUint64(&m.stats.misses),
+		Evictions:      atomic.LoadUint64(&m.stats.evictions),
+		BackendWrites:  atomic.LoadUint64(&m.stats.backendWrites),
+		BgWriterWrites: atomic.LoadUint64(&m.stats.bgWriterWrites),
+		Prefetched:     atomic.LoadUint64(&m.stats.prefetched),
+		PrefetchErrors: atomic.LoadUint64(&m.stats.prefetchErrors),
+		Relations:      make(map[common.Relation]RelationStats),
+	}
+	m.stats.relations.Range(func(key, value any) bool {
+		rc := value.(*relationCounters)
+		stats.Relations[key.(common.Relation)] = RelationStats{
+			Hits:   atomic.LoadUint64(&rc.hits),
+			Misses: atomic.LoadUint64(&rc.misses),
+		}
+		return true
+	})
+	for _, info := range m.Snapshot() {
+		if !info.IsUsed {
+			continue
+		}
+		rs := stats.Relations[info.Relation]
+		rs.Buffers++
+		if info.IsDirty {
+			rs.DirtyBuffers++
+		}
+		stats.Relations[info.Relation] = rs
+	}
+	return stats
+}
@@ -0,0 +1,102 @@
+package buffer
+
+import (
+	"testing"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/disk"
+	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	m, err := TestingNewManager()
+	assert.Nil(t, err)
+
+	rel := common.Relation{RelFileNumber: 1}
+	bufID, err := m.ReadBuffer(rel, disk.ForkNumberMain, page.NewPageID)
+	assert.Nil(t, err)
+	m.MarkDirty(bufID)
+
+	infos := m.Snapshot()
+	assert.Equal(t, bufferNum, len(infos))
+
+	info := infos[bufID]
+	assert.Equal(t, bufID, info.BufferID)
+	assert.True(t, info.IsUsed)
+	assert.Equal(t, rel, info.Relation)
+	assert.Equal(t, disk.ForkNumberMain, info.ForkNum)
+	assert.Equal(t, m.descriptors[bufID].tag.pageID, info.PageID)
+	assert.True(t, info.IsValid)
+	assert.True(t, info.IsDirty)
+	assert.Equal(t, uint32(1), info.PinCount)
+	assert.Equal(t, uint32(1), info.UsageCount)
+	assert.False(t, info.IOInProgress)
+
+	// the buffer never used
+	unused := infos[bufID+1]
+	assert.False(t, unused.IsUsed)
+	assert.False(t, unused.IsValid)
+	assert.Equal(t, uint32(0), unused.PinCount)
+}
+
+func TestStats(t *testing.T) {
+	m, err := TestingNewManager()
+	assert.Nil(t, err)
+
+	rel1 := common.Relation{RelFileNumber: 1}
+	rel2 := common.Relation{RelFileNumber: 2}
+	forkNum := disk.ForkNumberMain
+
+	// miss
+	bufID, err := m.ReadBuffer(rel1, forkNum, page.FirstPageID)
+	assert.Nil(t, err)
+	m.MarkDirty(bufID)
+	m.ReleaseBuffer(bufID)
+	// hit twice
+	for i := 0; i < 2; i++ {
+		bufID, err = m.ReadBuffer(rel1, forkNum, page.FirstPageID)
+		assert.Nil(t, err)
+		m.ReleaseBuffer(bufID)
+	}
+	// miss
+	bufID2, err := m.ReadBuffer(rel2, forkNum, page.FirstPageID)
+	assert.Nil(t, err)
+	m.ReleaseBuffer(bufID2)
+
+	// written by background writer
+	// the buffer is used recently, so don't skip it
+	result, err := m.syncOneBuffer(bufID, false)
+	assert.Nil(t, err)
+	assert.NotZero(t, result&syncResultWritten)
+
+	stats := m.Stats()
+	assert.Equal(t, uint64(2), stats.Hits)
+	assert.Equal(t, uint64(2), stats.Misses)
+	assert.Equal(t, uint64(0), stats.Evictions)
+	assert.Equal(t, uint64(0), stats.BackendWrites)
+	assert.Equal(t, uint64(1), stats.BgWriterWrites)
+	assert.Equal(t, 0.5, stats.HitRate())
+	assert.Equal(t, RelationStats{Hits: 2, Misses: 1, Buffers: 1, DirtyBuffers: 0}, stats.Relations[rel1])
+	assert.Equal(t, RelationStats{Hits: 0, Misses: 1, Buffers: 1, DirtyBuffers: 0}, stats.Relations[rel2])
+}
+
+func TestStatsEviction(t *testing.T) {
+	m, err := TestingNewManagerWithNoFreeList()
+	assert.Nil(t, err)
+
+	rel := common.Relation{RelFileNumber: 1}
+	forkNum := disk.ForkNumberMain
+	// fill all buffers with the dirty pages, then read one more page to evict the dirty page
+	for i := 0; i < bufferNum+1; i++ {
+		bufID, err := m.ReadBuffer(rel, forkNum, page.NewPageID)
+		assert.Nil(t, err)
+		m.MarkDirty(bufID)
+		m.ReleaseBuffer(bufID)
+	}
+	stats := m.Stats()
+	assert.Equal(t, uint64(bufferNum+1), stats.Misses)
+	assert.Equal(t, uint64(1), stats.Evictions)
+	assert.Equal(t, uint64(1), stats.BackendWrites)
+	assert.Equal(t, bufferNum, stats.Relations[rel].Buffers)
+}
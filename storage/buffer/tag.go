@@ -1,6 +1,8 @@
 package buffer
 
 import (
+	"fmt"
+
 	"github.com/HayatoShiba/ppdb/common"
 	"github.com/HayatoShiba/ppdb/storage/disk"
 	"github.com/HayatoShiba/ppdb/storage/page"
@@ -10,7 +12,7 @@ import (
 // buffer tag must be sufficient to locate where the page is on disk
 // see https://github.com/postgres/postgres/blob/a448e49bcbe40fb72e1ed85af910dd216d45bad8/src/include/storage/buf_internals.h#L79-L98
 type tag struct {
-	// relation
+	// relation (tablespace, database and relfilenumber)
 	rel common.Relation
 	// fork number
 	forkNum disk.ForkNumber
@@ -26,3 +28,8 @@ func newTag(rel common.Relation, forkNum disk.ForkNumber, pageID page.PageID) *t
 		pageID:  pageID,
 	}
 }
+
+// String returns the location of the page for logging
+func (t tag) String() string {
+	return fmt.Sprintf("rel %s fork %d page %d", t.rel, t.forkNum, t.pageID)
+}
@@ -14,6 +14,15 @@ func TestingNewManager() (*Manager, error) {
 	return NewManager(dm), nil
 }
 
+// TestingNewManagerWithFaults initializes the shared buffer manager whose disk io fails as scheduled by the injector
+func TestingNewManagerWithFaults(fi *disk.FaultInjector) (*Manager, error) {
+	dm, err := disk.TestingNewFaultBufferManager(fi)
+	if err != nil {
+		return nil, errors.Wrap(err, "disk.TestingNewFaultBufferManager failed")
+	}
+	return NewManager(dm), nil
+}
+
 // TestingNewManagerWithNoFreeList initializes the shared buffer manager with no free list
 func TestingNewManagerWithNoFreeList() (*Manager, error) {
 	dm, err := disk.TestingNewBufferManager()
@@ -22,6 +31,9 @@ func TestingNewManagerWithNoFreeList() (*Manager, error) {
 	}
 	m := NewManager(dm)
 	m.freeList = freeListInvalidID
+	for _, desc := range m.descriptors {
+		desc.nextFreeID = freeListNotInList
+	}
 	return m, nil
 }
 
@@ -33,6 +45,9 @@ func TestingNewManagerWithOneElementInFreeList() (*Manager, error) {
 	}
 	m := NewManager(dm)
 	m.freeList = FirstBufferID
+	for _, desc := range m.descriptors {
+		desc.nextFreeID = freeListNotInList
+	}
 	m.descriptors[FirstBufferID].nextFreeID = freeListInvalidID
 	return m, nil
 }
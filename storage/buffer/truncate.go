@@ -0,0 +1,118 @@
+/*
+Relation truncation removes the pages at the end of the relation fork (ex: vacuum returns the trailing empty pages to OS).
+
+Before the file is truncated, the buffers of the removed pages have to be dropped from shared buffer pool.
+otherwise,
+  - the dirty buffer is written out later (by eviction or background writer) and the file is extended again
+  - the page is extended again later, but ReadBuffer() finds the old page in the buffer
+
+the buffers are dropped without being written out, because the pages are removed anyway.
+
+the caller must prevent the other goroutines from accessing the removed pages during truncation
+(postgres holds AccessExclusiveLock on the relation. ppdb doesn't implement relation lock yet).
+
+see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/buffer/bufmgr.c#L3199
+*/
+package buffer
+
+import (
+	"sync/atomic"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/disk"
+	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/pkg/errors"
+)
+
+// Truncate truncates the relation fork to nPages pages after dropping the buffers of the removed pages
+// see smgrtruncate() https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/smgr/smgr.c
+func (m *Manager) Truncate(rel common.Relation, forkNum disk.ForkNumber, nPages int) error {
+	if nPages < 0 {
+		return errors.Errorf("the number of pages is negative: %d", nPages)
+	}
+	m.dropRelationBuffers(rel, forkNum, page.PageID(nPages))
+	// the read-ahead state may point to the removed pages
+	m.prefetcher.forget(rel, forkNum)
+
+	if err := m.dm.Truncate(rel, forkNum, nPages); err != nil {
+		return errors.Wrap(err, "dm.Truncate failed")
+	}
+	return nil
+}
+
+// dropRelationBuffers removes the pages from firstDelPageID of the relation fork from buffer pool
+// the dirty buffers are not written out
+// postgres looks up the buffer table for each page when the number of pages to drop is small,
+// but ppdb always scans all buffers for simplicity
+// see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/buffer/bufmgr.c#L3199
+func (m *Manager) dropRelationBuffers(rel common.Relation, forkNum disk.ForkNumber, firstDelPageID page.PageID) {
+	m.dropBuffers(func(t tag) bool {
+		return t.rel == rel && t.forkNum == forkNum && t.pageID >= firstDelPageID
+	})
+}
+
+// dropBuffers removes the pages whose tag matches from buffer pool without writing out
+// the buffer being read by prefetch worker is pinned until the read completes, so invalidateBuffer() waits for it
+func (m *Manager) dropBuffers(match func(t tag) bool) {
+	for bufID := FirstBufferID; bufID < bufferNum; bufID++ {
+		desc := m.descriptors[bufID]
+		// the buffer which has never been used can be skipped without header lock
+		if atomic.LoadUint32(&desc.state)&bmTagValid == 0 {
+			continue
+		}
+		// postgres checks the tag without header lock at first because most buffers don't match,
+		// but ppdb acquires header lock because the tag is not read atomically
+		desc.acquireHeaderLock()
+		if atomic.LoadUint32(&desc.state)&bmTagValid != 0 && match(desc.tag) {
+			// invalidateBuffer releases header lock
+			m.invalidateBuffer(bufID)
+		} else {
+			desc.releaseHeaderLock()
+		}
+	}
+}
+
+// invalidateBuffer removes the page from the buffer and puts the buffer on free list
+// the page is just discarded even if it is dirty
+// the caller must hold header lock. this releases header lock
+// if the buffer is pinned by other goroutine, wait for it to be unpinned.
+// the pin is expected to be held only for a short time (ex: the page is being written out)
+// see https://github.com/postgres/postgres/blob/d9d873bac67047cfacc9f5ef96ee488f2cb0f1c3/src/backend/storage/buffer/bufmgr.c#L1490
+func (m *Manager) invalidateBuffer(bufID BufferID) {
+	desc := m.descriptors[bufID]
+	oldTag := desc.tag
+	desc.releaseHeaderLock()
+
+	var sd spinDelay
+	for {
+		// the buffer table lock has to be acquired before header lock to delete the entry
+		m.table.Lock()
+		desc.acquireHeaderLock()
+		state := atomic.LoadUint32(&desc.state)
+		if state&bmTagValid == 0 || desc.tag != oldTag {
+			// other goroutine has evicted the page in the meantime. nothing to do
+			desc.releaseHeaderLock()
+			m.table.Unlock()
+			return
+		}
+		if desc.referenceCount() == 0 {
+			// clear the tag and the flags. usage count is reset too
+			delete(m.table.table, oldTag)
+			desc.tag = tag{}
+			state -= desc.usageCount() * usageCountOne
+			desc.releaseHeaderLockWithState(state &^ (bmTagValid | bmValid | bmDirty | bmJustDirtied))
+			m.table.Unlock()
+			break
+		}
+		desc.releaseHeaderLock()
+		m.table.Unlock()
+
+		// wait for the pin to be released
+		if desc.isIOInProgress() {
+			desc.waitIO()
+		} else {
+			sd.perform()
+		}
+	}
+	m.freeBuffer(bufID)
+}
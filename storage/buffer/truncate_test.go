@@ -0,0 +1,94 @@
+package buffer
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/disk"
+	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncate(t *testing.T) {
+	m, err := TestingNewManager()
+	assert.Nil(t, err)
+	rel := common.Relation{RelFileNumber: 1}
+	forkNum := disk.ForkNumberMain
+
+	// the storage has one page initially. extend it to 4 pages and dirty all of them
+	bufIDs := make([]BufferID, 4)
+	for i := range bufIDs {
+		pageID := page.PageID(i)
+		if i > 0 {
+			pageID = page.NewPageID
+		}
+		bufID, err := m.ReadBuffer(rel, forkNum, pageID)
+		assert.Nil(t, err)
+		m.AcquireContentLock(bufID, true)
+		m.GetPage(bufID)[100] = 'a'
+		m.MarkDirty(bufID)
+		m.ReleaseContentLock(bufID, true)
+		m.ReleaseBuffer(bufID)
+		bufIDs[i] = bufID
+	}
+	// the buffer of other relation is not dropped
+	other, err := m.ReadBuffer(common.Relation{RelFileNumber: 2}, forkNum, page.FirstPageID)
+	assert.Nil(t, err)
+	m.ReleaseBuffer(other)
+
+	err = m.Truncate(rel, forkNum, 2)
+	assert.Nil(t, err)
+
+	npid, err := m.dm.GetNPageID(rel, forkNum)
+	assert.Nil(t, err)
+	assert.Equal(t, page.PageID(1), npid)
+	for i, bufID := range bufIDs {
+		_, ok := m.table.table[tag{rel: rel, forkNum: forkNum, pageID: page.PageID(i)}]
+		state := atomic.LoadUint32(&m.descriptors[bufID].state)
+		if i < 2 {
+			assert.True(t, ok)
+			assert.NotZero(t, state&bmDirty)
+			continue
+		}
+		// the dropped buffer is invalidated without written out, and put on free list
+		assert.False(t, ok)
+		assert.Zero(t, state&(bmTagValid|bmValid|bmDirty))
+		assert.NotEqual(t, freeListNotInList, m.descriptors[bufID].nextFreeID)
+	}
+	_, ok := m.table.table[tag{rel: common.Relation{RelFileNumber: 2}, forkNum: forkNum, pageID: page.FirstPageID}]
+	assert.True(t, ok)
+
+	// the removed page is extended again as new page. the old content must not be seen
+	bufID, err := m.ReadBuffer(rel, forkNum, page.NewPageID)
+	assert.Nil(t, err)
+	assert.Equal(t, page.PageID(2), m.descriptors[bufID].tag.pageID)
+	assert.Equal(t, byte(0), m.GetPage(bufID)[100])
+	m.ReleaseBuffer(bufID)
+
+	err = m.Truncate(rel, forkNum, -1)
+	assert.NotNil(t, err)
+}
+
+func TestTruncateWaitsForPin(t *testing.T) {
+	m, err := TestingNewManager()
+	assert.Nil(t, err)
+	rel := common.Relation{RelFileNumber: 1}
+	forkNum := disk.ForkNumberMain
+
+	bufID, err := m.ReadBuffer(rel, forkNum, page.FirstPageID)
+	assert.Nil(t, err)
+	released := int32(0)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		atomic.StoreInt32(&released, 1)
+		m.ReleaseBuffer(bufID)
+	}()
+
+	// truncation waits for the pin to be released
+	err = m.Truncate(rel, forkNum, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&released))
+	assert.Zero(t, atomic.LoadUint32(&m.descriptors[bufID].state)&bmTagValid)
+}
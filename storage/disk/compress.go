@@ -0,0 +1,828 @@
+/*
+Transparent page compression.
+
+The main fork of the relation created with compression (see CreateCompressedRelation) stores each page compressed.
+The pages are compressed on write and decompressed on read inside disk manager,
+so buffer manager and everything above see plain pages.
+
+The compressed main fork is stored in the compressed file (relFileNumber_cmp, relFileNumber_cmp.1, ...) instead of the plain one.
+ppdb has no system catalog, so whether the relation is compressed is detected with the existence of the compressed file
+when the relation is accessed first, and the method is read from the header of the file.
+
+Each segment file of the compressed relation fork is laid out as below:
+  - header: magic, compression method, pages per segment and the number of pages in the segment
+  - address map: the entry per page. the entry has the location of the compressed page, its length and the space allocated for it
+  - data: the compressed pages. the space is allocated in chunks (compressChunkSize)
+
+The address map is sized to the pages written. it starts with the entries which fit in the first chunk together with
+the header, and it is doubled (up to pages per segment) when the page beyond it is written.
+the data in the way of the larger map is moved to the end of file. so the small relation takes a few chunks, not
+the map for the whole segment (pages per segment * compressEntrySize bytes, 1MB by default).
+
+The compressed segment implements Storage interface as if it were the plain segment file (see compressedStorage),
+so the segments, truncation and GetNPageID work in the same way as the plain relation fork.
+
+Some notes:
+  - the zero-filled page (ex: the page just extended) takes no space
+  - the page which cannot be compressed is stored as it is
+  - the rewritten page is written to new space and then the entry is switched to it. the page written before is never
+    overwritten in place, so the reads never see the page being written.
+    but nothing forces the data to reach disk before the entry (no fsync in between), so the crash may leave the entry
+    pointing to the space not written yet. like the torn plain page, this needs wal (full page writes) to be recovered.
+  - the old space of the rewritten page is reused by the following writes while the relation is open. the space is
+    not recorded in the file, so it is left unused after the relation is closed. such space is reclaimed only by
+    rewriting the whole relation (ex: VACUUM FULL, not implemented)
+  - fsm and vm forks are not compressed because they are small and updated frequently
+  - only the algorithms in the standard library are supported
+  - compression is not available with encryption because the encrypted pages cannot be compressed
+
+postgres doesn't support page compression (it relies on the file system like zfs or btrfs).
+*/
+package disk
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/pkg/errors"
+)
+
+// Compression is the method to compress pages of relation
+type Compression uint8
+
+const (
+	// CompressionNone doesn't compress pages
+	CompressionNone Compression = iota
+	// CompressionDeflate compresses pages with DEFLATE (compress/flate)
+	CompressionDeflate
+)
+
+const (
+	// compressMagic is written at the beginning of the compressed segment file
+	compressMagic = "ppdbpcmp"
+	// compressHeaderSize is the size of header of the compressed segment file
+	// - magic (8 bytes)
+	// - compression method (1 byte) and reserved (3 bytes)
+	// - pages per segment (4 bytes)
+	// - the number of pages (4 bytes)
+	// - the number of the entries of address map (4 bytes). 0 means pages per segment
+	compressHeaderSize = 24
+	// compressEntrySize is the size of the address map entry
+	// - chunk number where the compressed page is located (4 bytes)
+	// - the length of the compressed page (2 bytes)
+	// - the number of chunks allocated (2 bytes)
+	compressEntrySize = 8
+	// compressChunkSize is the unit of space allocation for the compressed pages
+	compressChunkSize = 512
+	// compressInitialMapPages is the number of the entries of address map when the segment is created
+	// the header and the entries fit in the first chunk
+	compressInitialMapPages = (compressChunkSize - compressHeaderSize) / compressEntrySize
+)
+
+// compressHeader is the header of the compressed segment file
+type compressHeader struct {
+	method          Compression
+	pagesPerSegment uint32
+	nPages          uint32
+	// mapPages is the number of the entries of address map
+	mapPages uint32
+}
+
+// newCompressHeader returns the header of the segment just created
+func newCompressHeader(method Compression, pagesPerSegment uint32) compressHeader {
+	h := compressHeader{method: method, pagesPerSegment: pagesPerSegment, mapPages: compressInitialMapPages}
+	if h.mapPages > pagesPerSegment {
+		h.mapPages = pagesPerSegment
+	}
+	return h
+}
+
+// compressEntry is the entry of address map
+// length 0 means the zero-filled page and length page.PageSize means the page is stored without compression
+type compressEntry struct {
+	chunk  uint32
+	length uint16
+	chunks uint16
+}
+
+// chunkExtent is the space of contiguous chunks
+type chunkExtent struct {
+	chunk  uint32
+	chunks uint16
+}
+
+// forkKey identifies relation fork
+type forkKey struct {
+	rel     common.Relation
+	forkNum ForkNumber
+}
+
+// compressedFork is the state of the compressed relation fork shared by its segments
+type compressedFork struct {
+	method Compression
+	// mu serializes the writes and truncation of the fork because they allocate space in the file
+	// the reads hold it shared, so they don't see the address map and the data being updated
+	mu sync.RWMutex
+	// free is the space released by the rewritten pages per segment. this is protected by mu
+	free map[SegmentNumber][]chunkExtent
+}
+
+// CreateCompressedRelation creates the main fork file of the relation whose pages are compressed with the method
+// if the file already exists, return error
+func (m *Manager) CreateCompressedRelation(rel common.Relation, method Compression) error {
+	if method == CompressionNone || method > CompressionDeflate {
+		return errors.Errorf("unexpected compression method: %d", method)
+	}
+	// the encrypted page cannot be compressed (compression must be done before encryption, but the page is encrypted first)
+	if m.cipher != nil {
+		return errors.New("compression is not available with encryption")
+	}
+	m.compressMu.Lock()
+	defer m.compressMu.Unlock()
+	ok, err := m.opener.Exists(rel, ForkNumberMain, 0)
+	if err != nil {
+		return errors.Wrap(err, "exists failed")
+	}
+	if ok {
+		return errors.Errorf("the plain relation already exists: %s", rel)
+	}
+	if err := m.opener.Create(rel, forkNumberCompressed); err != nil {
+		return errors.Wrap(err, "create failed")
+	}
+	st, err := m.opener.Open(rel, forkNumberCompressed, 0)
+	if err != nil {
+		return errors.Wrap(err, "open failed")
+	}
+	h := newCompressHeader(method, uint32(m.pagesPerSegment))
+	if err := writeCompressHeader(st, h); err != nil {
+		return errors.Wrap(err, "writeCompressHeader failed")
+	}
+	// the method must be persistent, otherwise the relation is treated as plain one after crash
+	if err := st.Sync(); err != nil {
+		return errors.Wrap(err, "Sync failed")
+	}
+	m.compressions[forkKey{rel: rel, forkNum: ForkNumberMain}] = &compressedFork{method: method}
+	return nil
+}
+
+// RelationCompression returns the compression method of the relation
+func (m *Manager) RelationCompression(rel common.Relation) (Compression, error) {
+	cf, err := m.compressedFork(rel, ForkNumberMain)
+	if err != nil {
+		return CompressionNone, errors.Wrap(err, "compressedFork failed")
+	}
+	if cf == nil {
+		return CompressionNone, nil
+	}
+	return cf.method, nil
+}
+
+// open opens the segment of relation fork
+// if the relation fork is compressed, the segment of compressed file is opened and wrapped so that the plain pages are read/written
+func (m *Manager) open(rel common.Relation, forkNum ForkNumber, seg SegmentNumber) (Storage, error) {
+	cf, err := m.compressedFork(rel, forkNum)
+	if err != nil {
+		return nil, errors.Wrap(err, "compressedFork failed")
+	}
+	if cf == nil {
+		return m.opener.Open(rel, forkNum, seg)
+	}
+	st, err := m.opener.Open(rel, forkNumberCompressed, seg)
+	if err != nil {
+		return nil, err
+	}
+	return &compressedStorage{st: st, fork: cf, seg: seg, pagesPerSegment: uint32(m.pagesPerSegment)}, nil
+}
+
+// exists returns whether the segment of relation fork exists
+// if the relation fork is compressed, the segment of compressed file is checked
+func (m *Manager) exists(rel common.Relation, forkNum ForkNumber, seg SegmentNumber) (bool, error) {
+	cf, err := m.compressedFork(rel, forkNum)
+	if err != nil {
+		return false, errors.Wrap(err, "compressedFork failed")
+	}
+	if cf == nil {
+		return m.opener.Exists(rel, forkNum, seg)
+	}
+	return m.opener.Exists(rel, forkNumberCompressed, seg)
+}
+
+// compressedFork returns the state of the compressed relation fork. if the fork is not compressed, return nil
+// the method is detected with the compressed file at first access and cached
+func (m *Manager) compressedFork(rel common.Relation, forkNum ForkNumber) (*compressedFork, error) {
+	if forkNum != ForkNumberMain {
+		return nil, nil
+	}
+	key := forkKey{rel: rel, forkNum: forkNum}
+	m.compressMu.Lock()
+	defer m.compressMu.Unlock()
+	if cf, ok := m.compressions[key]; ok {
+		return cf, nil
+	}
+	ok, err := m.opener.Exists(rel, forkNumberCompressed, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "exists failed")
+	}
+	if !ok {
+		m.compressions[key] = nil
+		return nil, nil
+	}
+	st, err := m.opener.Open(rel, forkNumberCompressed, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "open failed")
+	}
+	h, ok, err := readCompressHeader(st)
+	if err != nil {
+		return nil, errors.Wrap(err, "readCompressHeader failed")
+	}
+	if !ok {
+		return nil, errors.Errorf("the compressed file of relation %s has no header", rel)
+	}
+	if h.pagesPerSegment != uint32(m.pagesPerSegment) {
+		return nil, errors.Errorf("pages per segment of compressed relation %s is %d, but disk manager's is %d",
+			rel, h.pagesPerSegment, m.pagesPerSegment)
+	}
+	cf := &compressedFork{method: h.method}
+	m.compressions[key] = cf
+	return cf, nil
+}
+
+// forgetCompression discards the cached compression methods of the relation forks which match
+// they are detected again when accessed next time
+func (m *Manager) forgetCompression(match func(key forkKey) bool) {
+	m.compressMu.Lock()
+	defer m.compressMu.Unlock()
+	for key := range m.compressions {
+		if match(key) {
+			delete(m.compressions, key)
+		}
+	}
+}
+
+// readCompressHeader reads the header of the segment. if the segment has no header (ex: empty), return false
+func readCompressHeader(st Storage) (compressHeader, bool, error) {
+	var b [compressHeaderSize]byte
+	n, err := st.ReadAt(b[:], 0)
+	if err != nil && err != io.EOF {
+		return compressHeader{}, false, errors.Wrap(err, "ReadAt failed")
+	}
+	if n < len(compressMagic) || string(b[:len(compressMagic)]) != compressMagic {
+		return compressHeader{}, false, nil
+	}
+	if n != compressHeaderSize {
+		return compressHeader{}, false, errors.Errorf("the header of compressed segment is torn: %d", n)
+	}
+	h := compressHeader{
+		method:          Compression(b[8]),
+		pagesPerSegment: binary.LittleEndian.Uint32(b[12:16]),
+		nPages:          binary.LittleEndian.Uint32(b[16:20]),
+		mapPages:        binary.LittleEndian.Uint32(b[20:24]),
+	}
+	if h.method == CompressionNone || h.method > CompressionDeflate {
+		return compressHeader{}, false, errors.Errorf("unexpected compression method: %d", h.method)
+	}
+	// the address map has the entries which fit in the first chunk at least (see newCompressHeader)
+	if h.mapPages == 0 {
+		return compressHeader{}, false, errors.New("the address map of compressed segment is empty. the header is corrupted")
+	}
+	if h.mapPages > h.pagesPerSegment {
+		return compressHeader{}, false, errors.Errorf("the address map is larger than the segment: %d", h.mapPages)
+	}
+	return h, true, nil
+}
+
+// writeCompressHeader writes the header of the segment
+func writeCompressHeader(st Storage, h compressHeader) error {
+	var b [compressHeaderSize]byte
+	copy(b[:], compressMagic)
+	b[8] = byte(h.method)
+	binary.LittleEndian.PutUint32(b[12:16], h.pagesPerSegment)
+	binary.LittleEndian.PutUint32(b[16:20], h.nPages)
+	binary.LittleEndian.PutUint32(b[20:24], h.mapPages)
+	if _, err := st.WriteAt(b[:], 0); err != nil {
+		return errors.Wrap(err, "WriteAt failed")
+	}
+	return nil
+}
+
+// compressedStorage is the segment of compressed relation fork
+// this looks like the plain segment which has nPages pages, so the offset and the length must be aligned to page size
+type compressedStorage struct {
+	st              Storage
+	fork            *compressedFork
+	seg             SegmentNumber
+	pagesPerSegment uint32
+
+	// posMu protects pos
+	posMu sync.Mutex
+	// pos is the current position for Read/Write
+	pos int64
+}
+
+// dataStart returns the offset where the data area starts after the address map of mapPages entries
+func dataStart(mapPages uint32) int64 {
+	return roundUpChunk(entryOffset(mapPages)) * compressChunkSize
+}
+
+// roundUpChunk returns the number of chunks which cover the bytes
+func roundUpChunk(n int64) int64 {
+	return (n + compressChunkSize - 1) / compressChunkSize
+}
+
+// header returns the header of the segment
+// the segment just created is empty and its header is written at first write
+func (cs *compressedStorage) header() (compressHeader, error) {
+	h, ok, err := readCompressHeader(cs.st)
+	if err != nil {
+		return compressHeader{}, errors.Wrap(err, "readCompressHeader failed")
+	}
+	if !ok {
+		size, err := cs.st.Size()
+		if err != nil {
+			return compressHeader{}, errors.Wrap(err, "Size failed")
+		}
+		if size != 0 {
+			return compressHeader{}, errors.New("the segment of compressed relation has no header")
+		}
+		return newCompressHeader(cs.fork.method, cs.pagesPerSegment), nil
+	}
+	return h, nil
+}
+
+// entryOffset returns the offset of the address map entry of the page
+func entryOffset(segPageID uint32) int64 {
+	return compressHeaderSize + int64(segPageID)*compressEntrySize
+}
+
+// readEntry reads the address map entry of the page
+// the entry beyond the address map or the end of file is treated as the zero-filled page
+func (cs *compressedStorage) readEntry(h compressHeader, segPageID uint32) (compressEntry, error) {
+	if segPageID >= h.mapPages {
+		return compressEntry{}, nil
+	}
+	var b [compressEntrySize]byte
+	n, err := cs.st.ReadAt(b[:], entryOffset(segPageID))
+	if err == io.EOF && n == 0 {
+		return compressEntry{}, nil
+	}
+	if err != nil {
+		return compressEntry{}, errors.Wrap(err, "ReadAt failed")
+	}
+	return compressEntry{
+		chunk:  binary.LittleEndian.Uint32(b[0:4]),
+		length: binary.LittleEndian.Uint16(b[4:6]),
+		chunks: binary.LittleEndian.Uint16(b[6:8]),
+	}, nil
+}
+
+// writeEntry writes the address map entry of the page
+func (cs *compressedStorage) writeEntry(segPageID uint32, e compressEntry) error {
+	var b [compressEntrySize]byte
+	binary.LittleEndian.PutUint32(b[0:4], e.chunk)
+	binary.LittleEndian.PutUint16(b[4:6], e.length)
+	binary.LittleEndian.PutUint16(b[6:8], e.chunks)
+	if _, err := cs.st.WriteAt(b[:], entryOffset(segPageID)); err != nil {
+		return errors.Wrap(err, "WriteAt failed")
+	}
+	return nil
+}
+
+// checkPageAligned checks the io is aligned to page size
+func checkPageAligned(n int, off int64) error {
+	if n%page.PageSize != 0 || off%page.PageSize != 0 {
+		return errors.Errorf("io of compressed relation must be aligned to page size: offset %d, length %d", off, n)
+	}
+	return nil
+}
+
+// ReadAt reads the pages from the offset into p
+func (cs *compressedStorage) ReadAt(p []byte, off int64) (int, error) {
+	if err := checkPageAligned(len(p), off); err != nil {
+		return 0, err
+	}
+	cs.fork.mu.RLock()
+	defer cs.fork.mu.RUnlock()
+	h, err := cs.header()
+	if err != nil {
+		return 0, errors.Wrap(err, "header failed")
+	}
+	first := uint32(off / page.PageSize)
+	for i := 0; i < len(p)/page.PageSize; i++ {
+		segPageID := first + uint32(i)
+		if segPageID >= h.nPages {
+			return i * page.PageSize, io.EOF
+		}
+		if err := cs.readPage(h, segPageID, p[i*page.PageSize:(i+1)*page.PageSize]); err != nil {
+			return i * page.PageSize, errors.Wrapf(err, "readPage failed: page %d", segPageID)
+		}
+	}
+	return len(p), nil
+}
+
+// readPage reads the page and decompresses it into p. the caller must hold fork lock
+func (cs *compressedStorage) readPage(h compressHeader, segPageID uint32, p []byte) error {
+	e, err := cs.readEntry(h, segPageID)
+	if err != nil {
+		return errors.Wrap(err, "readEntry failed")
+	}
+	if e.length == 0 {
+		for i := range p {
+			p[i] = 0
+		}
+		return nil
+	}
+	if e.length > page.PageSize || int(e.length) > int(e.chunks)*compressChunkSize {
+		return errors.Errorf("the address map entry is broken: length %d, chunks %d", e.length, e.chunks)
+	}
+	data := p
+	if e.length != page.PageSize {
+		data = make([]byte, e.length)
+	}
+	if _, err := cs.st.ReadAt(data, int64(e.chunk)*compressChunkSize); err != nil {
+		return errors.Wrap(err, "ReadAt failed")
+	}
+	if e.length == page.PageSize {
+		// the page is stored without compression
+		return nil
+	}
+	if err := decompress(cs.fork.method, data, p); err != nil {
+		return errors.Wrap(err, "decompress failed")
+	}
+	return nil
+}
+
+// WriteAt compresses and writes the pages in p from the offset
+func (cs *compressedStorage) WriteAt(p []byte, off int64) (int, error) {
+	if err := checkPageAligned(len(p), off); err != nil {
+		return 0, err
+	}
+	cs.fork.mu.Lock()
+	defer cs.fork.mu.Unlock()
+	h, err := cs.header()
+	if err != nil {
+		return 0, errors.Wrap(err, "header failed")
+	}
+	first := uint32(off / page.PageSize)
+	n := len(p) / page.PageSize
+	if uint64(first)+uint64(n) > uint64(cs.pagesPerSegment) {
+		return 0, errors.Errorf("the pages exceed the segment: offset %d, length %d", off, len(p))
+	}
+	if end := first + uint32(n); end > h.mapPages {
+		if err := cs.growMap(&h, end); err != nil {
+			return 0, errors.Wrap(err, "growMap failed")
+		}
+	}
+	for i := 0; i < n; i++ {
+		if err := cs.writePage(h, first+uint32(i), p[i*page.PageSize:(i+1)*page.PageSize]); err != nil {
+			return i * page.PageSize, errors.Wrapf(err, "writePage failed: page %d", first+uint32(i))
+		}
+	}
+	// the pages between the old end and the offset are zero-filled pages like the plain file
+	if end := first + uint32(n); end > h.nPages {
+		h.nPages = end
+		if err := writeCompressHeader(cs.st, h); err != nil {
+			return len(p), errors.Wrap(err, "writeCompressHeader failed")
+		}
+	}
+	return len(p), nil
+}
+
+// writePage compresses and writes the page. the caller must hold fork lock
+// the page is within the address map
+func (cs *compressedStorage) writePage(h compressHeader, segPageID uint32, p []byte) error {
+	data, err := compress(cs.fork.method, p)
+	if err != nil {
+		return errors.Wrap(err, "compress failed")
+	}
+	e, err := cs.readEntry(h, segPageID)
+	if err != nil {
+		return errors.Wrap(err, "readEntry failed")
+	}
+	// the space which has the live page is not overwritten. the space of the zero-filled (or truncated) page can be
+	var released chunkExtent
+	if need := uint16(roundUpChunk(int64(len(data)))); need > 0 && (e.length != 0 || need > e.chunks) {
+		released = chunkExtent{chunk: e.chunk, chunks: e.chunks}
+		e.chunk, err = cs.allocate(h.mapPages, need)
+		if err != nil {
+			return errors.Wrap(err, "allocate failed")
+		}
+		e.chunks = need
+	}
+	if len(data) > 0 {
+		if _, err := cs.st.WriteAt(data, int64(e.chunk)*compressChunkSize); err != nil {
+			return errors.Wrap(err, "WriteAt failed")
+		}
+	}
+	// the entry is updated after the data is written, so the entry never points to the data not written
+	e.length = uint16(len(data))
+	if err := cs.writeEntry(segPageID, e); err != nil {
+		return errors.Wrap(err, "writeEntry failed")
+	}
+	cs.release(released)
+	return nil
+}
+
+// allocate returns the first chunk of the space of the chunks
+// the space released before is reused, otherwise the space is allocated at the end of file. the caller must hold fork lock
+func (cs *compressedStorage) allocate(mapPages uint32, chunks uint16) (uint32, error) {
+	free := cs.fork.free[cs.seg]
+	for i, ext := range free {
+		if ext.chunks < chunks {
+			continue
+		}
+		if ext.chunks == chunks {
+			cs.fork.free[cs.seg] = append(free[:i], free[i+1:]...)
+		} else {
+			free[i] = chunkExtent{chunk: ext.chunk + uint32(chunks), chunks: ext.chunks - chunks}
+		}
+		return ext.chunk, nil
+	}
+	size, err := cs.st.Size()
+	if err != nil {
+		return 0, errors.Wrap(err, "Size failed")
+	}
+	if start := dataStart(mapPages); size < start {
+		size = start
+	}
+	return uint32(roundUpChunk(size)), nil
+}
+
+// release records the space to be reused. the caller must hold fork lock
+func (cs *compressedStorage) release(ext chunkExtent) {
+	if ext.chunks == 0 {
+		return
+	}
+	if cs.fork.free == nil {
+		cs.fork.free = make(map[SegmentNumber][]chunkExtent)
+	}
+	cs.fork.free[cs.seg] = append(cs.fork.free[cs.seg], ext)
+}
+
+// dropFree discards the space released before the offset. the caller must hold fork lock
+func (cs *compressedStorage) dropFree(off int64) {
+	start := uint32(roundUpChunk(off))
+	free := cs.fork.free[cs.seg][:0]
+	for _, ext := range cs.fork.free[cs.seg] {
+		end := ext.chunk + uint32(ext.chunks)
+		if end <= start {
+			continue
+		}
+		if ext.chunk < start {
+			ext = chunkExtent{chunk: start, chunks: uint16(end - start)}
+		}
+		free = append(free, ext)
+	}
+	if cs.fork.free != nil {
+		cs.fork.free[cs.seg] = free
+	}
+}
+
+// growMap doubles the address map until it has the entries of the pages (up to pages per segment)
+// the data within the new map is moved to the end of file at first, and then the header is updated.
+// the writes are not synced in between, so the crash in the meantime may leave the map inconsistent (see the note at the top).
+// the caller must hold fork lock
+func (cs *compressedStorage) growMap(h *compressHeader, pages uint32) error {
+	mapPages := h.mapPages
+	for mapPages < pages {
+		mapPages *= 2
+	}
+	if mapPages > cs.pagesPerSegment {
+		mapPages = cs.pagesPerSegment
+	}
+	start := dataStart(mapPages)
+	cs.dropFree(start)
+	var released []chunkExtent
+	for segPageID := uint32(0); segPageID < h.mapPages; segPageID++ {
+		e, err := cs.readEntry(*h, segPageID)
+		if err != nil {
+			return errors.Wrap(err, "readEntry failed")
+		}
+		if e.chunks == 0 || int64(e.chunk)*compressChunkSize >= start {
+			continue
+		}
+		released = append(released, chunkExtent{chunk: e.chunk, chunks: e.chunks})
+		if e.length == 0 {
+			// no live page, so just discard the space
+			e.chunk, e.chunks = 0, 0
+		} else {
+			data := make([]byte, e.length)
+			if _, err := cs.st.ReadAt(data, int64(e.chunk)*compressChunkSize); err != nil {
+				return errors.Wrap(err, "ReadAt failed")
+			}
+			chunks := uint16(roundUpChunk(int64(e.length)))
+			chunk, err := cs.allocate(mapPages, chunks)
+			if err != nil {
+				return errors.Wrap(err, "allocate failed")
+			}
+			if _, err := cs.st.WriteAt(data, int64(chunk)*compressChunkSize); err != nil {
+				return errors.Wrap(err, "WriteAt failed")
+			}
+			e.chunk, e.chunks = chunk, chunks
+		}
+		if err := cs.writeEntry(segPageID, e); err != nil {
+			return errors.Wrap(err, "writeEntry failed")
+		}
+	}
+	for _, ext := range released {
+		cs.release(ext)
+	}
+	cs.dropFree(start)
+
+	// the new entries must be the zero-filled pages, but the data moved may remain there
+	size, err := cs.st.Size()
+	if err != nil {
+		return errors.Wrap(err, "Size failed")
+	}
+	if end := entryOffset(mapPages); size > entryOffset(h.mapPages) {
+		if size < end {
+			end = size
+		}
+		if _, err := cs.st.WriteAt(make([]byte, end-entryOffset(h.mapPages)), entryOffset(h.mapPages)); err != nil {
+			return errors.Wrap(err, "WriteAt failed")
+		}
+	}
+	h.mapPages = mapPages
+	if err := writeCompressHeader(cs.st, *h); err != nil {
+		return errors.Wrap(err, "writeCompressHeader failed")
+	}
+	return nil
+}
+
+// Read reads the pages at the current position into p
+func (cs *compressedStorage) Read(p []byte) (int, error) {
+	cs.posMu.Lock()
+	defer cs.posMu.Unlock()
+	n, err := cs.ReadAt(p, cs.pos)
+	cs.pos += int64(n)
+	return n, err
+}
+
+// Write writes the pages in p at the current position
+func (cs *compressedStorage) Write(p []byte) (int, error) {
+	cs.posMu.Lock()
+	defer cs.posMu.Unlock()
+	n, err := cs.WriteAt(p, cs.pos)
+	cs.pos += int64(n)
+	return n, err
+}
+
+// Seek sets the current position
+func (cs *compressedStorage) Seek(offset int64, whence int) (int64, error) {
+	cs.posMu.Lock()
+	defer cs.posMu.Unlock()
+	var base int64
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		base = cs.pos
+	case io.SeekEnd:
+		size, err := cs.Size()
+		if err != nil {
+			return 0, errors.Wrap(err, "Size failed")
+		}
+		base = size
+	default:
+		return 0, errors.Errorf("unexpected whence: %d", whence)
+	}
+	if base+offset < 0 {
+		return 0, errors.Errorf("negative position: %d", base+offset)
+	}
+	cs.pos = base + offset
+	return cs.pos, nil
+}
+
+// Size returns the size of the plain pages
+func (cs *compressedStorage) Size() (int64, error) {
+	cs.fork.mu.RLock()
+	defer cs.fork.mu.RUnlock()
+	h, err := cs.header()
+	if err != nil {
+		return 0, errors.Wrap(err, "header failed")
+	}
+	return int64(h.nPages) * page.PageSize, nil
+}
+
+// Truncate changes the number of pages. the size must be aligned to page size
+// the space of the removed pages is reused when the pages are written again.
+// when all pages are removed, the space is released
+func (cs *compressedStorage) Truncate(size int64) error {
+	if err := checkPageAligned(0, size); err != nil {
+		return err
+	}
+	cs.fork.mu.Lock()
+	defer cs.fork.mu.Unlock()
+	h, err := cs.header()
+	if err != nil {
+		return errors.Wrap(err, "header failed")
+	}
+	nPages := uint32(size / page.PageSize)
+	if nPages > cs.pagesPerSegment {
+		return errors.Errorf("the size exceeds the segment: %d", size)
+	}
+	if nPages == 0 {
+		// the header is kept so that the method is detected
+		h.nPages = 0
+		if err := writeCompressHeader(cs.st, h); err != nil {
+			return errors.Wrap(err, "writeCompressHeader failed")
+		}
+		if err := cs.st.Truncate(compressHeaderSize); err != nil {
+			return errors.Wrap(err, "Truncate failed")
+		}
+		if cs.fork.free != nil {
+			delete(cs.fork.free, cs.seg)
+		}
+		return nil
+	}
+	// the removed pages must be zero-filled when the segment is extended again
+	for segPageID := nPages; segPageID < h.nPages && segPageID < h.mapPages; segPageID++ {
+		e, err := cs.readEntry(h, segPageID)
+		if err != nil {
+			return errors.Wrap(err, "readEntry failed")
+		}
+		e.length = 0
+		if err := cs.writeEntry(segPageID, e); err != nil {
+			return errors.Wrap(err, "writeEntry failed")
+		}
+	}
+	h.nPages = nPages
+	if err := writeCompressHeader(cs.st, h); err != nil {
+		return errors.Wrap(err, "writeCompressHeader failed")
+	}
+	return nil
+}
+
+// Sync syncs the segment file
+func (cs *compressedStorage) Sync() error {
+	return cs.st.Sync()
+}
+
+// Close closes the segment file
+func (cs *compressedStorage) Close() error {
+	return cs.st.Close()
+}
+
+// flateWriters pools the deflate compressors because they are expensive to allocate
+var flateWriters = sync.Pool{
+	New: func() any {
+		// the error is returned only when the level is invalid
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	},
+}
+
+// compress compresses the page with the method
+// if the page is zero-filled, return empty. if the page cannot be compressed, return the page as it is
+func compress(method Compression, p []byte) ([]byte, error) {
+	if isZeroPage(p) {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	switch method {
+	case CompressionDeflate:
+		w := flateWriters.Get().(*flate.Writer)
+		defer flateWriters.Put(w)
+		w.Reset(&buf)
+		if _, err := w.Write(p); err != nil {
+			return nil, errors.Wrap(err, "Write failed")
+		}
+		if err := w.Close(); err != nil {
+			return nil, errors.Wrap(err, "Close failed")
+		}
+	default:
+		return nil, errors.Errorf("unexpected compression method: %d", method)
+	}
+	if buf.Len() >= page.PageSize {
+		return p, nil
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress decompresses data into the page p
+func decompress(method Compression, data []byte, p []byte) error {
+	switch method {
+	case CompressionDeflate:
+		r := flate.NewReader(bytes.NewReader(data))
+		defer r.Close()
+		if _, err := io.ReadFull(r, p); err != nil {
+			return errors.Wrap(err, "ReadFull failed")
+		}
+	default:
+		return errors.Errorf("unexpected compression method: %d", method)
+	}
+	return nil
+}
+
+// isZeroPage returns whether the page is zero-filled
+func isZeroPage(p []byte) bool {
+	for _, b := range p {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,376 @@
+package disk
+
+import (
+	"bytes"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateCompressedRelation(t *testing.T) {
+	dm, err := TestingNewBufferManager()
+	assert.Nil(t, err)
+	rel := common.Relation{RelFileNumber: 1}
+
+	err = dm.CreateCompressedRelation(rel, CompressionNone)
+	assert.NotNil(t, err)
+	err = dm.CreateCompressedRelation(rel, CompressionDeflate)
+	assert.Nil(t, err)
+	err = dm.CreateCompressedRelation(rel, CompressionDeflate)
+	assert.NotNil(t, err)
+	c, err := dm.RelationCompression(rel)
+	assert.Nil(t, err)
+	assert.Equal(t, CompressionDeflate, c)
+
+	// the plain relation
+	plain := common.Relation{RelFileNumber: 2}
+	err = dm.CreateRelation(plain)
+	assert.Nil(t, err)
+	c, err = dm.RelationCompression(plain)
+	assert.Nil(t, err)
+	assert.Equal(t, CompressionNone, c)
+	err = dm.CreateCompressedRelation(plain, CompressionDeflate)
+	assert.NotNil(t, err)
+}
+
+func TestCompressedReadWritePage(t *testing.T) {
+	rp, err := page.TestingNewRandomPage()
+	assert.Nil(t, err)
+	pages := []page.PagePtr{
+		// compressible page
+		testingPage('a'),
+		// incompressible page
+		rp,
+		// zero-filled page
+		page.NewPagePtr(),
+	}
+
+	dm, err := TestingNewFileManagerWithOptions(t, Options{PagesPerSegment: 2})
+	assert.Nil(t, err)
+	rel := common.Relation{RelFileNumber: 1}
+	err = dm.CreateCompressedRelation(rel, CompressionDeflate)
+	assert.Nil(t, err)
+
+	// the pages span the segments
+	err = dm.WritePages(rel, ForkNumberMain, page.FirstPageID, pages, false)
+	assert.Nil(t, err)
+	last, err := dm.GetNPageID(rel, ForkNumberMain)
+	assert.Nil(t, err)
+	assert.Equal(t, page.PageID(len(pages)-1), last)
+	for i, expected := range pages {
+		got := page.NewPagePtr()
+		err := dm.ReadPage(rel, ForkNumberMain, page.PageID(i), got)
+		assert.Nil(t, err)
+		assert.True(t, bytes.Equal(expected[:], got[:]), "page %d", i)
+	}
+	got, err := dm.ReadPages(rel, ForkNumberMain, page.FirstPageID, len(pages))
+	assert.Nil(t, err)
+	for i := range pages {
+		assert.True(t, bytes.Equal(pages[i][:], got[i][:]), "page %d", i)
+	}
+	// the page beyond the end cannot be read
+	err = dm.ReadPage(rel, ForkNumberMain, page.PageID(len(pages)), page.NewPagePtr())
+	assert.NotNil(t, err)
+
+	// the pages are stored in the compressed file, not in the plain file
+	_, err = os.Stat(testingSegmentPath(dm, rel, ForkNumberMain, 0))
+	assert.True(t, os.IsNotExist(err))
+	stat, err := os.Stat(testingSegmentPath(dm, rel, forkNumberCompressed, 1))
+	assert.Nil(t, err)
+	// the second segment has only the zero-filled page, which takes no space except the address map entry
+	assert.Equal(t, entryOffset(1), stat.Size())
+}
+
+func TestCompressedRewritePage(t *testing.T) {
+	dm, err := TestingNewBufferManager()
+	assert.Nil(t, err)
+	rel := common.Relation{RelFileNumber: 1}
+	err = dm.CreateCompressedRelation(rel, CompressionDeflate)
+	assert.Nil(t, err)
+	pageID, err := dm.ExtendPage(rel, ForkNumberMain, false)
+	assert.Nil(t, err)
+	assert.Equal(t, page.FirstPageID, pageID)
+
+	rp, err := page.TestingNewRandomPage()
+	assert.Nil(t, err)
+	tests := []struct {
+		name string
+		p    page.PagePtr
+	}{
+		{name: "compressible page", p: testingPage('a')},
+		{name: "the page which doesn't fit in the space allocated", p: rp},
+		{name: "the page which fits in the space allocated", p: testingPage('b')},
+		{name: "zero-filled page", p: page.NewPagePtr()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := dm.WritePage(rel, ForkNumberMain, pageID, tt.p, false)
+			assert.Nil(t, err)
+			got := page.NewPagePtr()
+			err = dm.ReadPage(rel, ForkNumberMain, pageID, got)
+			assert.Nil(t, err)
+			assert.True(t, bytes.Equal(tt.p[:], got[:]))
+		})
+	}
+}
+
+func TestCompressedTruncate(t *testing.T) {
+	dm, err := TestingNewBufferManager()
+	assert.Nil(t, err)
+	rel := common.Relation{RelFileNumber: 1}
+	err = dm.CreateCompressedRelation(rel, CompressionDeflate)
+	assert.Nil(t, err)
+	pages := []page.PagePtr{testingPage('a'), testingPage('b'), testingPage('c')}
+	err = dm.WritePages(rel, ForkNumberMain, page.FirstPageID, pages, false)
+	assert.Nil(t, err)
+
+	err = dm.Truncate(rel, ForkNumberMain, 1)
+	assert.Nil(t, err)
+	last, err := dm.GetNPageID(rel, ForkNumberMain)
+	assert.Nil(t, err)
+	assert.Equal(t, page.FirstPageID, last)
+
+	// the page extended again is zero-filled
+	pageID, err := dm.ExtendPage(rel, ForkNumberMain, false)
+	assert.Nil(t, err)
+	got := page.NewPagePtr()
+	err = dm.ReadPage(rel, ForkNumberMain, pageID, got)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(page.NewPagePtr()[:], got[:]))
+
+	// the relation is still compressed after truncated to 0
+	err = dm.Truncate(rel, ForkNumberMain, 0)
+	assert.Nil(t, err)
+	last, err = dm.GetNPageID(rel, ForkNumberMain)
+	assert.Nil(t, err)
+	assert.Equal(t, page.InvalidPageID, last)
+	err = dm.WritePage(rel, ForkNumberMain, page.FirstPageID, testingPage('d'), false)
+	assert.Nil(t, err)
+	err = dm.ReadPage(rel, ForkNumberMain, page.FirstPageID, got)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(testingPage('d')[:], got[:]))
+}
+
+func TestCompressedRelationReopen(t *testing.T) {
+	dd := common.TestingNewDataDir(t)
+	dm, err := NewManagerWithOptions(dd, Options{PagesPerSegment: 4})
+	assert.Nil(t, err)
+	rel := common.Relation{RelFileNumber: 1}
+	err = dm.CreateCompressedRelation(rel, CompressionDeflate)
+	assert.Nil(t, err)
+	err = dm.WritePage(rel, ForkNumberMain, page.FirstPageID, testingPage('a'), false)
+	assert.Nil(t, err)
+	assert.Nil(t, dm.Close())
+
+	t.Run("the compression is detected with the file", func(t *testing.T) {
+		dm, err := NewManagerWithOptions(dd, Options{PagesPerSegment: 4})
+		assert.Nil(t, err)
+		defer dm.Close()
+		c, err := dm.RelationCompression(rel)
+		assert.Nil(t, err)
+		assert.Equal(t, CompressionDeflate, c)
+		got := page.NewPagePtr()
+		err = dm.ReadPage(rel, ForkNumberMain, page.FirstPageID, got)
+		assert.Nil(t, err)
+		assert.True(t, bytes.Equal(testingPage('a')[:], got[:]))
+
+		// the compression is forgotten after unlink
+		err = dm.UnlinkRelation(rel)
+		assert.Nil(t, err)
+		c, err = dm.RelationCompression(rel)
+		assert.Nil(t, err)
+		assert.Equal(t, CompressionNone, c)
+	})
+	t.Run("pages per segment is different", func(t *testing.T) {
+		dd := common.TestingNewDataDir(t)
+		dm, err := NewManagerWithOptions(dd, Options{PagesPerSegment: 4})
+		assert.Nil(t, err)
+		err = dm.CreateCompressedRelation(rel, CompressionDeflate)
+		assert.Nil(t, err)
+		assert.Nil(t, dm.Close())
+
+		dm, err = NewManagerWithOptions(dd, Options{PagesPerSegment: 8})
+		assert.Nil(t, err)
+		defer dm.Close()
+		err = dm.ReadPage(rel, ForkNumberMain, page.FirstPageID, page.NewPagePtr())
+		assert.NotNil(t, err)
+	})
+}
+
+// testingCompressedStorage returns the first segment of the compressed relation
+func testingCompressedStorage(t *testing.T, dm *Manager, rel common.Relation) *compressedStorage {
+	st, err := dm.open(rel, ForkNumberMain, 0)
+	assert.Nil(t, err)
+	cs, ok := st.(*compressedStorage)
+	assert.True(t, ok)
+	return cs
+}
+
+func TestCompressedAddressMapGrows(t *testing.T) {
+	dm, err := TestingNewBufferManager()
+	assert.Nil(t, err)
+	rel := common.Relation{RelFileNumber: 1}
+	err = dm.CreateCompressedRelation(rel, CompressionDeflate)
+	assert.Nil(t, err)
+	cs := testingCompressedStorage(t, dm, rel)
+
+	// the small relation doesn't take the address map for the whole segment
+	err = dm.WritePage(rel, ForkNumberMain, page.FirstPageID, testingPage(1), false)
+	assert.Nil(t, err)
+	size, err := cs.st.Size()
+	assert.Nil(t, err)
+	assert.LessOrEqual(t, size, int64(2*compressChunkSize))
+
+	// the pages beyond the map move the data in the way
+	n := 4*compressInitialMapPages + 1
+	for i := 1; i < n; i++ {
+		err := dm.WritePage(rel, ForkNumberMain, page.PageID(i), testingPage(byte(i+1)), false)
+		assert.Nil(t, err)
+	}
+	h, err := cs.header()
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(8*compressInitialMapPages), h.mapPages)
+	for i := 0; i < n; i++ {
+		got := page.NewPagePtr()
+		err := dm.ReadPage(rel, ForkNumberMain, page.PageID(i), got)
+		assert.Nil(t, err)
+		assert.True(t, bytes.Equal(testingPage(byte(i + 1))[:], got[:]), "page %d", i)
+	}
+	// the map is not larger than the segment
+	err = dm.WritePage(rel, ForkNumberMain, page.PageID(defaultPagesPerSegment-1), testingPage('z'), false)
+	assert.Nil(t, err)
+	h, err = cs.header()
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(defaultPagesPerSegment), h.mapPages)
+	got := page.NewPagePtr()
+	err = dm.ReadPage(rel, ForkNumberMain, page.PageID(n-1), got)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(testingPage(byte(n))[:], got[:]))
+
+	t.Run("the header with empty address map is corrupted", func(t *testing.T) {
+		h := newCompressHeader(CompressionDeflate, 8)
+		h.mapPages = 0
+		st := NewBufferStorage(0)
+		assert.Nil(t, writeCompressHeader(st, h))
+		_, _, err := readCompressHeader(st)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestCompressedRewriteNotInPlace(t *testing.T) {
+	dm, err := TestingNewBufferManager()
+	assert.Nil(t, err)
+	rel := common.Relation{RelFileNumber: 1}
+	err = dm.CreateCompressedRelation(rel, CompressionDeflate)
+	assert.Nil(t, err)
+	cs := testingCompressedStorage(t, dm, rel)
+	entry := func() compressEntry {
+		h, err := cs.header()
+		assert.Nil(t, err)
+		e, err := cs.readEntry(h, 0)
+		assert.Nil(t, err)
+		return e
+	}
+
+	err = dm.WritePage(rel, ForkNumberMain, page.FirstPageID, testingPage('a'), false)
+	assert.Nil(t, err)
+	first := entry()
+	old := make([]byte, first.length)
+	_, err = cs.st.ReadAt(old, int64(first.chunk)*compressChunkSize)
+	assert.Nil(t, err)
+
+	// the page of the same size is written to new space and the old one is left as it is
+	err = dm.WritePage(rel, ForkNumberMain, page.FirstPageID, testingPage('b'), false)
+	assert.Nil(t, err)
+	second := entry()
+	assert.NotEqual(t, first.chunk, second.chunk)
+	b := make([]byte, first.length)
+	_, err = cs.st.ReadAt(b, int64(first.chunk)*compressChunkSize)
+	assert.Nil(t, err)
+	assert.Equal(t, old, b)
+
+	// the old space is reused by the next write
+	err = dm.WritePage(rel, ForkNumberMain, page.FirstPageID, testingPage('c'), false)
+	assert.Nil(t, err)
+	assert.Equal(t, first.chunk, entry().chunk)
+	got := page.NewPagePtr()
+	err = dm.ReadPage(rel, ForkNumberMain, page.FirstPageID, got)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(testingPage('c')[:], got[:]))
+}
+
+func TestCompressedConcurrentReadWrite(t *testing.T) {
+	dm, err := TestingNewBufferManager()
+	assert.Nil(t, err)
+	rel := common.Relation{RelFileNumber: 1}
+	err = dm.CreateCompressedRelation(rel, CompressionDeflate)
+	assert.Nil(t, err)
+	rp, err := page.TestingNewRandomPage()
+	assert.Nil(t, err)
+	// the pages of the different sizes
+	images := []page.PagePtr{testingPage('a'), rp, testingPage('b')}
+	err = dm.WritePage(rel, ForkNumberMain, page.FirstPageID, images[0], false)
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			if err := dm.WritePage(rel, ForkNumberMain, page.FirstPageID, images[i%len(images)], false); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got := page.NewPagePtr()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if err := dm.ReadPage(rel, ForkNumberMain, page.FirstPageID, got); err != nil {
+					t.Error(err)
+					return
+				}
+				var ok bool
+				for _, image := range images {
+					ok = ok || bytes.Equal(image[:], got[:])
+				}
+				if !ok {
+					t.Error("the page being written is read")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	t.Run("the read waits for the write", func(t *testing.T) {
+		cs := testingCompressedStorage(t, dm, rel)
+		cs.fork.mu.Lock()
+		read := make(chan error)
+		go func() {
+			read <- dm.ReadPage(rel, ForkNumberMain, page.FirstPageID, page.NewPagePtr())
+		}()
+		select {
+		case <-read:
+			t.Error("the page is read during the write")
+		case <-time.After(50 * time.Millisecond):
+		}
+		cs.fork.mu.Unlock()
+		assert.Nil(t, <-read)
+	})
+}
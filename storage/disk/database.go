@@ -0,0 +1,103 @@
+/*
+Database is the set of relations. One cluster (data directory) can have multiple databases.
+The relation files of the database are located under the directory named the database oid in each tablespace.
+  - default tablespace: base/database oid/relFileNumber
+  - other tablespace: pg_tblspc/tablespace oid/database oid/relFileNumber
+
+Like postgres, the database is created by copying the directories of the template database (file copy strategy).
+Default database is created when disk manager is initialized, and it is used as template by default (like template1 in postgres).
+Postgres also supports wal log strategy which copies the database block by block with wal, but ppdb doesn't have wal yet.
+
+see https://github.com/postgres/postgres/blob/20432f8731404d2cef2a155144aca5ab3ae98e95/src/backend/commands/dbcommands.c
+*/
+package disk
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/pkg/errors"
+)
+
+// getDatabasePath returns the directory path of the database in the tablespace relative to data directory
+// see GetDatabasePath() https://github.com/postgres/postgres/blob/a448e49bcbe40fb72e1ed85af910dd216d45bad8/src/common/relpath.c
+func getDatabasePath(spc common.Tablespace, db common.Database) string {
+	return filepath.Join(getTablespacePath(spc), fmt.Sprintf("%d", db))
+}
+
+// CreateDatabase creates the database by copying the files of the template database
+// the dirty buffers of the template database must be written out in advance (see buffer.Manager.CreateDatabase),
+// and the caller must prevent the other goroutines from modifying the template database during copy
+func (m *Manager) CreateDatabase(db, template common.Database) error {
+	// prevent the relations from being extended during copy
+	m.extendMu.Lock()
+	defer m.extendMu.Unlock()
+	if err := m.opener.CreateDatabase(db, template); err != nil {
+		return errors.Wrapf(err, "createDatabase failed: database %d", db)
+	}
+	return nil
+}
+
+// DropDatabase removes all relation files of the database
+// the caller is responsible for dropping the buffers of the database before drop (see buffer.Manager.DropDatabase)
+func (m *Manager) DropDatabase(db common.Database) error {
+	if db == common.DefaultDatabase {
+		return errors.New("default database cannot be dropped")
+	}
+	m.extendMu.Lock()
+	defer m.extendMu.Unlock()
+	m.forgetCompression(func(key forkKey) bool {
+		return key.rel.Database == db
+	})
+	if err := m.opener.DropDatabase(db); err != nil {
+		return errors.Wrapf(err, "dropDatabase failed: database %d", db)
+	}
+	return nil
+}
+
+// copyDir copies the files in src directory into dst directory
+// dst must not exist. the files are synced so that the copy is durable
+// see copydir() https://github.com/postgres/postgres/blob/20432f8731404d2cef2a155144aca5ab3ae98e95/src/backend/storage/file/copydir.c
+func copyDir(src, dst string) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return errors.Wrap(err, "os.ReadDir failed")
+	}
+	if err := os.Mkdir(dst, 0700); err != nil {
+		return errors.Wrap(err, "os.Mkdir failed")
+	}
+	for _, entry := range entries {
+		// the database directory has only relation files
+		if !entry.Type().IsRegular() {
+			return errors.Errorf("unexpected file in database directory: %s", entry.Name())
+		}
+		if err := copyFile(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+			return errors.Wrapf(err, "copyFile failed: %s", entry.Name())
+		}
+	}
+	return nil
+}
+
+// copyFile copies src file into dst file
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.Wrap(err, "os.Open failed")
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0700)
+	if err != nil {
+		return errors.Wrap(err, "os.OpenFile failed")
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		return errors.Wrap(err, "io.Copy failed")
+	}
+	if err := out.Sync(); err != nil {
+		return errors.Wrap(err, "Sync failed")
+	}
+	return nil
+}
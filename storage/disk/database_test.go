@@ -0,0 +1,129 @@
+package disk
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDatabase(t *testing.T) {
+	managers := []struct {
+		name string
+		new  func(t *testing.T) (*Manager, error)
+	}{
+		{
+			name: "file storage",
+			new:  TestingNewFileManager,
+		},
+		{
+			name: "buffer storage",
+			new: func(t *testing.T) (*Manager, error) {
+				return TestingNewBufferManager()
+			},
+		},
+	}
+	for _, mm := range managers {
+		t.Run(mm.name, func(t *testing.T) {
+			dm, err := mm.new(t)
+			assert.Nil(t, err)
+			spc := common.Tablespace(10)
+			err = dm.CreateTablespace(spc, filepath.Join(t.TempDir(), "spc"))
+			assert.Nil(t, err)
+
+			// the relations of template database in default tablespace and the other tablespace
+			template := []common.Relation{
+				{RelFileNumber: 1},
+				{Tablespace: spc, RelFileNumber: 2},
+			}
+			for i, rel := range template {
+				err = dm.CreateRelation(rel)
+				assert.Nil(t, err)
+				_, err = dm.ExtendPage(rel, ForkNumberMain, true)
+				assert.Nil(t, err)
+				err = dm.WritePage(rel, ForkNumberMain, page.FirstPageID, testingPage(byte(i)), true)
+				assert.Nil(t, err)
+			}
+
+			db := common.Database(1)
+			// the relation cannot be created before the database is created
+			err = dm.CreateRelation(common.Relation{Database: db, RelFileNumber: 3})
+			assert.NotNil(t, err)
+			// the template database doesn't exist
+			err = dm.CreateDatabase(db, common.Database(100))
+			assert.NotNil(t, err)
+
+			err = dm.CreateDatabase(db, common.DefaultDatabase)
+			assert.Nil(t, err)
+			// the database already exists
+			err = dm.CreateDatabase(db, common.DefaultDatabase)
+			assert.NotNil(t, err)
+
+			// the relations are copied from template database
+			for i, rel := range template {
+				copied := rel
+				copied.Database = db
+				got := page.NewPagePtr()
+				err = dm.ReadPage(copied, ForkNumberMain, page.FirstPageID, got)
+				assert.Nil(t, err)
+				assert.Equal(t, testingPage(byte(i)), got)
+
+				// the copy is independent of the template
+				err = dm.WritePage(copied, ForkNumberMain, page.FirstPageID, testingPage('z'), true)
+				assert.Nil(t, err)
+				err = dm.ReadPage(rel, ForkNumberMain, page.FirstPageID, got)
+				assert.Nil(t, err)
+				assert.Equal(t, testingPage(byte(i)), got)
+			}
+			rel := common.Relation{Tablespace: spc, Database: db, RelFileNumber: 3}
+			err = dm.CreateRelation(rel)
+			assert.Nil(t, err)
+
+			err = dm.DropDatabase(db)
+			assert.Nil(t, err)
+			// the database doesn't exist anymore
+			err = dm.DropDatabase(db)
+			assert.NotNil(t, err)
+			err = dm.CreateRelation(rel)
+			assert.NotNil(t, err)
+			ok, err := dm.opener.Exists(common.Relation{Database: db, RelFileNumber: 1}, ForkNumberMain, 0)
+			assert.Nil(t, err)
+			assert.False(t, ok)
+			// default database cannot be dropped
+			err = dm.DropDatabase(common.DefaultDatabase)
+			assert.NotNil(t, err)
+
+			// the database can be created again
+			err = dm.CreateDatabase(db, common.DefaultDatabase)
+			assert.Nil(t, err)
+		})
+	}
+}
+
+func TestDropDatabaseClosesFiles(t *testing.T) {
+	dm, err := TestingNewFileManager(t)
+	assert.Nil(t, err)
+	db := common.Database(1)
+	err = dm.CreateDatabase(db, common.DefaultDatabase)
+	assert.Nil(t, err)
+	rel := common.Relation{Database: db, RelFileNumber: 1}
+	err = dm.CreateRelation(rel)
+	assert.Nil(t, err)
+	_, err = dm.ExtendPage(rel, ForkNumberMain, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, dm.opener.(*fileOpener).vfds.openCount())
+
+	err = dm.DropDatabase(db)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, dm.opener.(*fileOpener).vfds.openCount())
+	_, err = os.Stat(filepath.Dir(testingSegmentPath(dm, rel, ForkNumberMain, 0)))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestGetDatabasePath(t *testing.T) {
+	assert.Equal(t, filepath.Join("base", "0"), getDatabasePath(common.DefaultTablespace, common.DefaultDatabase))
+	assert.Equal(t, filepath.Join("pg_tblspc", "10", "1"), getDatabasePath(common.Tablespace(10), common.Database(1)))
+}
@@ -0,0 +1,49 @@
+/*
+Transparent page encryption.
+
+When the cipher is given (see Options.Cipher), the pages are encrypted when they are written out
+and decrypted when they are read. buffer manager and everything above see plain pages.
+
+The tweak of the page is made of fork number, relfilenumber and page id (see encryption package),
+and the lsn at the head of page is not encrypted so that it can be read without the key (ex: recovery).
+*/
+package disk
+
+import (
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/encryption"
+	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/pkg/errors"
+)
+
+// encryptPages returns the copies of the pages encrypted
+// if encryption is disabled, the pages are returned as they are
+func (m *Manager) encryptPages(pages []page.PagePtr, rel common.Relation, forkNum ForkNumber, start page.PageID) ([]page.PagePtr, error) {
+	if m.cipher == nil {
+		return pages, nil
+	}
+	encrypted := make([]page.PagePtr, len(pages))
+	for i, p := range pages {
+		ep := page.NewPagePtr()
+		copy(ep[:page.LSNSize], p[:page.LSNSize])
+		tw := encryption.NewTweak(uint8(forkNum), uint32(rel.RelFileNumber), uint32(start+page.PageID(i)))
+		if err := m.cipher.Encrypt(ep[page.LSNSize:], p[page.LSNSize:], tw); err != nil {
+			return nil, errors.Wrap(err, "Encrypt failed")
+		}
+		encrypted[i] = ep
+	}
+	return encrypted, nil
+}
+
+// decryptPage decrypts the page read in place
+// the zero-filled page is not decrypted because it has never been written (ex: the hole of file)
+func (m *Manager) decryptPage(p page.PagePtr, rel common.Relation, forkNum ForkNumber, pageID page.PageID) error {
+	if m.cipher == nil || encryption.IsZero(p[:]) {
+		return nil
+	}
+	tw := encryption.NewTweak(uint8(forkNum), uint32(rel.RelFileNumber), uint32(pageID))
+	if err := m.cipher.Decrypt(p[page.LSNSize:], p[page.LSNSize:], tw); err != nil {
+		return errors.Wrap(err, "Decrypt failed")
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package disk
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/encryption"
+	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/stretchr/testify/assert"
+)
+
+func testingCipher(t *testing.T) *encryption.Cipher {
+	c, err := encryption.NewCipher(bytes.Repeat([]byte{'k'}, 64))
+	assert.Nil(t, err)
+	return c
+}
+
+func TestEncryptedReadWritePage(t *testing.T) {
+	dm, err := TestingNewFileManagerWithOptions(t, Options{Cipher: testingCipher(t)})
+	assert.Nil(t, err)
+	rel := common.Relation{RelFileNumber: 1}
+	err = dm.CreateRelation(rel)
+	assert.Nil(t, err)
+
+	p1 := testingPage('a')
+	p1[0] = 'l'
+	p2 := testingPage('a')
+	p2[0] = 'l'
+	pages := []page.PagePtr{p1, p2}
+	err = dm.WritePages(rel, ForkNumberMain, page.FirstPageID, pages, false)
+	assert.Nil(t, err)
+	// the page beyond the end is zero-filled (the hole of file)
+	err = dm.WritePage(rel, ForkNumberMain, page.PageID(3), testingPage('b'), false)
+	assert.Nil(t, err)
+
+	got, err := dm.ReadPages(rel, ForkNumberMain, page.FirstPageID, 4)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(p1[:], got[0][:]))
+	assert.True(t, bytes.Equal(p2[:], got[1][:]))
+	assert.True(t, bytes.Equal(page.NewPagePtr()[:], got[2][:]))
+	assert.True(t, bytes.Equal(testingPage('b')[:], got[3][:]))
+	// the page written is not modified by encryption
+	assert.Equal(t, byte('a'), p1[page.PageSize-1])
+
+	// the pages on disk are encrypted except for lsn
+	raw, err := os.ReadFile(testingSegmentPath(dm, rel, ForkNumberMain, 0))
+	assert.Nil(t, err)
+	raw1, raw2 := raw[:page.PageSize], raw[page.PageSize:2*page.PageSize]
+	assert.True(t, bytes.Equal(p1[:page.LSNSize], raw1[:page.LSNSize]))
+	assert.False(t, bytes.Equal(p1[page.LSNSize:], raw1[page.LSNSize:]))
+	// the same pages are encrypted differently at the different location
+	assert.False(t, bytes.Equal(raw1, raw2))
+
+	// the same page at the same page id of the different relation too
+	other := common.Relation{RelFileNumber: 2}
+	err = dm.CreateRelation(other)
+	assert.Nil(t, err)
+	err = dm.WritePage(other, ForkNumberMain, page.FirstPageID, p1, false)
+	assert.Nil(t, err)
+	rawOther, err := os.ReadFile(testingSegmentPath(dm, other, ForkNumberMain, 0))
+	assert.Nil(t, err)
+	assert.False(t, bytes.Equal(raw1, rawOther[:page.PageSize]))
+	gotOther := page.NewPagePtr()
+	err = dm.ReadPage(other, ForkNumberMain, page.FirstPageID, gotOther)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(p1[:], gotOther[:]))
+}
+
+func TestEncryptedCompressedRelation(t *testing.T) {
+	dm, err := TestingNewFileManagerWithOptions(t, Options{Cipher: testingCipher(t)})
+	assert.Nil(t, err)
+	err = dm.CreateCompressedRelation(common.Relation{RelFileNumber: 1}, CompressionDeflate)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,430 @@
+/*
+This file defines the storage wrapper which injects faults for crash and I/O error testing.
+
+FaultInjector is the script of faults shared by the storages. The faults are described below:
+  - fail the Nth read/write/sync: the operation returns ErrInjected without doing anything
+  - short read: the Nth read reads only the first bytes and returns io.ErrUnexpectedEOF
+  - torn write: the Nth write writes only the first bytes and returns ErrInjected (like power loss during write)
+  - latency: every read/write/sync sleeps before executing
+  - crash: the writes which have not been synced are dropped (like the contents of os page cache are lost)
+
+The faults can be injected through Opener (FaultOpener) or Storage (FaultStorage) interface,
+so the manager which uses them (ex: buffer manager, clog manager) can be tested without changing its code.
+This is intended to be used in test.
+*/
+package disk
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/pkg/errors"
+)
+
+// ErrInjected is the error returned by the injected fault
+var ErrInjected = errors.New("injected fault")
+
+// faultOp is the operation which the fault is injected into
+type faultOp int
+
+const (
+	faultOpRead faultOp = iota
+	faultOpWrite
+	faultOpSync
+)
+
+// fault is the fault scheduled to the operation
+type fault struct {
+	op faultOp
+	// countdown is decremented by each operation. the fault fires when it reaches 0
+	countdown int
+	// bytes is how many bytes are read/written actually. if negative, nothing is done and ErrInjected is returned
+	bytes int
+}
+
+// prefix returns how many bytes of the buffer whose length is n are read/written
+func (f *fault) prefix(n int) int {
+	if f.bytes < n {
+		return f.bytes
+	}
+	return n
+}
+
+// FaultInjector schedules the faults of the storages
+// this is safe for concurrent use
+type FaultInjector struct {
+	mu      sync.Mutex
+	faults  []*fault
+	latency time.Duration
+	// storages is the storages which have the writes not synced. they are rolled back by Crash()
+	storages map[*FaultStorage]struct{}
+}
+
+// NewFaultInjector initializes FaultInjector which injects no fault
+func NewFaultInjector() *FaultInjector {
+	return &FaultInjector{
+		storages: make(map[*FaultStorage]struct{}),
+	}
+}
+
+// FailRead makes the nth read from now fail. 1 means the next read
+func (fi *FaultInjector) FailRead(n int) {
+	fi.schedule(faultOpRead, n, -1)
+}
+
+// FailWrite makes the nth write from now fail. 1 means the next write
+func (fi *FaultInjector) FailWrite(n int) {
+	fi.schedule(faultOpWrite, n, -1)
+}
+
+// FailSync makes the nth sync from now fail. 1 means the next sync
+func (fi *FaultInjector) FailSync(n int) {
+	fi.schedule(faultOpSync, n, -1)
+}
+
+// ShortRead makes the nth read from now read only the first bytes
+func (fi *FaultInjector) ShortRead(n int, bytes int) {
+	fi.schedule(faultOpRead, n, bytes)
+}
+
+// TearWrite makes the nth write from now write only the first bytes
+func (fi *FaultInjector) TearWrite(n int, bytes int) {
+	fi.schedule(faultOpWrite, n, bytes)
+}
+
+// SetLatency makes every read/write/sync sleep for d. 0 means no latency
+func (fi *FaultInjector) SetLatency(d time.Duration) {
+	fi.mu.Lock()
+	fi.latency = d
+	fi.mu.Unlock()
+}
+
+// Reset removes all faults scheduled and the latency
+// the writes not synced are kept
+func (fi *FaultInjector) Reset() {
+	fi.mu.Lock()
+	fi.faults = nil
+	fi.latency = 0
+	fi.mu.Unlock()
+}
+
+// Crash drops the writes which have not been synced from all storages
+// the storages can be used after crash like the files after restart
+func (fi *FaultInjector) Crash() error {
+	fi.mu.Lock()
+	storages := fi.storages
+	fi.storages = make(map[*FaultStorage]struct{})
+	fi.mu.Unlock()
+	for fs := range storages {
+		if err := fs.rollback(); err != nil {
+			return errors.Wrap(err, "rollback failed")
+		}
+	}
+	return nil
+}
+
+// schedule adds the fault
+func (fi *FaultInjector) schedule(op faultOp, n int, bytes int) {
+	fi.mu.Lock()
+	fi.faults = append(fi.faults, &fault{op: op, countdown: n, bytes: bytes})
+	fi.mu.Unlock()
+}
+
+// next counts the operation and returns the fault which fires. if no fault fires, return nil
+// this also sleeps for the latency
+func (fi *FaultInjector) next(op faultOp) *fault {
+	fi.mu.Lock()
+	latency := fi.latency
+	var fired *fault
+	remaining := fi.faults[:0]
+	for _, f := range fi.faults {
+		if f.op == op {
+			f.countdown--
+			if f.countdown <= 0 && fired == nil {
+				fired = f
+				continue
+			}
+		}
+		remaining = append(remaining, f)
+	}
+	fi.faults = remaining
+	fi.mu.Unlock()
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	return fired
+}
+
+// track registers the storage which has the writes not synced
+func (fi *FaultInjector) track(fs *FaultStorage) {
+	fi.mu.Lock()
+	fi.storages[fs] = struct{}{}
+	fi.mu.Unlock()
+}
+
+// undo is the contents before the write (or truncate) which has not been synced
+type undo struct {
+	// size is the storage size before the write
+	size int64
+	// off and data are the contents overwritten by the write
+	off  int64
+	data []byte
+}
+
+// FaultStorage is the storage which injects faults scheduled by FaultInjector
+// this is safe for concurrent use
+type FaultStorage struct {
+	fi *FaultInjector
+	// mu protects st and undos, and serializes the writes to record undo
+	mu sync.Mutex
+	st Storage
+	// undos is the undo log of the writes not synced
+	undos []undo
+}
+
+// NewFaultStorage wraps the storage with faults scheduled by the injector
+func NewFaultStorage(st Storage, fi *FaultInjector) *FaultStorage {
+	return &FaultStorage{fi: fi, st: st}
+}
+
+// ReadAt reads storage at the offset into p
+func (fs *FaultStorage) ReadAt(p []byte, off int64) (int, error) {
+	if f := fs.fi.next(faultOpRead); f != nil {
+		if f.bytes < 0 {
+			return 0, ErrInjected
+		}
+		n, err := fs.storage().ReadAt(p[:f.prefix(len(p))], off)
+		if err != nil {
+			return n, err
+		}
+		return n, io.ErrUnexpectedEOF
+	}
+	return fs.storage().ReadAt(p, off)
+}
+
+// Read reads storage at the current position into p
+func (fs *FaultStorage) Read(p []byte) (int, error) {
+	off, err := fs.storage().Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	n, err := fs.ReadAt(p, off)
+	if _, serr := fs.storage().Seek(off+int64(n), io.SeekStart); serr != nil && err == nil {
+		err = serr
+	}
+	return n, err
+}
+
+// WriteAt writes p into storage at the offset
+func (fs *FaultStorage) WriteAt(p []byte, off int64) (int, error) {
+	if f := fs.fi.next(faultOpWrite); f != nil {
+		if f.bytes < 0 {
+			return 0, ErrInjected
+		}
+		n, err := fs.writeAt(p[:f.prefix(len(p))], off)
+		if err != nil {
+			return n, err
+		}
+		return n, ErrInjected
+	}
+	return fs.writeAt(p, off)
+}
+
+// Write writes p into storage at the current position
+func (fs *FaultStorage) Write(p []byte) (int, error) {
+	off, err := fs.storage().Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	n, err := fs.WriteAt(p, off)
+	if _, serr := fs.storage().Seek(off+int64(n), io.SeekStart); serr != nil && err == nil {
+		err = serr
+	}
+	return n, err
+}
+
+// writeAt records the contents overwritten and writes p
+func (fs *FaultStorage) writeAt(p []byte, off int64) (int, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	u, err := fs.record(off, off+int64(len(p)))
+	if err != nil {
+		return 0, errors.Wrap(err, "record failed")
+	}
+	fs.undos = append(fs.undos, u)
+	fs.fi.track(fs)
+	return fs.st.WriteAt(p, off)
+}
+
+// record returns the undo of the range [start, end). the caller must hold mu
+func (fs *FaultStorage) record(start, end int64) (undo, error) {
+	size, err := fs.st.Size()
+	if err != nil {
+		return undo{}, errors.Wrap(err, "Size failed")
+	}
+	u := undo{size: size, off: start}
+	if end > size {
+		end = size
+	}
+	if start < end {
+		u.data = make([]byte, end-start)
+		if _, err := fs.st.ReadAt(u.data, start); err != nil {
+			return undo{}, errors.Wrap(err, "ReadAt failed")
+		}
+	}
+	return u, nil
+}
+
+// Seek sets the offset for the next Read or Write
+func (fs *FaultStorage) Seek(offset int64, whence int) (int64, error) {
+	return fs.storage().Seek(offset, whence)
+}
+
+// Size returns the storage size
+func (fs *FaultStorage) Size() (int64, error) {
+	return fs.storage().Size()
+}
+
+// Truncate changes the storage size
+// this is treated as write, so this is rolled back by crash unless synced
+func (fs *FaultStorage) Truncate(size int64) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	cur, err := fs.st.Size()
+	if err != nil {
+		return errors.Wrap(err, "Size failed")
+	}
+	u, err := fs.record(size, cur)
+	if err != nil {
+		return errors.Wrap(err, "record failed")
+	}
+	fs.undos = append(fs.undos, u)
+	fs.fi.track(fs)
+	return fs.st.Truncate(size)
+}
+
+// Sync syncs the storage. the writes before sync survive crash
+func (fs *FaultStorage) Sync() error {
+	if f := fs.fi.next(faultOpSync); f != nil {
+		return ErrInjected
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fs.st.Sync(); err != nil {
+		return err
+	}
+	fs.undos = nil
+	return nil
+}
+
+// Close closes the storage
+func (fs *FaultStorage) Close() error {
+	return fs.storage().Close()
+}
+
+// storage returns the underlying storage
+func (fs *FaultStorage) storage() Storage {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.st
+}
+
+// rollback restores the contents before the writes not synced in reverse order
+func (fs *FaultStorage) rollback() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for i := len(fs.undos) - 1; i >= 0; i-- {
+		u := fs.undos[i]
+		if err := fs.st.Truncate(u.size); err != nil {
+			return errors.Wrap(err, "Truncate failed")
+		}
+		if len(u.data) > 0 {
+			if _, err := fs.st.WriteAt(u.data, u.off); err != nil {
+				return errors.Wrap(err, "WriteAt failed")
+			}
+		}
+	}
+	fs.undos = nil
+	return nil
+}
+
+// faultKey identifies the segment opened by FaultOpener
+type faultKey struct {
+	rel     common.Relation
+	forkNum ForkNumber
+	seg     SegmentNumber
+}
+
+// FaultOpener is the opener which opens the storages injecting faults scheduled by FaultInjector
+// the other operations are passed through to the wrapped opener
+type FaultOpener struct {
+	Opener
+	fi *FaultInjector
+
+	mu sync.Mutex
+	// storages keeps the storage per segment so that the writes not synced survive reopen until crash
+	storages map[faultKey]*FaultStorage
+}
+
+// NewFaultOpener wraps the opener with faults scheduled by the injector
+func NewFaultOpener(o Opener, fi *FaultInjector) *FaultOpener {
+	return &FaultOpener{
+		Opener:   o,
+		fi:       fi,
+		storages: make(map[faultKey]*FaultStorage),
+	}
+}
+
+// Open opens the segment with the wrapped opener and wraps it
+func (fo *FaultOpener) Open(rel common.Relation, forkNum ForkNumber, seg SegmentNumber) (Storage, error) {
+	st, err := fo.Opener.Open(rel, forkNum, seg)
+	if err != nil {
+		return nil, err
+	}
+	key := faultKey{rel: rel, forkNum: forkNum, seg: seg}
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+	fs, ok := fo.storages[key]
+	if !ok {
+		fs = NewFaultStorage(st, fo.fi)
+		fo.storages[key] = fs
+		return fs, nil
+	}
+	// the storage may be reopened after closed
+	fs.mu.Lock()
+	fs.st = st
+	fs.mu.Unlock()
+	return fs, nil
+}
+
+// Unlink removes the segments with the wrapped opener and forgets them
+func (fo *FaultOpener) Unlink(rel common.Relation, forkNum ForkNumber) error {
+	fo.forget(func(key faultKey) bool {
+		return key.rel == rel && key.forkNum == forkNum
+	})
+	return fo.Opener.Unlink(rel, forkNum)
+}
+
+// DropDatabase removes the database with the wrapped opener and forgets its segments
+func (fo *FaultOpener) DropDatabase(db common.Database) error {
+	fo.forget(func(key faultKey) bool {
+		return key.rel.Database == db
+	})
+	return fo.Opener.DropDatabase(db)
+}
+
+// forget discards the storages (and their writes not synced) whose key matches
+func (fo *FaultOpener) forget(match func(key faultKey) bool) {
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+	for key, fs := range fo.storages {
+		if match(key) {
+			fo.fi.mu.Lock()
+			delete(fo.fi.storages, fs)
+			fo.fi.mu.Unlock()
+			delete(fo.storages, key)
+		}
+	}
+}
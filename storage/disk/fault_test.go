@@ -0,0 +1,178 @@
+package disk
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFaultStorageFailWrite(t *testing.T) {
+	fi := NewFaultInjector()
+	fs := NewFaultStorage(NewBufferStorage(0), fi)
+
+	// the second write fails
+	fi.FailWrite(2)
+	_, err := fs.WriteAt([]byte("a"), 0)
+	assert.Nil(t, err)
+	_, err = fs.WriteAt([]byte("b"), 1)
+	assert.Equal(t, ErrInjected, err)
+	_, err = fs.WriteAt([]byte("c"), 2)
+	assert.Nil(t, err)
+
+	// the failed write doesn't change anything
+	p := make([]byte, 3)
+	_, err = fs.ReadAt(p, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{'a', 0, 'c'}, p)
+}
+
+func TestFaultStorageShortRead(t *testing.T) {
+	fi := NewFaultInjector()
+	fs := NewFaultStorage(NewBufferStorage(0), fi)
+	_, err := fs.WriteAt([]byte("abcd"), 0)
+	assert.Nil(t, err)
+
+	fi.ShortRead(1, 2)
+	p := make([]byte, 4)
+	n, err := fs.ReadAt(p, 0)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []byte{'a', 'b', 0, 0}, p)
+
+	// the fault fires only once
+	n, err = fs.ReadAt(p, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, n)
+}
+
+func TestFaultStorageTearWrite(t *testing.T) {
+	fi := NewFaultInjector()
+	fs := NewFaultStorage(NewBufferStorage(0), fi)
+	_, err := fs.WriteAt([]byte("abcd"), 0)
+	assert.Nil(t, err)
+
+	fi.TearWrite(1, 1)
+	n, err := fs.WriteAt([]byte("wxyz"), 0)
+	assert.Equal(t, ErrInjected, err)
+	assert.Equal(t, 1, n)
+
+	p := make([]byte, 4)
+	_, err = fs.ReadAt(p, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("wbcd"), p)
+}
+
+func TestFaultInjectorCrash(t *testing.T) {
+	fi := NewFaultInjector()
+	fs := NewFaultStorage(NewBufferStorage(0), fi)
+
+	// synced contents survive crash
+	_, err := fs.WriteAt([]byte("abcd"), 0)
+	assert.Nil(t, err)
+	assert.Nil(t, fs.Sync())
+
+	// overwrite, extend and truncate without sync
+	_, err = fs.WriteAt([]byte("xy"), 1)
+	assert.Nil(t, err)
+	_, err = fs.WriteAt([]byte("efgh"), 4)
+	assert.Nil(t, err)
+	assert.Nil(t, fs.Truncate(2))
+
+	assert.Nil(t, fi.Crash())
+	size, err := fs.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), size)
+	p := make([]byte, 4)
+	_, err = fs.ReadAt(p, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("abcd"), p)
+
+	// crash again doesn't change anything
+	assert.Nil(t, fi.Crash())
+	_, err = fs.ReadAt(p, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("abcd"), p)
+}
+
+func TestFaultStorageFailSync(t *testing.T) {
+	fi := NewFaultInjector()
+	fs := NewFaultStorage(NewBufferStorage(0), fi)
+	_, err := fs.WriteAt([]byte("abcd"), 0)
+	assert.Nil(t, err)
+
+	// the write is not persisted when sync fails
+	fi.FailSync(1)
+	assert.Equal(t, ErrInjected, fs.Sync())
+	assert.Nil(t, fi.Crash())
+	size, err := fs.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), size)
+}
+
+func TestFaultInjectorLatency(t *testing.T) {
+	fi := NewFaultInjector()
+	fs := NewFaultStorage(NewBufferStorage(1), fi)
+
+	fi.SetLatency(20 * time.Millisecond)
+	start := time.Now()
+	_, err := fs.ReadAt(make([]byte, 1), 0)
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	// Reset removes the latency and the faults
+	fi.FailRead(1)
+	fi.Reset()
+	start = time.Now()
+	_, err = fs.ReadAt(make([]byte, 1), 0)
+	assert.Nil(t, err)
+	assert.Less(t, time.Since(start), 20*time.Millisecond)
+}
+
+func TestFaultOpener(t *testing.T) {
+	fi := NewFaultInjector()
+	m, err := TestingNewFaultBufferManager(fi)
+	assert.Nil(t, err)
+
+	rel := common.Relation{RelFileNumber: 1}
+	pageID, err := m.ExtendPage(rel, ForkNumberMain, false)
+	assert.Nil(t, err)
+	rp, err := page.TestingNewRandomPage()
+	assert.Nil(t, err)
+
+	t.Run("write fails", func(t *testing.T) {
+		fi.FailWrite(1)
+		err := m.WritePage(rel, ForkNumberMain, pageID, rp, true)
+		assert.Equal(t, ErrInjected, errors.Cause(err))
+	})
+	t.Run("read fails", func(t *testing.T) {
+		fi.FailRead(1)
+		err := m.ReadPage(rel, ForkNumberMain, pageID, page.NewPagePtr())
+		assert.Equal(t, ErrInjected, errors.Cause(err))
+	})
+	t.Run("the write not synced is dropped by crash", func(t *testing.T) {
+		err := m.WritePage(rel, ForkNumberMain, pageID, rp, true)
+		assert.Nil(t, err)
+		assert.Nil(t, fi.Crash())
+
+		p := page.NewPagePtr()
+		err = m.ReadPage(rel, ForkNumberMain, pageID, p)
+		assert.Nil(t, err)
+		assert.True(t, bytes.Equal(page.NewPagePtr()[:], p[:]))
+	})
+	t.Run("the write synced survives crash", func(t *testing.T) {
+		err := m.WritePage(rel, ForkNumberMain, pageID, rp, false)
+		assert.Nil(t, err)
+		assert.Nil(t, fi.Crash())
+
+		p := page.NewPagePtr()
+		err = m.ReadPage(rel, ForkNumberMain, pageID, p)
+		assert.Nil(t, err)
+		assert.True(t, bytes.Equal(rp[:], p[:]))
+	})
+}
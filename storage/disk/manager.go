@@ -1,5 +1,5 @@
 /*
-Disk manager deals with the files under base directory.
+Disk manager deals with the files under base directory and tablespace directories.
 This mainly manages table files/fsm files/vm files/(index files if index is implemented).
 
 note: pg_xact directory (clog) and pg_wal directory (wal) are not managed by this manager.
@@ -10,104 +10,266 @@ See smgr README https://github.com/postgres/postgres/blob/b0a55e43299c4ea2a9a8c7
 
 Postgres seems to manage file descriptors by itself not to exceed system limits on the number of open files a single process can have.
 This may be called `virtual file descriptor` see https://github.com/postgres/postgres/blob/2d4f1ba6cfc2f0a977f1c30bda9848041343e248/src/backend/storage/file/fd.c#L1-L71
+ppdb also implements it. see vfd.go
+
+Relation fork file is divided into segments like postgres. see segment.go
+Relation can be placed in tablespace. see tablespace.go
+Relation belongs to database. see database.go
+The pages of relation can be compressed transparently. see compress.go
+The pages can be encrypted transparently. see encrypt.go
 
 ppdb does not support
-- database and schema (so CREATE DATABASE and CREATE SCHEMA is not supported)
-- the division of files into segments (see https://github.com/postgres/postgres/blob/85d8b30724c0fd117a683cc72706f71b28463a05/src/backend/storage/smgr/md.c#L44-L80
+- schema (so CREATE SCHEMA is not supported)
 - ...
 */
 package disk
 
 import (
 	"os"
+	"sync"
 
 	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/encryption"
 	"github.com/HayatoShiba/ppdb/storage/page"
 	"github.com/pkg/errors"
 )
 
-// the directory path of database files
-// table files, fsm files, vm files, (index files) are located under this directory
-// the path of the table file in postgres is
-// - base/database oid/table oid/files
-// ppdb supports only table, so the path of the table file in ppdb is
-// - base/database/table oid/files
-var baseDir = "base/database"
-
 // Manager manages disk
 type Manager struct {
 	// opener opens files or buffer on memory
-	opener
+	opener Opener
+	// pagesPerSegment is the number of pages per segment file
+	pagesPerSegment page.PageID
+	// extendMu serializes the extension of files
+	// the other operations can be executed concurrently because opener and storages are thread-safe
+	// and the io is executed at the offset (not the current position of the file)
+	// this is similar to relation extension lock in postgres
+	extendMu sync.Mutex
+
+	// compressMu protects compressions
+	compressMu sync.Mutex
+	// compressions caches the compression state of the relation forks accessed. nil means the fork is not compressed
+	compressions map[forkKey]*compressedFork
+
+	// cipher encrypts the pages. if nil, the pages are not encrypted
+	cipher *encryption.Cipher
 }
 
-// NewManager initializes disk manager
-func NewManager() (*Manager, error) {
-	// check whether the directory already exists
-	if _, err := os.Stat(baseDir); !os.IsExist(err) {
-		if err := os.MkdirAll(baseDir, 0700); err != nil {
+// IOMethod is the method to execute disk io
+// see io_method parameter in postgres 18
+type IOMethod int
+
+const (
+	// IOMethodSync executes io with pread/pwrite synchronously
+	IOMethodSync IOMethod = iota
+	// IOMethodIOUring executes io with io_uring. this is available only on linux/amd64 and linux/arm64
+	IOMethodIOUring
+)
+
+// Options is options of disk manager
+type Options struct {
+	// IOMethod is the method to execute disk io
+	IOMethod IOMethod
+	// PagesPerSegment is the number of pages per segment file. if 0, segment size is 1GB
+	PagesPerSegment int
+	// Cipher encrypts the pages written out. if nil, the pages are not encrypted
+	// the cipher is opened with the key of the cluster (see encryption.Open)
+	// the compressed relation cannot be created with encryption (see compress.go)
+	Cipher *encryption.Cipher
+}
+
+// NewManager initializes disk manager with default options
+// the files are located under the data directory
+func NewManager(dd *common.DataDir) (*Manager, error) {
+	return NewManagerWithOptions(dd, Options{})
+}
+
+// NewManagerWithOptions initializes disk manager with the options
+func NewManagerWithOptions(dd *common.DataDir, opts Options) (*Manager, error) {
+	pagesPerSegment, err := pagesPerSegment(opts.PagesPerSegment)
+	if err != nil {
+		return nil, errors.Wrap(err, "pagesPerSegment failed")
+	}
+	// the directory of default database and the directory for tablespace links
+	for _, dir := range []string{getDatabasePath(common.DefaultTablespace, common.DefaultDatabase), tablespaceLinkDir} {
+		if err := os.MkdirAll(dd.Join(dir), 0700); err != nil {
 			return nil, errors.Wrap(err, "os.MkdirAll failed")
 		}
 	}
 
-	return &Manager{newFileOpener()}, nil
+	fo := newFileOpener(dd.Path(), maxOpenFiles())
+	switch opts.IOMethod {
+	case IOMethodSync:
+	case IOMethodIOUring:
+		ring, err := newURing(uringEntries)
+		if err != nil {
+			return nil, errors.Wrap(err, "newURing failed")
+		}
+		fo.ring = ring
+	default:
+		return nil, errors.Errorf("unexpected io method: %d", opts.IOMethod)
+	}
+	return newManager(fo, pagesPerSegment, opts.Cipher), nil
+}
+
+// NewManagerWithOpener initializes disk manager with the storage backend
+// ex: NewManagerWithOpener(NewBufferOpener(), Options{}) runs fully in-memory instance
+// IOMethod option is not available because the io is executed by the storage opened by the opener
+func NewManagerWithOpener(o Opener, opts Options) (*Manager, error) {
+	if o == nil {
+		return nil, errors.New("opener is nil")
+	}
+	pagesPerSegment, err := pagesPerSegment(opts.PagesPerSegment)
+	if err != nil {
+		return nil, errors.Wrap(err, "pagesPerSegment failed")
+	}
+	if opts.IOMethod != IOMethodSync {
+		return nil, errors.Errorf("io method is not available with opener: %d", opts.IOMethod)
+	}
+	return newManager(o, pagesPerSegment, opts.Cipher), nil
+}
+
+// newManager initializes disk manager with the opener
+func newManager(o Opener, pagesPerSegment page.PageID, c *encryption.Cipher) *Manager {
+	return &Manager{
+		opener:          o,
+		pagesPerSegment: pagesPerSegment,
+		compressions:    make(map[forkKey]*compressedFork),
+		cipher:          c,
+	}
+}
+
+// pagesPerSegment validates the number of pages per segment. 0 means default
+func pagesPerSegment(n int) (page.PageID, error) {
+	if n == 0 {
+		return defaultPagesPerSegment, nil
+	}
+	if n < 0 || uint64(n) > uint64(page.MaxPageID) {
+		return 0, errors.Errorf("the number of pages per segment is out of range: %d", n)
+	}
+	return page.PageID(n), nil
 }
 
 // ReadPage reads page from disk into page.PagePtr
 func (m *Manager) ReadPage(rel common.Relation, forkNum ForkNumber, pageID page.PageID, p page.PagePtr) error {
-	offset := page.CalculateFileOffset(pageID)
-	st, err := m.open(rel, forkNum)
+	seg, segPageID := m.segmentOf(pageID)
+	st, err := m.open(rel, forkNum, seg)
 	if err != nil {
 		return errors.Wrap(err, "open failed")
 	}
 
-	ret, err := st.Seek(offset, os.SEEK_SET)
+	n, err := st.ReadAt(p[:], page.CalculateFileOffset(segPageID))
 	if err != nil {
-		return errors.Wrap(err, "Seek failed")
+		return errors.Wrap(err, "ReadAt failed")
 	}
-	if ret != offset {
-		return errors.Errorf("Seek failed to seek: ret %d, offset %d", ret, offset)
+	if n != len(p) {
+		return errors.Errorf("ReadAt failed to read the whole page: %d, page length is %d", n, len(p))
 	}
-	n, err := st.Read(p[:])
+	if err := m.decryptPage(p, rel, forkNum, pageID); err != nil {
+		return errors.Wrap(err, "decryptPage failed")
+	}
+	return nil
+}
+
+// ReadPages reads n pages from start page with one io per segment
+// this is expected to be used by bulk read (ex: sequential scan) which can issue large contiguous io
+// postgres (17 or later) implements this as mdreadv() in src/backend/storage/smgr/md.c
+func (m *Manager) ReadPages(rel common.Relation, forkNum ForkNumber, start page.PageID, n int) ([]page.PagePtr, error) {
+	if err := checkPageRange(start, n); err != nil {
+		return nil, errors.Wrap(err, "checkPageRange failed")
+	}
+	pages := make([]page.PagePtr, n)
+	bufs := make([][]byte, n)
+	for i := range pages {
+		pages[i] = page.NewPagePtr()
+		bufs[i] = pages[i][:]
+	}
+
+	err := m.forEachSegment(rel, forkNum, start, n, func(st Storage, i int, segPageID page.PageID, cnt int) error {
+		nread, err := readvAt(st, bufs[i:i+cnt], page.CalculateFileOffset(segPageID))
+		if err != nil {
+			return errors.Wrap(err, "readvAt failed")
+		}
+		if nread != cnt*page.PageSize {
+			return errors.Errorf("readvAt failed to read the whole pages: %d, pages length is %d", nread, cnt*page.PageSize)
+		}
+		return nil
+	})
 	if err != nil {
-		return errors.Wrap(err, "Read failed")
+		return nil, errors.Wrap(err, "forEachSegment failed")
 	}
-	if n != len(p) {
-		return errors.Errorf("Read failed to read the whole page: %d, page length is %d", n, len(p))
+	for i, p := range pages {
+		if err := m.decryptPage(p, rel, forkNum, start+page.PageID(i)); err != nil {
+			return nil, errors.Wrap(err, "decryptPage failed")
+		}
 	}
-	return nil
+	return pages, nil
 }
 
 // WritePage writes page out to disk
 // see https://github.com/postgres/postgres/blob/85d8b30724c0fd117a683cc72706f71b28463a05/src/backend/storage/smgr/md.c#L738
 func (m *Manager) WritePage(rel common.Relation, forkNum ForkNumber, pageID page.PageID, p page.PagePtr, skipFsync bool) error {
-	offset := page.CalculateFileOffset(pageID)
-	st, err := m.open(rel, forkNum)
-	if err != nil {
-		return errors.Wrap(err, "open failed")
+	return m.writePages(rel, forkNum, pageID, []page.PagePtr{p}, skipFsync)
+}
+
+// WritePages writes the pages out to disk contiguously from start page with one io per segment
+// postgres (17 or later) implements this as mdwritev() in src/backend/storage/smgr/md.c
+func (m *Manager) WritePages(rel common.Relation, forkNum ForkNumber, start page.PageID, pages []page.PagePtr, skipFsync bool) error {
+	if err := checkPageRange(start, len(pages)); err != nil {
+		return errors.Wrap(err, "checkPageRange failed")
 	}
+	return m.writePages(rel, forkNum, start, pages, skipFsync)
+}
 
-	ret, err := st.Seek(offset, os.SEEK_SET)
+// writePages writes the pages out to disk
+func (m *Manager) writePages(rel common.Relation, forkNum ForkNumber, start page.PageID, pages []page.PagePtr, skipFsync bool) error {
+	pages, err := m.encryptPages(pages, rel, forkNum, start)
 	if err != nil {
-		return errors.Wrap(err, "Seek failed")
-	}
-	if ret != offset {
-		return errors.Errorf("Seek failed to seek: ret %d, offset %d", ret, offset)
+		return errors.Wrap(err, "encryptPages failed")
 	}
+	err = m.forEachSegment(rel, forkNum, start, len(pages), func(st Storage, i int, segPageID page.PageID, cnt int) error {
+		var n int
+		var err error
+		size := cnt * page.PageSize
+		offset := page.CalculateFileOffset(segPageID)
+		if cnt == 1 {
+			n, err = st.WriteAt(pages[i][:], offset)
+		} else {
+			bufs := make([][]byte, cnt)
+			for j := range bufs {
+				bufs[j] = pages[i+j][:]
+			}
+			n, err = writevAt(st, bufs, offset)
+		}
+		if err != nil {
+			return errors.Wrap(err, "WriteAt failed")
+		}
+		if n != size {
+			return errors.Errorf("WriteAt failed to write the whole pages: %d, the pages length is %d", n, size)
+		}
 
-	n, err := st.Write(p[:])
+		if !skipFsync {
+			// postgres seems to send request to checkpointer at first?
+			// see https://github.com/postgres/postgres/blob/85d8b30724c0fd117a683cc72706f71b28463a05/src/backend/storage/smgr/md.c#L789
+			if err := st.Sync(); err != nil {
+				return errors.Wrap(err, "Sync failed")
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return errors.Wrap(err, "WriteAt failed")
-	}
-	if n != len(p) {
-		return errors.Errorf("WriteAt failed to write the whole page: %d, the page length is %d", n, len(p))
+		return errors.Wrap(err, "forEachSegment failed")
 	}
+	return nil
+}
 
-	if !skipFsync {
-		// postgres seems to send request to checkpointer at first?
-		// see https://github.com/postgres/postgres/blob/85d8b30724c0fd117a683cc72706f71b28463a05/src/backend/storage/smgr/md.c#L789
-		if err := st.Sync(); err != nil {
-			return errors.Wrap(err, "Sync failed")
-		}
+// checkPageRange checks n pages from start page are valid
+func checkPageRange(start page.PageID, n int) error {
+	if n <= 0 {
+		return errors.Errorf("the number of pages must be positive: %d", n)
+	}
+	if start == page.InvalidPageID || uint64(start)+uint64(n)-1 > uint64(page.MaxPageID) {
+		return errors.Errorf("the pages exceed MaxPageID: start %d, n %d", start, n)
 	}
 	return nil
 }
@@ -117,6 +279,8 @@ func (m *Manager) WritePage(rel common.Relation, forkNum ForkNumber, pageID page
 // TODO: have to consider concurrent access? (I'm not sure)
 // see https://github.com/postgres/postgres/blob/85d8b30724c0fd117a683cc72706f71b28463a05/src/backend/storage/smgr/md.c#L449
 func (m *Manager) ExtendPage(rel common.Relation, forkNum ForkNumber, skipFsync bool) (page.PageID, error) {
+	m.extendMu.Lock()
+	defer m.extendMu.Unlock()
 	pageID, err := m.GetNPageID(rel, forkNum)
 	if err != nil {
 		return page.InvalidPageID, errors.Wrap(err, "GetNPageID failed")
@@ -128,29 +292,154 @@ func (m *Manager) ExtendPage(rel common.Relation, forkNum ForkNumber, skipFsync
 	}
 
 	pid := pageID + 1
-	if err := m.WritePage(rel, forkNum, pid, page.NewPagePtr(), skipFsync); err != nil {
-		return page.InvalidPageID, errors.Wrap(err, "WritePage failed")
+	if err := m.writePages(rel, forkNum, pid, []page.PagePtr{page.NewPagePtr()}, skipFsync); err != nil {
+		return page.InvalidPageID, errors.Wrap(err, "writePages failed")
 	}
 	return pid, nil
 }
 
-// GetNPageID returns the last PageID of the file
+// Truncate truncates the relation fork to nPages pages. the pages from page id nPages are removed
+// the caller is responsible for dropping the buffers of the removed pages before truncation (see buffer.Manager.Truncate)
+// otherwise the dirty buffer is written out later and the file is extended again.
+// like postgres, the segments after the new last segment are truncated to 0 but not removed.
+// the empty segment is ignored by GetNPageID() and reused when the relation fork is extended again.
+// see mdtruncate() https://github.com/postgres/postgres/blob/85d8b30724c0fd117a683cc72706f71b28463a05/src/backend/storage/smgr/md.c
+func (m *Manager) Truncate(rel common.Relation, forkNum ForkNumber, nPages int) error {
+	// prevent the relation fork from being extended during truncation
+	m.extendMu.Lock()
+	defer m.extendMu.Unlock()
+
+	lastPageID, err := m.GetNPageID(rel, forkNum)
+	if err != nil {
+		return errors.Wrap(err, "GetNPageID failed")
+	}
+	curPages := uint64(lastPageID) + 1
+	if lastPageID == page.InvalidPageID {
+		curPages = 0
+	}
+	if nPages < 0 || uint64(nPages) > curPages {
+		return errors.Errorf("the number of pages is out of range: %d, the relation fork has %d pages", nPages, curPages)
+	}
+	if uint64(nPages) == curPages {
+		// nothing to do
+		return nil
+	}
+
+	lastSeg, _ := m.segmentOf(lastPageID)
+	for seg := SegmentNumber(0); seg <= lastSeg; seg++ {
+		priorPages := uint64(seg) * uint64(m.pagesPerSegment)
+		if priorPages+uint64(m.pagesPerSegment) <= uint64(nPages) {
+			// the whole segment remains
+			continue
+		}
+		// the segment is no longer active (truncated to 0) or partially truncated
+		var keep uint64
+		if uint64(nPages) > priorPages {
+			keep = uint64(nPages) - priorPages
+		}
+		st, err := m.open(rel, forkNum, seg)
+		if err != nil {
+			return errors.Wrap(err, "open failed")
+		}
+		if err := st.Truncate(int64(keep) * page.PageSize); err != nil {
+			return errors.Wrapf(err, "Truncate failed: segment %d", seg)
+		}
+		// the truncation must be persistent before the removed pages are reused
+		if err := st.Sync(); err != nil {
+			return errors.Wrapf(err, "Sync failed: segment %d", seg)
+		}
+	}
+	return nil
+}
+
+// GetNPageID returns the last PageID of the relation fork
+// the segments are checked in order until the segment which is not full is found
 // maybe the last page id should be cached for the performance improvement
 // see https://github.com/postgres/postgres/blob/85d8b30724c0fd117a683cc72706f71b28463a05/src/backend/storage/smgr/md.c#L801
 func (m *Manager) GetNPageID(rel common.Relation, forkNum ForkNumber) (page.PageID, error) {
-	st, err := m.open(rel, forkNum)
-	if err != nil {
-		return page.InvalidPageID, errors.Wrap(err, "openRelationForkFile failed")
+	for seg := SegmentNumber(0); ; seg++ {
+		st, err := m.open(rel, forkNum, seg)
+		if err != nil {
+			return page.InvalidPageID, errors.Wrap(err, "openRelationForkFile failed")
+		}
+		size, err := st.Size()
+		if err != nil {
+			return page.InvalidPageID, errors.Wrap(err, "f.Stat failed")
+		}
+		// ignore torn page
+		// see https://github.com/postgres/postgres/blob/85d8b30724c0fd117a683cc72706f71b28463a05/src/backend/storage/smgr/md.c#L1366
+		nPages := uint64(size / page.PageSize)
+		if nPages < uint64(m.pagesPerSegment) {
+			return lastPageID(seg, m.pagesPerSegment, nPages), nil
+		}
+		// the segment is full. check whether the next segment exists
+		// the next segment is not created here, otherwise the segment lost by accident is created silently
+		ok, err := m.exists(rel, forkNum, seg+1)
+		if err != nil {
+			return page.InvalidPageID, errors.Wrap(err, "exists failed")
+		}
+		if !ok {
+			return lastPageID(seg, m.pagesPerSegment, nPages), nil
+		}
 	}
-	size, err := st.Size()
-	if err != nil {
-		return page.InvalidPageID, errors.Wrap(err, "f.Stat failed")
+}
+
+// lastPageID returns the last page id when the segment has nPages pages and it is the last segment
+// if there is no page, return InvalidPageID
+func lastPageID(seg SegmentNumber, pagesPerSegment page.PageID, nPages uint64) page.PageID {
+	total := uint64(seg)*uint64(pagesPerSegment) + nPages
+	if total == 0 {
+		return page.InvalidPageID
 	}
-	if size == 0 {
-		return page.InvalidPageID, nil
+	return page.PageID(total - 1)
+}
+
+// CreateRelation creates the main fork file of the relation
+// if the file already exists, return error. fsm and vm fork files are created when they are accessed first
+// see mdcreate() https://github.com/postgres/postgres/blob/85d8b30724c0fd117a683cc72706f71b28463a05/src/backend/storage/smgr/md.c
+func (m *Manager) CreateRelation(rel common.Relation) error {
+	if err := m.opener.Create(rel, ForkNumberMain); err != nil {
+		return errors.Wrap(err, "create failed")
 	}
-	// ignore torn page
-	// see https://github.com/postgres/postgres/blob/85d8b30724c0fd117a683cc72706f71b28463a05/src/backend/storage/smgr/md.c#L1366
-	lastPageID := (size / page.PageSize) - 1
-	return page.PageID(lastPageID), nil
+	return nil
+}
+
+// UnlinkRelation removes all fork files (main, fsm, vm and compressed main) of the relation
+// the fork file which doesn't exist is skipped
+// the caller is responsible for dropping the buffers of the relation before unlink (see buffer.Manager.UnlinkRelation)
+// see mdunlink() https://github.com/postgres/postgres/blob/85d8b30724c0fd117a683cc72706f71b28463a05/src/backend/storage/smgr/md.c
+func (m *Manager) UnlinkRelation(rel common.Relation) error {
+	// prevent the relation from being extended during unlink
+	m.extendMu.Lock()
+	defer m.extendMu.Unlock()
+	m.forgetCompression(func(key forkKey) bool {
+		return key.rel == rel
+	})
+	for forkNum := ForkNumberMain; forkNum <= maxFileForkNum; forkNum++ {
+		if err := m.opener.Unlink(rel, forkNum); err != nil {
+			return errors.Wrapf(err, "unlink failed: fork %d", forkNum)
+		}
+	}
+	return nil
+}
+
+// CloseRelation closes all fork files of the relation
+// the files are opened again when accessed next time
+// this is mdclose() in postgres. see https://github.com/postgres/postgres/blob/85d8b30724c0fd117a683cc72706f71b28463a05/src/backend/storage/smgr/md.c
+func (m *Manager) CloseRelation(rel common.Relation) error {
+	m.forgetCompression(func(key forkKey) bool {
+		return key.rel == rel
+	})
+	if err := m.opener.CloseRelation(rel); err != nil {
+		return errors.Wrap(err, "closeRelation failed")
+	}
+	return nil
+}
+
+// Close closes all files. disk manager cannot be used anymore
+func (m *Manager) Close() error {
+	if err := m.opener.Close(); err != nil {
+		return errors.Wrap(err, "close failed")
+	}
+	return nil
 }
@@ -3,6 +3,7 @@ package disk
 import (
 	"bytes"
 	"os"
+	"sync"
 	"testing"
 
 	"github.com/HayatoShiba/ppdb/common"
@@ -11,9 +12,38 @@ import (
 )
 
 func TestNewManager(t *testing.T) {
-	baseDir = t.TempDir()
-	_, err := NewManager()
+	dm, err := NewManager(common.TestingNewDataDir(t))
 	assert.Nil(t, err)
+	defer dm.Close()
+}
+
+func TestNewManagerOnDifferentDataDir(t *testing.T) {
+	// two clusters can be opened in one process
+	dm1, err := TestingNewFileManager(t)
+	assert.Nil(t, err)
+	dm2, err := TestingNewFileManager(t)
+	assert.Nil(t, err)
+
+	rel := common.Relation{RelFileNumber: 1}
+	p1 := testingPage('a')
+	p2 := testingPage('b')
+	err = dm1.WritePage(rel, ForkNumberMain, page.FirstPageID, p1, false)
+	assert.Nil(t, err)
+	err = dm2.WritePage(rel, ForkNumberMain, page.FirstPageID, p2, false)
+	assert.Nil(t, err)
+
+	got := page.NewPagePtr()
+	err = dm1.ReadPage(rel, ForkNumberMain, page.FirstPageID, got)
+	assert.Nil(t, err)
+	assert.Equal(t, p1, got)
+	err = dm2.ReadPage(rel, ForkNumberMain, page.FirstPageID, got)
+	assert.Nil(t, err)
+	assert.Equal(t, p2, got)
+}
+
+// testingSegmentPath returns the path of the segment file of disk manager with file storage
+func testingSegmentPath(dm *Manager, rel common.Relation, forkNum ForkNumber, seg SegmentNumber) string {
+	return dm.opener.(*fileOpener).path(rel, forkNum, seg)
 }
 
 func TestReadPage(t *testing.T) {
@@ -21,8 +51,8 @@ func TestReadPage(t *testing.T) {
 	assert.Nil(t, err)
 
 	// create test file
-	rel := common.Relation(1)
-	path := getRelationForkFilePath(rel, ForkNumberMain)
+	rel := common.Relation{RelFileNumber: 1}
+	path := testingSegmentPath(dm, rel, ForkNumberMain, 0)
 	f, err := os.Create(path)
 	assert.Nil(t, err)
 
@@ -40,12 +70,12 @@ func TestWritePage(t *testing.T) {
 	dm, err := TestingNewFileManager(t)
 	assert.Nil(t, err)
 
-	rel := common.Relation(1)
+	rel := common.Relation{RelFileNumber: 1}
 	expected := [page.PageSize]byte{'g', 'a'}
 	err = dm.WritePage(rel, ForkNumberMain, page.FirstPageID, page.PagePtr(&expected), false)
 	assert.Nil(t, err)
 
-	path := getRelationForkFilePath(rel, ForkNumberMain)
+	path := testingSegmentPath(dm, rel, ForkNumberMain, 0)
 	got, err := os.ReadFile(path)
 	assert.Nil(t, err)
 
@@ -58,8 +88,8 @@ func TestExtendPage(t *testing.T) {
 	assert.Nil(t, err)
 
 	// create test file
-	rel := common.Relation(1)
-	path := getRelationForkFilePath(rel, ForkNumberMain)
+	rel := common.Relation{RelFileNumber: 1}
+	path := testingSegmentPath(dm, rel, ForkNumberMain, 0)
 	f, err := os.Create(path)
 	assert.Nil(t, err)
 
@@ -105,8 +135,8 @@ func TestGetNPageID(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// create test file
-			rel := common.Relation(1)
-			path := getRelationForkFilePath(rel, ForkNumberMain)
+			rel := common.Relation{RelFileNumber: 1}
+			path := testingSegmentPath(dm, rel, ForkNumberMain, 0)
 			f, err := os.Create(path)
 			assert.Nil(t, err)
 
@@ -122,3 +152,263 @@ func TestGetNPageID(t *testing.T) {
 		})
 	}
 }
+
+func TestReadWritePages(t *testing.T) {
+	managers := []struct {
+		name string
+		new  func(t *testing.T) (*Manager, error)
+	}{
+		{
+			name: "file storage",
+			new:  TestingNewFileManager,
+		},
+		{
+			name: "buffer storage",
+			new: func(t *testing.T) (*Manager, error) {
+				return TestingNewBufferManager()
+			},
+		},
+		{
+			name: "io_uring",
+			new: func(t *testing.T) (*Manager, error) {
+				dm, err := NewManagerWithOptions(common.TestingNewDataDir(t), Options{IOMethod: IOMethodIOUring})
+				if err != nil {
+					t.Skipf("io_uring is not available: %v", err)
+				}
+				t.Cleanup(func() {
+					dm.Close()
+				})
+				return dm, nil
+			},
+		},
+	}
+	for _, mm := range managers {
+		t.Run(mm.name, func(t *testing.T) {
+			dm, err := mm.new(t)
+			assert.Nil(t, err)
+			rel := common.Relation{RelFileNumber: 1}
+
+			// write 3 pages from page 1 with one io
+			expected := make([]page.PagePtr, 3)
+			for i := range expected {
+				expected[i] = page.NewPagePtr()
+				expected[i][0] = byte('a' + i)
+				expected[i][page.PageSize-1] = byte('x' + i)
+			}
+			err = dm.WritePages(rel, ForkNumberMain, page.FirstPageID+1, expected, false)
+			assert.Nil(t, err)
+			last, err := dm.GetNPageID(rel, ForkNumberMain)
+			assert.Nil(t, err)
+			assert.Equal(t, page.PageID(3), last)
+
+			// read them with one io
+			got, err := dm.ReadPages(rel, ForkNumberMain, page.FirstPageID+1, 3)
+			assert.Nil(t, err)
+			assert.Equal(t, len(expected), len(got))
+			for i := range expected {
+				assert.True(t, bytes.Equal(expected[i][:], got[i][:]))
+			}
+
+			// the pages written with one io can be read one by one
+			p := page.NewPagePtr()
+			err = dm.ReadPage(rel, ForkNumberMain, page.FirstPageID+2, p)
+			assert.Nil(t, err)
+			assert.True(t, bytes.Equal(expected[1][:], p[:]))
+
+			// the pages beyond the end of file cannot be read
+			_, err = dm.ReadPages(rel, ForkNumberMain, page.FirstPageID+2, 3)
+			assert.NotNil(t, err)
+			// invalid range
+			_, err = dm.ReadPages(rel, ForkNumberMain, page.FirstPageID, 0)
+			assert.NotNil(t, err)
+			_, err = dm.ReadPages(rel, ForkNumberMain, page.MaxPageID, 2)
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestIOURingConcurrentIO(t *testing.T) {
+	dm, err := NewManagerWithOptions(common.TestingNewDataDir(t), Options{IOMethod: IOMethodIOUring})
+	if err != nil {
+		t.Skipf("io_uring is not available: %v", err)
+	}
+	defer dm.Close()
+
+	// more goroutines than the entries of submission queue issue io at the same time
+	goroutineNum := uringEntries * 2
+	var wg sync.WaitGroup
+	for g := 0; g < goroutineNum; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			rel := common.Relation{RelFileNumber: common.RelFileNumber(g + 1)}
+			expected := page.NewPagePtr()
+			expected[0] = byte(g)
+			err := dm.WritePages(rel, ForkNumberMain, page.FirstPageID, []page.PagePtr{expected}, false)
+			assert.Nil(t, err)
+			got := page.NewPagePtr()
+			err = dm.ReadPage(rel, ForkNumberMain, page.FirstPageID, got)
+			assert.Nil(t, err)
+			assert.True(t, bytes.Equal(expected[:], got[:]), "goroutine %d", g)
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestReadPagesWithOneIO(t *testing.T) {
+	dm, err := TestingNewBufferManager()
+	assert.Nil(t, err)
+	rel := common.Relation{RelFileNumber: 1}
+	pages := []page.PagePtr{page.NewPagePtr(), page.NewPagePtr(), page.NewPagePtr()}
+	err = dm.WritePages(rel, ForkNumberMain, page.FirstPageID, pages, true)
+	assert.Nil(t, err)
+
+	_, err = dm.ReadPages(rel, ForkNumberMain, page.FirstPageID, len(pages))
+	assert.Nil(t, err)
+	cnt, err := TestingReadCount(dm, rel, ForkNumberMain)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, cnt)
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name      string
+		nPages    int
+		expected  page.PageID
+		segSizes  []int64
+		expectErr bool
+	}{
+		{
+			name:     "truncate within the last segment",
+			nPages:   4,
+			expected: page.PageID(3),
+			segSizes: []int64{2, 2, 0},
+		},
+		{
+			name:     "truncate within the first segment",
+			nPages:   1,
+			expected: page.FirstPageID,
+			segSizes: []int64{1, 0, 0},
+		},
+		{
+			name:     "truncate all pages",
+			nPages:   0,
+			expected: page.InvalidPageID,
+			segSizes: []int64{0, 0, 0},
+		},
+		{
+			name:     "nothing to truncate",
+			nPages:   5,
+			expected: page.PageID(4),
+			segSizes: []int64{2, 2, 1},
+		},
+		{
+			name:      "more pages than the relation fork",
+			nPages:    6,
+			expectErr: true,
+		},
+		{
+			name:      "negative",
+			nPages:    -1,
+			expectErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dm, err := NewManagerWithOptions(common.TestingNewDataDir(t), Options{PagesPerSegment: 2})
+			assert.Nil(t, err)
+			defer dm.Close()
+			rel := common.Relation{RelFileNumber: 1}
+			for i := 0; i < 5; i++ {
+				_, err := dm.ExtendPage(rel, ForkNumberMain, true)
+				assert.Nil(t, err)
+			}
+
+			err = dm.Truncate(rel, ForkNumberMain, tt.nPages)
+			if tt.expectErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			got, err := dm.GetNPageID(rel, ForkNumberMain)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expected, got)
+			for seg, size := range tt.segSizes {
+				stat, err := os.Stat(testingSegmentPath(dm, rel, ForkNumberMain, SegmentNumber(seg)))
+				assert.Nil(t, err)
+				assert.Equal(t, size*page.PageSize, stat.Size())
+			}
+
+			// the removed pages are extended again as new pages
+			pageID, err := dm.ExtendPage(rel, ForkNumberMain, true)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expected+1, pageID)
+		})
+	}
+}
+
+func TestCreateUnlinkRelation(t *testing.T) {
+	t.Run("file storage", func(t *testing.T) {
+		dm, err := NewManagerWithOptions(common.TestingNewDataDir(t), Options{PagesPerSegment: 2})
+		assert.Nil(t, err)
+		defer dm.Close()
+		rel := common.Relation{RelFileNumber: 1}
+
+		err = dm.CreateRelation(rel)
+		assert.Nil(t, err)
+		stat, err := os.Stat(testingSegmentPath(dm, rel, ForkNumberMain, 0))
+		assert.Nil(t, err)
+		assert.Equal(t, int64(0), stat.Size())
+		// the relation already exists
+		err = dm.CreateRelation(rel)
+		assert.NotNil(t, err)
+
+		// the main fork has 2 segments and fsm fork has 1 segment
+		for i := 0; i < 3; i++ {
+			_, err := dm.ExtendPage(rel, ForkNumberMain, true)
+			assert.Nil(t, err)
+		}
+		_, err = dm.ExtendPage(rel, ForkNumberFSM, true)
+		assert.Nil(t, err)
+
+		err = dm.UnlinkRelation(rel)
+		assert.Nil(t, err)
+		for _, path := range []string{
+			testingSegmentPath(dm, rel, ForkNumberMain, 0),
+			testingSegmentPath(dm, rel, ForkNumberMain, 1),
+			testingSegmentPath(dm, rel, ForkNumberFSM, 0),
+		} {
+			_, err := os.Stat(path)
+			assert.True(t, os.IsNotExist(err))
+		}
+		assert.Equal(t, 0, dm.opener.(*fileOpener).vfds.openCount())
+
+		// unlink of the relation which doesn't exist does nothing
+		err = dm.UnlinkRelation(rel)
+		assert.Nil(t, err)
+		// the relation can be created again
+		err = dm.CreateRelation(rel)
+		assert.Nil(t, err)
+		last, err := dm.GetNPageID(rel, ForkNumberMain)
+		assert.Nil(t, err)
+		assert.Equal(t, page.InvalidPageID, last)
+	})
+	t.Run("buffer storage", func(t *testing.T) {
+		dm, err := TestingNewBufferManager()
+		assert.Nil(t, err)
+		rel := common.Relation{RelFileNumber: 1}
+
+		err = dm.CreateRelation(rel)
+		assert.Nil(t, err)
+		err = dm.CreateRelation(rel)
+		assert.NotNil(t, err)
+		_, err = dm.ExtendPage(rel, ForkNumberMain, true)
+		assert.Nil(t, err)
+
+		err = dm.UnlinkRelation(rel)
+		assert.Nil(t, err)
+		ok, err := dm.opener.Exists(rel, ForkNumberMain, 0)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+	})
+}
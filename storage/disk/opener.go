@@ -1,75 +1,535 @@
 /*
-This file defines opener interface and its implementations.
+This file defines Opener interface and its implementations.
 We don't want to execute disk I/O in test, so it's better to use byte slice instead of actual file in test.
-For this reason, opener interface is defined. Opener opens its storage. The implementations are:
-- fileOpener: open and return file.
-- bufferOpener: open and return byte slice. this is intended to be used in test.
+For this reason, Opener interface is defined. Opener opens its storage. The implementations are:
+- fileOpener: open and return file through vfd cache (see vfd.go). this is used by NewManager.
+- BufferOpener: open and return byte slice. this is intended to be used in test or in-memory instance.
+
+Opener is exported so that the other packages can supply their own storage backend (see NewManagerWithOpener).
+Opener is used by multiple goroutines concurrently, so the implementations must be thread-safe.
 */
 package disk
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 
 	"github.com/HayatoShiba/ppdb/common"
 	"github.com/pkg/errors"
 )
 
-// opener opens storage
-type opener interface {
-	open(common.Relation, ForkNumber) (storage, error)
+// Opener opens storage
+type Opener interface {
+	// Open opens the segment of relation fork. if it doesn't exist, it is created
+	Open(common.Relation, ForkNumber, SegmentNumber) (Storage, error)
+	// Exists returns whether the segment of relation fork exists
+	Exists(common.Relation, ForkNumber, SegmentNumber) (bool, error)
+	// Create creates the first segment of relation fork. if it already exists, return error
+	Create(common.Relation, ForkNumber) error
+	// Unlink closes and removes all segments of relation fork. if it doesn't exist, do nothing
+	Unlink(common.Relation, ForkNumber) error
+	// CloseRelation closes all segments of all fork files of the relation
+	CloseRelation(common.Relation) error
+	// CreateTablespace creates the tablespace located at the location. if it already exists, return error
+	CreateTablespace(common.Tablespace, string) error
+	// DropTablespace drops the empty tablespace. if it doesn't exist or isn't empty, return error
+	DropTablespace(common.Tablespace) error
+	// CreateDatabase creates the database by copying the files of the template database in all tablespaces
+	CreateDatabase(db, template common.Database) error
+	// DropDatabase closes and removes the files of the database in all tablespaces
+	DropDatabase(common.Database) error
+	// Close closes all files
+	Close() error
 }
 
 // fileOpener opens file
 // maybe should be better name
 type fileOpener struct {
-	// cache file descriptors after open the files
-	st map[string]storage
+	// dir is data directory where the files are located
+	dir string
+	// vfds caches file descriptors after open the files
+	vfds *vfdCache
+	// ring is io_uring instance shared by the files. if nil, io is executed with pread/pwrite
+	ring *uring
 }
 
 // newFileOpener initializes fileOpener
-func newFileOpener() *fileOpener {
-	return &fileOpener{
-		st: make(map[string]storage),
+// at most maxOpen files are opened at the same time
+func newFileOpener(dir string, maxOpen int) *fileOpener {
+	fo := &fileOpener{dir: dir}
+	fo.vfds = newVFDCache(maxOpen, fo.openFile)
+	return fo
+}
+
+// path returns the path of the segment file under data directory
+func (fo *fileOpener) path(rel common.Relation, forkNum ForkNumber, seg SegmentNumber) string {
+	return filepath.Join(fo.dir, getSegmentFilePath(rel, forkNum, seg))
+}
+
+// Open opens and returns specified database file under data directory
+func (fo *fileOpener) Open(rel common.Relation, forkNum ForkNumber, seg SegmentNumber) (Storage, error) {
+	filePath := fo.path(rel, forkNum, seg)
+	v, err := fo.vfds.get(filePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "vfds.get failed")
 	}
+	return v, nil
 }
 
-// open opens and returns specified database file under base directory
-func (fo *fileOpener) open(rel common.Relation, forkNum ForkNumber) (storage, error) {
-	filePath := getRelationForkFilePath(rel, forkNum)
-	// when file descriptor is cached, just return it
-	st, ok := fo.st[filePath]
-	if ok {
-		return st, nil
+// Exists returns whether the file exists
+func (fo *fileOpener) Exists(rel common.Relation, forkNum ForkNumber, seg SegmentNumber) (bool, error) {
+	filePath := fo.path(rel, forkNum, seg)
+	if fo.vfds.has(filePath) {
+		return true, nil
+	}
+	if _, err := os.Stat(filePath); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "os.Stat failed")
+	}
+	return true, nil
+}
+
+// Create creates the first segment file
+// the file is opened through vfd cache when accessed
+func (fo *fileOpener) Create(rel common.Relation, forkNum ForkNumber) error {
+	if err := fo.createDatabaseDir(rel.Tablespace, rel.Database); err != nil {
+		return errors.Wrap(err, "createDatabaseDir failed")
+	}
+	filePath := fo.path(rel, forkNum, 0)
+	fd, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0700)
+	if err != nil {
+		return errors.Wrap(err, "os.OpenFile failed")
+	}
+	if err := fd.Close(); err != nil {
+		return errors.Wrap(err, "Close failed")
 	}
-	fd, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, 0700)
+	return nil
+}
+
+// Unlink closes and removes all segment files
+// the segments are removed in order until the segment which doesn't exist is found
+// postgres truncates the first segment and removes it at next checkpoint not to reuse the relfilenode before checkpoint,
+// but ppdb removes it at once because ppdb doesn't implement checkpoint yet.
+// see mdunlinkfork() https://github.com/postgres/postgres/blob/85d8b30724c0fd117a683cc72706f71b28463a05/src/backend/storage/smgr/md.c
+func (fo *fileOpener) Unlink(rel common.Relation, forkNum ForkNumber) error {
+	base := filepath.Join(fo.dir, getRelationForkFilePath(rel, forkNum))
+	err := fo.vfds.closePaths(func(path string) bool {
+		return path == base || strings.HasPrefix(path, base+".")
+	}, true)
+	if err != nil {
+		return errors.Wrap(err, "vfds.closePaths failed")
+	}
+	for seg := SegmentNumber(0); ; seg++ {
+		if err := os.Remove(fo.path(rel, forkNum, seg)); err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return errors.Wrapf(err, "os.Remove failed: segment %d", seg)
+		}
+	}
+}
+
+// openFile opens the actual file. this is called by vfd cache
+func (fo *fileOpener) openFile(path string) (Storage, error) {
+	fd, err := openOSFile(path)
 	if err != nil {
-		return nil, errors.Wrap(err, "os.OpenFile failed")
+		return nil, errors.Wrap(err, "openOSFile failed")
+	}
+	if fo.ring != nil {
+		return uringStorage{fileStorage{fd}, fo.ring}, nil
 	}
-	// cache file descriptor when open the file
-	fo.st[filePath] = fileStorage{fd}
 	return fileStorage{fd}, nil
 }
 
-// bufferOpener opens buffer
-type bufferOpener struct {
-	st map[string]storage
+// CloseRelation closes all segments of all fork files of the relation
+func (fo *fileOpener) CloseRelation(rel common.Relation) error {
+	for forkNum := ForkNumberMain; forkNum <= maxFileForkNum; forkNum++ {
+		base := filepath.Join(fo.dir, getRelationForkFilePath(rel, forkNum))
+		err := fo.vfds.closePaths(func(path string) bool {
+			return path == base || strings.HasPrefix(path, base+".")
+		}, false)
+		if err != nil {
+			return errors.Wrap(err, "vfds.closePaths failed")
+		}
+	}
+	return nil
+}
+
+// CreateTablespace links the location from pg_tblspc directory
+func (fo *fileOpener) CreateTablespace(spc common.Tablespace, location string) error {
+	link := filepath.Join(fo.dir, getTablespacePath(spc))
+	if _, err := os.Lstat(link); err == nil {
+		return errors.Errorf("the tablespace already exists: %s", link)
+	} else if !os.IsNotExist(err) {
+		return errors.Wrap(err, "os.Lstat failed")
+	}
+	if err := os.MkdirAll(location, 0700); err != nil {
+		return errors.Wrap(err, "os.MkdirAll failed")
+	}
+	// if the location is not empty, the location may be used by other tablespace (maybe of other cluster)
+	entries, err := os.ReadDir(location)
+	if err != nil {
+		return errors.Wrap(err, "os.ReadDir failed")
+	}
+	if len(entries) > 0 {
+		return errors.Errorf("the directory is not empty. it may be already in use as a tablespace: %s", location)
+	}
+	if err := os.Symlink(location, link); err != nil {
+		return errors.Wrap(err, "os.Symlink failed")
+	}
+	return nil
+}
+
+// DropTablespace removes the empty directories of databases under the location and the link
+func (fo *fileOpener) DropTablespace(spc common.Tablespace) error {
+	link := filepath.Join(fo.dir, getTablespacePath(spc))
+	entries, err := os.ReadDir(link)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errors.Errorf("the tablespace doesn't exist: %s", link)
+		}
+		return errors.Wrap(err, "os.ReadDir failed")
+	}
+	// check all directories before removing anything
+	for _, entry := range entries {
+		files, err := os.ReadDir(filepath.Join(link, entry.Name()))
+		if err != nil {
+			return errors.Wrap(err, "os.ReadDir failed")
+		}
+		if len(files) > 0 {
+			return errors.Errorf("the tablespace is not empty: %s", link)
+		}
+	}
+	for _, entry := range entries {
+		if err := os.Remove(filepath.Join(link, entry.Name())); err != nil {
+			return errors.Wrap(err, "os.Remove failed")
+		}
+	}
+	if err := os.Remove(link); err != nil {
+		return errors.Wrap(err, "os.Remove failed")
+	}
+	return nil
+}
+
+// createDatabaseDir creates the directory of the database under the tablespace if it doesn't exist
+// the directory under default tablespace is created by createDatabase, so it must exist already
+// see TablespaceCreateDbspace() https://github.com/postgres/postgres/blob/20432f8731404d2cef2a155144aca5ab3ae98e95/src/backend/commands/tablespace.c
+func (fo *fileOpener) createDatabaseDir(spc common.Tablespace, db common.Database) error {
+	if _, err := os.Stat(filepath.Join(fo.dir, getDatabasePath(common.DefaultTablespace, db))); err != nil {
+		if os.IsNotExist(err) {
+			return errors.Errorf("the database doesn't exist: %d", db)
+		}
+		return errors.Wrap(err, "os.Stat failed")
+	}
+	if spc == common.DefaultTablespace {
+		return nil
+	}
+	// check the link in advance. otherwise MkdirAll creates the directory instead of the link
+	if _, err := os.Stat(filepath.Join(fo.dir, getTablespacePath(spc))); err != nil {
+		if os.IsNotExist(err) {
+			return errors.Errorf("the tablespace doesn't exist: %d", spc)
+		}
+		return errors.Wrap(err, "os.Stat failed")
+	}
+	if err := os.MkdirAll(filepath.Join(fo.dir, getDatabasePath(spc, db)), 0700); err != nil {
+		return errors.Wrap(err, "os.MkdirAll failed")
+	}
+	return nil
+}
+
+// tablespacePaths returns the paths of all tablespaces (relative to data directory)
+// default tablespace comes last
+func (fo *fileOpener) tablespacePaths() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(fo.dir, tablespaceLinkDir))
+	if err != nil {
+		return nil, errors.Wrap(err, "os.ReadDir failed")
+	}
+	paths := make([]string, 0, len(entries)+1)
+	for _, entry := range entries {
+		paths = append(paths, filepath.Join(tablespaceLinkDir, entry.Name()))
+	}
+	return append(paths, defaultTablespaceDir), nil
 }
 
-// newBufferOpener initializes bufferOpener
-func newBufferOpener() *bufferOpener {
-	return &bufferOpener{
-		st: make(map[string]storage),
+// CreateDatabase copies the directories of the template database into the new database directories
+// the directory under default tablespace is copied last, because its existence means the database exists
+// see createdb() and CreateDatabaseUsingFileCopy() https://github.com/postgres/postgres/blob/20432f8731404d2cef2a155144aca5ab3ae98e95/src/backend/commands/dbcommands.c
+func (fo *fileOpener) CreateDatabase(db, template common.Database) error {
+	if _, err := os.Stat(filepath.Join(fo.dir, getDatabasePath(common.DefaultTablespace, template))); err != nil {
+		if os.IsNotExist(err) {
+			return errors.Errorf("the template database doesn't exist: %d", template)
+		}
+		return errors.Wrap(err, "os.Stat failed")
 	}
+	if _, err := os.Stat(filepath.Join(fo.dir, getDatabasePath(common.DefaultTablespace, db))); err == nil {
+		return errors.Errorf("the database already exists: %d", db)
+	} else if !os.IsNotExist(err) {
+		return errors.Wrap(err, "os.Stat failed")
+	}
+	spcPaths, err := fo.tablespacePaths()
+	if err != nil {
+		return errors.Wrap(err, "tablespacePaths failed")
+	}
+	for _, spcPath := range spcPaths {
+		src := filepath.Join(fo.dir, spcPath, fmt.Sprintf("%d", template))
+		dst := filepath.Join(fo.dir, spcPath, fmt.Sprintf("%d", db))
+		// the template database may not have the directory under the tablespace
+		if _, err := os.Stat(src); os.IsNotExist(err) {
+			continue
+		}
+		if err := copyDir(src, dst); err != nil {
+			// remove the directories copied so far like createdb_failure_callback() in postgres
+			for _, spcPath := range spcPaths {
+				os.RemoveAll(filepath.Join(fo.dir, spcPath, fmt.Sprintf("%d", db)))
+			}
+			return errors.Wrapf(err, "copyDir failed: %s", src)
+		}
+	}
+	return nil
 }
 
-// open returns specified buffer
-func (bo *bufferOpener) open(rel common.Relation, forkNum ForkNumber) (storage, error) {
-	path := getRelationForkFilePath(rel, forkNum)
+// DropDatabase closes and removes the directories of the database
+// the directory under default tablespace is removed last, because its existence means the database exists
+// see dropdb() https://github.com/postgres/postgres/blob/20432f8731404d2cef2a155144aca5ab3ae98e95/src/backend/commands/dbcommands.c
+func (fo *fileOpener) DropDatabase(db common.Database) error {
+	if _, err := os.Stat(filepath.Join(fo.dir, getDatabasePath(common.DefaultTablespace, db))); err != nil {
+		if os.IsNotExist(err) {
+			return errors.Errorf("the database doesn't exist: %d", db)
+		}
+		return errors.Wrap(err, "os.Stat failed")
+	}
+	spcPaths, err := fo.tablespacePaths()
+	if err != nil {
+		return errors.Wrap(err, "tablespacePaths failed")
+	}
+	dirs := make([]string, 0, len(spcPaths))
+	for _, spcPath := range spcPaths {
+		dirs = append(dirs, filepath.Join(fo.dir, spcPath, fmt.Sprintf("%d", db)))
+	}
+	err = fo.vfds.closePaths(func(path string) bool {
+		for _, dir := range dirs {
+			if strings.HasPrefix(path, dir+string(filepath.Separator)) {
+				return true
+			}
+		}
+		return false
+	}, true)
+	if err != nil {
+		return errors.Wrap(err, "vfds.closePaths failed")
+	}
+	for _, dir := range dirs {
+		if err := os.RemoveAll(dir); err != nil {
+			return errors.Wrap(err, "os.RemoveAll failed")
+		}
+	}
+	return nil
+}
+
+// Close closes all files and io_uring instance
+func (fo *fileOpener) Close() error {
+	if err := fo.vfds.close(); err != nil {
+		return errors.Wrap(err, "vfds.close failed")
+	}
+	if fo.ring != nil {
+		if err := fo.ring.close(); err != nil {
+			return errors.Wrap(err, "ring.close failed")
+		}
+	}
+	return nil
+}
+
+// BufferOpener opens buffer on memory
+// the relation files are not persisted, so this is for test or in-memory instance
+// note: unlike file, the first segment is initialized with one page when it is opened without Create
+type BufferOpener struct {
+	mu sync.Mutex
+	st map[string]Storage
+	// tablespaces is the tablespaces created. the location is ignored because buffer is not located on disk
+	tablespaces map[common.Tablespace]struct{}
+	// databases is the databases created
+	databases map[common.Database]struct{}
+}
+
+// NewBufferOpener initializes BufferOpener which has default database only
+func NewBufferOpener() *BufferOpener {
+	return &BufferOpener{
+		st:          make(map[string]Storage),
+		tablespaces: make(map[common.Tablespace]struct{}),
+		databases:   map[common.Database]struct{}{common.DefaultDatabase: {}},
+	}
+}
+
+// hasTablespace returns whether the tablespace exists. the caller must hold mu
+// this corresponds to the file open failure because the directory doesn't exist
+func (bo *BufferOpener) hasTablespace(spc common.Tablespace) bool {
+	if spc == common.DefaultTablespace {
+		return true
+	}
+	_, ok := bo.tablespaces[spc]
+	return ok
+}
+
+// Open returns specified buffer
+func (bo *BufferOpener) Open(rel common.Relation, forkNum ForkNumber, seg SegmentNumber) (Storage, error) {
+	path := getSegmentFilePath(rel, forkNum, seg)
+	bo.mu.Lock()
+	defer bo.mu.Unlock()
+	if !bo.hasTablespace(rel.Tablespace) {
+		return nil, errors.Errorf("the tablespace doesn't exist: %d", rel.Tablespace)
+	}
+	if _, ok := bo.databases[rel.Database]; !ok {
+		return nil, errors.Errorf("the database doesn't exist: %d", rel.Database)
+	}
 	buf, ok := bo.st[path]
 	if ok {
 		return buf, nil
 	}
-	buf = newBufferStorage()
+	// the first segment is initialized with one page, and the following segments are empty like the new file
+	if seg == 0 {
+		buf = NewBufferStorage(1)
+	} else {
+		buf = NewBufferStorage(0)
+	}
 	bo.st[path] = buf
 	return buf, nil
 }
+
+// Exists returns whether the buffer has been opened
+func (bo *BufferOpener) Exists(rel common.Relation, forkNum ForkNumber, seg SegmentNumber) (bool, error) {
+	path := getSegmentFilePath(rel, forkNum, seg)
+	bo.mu.Lock()
+	defer bo.mu.Unlock()
+	_, ok := bo.st[path]
+	return ok, nil
+}
+
+// Create creates empty buffer for the first segment
+func (bo *BufferOpener) Create(rel common.Relation, forkNum ForkNumber) error {
+	path := getSegmentFilePath(rel, forkNum, 0)
+	bo.mu.Lock()
+	defer bo.mu.Unlock()
+	if !bo.hasTablespace(rel.Tablespace) {
+		return errors.Errorf("the tablespace doesn't exist: %d", rel.Tablespace)
+	}
+	if _, ok := bo.databases[rel.Database]; !ok {
+		return errors.Errorf("the database doesn't exist: %d", rel.Database)
+	}
+	if _, ok := bo.st[path]; ok {
+		return errors.Errorf("the relation fork already exists: %s", path)
+	}
+	// unlike open(), the buffer is empty like the file created newly
+	bo.st[path] = NewBufferStorage(0)
+	return nil
+}
+
+// Unlink removes the buffers of all segments
+func (bo *BufferOpener) Unlink(rel common.Relation, forkNum ForkNumber) error {
+	base := getRelationForkFilePath(rel, forkNum)
+	bo.mu.Lock()
+	defer bo.mu.Unlock()
+	for path := range bo.st {
+		if path == base || strings.HasPrefix(path, base+".") {
+			delete(bo.st, path)
+		}
+	}
+	return nil
+}
+
+// CloseRelation doesn't do anything
+// buffer works as file on disk, so the contents must remain after closed
+func (bo *BufferOpener) CloseRelation(rel common.Relation) error {
+	return nil
+}
+
+// CreateTablespace registers the tablespace
+func (bo *BufferOpener) CreateTablespace(spc common.Tablespace, location string) error {
+	bo.mu.Lock()
+	defer bo.mu.Unlock()
+	if bo.hasTablespace(spc) {
+		return errors.Errorf("the tablespace already exists: %d", spc)
+	}
+	bo.tablespaces[spc] = struct{}{}
+	return nil
+}
+
+// DropTablespace unregisters the tablespace
+func (bo *BufferOpener) DropTablespace(spc common.Tablespace) error {
+	bo.mu.Lock()
+	defer bo.mu.Unlock()
+	if !bo.hasTablespace(spc) {
+		return errors.Errorf("the tablespace doesn't exist: %d", spc)
+	}
+	dir := getTablespacePath(spc) + string(filepath.Separator)
+	for path := range bo.st {
+		if strings.HasPrefix(path, dir) {
+			return errors.Errorf("the tablespace is not empty: %d", spc)
+		}
+	}
+	delete(bo.tablespaces, spc)
+	return nil
+}
+
+// databasePrefixes returns the path prefixes of the database in all tablespaces. the caller must hold mu
+func (bo *BufferOpener) databasePrefixes(db common.Database) []string {
+	prefixes := []string{getDatabasePath(common.DefaultTablespace, db) + string(filepath.Separator)}
+	for spc := range bo.tablespaces {
+		prefixes = append(prefixes, getDatabasePath(spc, db)+string(filepath.Separator))
+	}
+	return prefixes
+}
+
+// CreateDatabase copies the buffers of the template database
+func (bo *BufferOpener) CreateDatabase(db, template common.Database) error {
+	bo.mu.Lock()
+	defer bo.mu.Unlock()
+	if _, ok := bo.databases[template]; !ok {
+		return errors.Errorf("the template database doesn't exist: %d", template)
+	}
+	if _, ok := bo.databases[db]; ok {
+		return errors.Errorf("the database already exists: %d", db)
+	}
+	srcs := bo.databasePrefixes(template)
+	dsts := bo.databasePrefixes(db)
+	copied := make(map[string]Storage)
+	for path, st := range bo.st {
+		for i, src := range srcs {
+			if strings.HasPrefix(path, src) {
+				copied[dsts[i]+strings.TrimPrefix(path, src)] = st.(*BufferStorage).clone()
+			}
+		}
+	}
+	for path, st := range copied {
+		bo.st[path] = st
+	}
+	bo.databases[db] = struct{}{}
+	return nil
+}
+
+// DropDatabase removes the buffers of the database
+func (bo *BufferOpener) DropDatabase(db common.Database) error {
+	bo.mu.Lock()
+	defer bo.mu.Unlock()
+	if _, ok := bo.databases[db]; !ok {
+		return errors.Errorf("the database doesn't exist: %d", db)
+	}
+	prefixes := bo.databasePrefixes(db)
+	for path := range bo.st {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(path, prefix) {
+				delete(bo.st, path)
+			}
+		}
+	}
+	delete(bo.databases, db)
+	return nil
+}
+
+// Close doesn't do anything. see CloseRelation
+func (bo *BufferOpener) Close() error {
+	return nil
+}
@@ -0,0 +1,53 @@
+package disk_test
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/disk"
+	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingOpener is the storage backend supplied by the other package
+// this wraps BufferOpener and counts how many times the storage is opened
+type countingOpener struct {
+	*disk.BufferOpener
+	nopen int64
+}
+
+func (co *countingOpener) Open(rel common.Relation, forkNum disk.ForkNumber, seg disk.SegmentNumber) (disk.Storage, error) {
+	atomic.AddInt64(&co.nopen, 1)
+	return co.BufferOpener.Open(rel, forkNum, seg)
+}
+
+func TestNewManagerWithOpener(t *testing.T) {
+	co := &countingOpener{BufferOpener: disk.NewBufferOpener()}
+	dm, err := disk.NewManagerWithOpener(co, disk.Options{PagesPerSegment: 2})
+	assert.Nil(t, err)
+	defer dm.Close()
+
+	rel := common.Relation{RelFileNumber: 1}
+	err = dm.CreateRelation(rel)
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		_, err := dm.ExtendPage(rel, disk.ForkNumberMain, true)
+		assert.Nil(t, err)
+	}
+	expected := page.NewPagePtr()
+	expected[0] = 'a'
+	err = dm.WritePage(rel, disk.ForkNumberMain, page.PageID(2), expected, true)
+	assert.Nil(t, err)
+	got := page.NewPagePtr()
+	err = dm.ReadPage(rel, disk.ForkNumberMain, page.PageID(2), got)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, got)
+	assert.True(t, atomic.LoadInt64(&co.nopen) > 0)
+
+	// io method is not available with opener
+	_, err = disk.NewManagerWithOpener(co, disk.Options{IOMethod: disk.IOMethodIOUring})
+	assert.NotNil(t, err)
+	_, err = disk.NewManagerWithOpener(nil, disk.Options{})
+	assert.NotNil(t, err)
+}
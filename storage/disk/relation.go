@@ -3,8 +3,11 @@ package disk
 import (
 	"fmt"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/HayatoShiba/ppdb/common"
+	"github.com/pkg/errors"
 )
 
 // relation has main table file, fsm file, vm file and these are identified with fork number.
@@ -21,23 +24,86 @@ const (
 	ForkNumberFSM
 	// ForkNumberVM is fork number of visibility map
 	ForkNumberVM
+	// forkNumberCompressed is fork number of compressed main fork (see compress.go)
+	// this is used only inside disk manager. the compressed main fork is accessed with ForkNumberMain from outside
+	forkNumberCompressed
 )
 
 // maxForkNum is for how many fork number exists
 const maxForkNum = ForkNumberVM
 
+// maxFileForkNum is the max fork number which has files including the ones used only inside disk manager
+const maxFileForkNum = forkNumberCompressed
+
 // forkFilePathSuffix is defined for file path
-var forkFilePathSuffix = []string{"main", "fsm", "vm"}
+var forkFilePathSuffix = []string{"main", "fsm", "vm", "cmp"}
+
+// String returns the name of the fork, which is used as the suffix of the fork file path
+func (f ForkNumber) String() string {
+	if f < ForkNumberMain || f > maxFileForkNum {
+		return fmt.Sprintf("fork(%d)", int(f))
+	}
+	return forkFilePathSuffix[f]
+}
 
-// getRelationForkFilePath returns file path under base directory
+// getRelationForkFilePath returns file path relative to data directory
 // the path of each relation fork file in ppdb is described below
-// - main table file: /base/database/tableOid
-// - fsm file:  /base/database/tableOid_fsm
-// - vm file: /base/database/tableOid_vm
+// - main table file: /base/database oid/relFileNumber
+// - fsm file:  /base/database oid/relFileNumber_fsm
+// - vm file: /base/database oid/relFileNumber_vm
+// - compressed main table file: /base/database oid/relFileNumber_cmp
+// when the relation is located in the tablespace other than default, /base is replaced with /pg_tblspc/tablespace oid
+// the data directory is joined by opener (see fileOpener.path())
 // see https://github.com/postgres/postgres/blob/a448e49bcbe40fb72e1ed85af910dd216d45bad8/src/common/relpath.c#L141
 func getRelationForkFilePath(rel common.Relation, forkNumber ForkNumber) string {
+	dir := getDatabasePath(rel.Tablespace, rel.Database)
 	if forkNumber == ForkNumberMain {
-		return filepath.Join(baseDir, fmt.Sprintf("%d", rel))
+		return filepath.Join(dir, fmt.Sprintf("%d", rel.RelFileNumber))
+	}
+	return filepath.Join(dir, fmt.Sprintf("%d_%s", rel.RelFileNumber, forkFilePathSuffix[forkNumber]))
+}
+
+// getSegmentFilePath returns file path of the segment of relation fork
+// the first segment doesn't have suffix, and the following segments have the suffix `.segmentNumber`
+// - main table file: /base/database oid/relFileNumber, /base/database oid/relFileNumber.1, ...
+// - fsm file: /base/database oid/relFileNumber_fsm, /base/database oid/relFileNumber_fsm.1, ...
+// this is _mdfd_segpath() in postgres
+func getSegmentFilePath(rel common.Relation, forkNumber ForkNumber, seg SegmentNumber) string {
+	path := getRelationForkFilePath(rel, forkNumber)
+	if seg == 0 {
+		return path
+	}
+	return fmt.Sprintf("%s.%d", path, seg)
+}
+
+// ParseSegmentFileName parses the file name of the segment of relation fork (ex: 16384_fsm.1)
+// this is the inverse of getSegmentFilePath, and used when the file is inspected directly (see /storage/inspect)
+func ParseSegmentFileName(name string) (common.RelFileNumber, ForkNumber, SegmentNumber, error) {
+	base, segStr, hasSeg := strings.Cut(name, ".")
+	var seg SegmentNumber
+	if hasSeg {
+		n, err := strconv.ParseUint(segStr, 10, 32)
+		if err != nil || n == 0 {
+			return 0, 0, 0, errors.Errorf("invalid segment number: %s", name)
+		}
+		seg = SegmentNumber(n)
+	}
+	relStr, suffix, hasSuffix := strings.Cut(base, "_")
+	forkNum := ForkNumberMain
+	if hasSuffix {
+		forkNum = -1
+		for f := ForkNumberFSM; f <= maxFileForkNum; f++ {
+			if forkFilePathSuffix[f] == suffix {
+				forkNum = f
+			}
+		}
+		if forkNum < 0 {
+			return 0, 0, 0, errors.Errorf("invalid fork: %s", name)
+		}
+	}
+	n, err := strconv.ParseUint(relStr, 10, 32)
+	if err != nil {
+		return 0, 0, 0, errors.Errorf("invalid relfilenumber: %s", name)
 	}
-	return filepath.Join(baseDir, fmt.Sprintf("%d_%s", rel, forkFilePathSuffix[forkNumber]))
+	return common.RelFileNumber(n), forkNum, seg, nil
 }
@@ -11,29 +11,107 @@ import (
 func TestGetRelationForkFilePath(t *testing.T) {
 	tests := []struct {
 		name     string
+		rel      common.Relation
 		forkNum  ForkNumber
 		expected string
 	}{
 		{
 			name:     "get main table path",
+			rel:      common.Relation{RelFileNumber: 1},
 			forkNum:  ForkNumberMain,
-			expected: filepath.Join(baseDir, "1"),
+			expected: filepath.Join("base", "0", "1"),
 		},
 		{
 			name:     "get fsm table path",
+			rel:      common.Relation{RelFileNumber: 1},
 			forkNum:  ForkNumberFSM,
-			expected: filepath.Join(baseDir, "1_fsm"),
+			expected: filepath.Join("base", "0", "1_fsm"),
 		},
 		{
 			name:     "get vm table path",
+			rel:      common.Relation{RelFileNumber: 1},
 			forkNum:  ForkNumberVM,
-			expected: filepath.Join(baseDir, "1_vm"),
+			expected: filepath.Join("base", "0", "1_vm"),
+		},
+		{
+			name:     "get main table path in tablespace",
+			rel:      common.Relation{Tablespace: 10, RelFileNumber: 1},
+			forkNum:  ForkNumberMain,
+			expected: filepath.Join("pg_tblspc", "10", "0", "1"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := getRelationForkFilePath(tt.rel, tt.forkNum)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestGetSegmentFilePath(t *testing.T) {
+	tests := []struct {
+		name     string
+		forkNum  ForkNumber
+		seg      SegmentNumber
+		expected string
+	}{
+		{
+			name:     "first segment",
+			forkNum:  ForkNumberMain,
+			seg:      0,
+			expected: filepath.Join("base", "0", "1"),
+		},
+		{
+			name:     "second segment",
+			forkNum:  ForkNumberMain,
+			seg:      1,
+			expected: filepath.Join("base", "0", "1.1"),
+		},
+		{
+			name:     "fsm segment",
+			forkNum:  ForkNumberFSM,
+			seg:      12,
+			expected: filepath.Join("base", "0", "1_fsm.12"),
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := getRelationForkFilePath(common.Relation(1), tt.forkNum)
+			got := getSegmentFilePath(common.Relation{RelFileNumber: 1}, tt.forkNum, tt.seg)
 			assert.Equal(t, tt.expected, got)
 		})
 	}
 }
+
+func TestParseSegmentFileName(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		rel     common.RelFileNumber
+		forkNum ForkNumber
+		seg     SegmentNumber
+		isErr   bool
+	}{
+		{name: "main fork", file: "16384", rel: 16384, forkNum: ForkNumberMain},
+		{name: "fsm fork", file: "16384_fsm", rel: 16384, forkNum: ForkNumberFSM},
+		{name: "the segment of vm fork", file: "16384_vm.2", rel: 16384, forkNum: ForkNumberVM, seg: 2},
+		{name: "compressed fork", file: "1_cmp", rel: 1, forkNum: forkNumberCompressed},
+		{name: "unknown fork", file: "16384_abc", isErr: true},
+		{name: "invalid relfilenumber", file: "abc", isErr: true},
+		{name: "invalid segment", file: "16384.0", isErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rel, forkNum, seg, err := ParseSegmentFileName(tt.file)
+			if tt.isErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.rel, rel)
+			assert.Equal(t, tt.forkNum, forkNum)
+			assert.Equal(t, tt.seg, seg)
+			// the inverse
+			assert.Equal(t, tt.file, filepath.Base(getSegmentFilePath(common.Relation{RelFileNumber: rel}, forkNum, seg)))
+		})
+	}
+}
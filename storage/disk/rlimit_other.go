@@ -0,0 +1,8 @@
+//go:build !unix
+
+package disk
+
+// openFileLimit returns false because RLIMIT_NOFILE is not available
+func openFileLimit() (uint64, bool) {
+	return 0, false
+}
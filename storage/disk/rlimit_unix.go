@@ -0,0 +1,14 @@
+//go:build unix
+
+package disk
+
+import "syscall"
+
+// openFileLimit returns the soft limit of the number of open files (RLIMIT_NOFILE)
+func openFileLimit() (uint64, bool) {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0, false
+	}
+	return uint64(rl.Cur), true
+}
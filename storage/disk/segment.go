@@ -0,0 +1,57 @@
+/*
+Segment files.
+
+A relation fork is divided into segment files of fixed size (1GB by default).
+- the first segment: base/database oid/relFileNumber
+- the following segments: base/database oid/relFileNumber.1, base/database oid/relFileNumber.2, ...
+(fsm and vm forks are also divided in the same way: tableOid_fsm.1, ...)
+
+This is because some file systems limit the file size, and large files are hard to handle for os utilities.
+Each segment except the last one must be full (have the pages of segment size).
+So the page is located with page id: the segment number is pageID / pages per segment
+and the offset in the segment is (pageID % pages per segment) * page size.
+
+postgres calls the segment size RELSEG_SIZE and it is fixed at build time.
+ppdb allows to configure it per disk manager (see Options), mainly for testing.
+see https://github.com/postgres/postgres/blob/85d8b30724c0fd117a683cc72706f71b28463a05/src/backend/storage/smgr/md.c#L44-L80
+*/
+package disk
+
+import (
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/pkg/errors"
+)
+
+// defaultPagesPerSegment is the number of pages per segment file. segment size is 1GB by default
+const defaultPagesPerSegment = (1 << 30) / page.PageSize
+
+// SegmentNumber identifies segment file of relation fork
+type SegmentNumber uint32
+
+// segmentOf returns the segment number where the page is located and the page id within the segment
+func (m *Manager) segmentOf(pageID page.PageID) (SegmentNumber, page.PageID) {
+	return SegmentNumber(pageID / m.pagesPerSegment), pageID % m.pagesPerSegment
+}
+
+// forEachSegment splits n pages from start page into the runs within each segment and calls f for each run
+// f receives the storage of the segment, the index of the first page of the run, the page id within the segment and the number of pages of the run
+func (m *Manager) forEachSegment(rel common.Relation, forkNum ForkNumber, start page.PageID, n int,
+	f func(st Storage, i int, segPageID page.PageID, cnt int) error) error {
+	for i := 0; i < n; {
+		seg, segPageID := m.segmentOf(start + page.PageID(i))
+		cnt := n - i
+		if rest := int(m.pagesPerSegment - segPageID); cnt > rest {
+			cnt = rest
+		}
+		st, err := m.open(rel, forkNum, seg)
+		if err != nil {
+			return errors.Wrap(err, "open failed")
+		}
+		if err := f(st, i, segPageID, cnt); err != nil {
+			return err
+		}
+		i += cnt
+	}
+	return nil
+}
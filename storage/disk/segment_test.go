@@ -0,0 +1,128 @@
+package disk
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSegment(t *testing.T) {
+	dm, err := NewManagerWithOptions(common.TestingNewDataDir(t), Options{PagesPerSegment: 2})
+	assert.Nil(t, err)
+	defer dm.Close()
+	rel := common.Relation{RelFileNumber: 1}
+
+	t.Run("extend pages over segments", func(t *testing.T) {
+		for i := 0; i < 4; i++ {
+			pageID, err := dm.ExtendPage(rel, ForkNumberMain, true)
+			assert.Nil(t, err)
+			assert.Equal(t, page.PageID(i), pageID)
+		}
+		// the last segment is full and the next segment doesn't exist
+		last, err := dm.GetNPageID(rel, ForkNumberMain)
+		assert.Nil(t, err)
+		assert.Equal(t, page.PageID(3), last)
+		_, err = os.Stat(testingSegmentPath(dm, rel, ForkNumberMain, 2))
+		assert.True(t, os.IsNotExist(err))
+
+		pageID, err := dm.ExtendPage(rel, ForkNumberMain, true)
+		assert.Nil(t, err)
+		assert.Equal(t, page.PageID(4), pageID)
+		for seg, expected := range []int64{2, 2, 1} {
+			stat, err := os.Stat(testingSegmentPath(dm, rel, ForkNumberMain, SegmentNumber(seg)))
+			assert.Nil(t, err)
+			assert.Equal(t, expected*page.PageSize, stat.Size())
+		}
+	})
+
+	t.Run("read and write pages over segments", func(t *testing.T) {
+		expected := []page.PagePtr{testingPage('a'), testingPage('b'), testingPage('c')}
+		err := dm.WritePages(rel, ForkNumberMain, page.FirstPageID+1, expected, false)
+		assert.Nil(t, err)
+
+		got, err := dm.ReadPages(rel, ForkNumberMain, page.FirstPageID+1, 3)
+		assert.Nil(t, err)
+		for i := range expected {
+			assert.True(t, bytes.Equal(expected[i][:], got[i][:]))
+		}
+		p := page.NewPagePtr()
+		err = dm.ReadPage(rel, ForkNumberMain, page.PageID(3), p)
+		assert.Nil(t, err)
+		assert.True(t, bytes.Equal(expected[2][:], p[:]))
+	})
+
+	t.Run("close all segments", func(t *testing.T) {
+		fo := dm.opener.(*fileOpener)
+		assert.Equal(t, 3, fo.vfds.openCount())
+		err := dm.CloseRelation(rel)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, fo.vfds.openCount())
+	})
+}
+
+func TestSegmentBeyond4GB(t *testing.T) {
+	dm, err := NewManagerWithOptions(common.TestingNewDataDir(t), Options{})
+	assert.Nil(t, err)
+	defer dm.Close()
+	rel := common.Relation{RelFileNumber: 1}
+
+	// create 4 full segments (4GB) as sparse files
+	for seg := SegmentNumber(0); seg < 4; seg++ {
+		f, err := os.Create(testingSegmentPath(dm, rel, ForkNumberMain, seg))
+		assert.Nil(t, err)
+		assert.Nil(t, f.Truncate(defaultPagesPerSegment*page.PageSize))
+		assert.Nil(t, f.Close())
+	}
+	last, err := dm.GetNPageID(rel, ForkNumberMain)
+	assert.Nil(t, err)
+	assert.Equal(t, page.PageID(4*defaultPagesPerSegment-1), last)
+
+	// the page beyond 4GB is located in the fifth segment
+	pageID, err := dm.ExtendPage(rel, ForkNumberMain, true)
+	assert.Nil(t, err)
+	assert.Equal(t, page.PageID(4*defaultPagesPerSegment), pageID)
+	err = dm.WritePage(rel, ForkNumberMain, pageID, testingPage('a'), true)
+	assert.Nil(t, err)
+
+	p := page.NewPagePtr()
+	err = dm.ReadPage(rel, ForkNumberMain, pageID, p)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(testingPage('a')[:], p[:]))
+	stat, err := os.Stat(testingSegmentPath(dm, rel, ForkNumberMain, 4))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(page.PageSize), stat.Size())
+	last, err = dm.GetNPageID(rel, ForkNumberMain)
+	assert.Nil(t, err)
+	assert.Equal(t, pageID, last)
+}
+
+func TestSegmentWithBufferStorage(t *testing.T) {
+	dm, err := TestingNewBufferManager()
+	assert.Nil(t, err)
+	dm.pagesPerSegment = 2
+	rel := common.Relation{RelFileNumber: 1}
+
+	// the first segment has one page initially
+	for i := 1; i < 5; i++ {
+		pageID, err := dm.ExtendPage(rel, ForkNumberMain, true)
+		assert.Nil(t, err)
+		assert.Equal(t, page.PageID(i), pageID)
+	}
+	_, err = dm.ReadPages(rel, ForkNumberMain, page.FirstPageID, 5)
+	assert.Nil(t, err)
+	// one io per segment
+	cnt, err := TestingReadCount(dm, rel, ForkNumberMain)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, cnt)
+}
+
+func TestNewManagerWithOptions(t *testing.T) {
+	_, err := NewManagerWithOptions(common.TestingNewDataDir(t), Options{PagesPerSegment: -1})
+	assert.NotNil(t, err)
+	_, err = NewManagerWithOptions(common.TestingNewDataDir(t), Options{IOMethod: IOMethod(100)})
+	assert.NotNil(t, err)
+}
@@ -1,32 +1,93 @@
 /*
-This file defines storage interface and its implementations.
+This file defines Storage interface and its implementations.
 We don't want to execute disk I/O in test, so it's better to use byte slice instead of actual file in test.
-For this reason, storage interface is defined. Possible operation with storage is read/write/seek/sync/get size.
-(TODO: implement close method)
+For this reason, Storage interface is defined. Possible operation with storage is read/write/seek/sync/get size/truncate/close.
 The implementations are:
 - fileStorage: wrapper of os.File
-- bufferStorage: this consists of byte slice and the current position of the byte slice.
+- BufferStorage: this consists of byte slice and the current position of the byte slice.
+- uringStorage: wrapper of os.File which executes io with io_uring (only on linux/amd64 and linux/arm64). see uring_linux.go
+
+BufferStorage is exported so that the other storage backends (Opener implementations) can use it as in-memory storage.
+
+Disk manager reads/writes at the offset with ReadAt/WriteAt (pread/pwrite) instead of Seek + Read/Write,
+so the io doesn't depend on the current position of the storage.
+Multiple pages can be read/written with one io (preadv/pwritev) if the storage implements vectorStorage.
 
 note:
 - bytes.Buffer doesn't implement io.Seeker because it is designed to read data in buffer once.
 - bytes.Reader doesn't implement io.Writer
-- so it may be better to define bufferStorage by myself.
+- so it may be better to define BufferStorage by myself.
 */
 package disk
 
 import (
 	"io"
 	"os"
+	"sync"
 
 	"github.com/HayatoShiba/ppdb/storage/page"
 	"github.com/pkg/errors"
 )
 
-// storage is storage which implements multiple operations necessary for ppdb database file.
-type storage interface {
+// Storage is storage which implements multiple operations necessary for ppdb database file.
+// the implementations must be safe for concurrent use, because the storage is shared by goroutines
+type Storage interface {
 	io.ReadWriteSeeker
+	io.ReaderAt
+	io.WriterAt
 	Size() (int64, error)
+	Truncate(size int64) error
 	Sync() error
+	Close() error
+}
+
+// vectorStorage is storage which reads/writes multiple buffers at the offset with one io (preadv/pwritev)
+// the buffers are read/written contiguously from the offset
+type vectorStorage interface {
+	readvAt(bufs [][]byte, off int64) (int, error)
+	writevAt(bufs [][]byte, off int64) (int, error)
+}
+
+// readvAt reads storage from the offset into the buffers contiguously with one io
+// if the storage doesn't implement vectorStorage, read into temporary contiguous buffer and copy it
+func readvAt(st Storage, bufs [][]byte, off int64) (int, error) {
+	if vs, ok := st.(vectorStorage); ok {
+		return vs.readvAt(bufs, off)
+	}
+	tmp := make([]byte, totalLen(bufs))
+	n, err := st.ReadAt(tmp, off)
+	scatter(bufs, tmp[:n])
+	return n, err
+}
+
+// writevAt writes the buffers contiguously into storage from the offset with one io
+// if the storage doesn't implement vectorStorage, copy the buffers into temporary contiguous buffer and write it
+func writevAt(st Storage, bufs [][]byte, off int64) (int, error) {
+	if vs, ok := st.(vectorStorage); ok {
+		return vs.writevAt(bufs, off)
+	}
+	tmp := make([]byte, 0, totalLen(bufs))
+	for _, b := range bufs {
+		tmp = append(tmp, b...)
+	}
+	return st.WriteAt(tmp, off)
+}
+
+// totalLen returns the sum of the length of the buffers
+func totalLen(bufs [][]byte) int {
+	total := 0
+	for _, b := range bufs {
+		total += len(b)
+	}
+	return total
+}
+
+// scatter copies src into the buffers in order
+func scatter(bufs [][]byte, src []byte) {
+	for _, b := range bufs {
+		n := copy(b, src)
+		src = src[n:]
+	}
 }
 
 // fileStorage is file storage
@@ -34,6 +95,12 @@ type fileStorage struct {
 	*os.File
 }
 
+// NewFileStorage wraps the file as Storage
+// this is for the other managers which manage the file by themselves (ex: clog)
+func NewFileStorage(f *os.File) Storage {
+	return fileStorage{f}
+}
+
 // Size returns the storage's size
 func (fs fileStorage) Size() (int64, error) {
 	stat, err := fs.Stat()
@@ -43,8 +110,11 @@ func (fs fileStorage) Size() (int64, error) {
 	return stat.Size(), nil
 }
 
-// bufferStorage is buffer storage
-type bufferStorage struct {
+// BufferStorage is in-memory storage which works as file on disk
+// this is safe for concurrent use
+type BufferStorage struct {
+	// mu protects all fields
+	mu sync.Mutex
 	// buf is actual contents
 	buf []byte
 	// off is current position
@@ -52,63 +122,151 @@ type bufferStorage struct {
 	off int
 }
 
-// newBufferStorage initializes bufferStorage
-func newBufferStorage() *bufferStorage {
-	// initialize with one page size
-	buf := make([]byte, page.PageSize)
-	return &bufferStorage{
+// NewBufferStorage initializes BufferStorage with nPages 0-filled pages
+func NewBufferStorage(nPages int) *BufferStorage {
+	buf := make([]byte, nPages*page.PageSize)
+	return &BufferStorage{
 		buf: buf,
 		off: 0,
 	}
 }
 
+// clone returns the copy of the buffer. this works as file copy
+func (bs *BufferStorage) clone() *BufferStorage {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	buf := make([]byte, len(bs.buf))
+	copy(buf, bs.buf)
+	return &BufferStorage{buf: buf}
+}
+
 // Size returns the buffer size
-func (bs *bufferStorage) Size() (int64, error) {
+func (bs *BufferStorage) Size() (int64, error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
 	size := len(bs.buf)
 	return int64(size), nil
 }
 
+// Truncate changes the buffer size
+// if the size is larger than the current size, the buffer is extended with 0
+func (bs *BufferStorage) Truncate(size int64) error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if size < 0 {
+		return errors.Errorf("size is negative: %d", size)
+	}
+	if int(size) <= len(bs.buf) {
+		bs.buf = bs.buf[:size]
+		return nil
+	}
+	bs.buf = append(bs.buf, make([]byte, int(size)-len(bs.buf))...)
+	return nil
+}
+
 // Sync doesn't do anything
-func (bs *bufferStorage) Sync() error {
+func (bs *BufferStorage) Sync() error {
 	// on-memory byte slice doesn't need sync
 	return nil
 }
 
+// Close doesn't do anything
+// the contents remain because buffer storage works as file on disk
+func (bs *BufferStorage) Close() error {
+	return nil
+}
+
 // Read reads buffer at current position into p
-func (bs *bufferStorage) Read(p []byte) (n int, err error) {
-	nread := copy(p, bs.buf[bs.off:])
+func (bs *BufferStorage) Read(p []byte) (n int, err error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	nread, err := bs.readAt(p, int64(bs.off))
+	bs.off = bs.off + nread
+	return nread, err
+}
+
+// ReadAt reads buffer at the offset into p
+func (bs *BufferStorage) ReadAt(p []byte, off int64) (n int, err error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return bs.readAt(p, off)
+}
+
+// readAt reads buffer at the offset into p. the caller must hold mu
+func (bs *BufferStorage) readAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.Errorf("offset is negative: %d", off)
+	}
+	// like os.File, reading nothing succeeds even beyond the end of buffer
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if off >= int64(len(bs.buf)) {
+		return 0, io.EOF
+	}
+	nread := copy(p, bs.buf[off:])
 	if nread != len(p) {
-		return nread, errors.Errorf("cannot fully read: nread %d, len %d", nread, len(p))
+		// like os.File, io.EOF is returned when the end of buffer is reached before p is filled
+		return nread, io.EOF
 	}
-	bs.off = bs.off + nread
 	return nread, nil
 }
 
 // Write writes p into buffer at current position
-func (bs *bufferStorage) Write(p []byte) (n int, err error) {
-	// grow slice if seek makes offset ahead of capacity of the slice
-	if len(bs.buf) < bs.off {
-		t := make([]byte, bs.off-len(bs.buf))
-		bs.buf = append(bs.buf, t[:]...)
-	}
-	// if the buffer is EOF, then extend the byte slice with page size
-	if len(bs.buf) == bs.off {
-		pg := page.NewPagePtr()
-		bs.buf = append(bs.buf, pg[:]...)
-	}
-	nwritten := copy(bs.buf[bs.off:], p)
+func (bs *BufferStorage) Write(p []byte) (n int, err error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	nwritten, err := bs.writeAt(p, int64(bs.off))
+	bs.off = bs.off + nwritten
+	return nwritten, err
+}
+
+// WriteAt writes p into buffer at the offset
+// if the offset is ahead of the end of buffer, the gap is 0-filled
+func (bs *BufferStorage) WriteAt(p []byte, off int64) (n int, err error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return bs.writeAt(p, off)
+}
+
+// writeAt writes p into buffer at the offset. the caller must hold mu
+func (bs *BufferStorage) writeAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.Errorf("offset is negative: %d", off)
+	}
+	// grow slice if the end of p is ahead of the end of the slice
+	if end := int(off) + len(p); len(bs.buf) < end {
+		t := make([]byte, end-len(bs.buf))
+		bs.buf = append(bs.buf, t...)
+	}
+	nwritten := copy(bs.buf[off:], p)
 	if nwritten != len(p) {
 		return nwritten, errors.Errorf("cannot fully written: nread %d, len %d", nwritten, len(p))
 	}
-	bs.off = bs.off + nwritten
 	return nwritten, nil
 }
 
-// Seek seeks and moves buffer off
-func (bs *bufferStorage) Seek(offset int64, whence int) (int64, error) {
-	if whence != os.SEEK_SET {
+// Seek sets the offset for the next Read or Write like os.File
+// whence is io.SeekStart (relative to the start), io.SeekCurrent (relative to the current offset) or io.SeekEnd (relative to the end)
+// the offset can be beyond the end of buffer. the gap is 0-filled when written
+func (bs *BufferStorage) Seek(offset int64, whence int) (int64, error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	var base int64
+	switch whence {
+	case io.SeekStart:
+		base = 0
+	case io.SeekCurrent:
+		base = int64(bs.off)
+	case io.SeekEnd:
+		base = int64(len(bs.buf))
+	default:
 		return 0, errors.Errorf("whence is unexpected: %d", whence)
 	}
-	bs.off = int(offset)
-	return offset, nil
+	off := base + offset
+	if off < 0 {
+		return 0, errors.Errorf("offset is negative: %d", off)
+	}
+	bs.off = int(off)
+	return off, nil
 }
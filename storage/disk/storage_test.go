@@ -0,0 +1,140 @@
+package disk
+
+import (
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBufferStorageSeek(t *testing.T) {
+	bs := NewBufferStorage(1)
+	tests := []struct {
+		name      string
+		offset    int64
+		whence    int
+		expected  int64
+		expectErr bool
+	}{
+		{
+			name:     "seek start",
+			offset:   10,
+			whence:   io.SeekStart,
+			expected: 10,
+		},
+		{
+			name:     "seek current",
+			offset:   -5,
+			whence:   io.SeekCurrent,
+			expected: 5,
+		},
+		{
+			name:     "seek end",
+			offset:   -1,
+			whence:   io.SeekEnd,
+			expected: page.PageSize - 1,
+		},
+		{
+			name:     "seek beyond the end",
+			offset:   10,
+			whence:   io.SeekEnd,
+			expected: page.PageSize + 10,
+		},
+		{
+			name:      "negative offset",
+			offset:    -1,
+			whence:    io.SeekStart,
+			expectErr: true,
+		},
+		{
+			name:      "unexpected whence",
+			offset:    0,
+			whence:    100,
+			expectErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := bs.Seek(tt.offset, tt.whence)
+			if tt.expectErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestBufferStorageReadWrite(t *testing.T) {
+	bs := NewBufferStorage(0)
+	n, err := bs.Write([]byte("hello"))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	// append at the end
+	_, err = bs.Seek(0, io.SeekEnd)
+	assert.Nil(t, err)
+	_, err = bs.Write([]byte(" world"))
+	assert.Nil(t, err)
+
+	_, err = bs.Seek(0, io.SeekStart)
+	assert.Nil(t, err)
+	got := make([]byte, 11)
+	_, err = bs.Read(got)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello world", string(got))
+
+	// the gap is 0-filled when written beyond the end
+	_, err = bs.Seek(2, io.SeekEnd)
+	assert.Nil(t, err)
+	_, err = bs.Write([]byte("!"))
+	assert.Nil(t, err)
+	size, err := bs.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(14), size)
+	b := make([]byte, 3)
+	_, err = bs.ReadAt(b, 11)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0, 0, '!'}, b)
+}
+
+func TestBufferStorageReadEmpty(t *testing.T) {
+	bs := NewBufferStorage(0)
+	n, err := bs.ReadAt(nil, 1<<20)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	_, err = bs.Write([]byte("hello"))
+	assert.Nil(t, err)
+	_, err = bs.Seek(10, io.SeekEnd)
+	assert.Nil(t, err)
+	n, err = bs.Read(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	// reading beyond the end is still EOF
+	_, err = bs.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestBufferStorageConcurrent(t *testing.T) {
+	bs := NewBufferStorage(0)
+	pageNum := 16
+	var wg sync.WaitGroup
+	for i := 0; i < pageNum; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := bs.WriteAt(testingPage(byte(i))[:], int64(i*page.PageSize))
+			assert.Nil(t, err)
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < pageNum; i++ {
+		p := page.NewPagePtr()
+		_, err := bs.ReadAt(p[:], int64(i*page.PageSize))
+		assert.Nil(t, err)
+		assert.Equal(t, testingPage(byte(i)), p)
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Tablespace is the directory where relation files are located.
+This lets us place relations on different disks (ex: hot tables on NVMe and archival tables on bulk disks).
+
+The relation files in default tablespace are located under base directory.
+The relation files in the other tablespaces are located under the directory specified when the tablespace is created (location).
+Like postgres, the location is linked from pg_tblspc directory under data directory with symbolic link named the tablespace oid,
+so the path of the relation file can be resolved only with tablespace oid:
+  - default tablespace: base/database oid/relFileNumber
+  - other tablespace: pg_tblspc/tablespace oid/database oid/relFileNumber (-> location/database oid/relFileNumber)
+
+The directory of database under the tablespace other than default is created when the first relation of the database is created.
+
+The mapping from relation to tablespace is held by the relation itself (common.Relation.Tablespace)
+because it is stored in system catalog (pg_class table) in postgres.
+
+see https://github.com/postgres/postgres/blob/20432f8731404d2cef2a155144aca5ab3ae98e95/src/backend/commands/tablespace.c
+*/
+package disk
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultTablespaceDir is the directory of default tablespace under data directory
+	defaultTablespaceDir = "base"
+	// tablespaceLinkDir is the directory under data directory where the links to tablespace locations are located
+	tablespaceLinkDir = "pg_tblspc"
+)
+
+// getTablespacePath returns the directory path of the tablespace relative to data directory
+// see GetDatabasePath() https://github.com/postgres/postgres/blob/a448e49bcbe40fb72e1ed85af910dd216d45bad8/src/common/relpath.c
+func getTablespacePath(spc common.Tablespace) string {
+	if spc == common.DefaultTablespace {
+		return defaultTablespaceDir
+	}
+	return filepath.Join(tablespaceLinkDir, fmt.Sprintf("%d", spc))
+}
+
+// CreateTablespace creates the tablespace located at the location
+// the location must be absolute path. if the location doesn't exist, it is created.
+// if the location is not empty (ex: used by other tablespace), return error
+// see CreateTableSpace() and create_tablespace_directories() in postgres
+func (m *Manager) CreateTablespace(spc common.Tablespace, location string) error {
+	if spc == common.DefaultTablespace {
+		return errors.New("default tablespace cannot be created")
+	}
+	if !filepath.IsAbs(location) {
+		return errors.Errorf("tablespace location must be an absolute path: %s", location)
+	}
+	if err := m.opener.CreateTablespace(spc, location); err != nil {
+		return errors.Wrapf(err, "createTablespace failed: tablespace %d", spc)
+	}
+	return nil
+}
+
+// DropTablespace drops the tablespace
+// the tablespace must be empty (all relations in the tablespace must be unlinked in advance).
+// the empty directories of databases are removed
+// the location itself is not removed like postgres
+// see DropTableSpace() and destroy_tablespace_directories() in postgres
+func (m *Manager) DropTablespace(spc common.Tablespace) error {
+	if spc == common.DefaultTablespace {
+		return errors.New("default tablespace cannot be dropped")
+	}
+	if err := m.opener.DropTablespace(spc); err != nil {
+		return errors.Wrapf(err, "dropTablespace failed: tablespace %d", spc)
+	}
+	return nil
+}
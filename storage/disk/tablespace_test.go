@@ -0,0 +1,127 @@
+package disk
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetTablespacePath(t *testing.T) {
+	assert.Equal(t, "base", getTablespacePath(common.DefaultTablespace))
+	assert.Equal(t, filepath.Join("pg_tblspc", "10"), getTablespacePath(common.Tablespace(10)))
+}
+
+func TestTablespace(t *testing.T) {
+	managers := []struct {
+		name string
+		new  func(t *testing.T) (*Manager, error)
+	}{
+		{
+			name: "file storage",
+			new:  TestingNewFileManager,
+		},
+		{
+			name: "buffer storage",
+			new: func(t *testing.T) (*Manager, error) {
+				return TestingNewBufferManager()
+			},
+		},
+	}
+	for _, mm := range managers {
+		t.Run(mm.name, func(t *testing.T) {
+			dm, err := mm.new(t)
+			assert.Nil(t, err)
+			spc := common.Tablespace(10)
+			location := filepath.Join(t.TempDir(), "nvme")
+			rel := common.Relation{Tablespace: spc, RelFileNumber: 1}
+
+			// the relation cannot be created before the tablespace is created
+			err = dm.CreateRelation(rel)
+			assert.NotNil(t, err)
+
+			err = dm.CreateTablespace(spc, location)
+			assert.Nil(t, err)
+			// the tablespace already exists
+			err = dm.CreateTablespace(spc, location)
+			assert.NotNil(t, err)
+
+			err = dm.CreateRelation(rel)
+			assert.Nil(t, err)
+			pageID, err := dm.ExtendPage(rel, ForkNumberMain, true)
+			assert.Nil(t, err)
+			expected := testingPage('a')
+			err = dm.WritePage(rel, ForkNumberMain, pageID, expected, true)
+			assert.Nil(t, err)
+			// the relation which has the same relfilenumber in default tablespace is different relation
+			other := common.Relation{RelFileNumber: 1}
+			err = dm.CreateRelation(other)
+			assert.Nil(t, err)
+			otherPageID, err := dm.ExtendPage(other, ForkNumberMain, true)
+			assert.Nil(t, err)
+			err = dm.WritePage(other, ForkNumberMain, otherPageID, testingPage('b'), true)
+			assert.Nil(t, err)
+
+			got := page.NewPagePtr()
+			err = dm.ReadPage(rel, ForkNumberMain, pageID, got)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, got)
+
+			// the tablespace which has relation cannot be dropped
+			err = dm.DropTablespace(spc)
+			assert.NotNil(t, err)
+			err = dm.UnlinkRelation(rel)
+			assert.Nil(t, err)
+			err = dm.DropTablespace(spc)
+			assert.Nil(t, err)
+			// the tablespace doesn't exist
+			err = dm.DropTablespace(spc)
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestTablespaceLocation(t *testing.T) {
+	dm, err := TestingNewFileManager(t)
+	assert.Nil(t, err)
+	location := t.TempDir()
+	rel := common.Relation{Tablespace: 10, RelFileNumber: 1}
+
+	err = dm.CreateTablespace(rel.Tablespace, location)
+	assert.Nil(t, err)
+	err = dm.CreateRelation(rel)
+	assert.Nil(t, err)
+	_, err = dm.ExtendPage(rel, ForkNumberMain, true)
+	assert.Nil(t, err)
+
+	// the file is located under the location through the link
+	stat, err := os.Stat(filepath.Join(location, "0", "1"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(page.PageSize), stat.Size())
+
+	// the location already used cannot be used by other tablespace
+	err = dm.CreateTablespace(common.Tablespace(11), location)
+	assert.NotNil(t, err)
+	// the location must be absolute path
+	err = dm.CreateTablespace(common.Tablespace(11), "relative")
+	assert.NotNil(t, err)
+	// default tablespace cannot be created and dropped
+	err = dm.CreateTablespace(common.DefaultTablespace, t.TempDir())
+	assert.NotNil(t, err)
+	err = dm.DropTablespace(common.DefaultTablespace)
+	assert.NotNil(t, err)
+
+	// the location remains after the tablespace is dropped
+	err = dm.UnlinkRelation(rel)
+	assert.Nil(t, err)
+	err = dm.DropTablespace(rel.Tablespace)
+	assert.Nil(t, err)
+	_, err = os.Stat(location)
+	assert.Nil(t, err)
+	// the location can be used again
+	err = dm.CreateTablespace(common.Tablespace(11), location)
+	assert.Nil(t, err)
+}
@@ -1,16 +1,101 @@
 package disk
 
-import "testing"
+import (
+	"sync"
+	"testing"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/pkg/errors"
+)
 
 // TestingNewFileManager initializes disk manager with file storage.
+// the files are located under t.TempDir() so that they are removed after test is completed
 func TestingNewFileManager(t *testing.T) (*Manager, error) {
-	// update base dir with t.TempDir()
-	// because we want to remove the generated file after test is completed
-	baseDir = t.TempDir()
-	return NewManager()
+	return TestingNewFileManagerWithOptions(t, Options{})
+}
+
+// TestingNewFileManagerWithOptions initializes disk manager with file storage and the options.
+// the manager is closed when the test completes
+func TestingNewFileManagerWithOptions(t *testing.T, opts Options) (*Manager, error) {
+	dm, err := NewManagerWithOptions(common.TestingNewDataDir(t), opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "NewManagerWithOptions failed")
+	}
+	t.Cleanup(func() {
+		dm.Close()
+	})
+	return dm, nil
 }
 
-// TestingNewManager initializes disk manager with buffer storage instead of file storage. This prevents unnecessary disk I/O.
+// TestingNewBufferManager initializes disk manager with buffer storage instead of file storage. This prevents unnecessary disk I/O.
+// the reads of each relation fork are counted (see TestingReadCount)
 func TestingNewBufferManager() (*Manager, error) {
-	return &Manager{newBufferOpener()}, nil
+	return NewManagerWithOpener(&readCountOpener{
+		Opener: NewBufferOpener(),
+		counts: make(map[forkKey]int),
+	}, Options{})
+}
+
+// TestingNewFaultBufferManager initializes disk manager with buffer storage which injects the faults scheduled by the injector
+func TestingNewFaultBufferManager(fi *FaultInjector) (*Manager, error) {
+	return NewManagerWithOpener(NewFaultOpener(NewBufferOpener(), fi), Options{})
+}
+
+// TestingReadCount returns how many times the relation fork has been read successfully.
+// the reads of all segments are summed up
+// this is expected to be used with TestingNewBufferManager
+func TestingReadCount(m *Manager, rel common.Relation, forkNum ForkNumber) (int, error) {
+	o, ok := m.opener.(*readCountOpener)
+	if !ok {
+		return 0, errors.New("the opener doesn't count reads")
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.counts[forkKey{rel: rel, forkNum: forkNum}], nil
+}
+
+// readCountOpener wraps the storages opened so that the reads are counted per relation fork
+type readCountOpener struct {
+	Opener
+	// mu protects counts
+	mu     sync.Mutex
+	counts map[forkKey]int
+}
+
+// Open opens the segment and wraps it with readCountStorage
+func (o *readCountOpener) Open(rel common.Relation, forkNum ForkNumber, segNum SegmentNumber) (Storage, error) {
+	st, err := o.Opener.Open(rel, forkNum, segNum)
+	if err != nil {
+		return nil, err
+	}
+	return &readCountStorage{Storage: st, opener: o, key: forkKey{rel: rel, forkNum: forkNum}}, nil
+}
+
+// readCountStorage counts the successful reads of the storage
+type readCountStorage struct {
+	Storage
+	opener *readCountOpener
+	key    forkKey
+}
+
+// count counts the read if it succeeded
+func (s *readCountStorage) count(err error) {
+	if err != nil {
+		return
+	}
+	s.opener.mu.Lock()
+	s.opener.counts[s.key]++
+	s.opener.mu.Unlock()
+}
+
+func (s *readCountStorage) Read(p []byte) (int, error) {
+	n, err := s.Storage.Read(p)
+	s.count(err)
+	return n, err
+}
+
+func (s *readCountStorage) ReadAt(p []byte, off int64) (int, error) {
+	n, err := s.Storage.ReadAt(p, off)
+	s.count(err)
+	return n, err
 }
@@ -0,0 +1,406 @@
+//go:build linux && (amd64 || arm64)
+
+/*
+io_uring backend of disk io.
+
+io_uring is asynchronous io interface of linux (5.1 or later).
+the application puts io requests on submission queue (SQ) and the kernel puts the results on completion queue (CQ).
+both queues are ring buffers shared between the application and the kernel with mmap.
+- io_uring_setup(): create io_uring instance and return its file descriptor
+- mmap the SQ ring, the CQ ring and the SQ entries (SQE)
+- io_uring_enter(): submit the requests on SQ, and wait for the completions
+
+ppdb uses io_uring with vectored io (IORING_OP_READV/IORING_OP_WRITEV),
+so multiple pages are read/written with one request and the io doesn't depend on the file position.
+the ring is shared by the files, and the requests of many goroutines are in flight at the same time:
+  - submit: the goroutine puts the request on SQ and submits it without waiting (only this is serialized by mutex)
+  - reap: the reaper goroutine waits for the completions and passes each result to the goroutine waiting for it
+
+the number of requests in flight is limited to the SQ size, so neither SQ nor CQ overflows.
+
+the implementation uses syscall package only (no liburing) so the definitions below follow linux/io_uring.h.
+the system call numbers are the same on amd64 and arm64 only (ex: mips uses others), so the build is restricted to them.
+see https://github.com/torvalds/linux/blob/v6.1/include/uapi/linux/io_uring.h
+see https://kernel.dk/io_uring.pdf
+*/
+package disk
+
+import (
+	"io"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// system call numbers on amd64 and arm64
+	sysIOURingSetup = 425
+	sysIOURingEnter = 426
+
+	// the offsets to mmap the rings
+	ioringOffSQRing = 0
+	ioringOffCQRing = 0x8000000
+	ioringOffSQEs   = 0x10000000
+
+	// opcodes
+	ioringOpNop    = 0
+	ioringOpReadv  = 1
+	ioringOpWritev = 2
+
+	// io_uring_enter flags: wait for the completions
+	ioringEnterGetEvents = 1
+
+	// the number of entries of submission queue
+	uringEntries = 32
+	// the max number of buffers per io. this is IOV_MAX in linux
+	iovMax = 1024
+
+	// the user data of the request which stops the reaper
+	uringCloseUserData = math.MaxUint64
+)
+
+// sqringOffsets is struct io_sqring_offsets
+type sqringOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	flags       uint32
+	dropped     uint32
+	array       uint32
+	resv1       uint32
+	userAddr    uint64
+}
+
+// cqringOffsets is struct io_cqring_offsets
+type cqringOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	overflow    uint32
+	cqes        uint32
+	flags       uint32
+	resv1       uint32
+	userAddr    uint64
+}
+
+// uringParams is struct io_uring_params
+type uringParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFd         uint32
+	resv         [3]uint32
+	sqOff        sqringOffsets
+	cqOff        cqringOffsets
+}
+
+// uringSQE is struct io_uring_sqe (submission queue entry)
+type uringSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	rwFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFdIn  int32
+	addr3       uint64
+	pad         uint64
+}
+
+// uringCQE is struct io_uring_cqe (completion queue entry)
+type uringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// uring is io_uring instance
+type uring struct {
+	fd int
+	// slots limits the number of requests in flight to the number of SQ entries
+	slots chan struct{}
+	// sqMu protects submission queue
+	sqMu sync.Mutex
+	// mu protects waiters and nextUserData
+	mu sync.Mutex
+	// waiters is the channel to pass the result of the request to. the key is the user data of the request
+	waiters      map[uint64]chan int32
+	nextUserData uint64
+	// reaperDone is closed when the reaper exits. nil if the reaper has not started
+	reaperDone chan struct{}
+
+	// mmaped memory
+	sqRing  []byte
+	cqRing  []byte
+	sqesMem []byte
+
+	// submission queue
+	sqHead  *uint32
+	sqTail  *uint32
+	sqMask  *uint32
+	sqArray []uint32
+	sqes    []uringSQE
+
+	// completion queue. only the reaper consumes it
+	cqHead *uint32
+	cqTail *uint32
+	cqMask *uint32
+	cqes   []uringCQE
+}
+
+// newURing sets up io_uring instance and maps its rings
+// this fails when io_uring is not available (ex: old kernel, disabled by seccomp)
+func newURing(entries uint32) (*uring, error) {
+	var p uringParams
+	fd, _, errno := syscall.Syscall(sysIOURingSetup, uintptr(entries), uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return nil, errors.Wrap(errno, "io_uring_setup failed")
+	}
+	r := &uring{
+		fd:      int(fd),
+		slots:   make(chan struct{}, p.sqEntries),
+		waiters: make(map[uint64]chan int32),
+	}
+
+	var err error
+	sqSize := int(p.sqOff.array + p.sqEntries*uint32(unsafe.Sizeof(uint32(0))))
+	if r.sqRing, err = mmapRing(r.fd, ioringOffSQRing, sqSize); err != nil {
+		r.close()
+		return nil, errors.Wrap(err, "mmap submission queue ring failed")
+	}
+	cqSize := int(p.cqOff.cqes + p.cqEntries*uint32(unsafe.Sizeof(uringCQE{})))
+	if r.cqRing, err = mmapRing(r.fd, ioringOffCQRing, cqSize); err != nil {
+		r.close()
+		return nil, errors.Wrap(err, "mmap completion queue ring failed")
+	}
+	sqesSize := int(p.sqEntries * uint32(unsafe.Sizeof(uringSQE{})))
+	if r.sqesMem, err = mmapRing(r.fd, ioringOffSQEs, sqesSize); err != nil {
+		r.close()
+		return nil, errors.Wrap(err, "mmap submission queue entries failed")
+	}
+
+	r.sqHead = (*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.head]))
+	r.sqTail = (*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.tail]))
+	r.sqMask = (*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.ringMask]))
+	r.sqArray = unsafe.Slice((*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.array])), p.sqEntries)
+	r.sqes = unsafe.Slice((*uringSQE)(unsafe.Pointer(&r.sqesMem[0])), p.sqEntries)
+
+	r.cqHead = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.head]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.tail]))
+	r.cqMask = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.ringMask]))
+	r.cqes = unsafe.Slice((*uringCQE)(unsafe.Pointer(&r.cqRing[p.cqOff.cqes])), p.cqEntries)
+
+	r.reaperDone = make(chan struct{})
+	go r.reap()
+	return r, nil
+}
+
+// mmapRing maps the ring of io_uring
+func mmapRing(fd int, offset int64, size int) ([]byte, error) {
+	return syscall.Mmap(fd, offset, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+}
+
+// close stops the reaper, unmaps the rings and closes io_uring instance
+// the caller must ensure no io is in progress
+func (r *uring) close() error {
+	if r.reaperDone != nil {
+		// the reaper exits when it reaps this request
+		if err := r.submit(uringSQE{opcode: ioringOpNop, userData: uringCloseUserData}); err != nil {
+			return errors.Wrap(err, "submit failed")
+		}
+		<-r.reaperDone
+		r.reaperDone = nil
+	}
+	for _, mem := range [][]byte{r.sqesMem, r.cqRing, r.sqRing} {
+		if mem != nil {
+			if err := syscall.Munmap(mem); err != nil {
+				return errors.Wrap(err, "munmap failed")
+			}
+		}
+	}
+	r.sqesMem, r.cqRing, r.sqRing = nil, nil, nil
+	if err := syscall.Close(r.fd); err != nil {
+		return errors.Wrap(err, "close failed")
+	}
+	return nil
+}
+
+// submit puts the request on submission queue and submits it without waiting for the completion
+// the caller must hold a slot so that submission queue has room
+func (r *uring) submit(sqe uringSQE) error {
+	r.sqMu.Lock()
+	defer r.sqMu.Unlock()
+	// only the goroutine holding sqMu produces submission queue entries, so tail can be read without race
+	tail := atomic.LoadUint32(r.sqTail)
+	idx := tail & atomic.LoadUint32(r.sqMask)
+	r.sqes[idx] = sqe
+	r.sqArray[idx] = idx
+	// the kernel must see the entry before the tail is updated
+	atomic.StoreUint32(r.sqTail, tail+1)
+
+	for {
+		_, _, errno := syscall.Syscall6(sysIOURingEnter, uintptr(r.fd), 1, 0, 0, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		// the kernel consumes submission queue only in io_uring_enter (no SQPOLL), which is serialized by sqMu.
+		// so if the entry has not been consumed, it can be taken back.
+		// if it has been consumed, the completion is reaped anyway even though io_uring_enter failed
+		if errno != 0 && atomic.LoadUint32(r.sqHead) == tail {
+			atomic.StoreUint32(r.sqTail, tail)
+			return errors.Wrap(errno, "io_uring_enter failed")
+		}
+		return nil
+	}
+}
+
+// reap waits for the completions and passes the results to the waiters
+// this runs on its own goroutine until the close request is reaped
+func (r *uring) reap() {
+	defer close(r.reaperDone)
+	for {
+		for head := atomic.LoadUint32(r.cqHead); head != atomic.LoadUint32(r.cqTail); head++ {
+			cqe := r.cqes[head&atomic.LoadUint32(r.cqMask)]
+			atomic.StoreUint32(r.cqHead, head+1)
+			if cqe.userData == uringCloseUserData {
+				return
+			}
+			r.mu.Lock()
+			ch, ok := r.waiters[cqe.userData]
+			delete(r.waiters, cqe.userData)
+			r.mu.Unlock()
+			if ok {
+				ch <- cqe.res
+			}
+		}
+		_, _, errno := syscall.Syscall6(sysIOURingEnter, uintptr(r.fd), 0, 1, ioringEnterGetEvents, 0, 0)
+		if errno != 0 && errno != syscall.EINTR {
+			// the ring cannot be used anymore. fail all requests waiting
+			r.mu.Lock()
+			for userData, ch := range r.waiters {
+				delete(r.waiters, userData)
+				ch <- -int32(errno)
+			}
+			r.mu.Unlock()
+			return
+		}
+	}
+}
+
+// rw reads/writes the buffers contiguously from the offset of the file with one request, and waits for the completion
+// this returns the number of bytes read/written
+func (r *uring) rw(opcode uint8, fd uintptr, bufs [][]byte, off int64) (int, error) {
+	if len(bufs) > iovMax {
+		return 0, errors.Errorf("too many buffers: %d, max is %d", len(bufs), iovMax)
+	}
+	iovecs := make([]syscall.Iovec, 0, len(bufs))
+	for _, b := range bufs {
+		if len(b) == 0 {
+			continue
+		}
+		iov := syscall.Iovec{Base: &b[0]}
+		iov.SetLen(len(b))
+		iovecs = append(iovecs, iov)
+	}
+	if len(iovecs) == 0 {
+		return 0, nil
+	}
+
+	r.slots <- struct{}{}
+	defer func() { <-r.slots }()
+
+	ch := make(chan int32, 1)
+	r.mu.Lock()
+	userData := r.nextUserData
+	r.nextUserData++
+	r.waiters[userData] = ch
+	r.mu.Unlock()
+
+	err := r.submit(uringSQE{
+		opcode:   opcode,
+		fd:       int32(fd),
+		off:      uint64(off),
+		addr:     uint64(uintptr(unsafe.Pointer(&iovecs[0]))),
+		len:      uint32(len(iovecs)),
+		userData: userData,
+	})
+	if err != nil {
+		r.mu.Lock()
+		delete(r.waiters, userData)
+		r.mu.Unlock()
+		return 0, errors.Wrap(err, "submit failed")
+	}
+	res := <-ch
+	// the kernel has completed to access the buffers
+	runtime.KeepAlive(iovecs)
+	runtime.KeepAlive(bufs)
+
+	if res < 0 {
+		return 0, errors.Wrap(syscall.Errno(-res), "io failed")
+	}
+	return int(res), nil
+}
+
+// uringStorage is file storage which executes io with io_uring
+// the other operations (ex: size, sync) are executed with os.File
+type uringStorage struct {
+	fileStorage
+	ring *uring
+}
+
+// ReadAt reads the file at the offset into p
+func (us uringStorage) ReadAt(p []byte, off int64) (int, error) {
+	return us.readvAt([][]byte{p}, off)
+}
+
+// WriteAt writes p into the file at the offset
+func (us uringStorage) WriteAt(p []byte, off int64) (int, error) {
+	return us.writevAt([][]byte{p}, off)
+}
+
+// readvAt reads the file from the offset into the buffers contiguously
+// like io.ReaderAt, return error when the buffers are not fully read
+func (us uringStorage) readvAt(bufs [][]byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.Errorf("offset is negative: %d", off)
+	}
+	n, err := us.ring.rw(ioringOpReadv, us.Fd(), bufs, off)
+	if err != nil {
+		return n, errors.Wrap(err, "readv failed")
+	}
+	if n < totalLen(bufs) {
+		// regular file is read partially only at the end of file
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// writevAt writes the buffers into the file from the offset contiguously
+func (us uringStorage) writevAt(bufs [][]byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.Errorf("offset is negative: %d", off)
+	}
+	n, err := us.ring.rw(ioringOpWritev, us.Fd(), bufs, off)
+	if err != nil {
+		return n, errors.Wrap(err, "writev failed")
+	}
+	if n < totalLen(bufs) {
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
@@ -0,0 +1,27 @@
+//go:build !linux || !(amd64 || arm64)
+
+package disk
+
+import "github.com/pkg/errors"
+
+// the number of entries of submission queue
+const uringEntries = 32
+
+// uring is io_uring instance. io_uring is available only on linux/amd64 and linux/arm64. see uring_linux.go
+type uring struct{}
+
+// newURing always fails because io_uring is not available
+func newURing(entries uint32) (*uring, error) {
+	return nil, errors.New("io_uring is available only on linux/amd64 and linux/arm64")
+}
+
+// close does nothing
+func (r *uring) close() error {
+	return nil
+}
+
+// uringStorage is never used because newURing always fails
+type uringStorage struct {
+	fileStorage
+	ring *uring
+}
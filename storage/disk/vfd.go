@@ -0,0 +1,428 @@
+/*
+Virtual file descriptor (VFD).
+
+The number of files a process can open at the same time is limited by the system (RLIMIT_NOFILE).
+Database has many relation fork files, so opening them all and never closing them exceeds the limit.
+VFD layer virtualizes file descriptors:
+  - vfd is handed out instead of actual file descriptor. vfd remembers the file path.
+  - the actual files are kept open in LRU order, and the number of open files is capped below RLIMIT_NOFILE.
+  - when the limit is reached, the least recently used file is closed.
+  - when the closed file is accessed through vfd again, it is reopened transparently.
+
+the file is not closed while it is in use (io is executed) by other goroutine.
+if all the open files are in use, the limit may be exceeded temporarily.
+the file which has been written without sync is synced before it is closed by LRU,
+because the write error on fsync may be lost after the file descriptor is closed.
+
+postgres manages vfds in LRU ring and keeps the file position (seekPos) in vfd. ppdb does the same.
+see https://github.com/postgres/postgres/blob/2d4f1ba6cfc2f0a977f1c30bda9848041343e248/src/backend/storage/file/fd.c#L1-L71
+*/
+package disk
+
+import (
+	"container/list"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// the max number of files opened by vfd cache
+	// this is max_files_per_process in postgres and default is 1000
+	maxFilesPerProcess = 1000
+	// the number of file descriptors reserved for the files not managed by vfd cache (ex: clog, wal, stdio)
+	// postgres reserves 10 for system (NUM_RESERVED_FDS) but ppdb has more files outside vfd cache
+	numReservedFDs = 64
+	// the min number of files opened by vfd cache
+	// postgres fails to start when less than 10 files can be opened (FD_MINFREE)
+	minFilesPerProcess = 10
+)
+
+// maxOpenFiles returns the max number of files opened by vfd cache
+// this is capped below RLIMIT_NOFILE
+// see https://github.com/postgres/postgres/blob/2d4f1ba6cfc2f0a977f1c30bda9848041343e248/src/backend/storage/file/fd.c#L1015
+func maxOpenFiles() int {
+	max := maxFilesPerProcess
+	if limit, ok := openFileLimit(); ok && limit-numReservedFDs < uint64(max) {
+		if limit < numReservedFDs+minFilesPerProcess {
+			return minFilesPerProcess
+		}
+		max = int(limit - numReservedFDs)
+	}
+	return max
+}
+
+// vfdCache manages vfds and the open files in LRU order
+type vfdCache struct {
+	// mu protects all fields including the fields of vfd except pos
+	mu sync.Mutex
+	// maxOpen is the max number of the open files
+	maxOpen int
+	// vfds is all vfds. file path -> vfd
+	vfds map[string]*vfd
+	// lru is the list of vfds whose file is open. the front is the most recently used
+	lru *list.List
+	// openFile opens the file
+	openFile func(path string) (Storage, error)
+	// closed indicates the cache has been closed
+	closed bool
+}
+
+// newVFDCache initializes vfd cache
+func newVFDCache(maxOpen int, openFile func(path string) (Storage, error)) *vfdCache {
+	return &vfdCache{
+		maxOpen:  maxOpen,
+		vfds:     make(map[string]*vfd),
+		lru:      list.New(),
+		openFile: openFile,
+	}
+}
+
+// get returns vfd of the file path
+// when vfd is created, the file is opened here to report error (ex: permission denied) early
+func (c *vfdCache) get(path string) (*vfd, error) {
+	c.mu.Lock()
+	v, ok := c.vfds[path]
+	if ok {
+		c.mu.Unlock()
+		return v, nil
+	}
+	v = &vfd{cache: c, path: path}
+	c.vfds[path] = v
+	c.mu.Unlock()
+
+	if _, err := c.acquire(v); err != nil {
+		c.mu.Lock()
+		_ = c.forget(v)
+		c.mu.Unlock()
+		return nil, errors.Wrap(err, "acquire failed")
+	}
+	c.release(v)
+	return v, nil
+}
+
+// acquire marks vfd in use and returns its open file
+// if the file is closed, reopen it. the caller must call release() after the io
+// see https://github.com/postgres/postgres/blob/2d4f1ba6cfc2f0a977f1c30bda9848041343e248/src/backend/storage/file/fd.c#L1381
+func (c *vfdCache) acquire(v *vfd) (Storage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, errors.New("vfd cache has been closed")
+	}
+	if v.forgotten {
+		return nil, errors.Errorf("vfd has been closed: %s", v.path)
+	}
+	if v.st == nil {
+		// close other files before open not to exceed the limit
+		if err := c.evict(c.maxOpen - 1); err != nil {
+			return nil, errors.Wrap(err, "evict failed")
+		}
+		st, err := c.openFile(v.path)
+		if err != nil {
+			return nil, errors.Wrap(err, "openFile failed")
+		}
+		v.st = st
+		v.elem = c.lru.PushFront(v)
+	} else {
+		c.lru.MoveToFront(v.elem)
+	}
+	v.inUse++
+	return v.st, nil
+}
+
+// release marks the end of the io
+// if vfd has been closed during the io, close the file here
+func (c *vfdCache) release(v *vfd) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v.inUse--
+	if v.forgotten && v.inUse == 0 {
+		// the error is reported to nobody. the file has been synced when closed explicitly if needed
+		_ = c.closeFile(v)
+	}
+}
+
+// evict closes the least recently used files until the number of open files is n or less
+// the files in use are skipped. the caller must hold mu
+func (c *vfdCache) evict(n int) error {
+	for e := c.lru.Back(); e != nil && c.lru.Len() > n; {
+		v := e.Value.(*vfd)
+		e = e.Prev()
+		if v.inUse > 0 {
+			continue
+		}
+		if err := c.closeFile(v); err != nil {
+			return errors.Wrap(err, "closeFile failed")
+		}
+	}
+	return nil
+}
+
+// closeFile closes the file of vfd. vfd itself remains and the file is reopened when accessed
+// the caller must hold mu
+func (c *vfdCache) closeFile(v *vfd) error {
+	if v.st == nil {
+		return nil
+	}
+	if v.dirty {
+		if err := v.st.Sync(); err != nil {
+			return errors.Wrap(err, "Sync failed")
+		}
+		v.dirty = false
+	}
+	c.lru.Remove(v.elem)
+	st := v.st
+	v.st = nil
+	v.elem = nil
+	if err := st.Close(); err != nil {
+		return errors.Wrap(err, "Close failed")
+	}
+	return nil
+}
+
+// forget closes the file and removes vfd from cache
+// when vfd is in use, the file is closed after the io. the caller must hold mu
+// see https://github.com/postgres/postgres/blob/2d4f1ba6cfc2f0a977f1c30bda9848041343e248/src/backend/storage/file/fd.c#L1933
+func (c *vfdCache) forget(v *vfd) error {
+	// the file may have been opened again with new vfd after this vfd was forgotten
+	if c.vfds[v.path] == v {
+		delete(c.vfds, v.path)
+	}
+	v.forgotten = true
+	if v.inUse > 0 {
+		return nil
+	}
+	return c.closeFile(v)
+}
+
+// closePaths closes the files of the paths matched if they are open, and removes their vfds from cache
+// the vfd may still be used by other goroutine which has got it before.
+// when the files are removed after this (ex: Unlink), forget must be true. then the vfds are closed like forget()
+// and cannot be used anymore, otherwise the removed file is created again when the vfd is accessed.
+// otherwise, the file is reopened and closed by LRU later.
+func (c *vfdCache) closePaths(match func(path string) bool, forget bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for path, v := range c.vfds {
+		if !match(path) {
+			continue
+		}
+		delete(c.vfds, path)
+		if forget {
+			v.forgotten = true
+		}
+		if v.inUse > 0 {
+			// the file is closed after the io by release() or LRU
+			continue
+		}
+		if err := c.closeFile(v); err != nil {
+			return errors.Wrapf(err, "closeFile failed: %s", path)
+		}
+	}
+	return nil
+}
+
+// has returns whether vfd of the path exists
+func (c *vfdCache) has(path string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.vfds[path]
+	return ok
+}
+
+// close closes all vfds. the cache cannot be used anymore
+func (c *vfdCache) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	var firstErr error
+	for _, v := range c.vfds {
+		if err := c.forget(v); err != nil && firstErr == nil {
+			firstErr = errors.Wrapf(err, "forget failed: %s", v.path)
+		}
+	}
+	return firstErr
+}
+
+// openCount returns the number of the open files
+func (c *vfdCache) openCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// vfd is virtual file descriptor
+// this implements storage, so it can be used like file
+type vfd struct {
+	cache *vfdCache
+	path  string
+	// st is the open file. nil when the file is closed
+	st Storage
+	// elem is the element in LRU list. nil when the file is closed
+	elem *list.Element
+	// inUse is the number of the io in progress. the file is not closed while in use
+	inUse int
+	// dirty indicates the file has been written without sync
+	dirty bool
+	// forgotten indicates vfd has been closed explicitly and removed from cache
+	forgotten bool
+
+	// pos is the current position for Read/Write/Seek. this is protected by posMu
+	// the position is kept in vfd because it is lost when the file is closed
+	posMu sync.Mutex
+	pos   int64
+}
+
+// ReadAt reads the file at the offset into p
+func (v *vfd) ReadAt(p []byte, off int64) (int, error) {
+	st, err := v.cache.acquire(v)
+	if err != nil {
+		return 0, errors.Wrap(err, "acquire failed")
+	}
+	defer v.cache.release(v)
+	return st.ReadAt(p, off)
+}
+
+// WriteAt writes p into the file at the offset
+func (v *vfd) WriteAt(p []byte, off int64) (int, error) {
+	st, err := v.cache.acquire(v)
+	if err != nil {
+		return 0, errors.Wrap(err, "acquire failed")
+	}
+	defer v.cache.release(v)
+	v.markDirty()
+	return st.WriteAt(p, off)
+}
+
+// readvAt reads the file from the offset into the buffers contiguously
+func (v *vfd) readvAt(bufs [][]byte, off int64) (int, error) {
+	st, err := v.cache.acquire(v)
+	if err != nil {
+		return 0, errors.Wrap(err, "acquire failed")
+	}
+	defer v.cache.release(v)
+	return readvAt(st, bufs, off)
+}
+
+// writevAt writes the buffers into the file from the offset contiguously
+func (v *vfd) writevAt(bufs [][]byte, off int64) (int, error) {
+	st, err := v.cache.acquire(v)
+	if err != nil {
+		return 0, errors.Wrap(err, "acquire failed")
+	}
+	defer v.cache.release(v)
+	v.markDirty()
+	return writevAt(st, bufs, off)
+}
+
+// markDirty marks the file written without sync
+func (v *vfd) markDirty() {
+	v.cache.mu.Lock()
+	v.dirty = true
+	v.cache.mu.Unlock()
+}
+
+// Read reads the file at the current position into p
+func (v *vfd) Read(p []byte) (int, error) {
+	v.posMu.Lock()
+	defer v.posMu.Unlock()
+	n, err := v.ReadAt(p, v.pos)
+	v.pos += int64(n)
+	return n, err
+}
+
+// Write writes p into the file at the current position
+func (v *vfd) Write(p []byte) (int, error) {
+	v.posMu.Lock()
+	defer v.posMu.Unlock()
+	n, err := v.WriteAt(p, v.pos)
+	v.pos += int64(n)
+	return n, err
+}
+
+// Seek sets the current position
+func (v *vfd) Seek(offset int64, whence int) (int64, error) {
+	v.posMu.Lock()
+	defer v.posMu.Unlock()
+	var base int64
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		base = v.pos
+	case io.SeekEnd:
+		size, err := v.Size()
+		if err != nil {
+			return 0, errors.Wrap(err, "Size failed")
+		}
+		base = size
+	default:
+		return 0, errors.Errorf("whence is unexpected: %d", whence)
+	}
+	if base+offset < 0 {
+		return 0, errors.Errorf("the position is negative: %d", base+offset)
+	}
+	v.pos = base + offset
+	return v.pos, nil
+}
+
+// Size returns the file size
+func (v *vfd) Size() (int64, error) {
+	st, err := v.cache.acquire(v)
+	if err != nil {
+		return 0, errors.Wrap(err, "acquire failed")
+	}
+	defer v.cache.release(v)
+	return st.Size()
+}
+
+// Truncate changes the file size
+func (v *vfd) Truncate(size int64) error {
+	st, err := v.cache.acquire(v)
+	if err != nil {
+		return errors.Wrap(err, "acquire failed")
+	}
+	defer v.cache.release(v)
+	v.markDirty()
+	return st.Truncate(size)
+}
+
+// Sync syncs the file
+func (v *vfd) Sync() error {
+	st, err := v.cache.acquire(v)
+	if err != nil {
+		return errors.Wrap(err, "acquire failed")
+	}
+	defer v.cache.release(v)
+	// clear dirty before sync. if the file is written during sync, it is marked dirty again
+	v.cache.mu.Lock()
+	v.dirty = false
+	v.cache.mu.Unlock()
+	if err := st.Sync(); err != nil {
+		v.markDirty()
+		return errors.Wrap(err, "Sync failed")
+	}
+	return nil
+}
+
+// Close closes the file and removes vfd from cache
+// vfd cannot be used anymore. open the file again to access it
+func (v *vfd) Close() error {
+	v.cache.mu.Lock()
+	defer v.cache.mu.Unlock()
+	if v.forgotten {
+		return nil
+	}
+	return v.cache.forget(v)
+}
+
+// openOSFile opens the file with flags for database file
+func openOSFile(path string) (*os.File, error) {
+	fd, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0700)
+	if err != nil {
+		return nil, errors.Wrap(err, "os.OpenFile failed")
+	}
+	return fd, nil
+}
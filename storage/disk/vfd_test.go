@@ -0,0 +1,215 @@
+package disk
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/stretchr/testify/assert"
+)
+
+// testingNewVFDManager initializes disk manager with file storage which opens at most maxOpen files
+func testingNewVFDManager(t *testing.T, maxOpen int) (*Manager, *fileOpener) {
+	dir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(dir, getDatabasePath(common.DefaultTablespace, common.DefaultDatabase)), 0700)
+	assert.Nil(t, err)
+	fo := newFileOpener(dir, maxOpen)
+	dm := newManager(fo, defaultPagesPerSegment, nil)
+	t.Cleanup(func() {
+		dm.Close()
+	})
+	return dm, fo
+}
+
+// testingPage returns the page filled with b
+func testingPage(b byte) page.PagePtr {
+	p := page.NewPagePtr()
+	for i := range p {
+		p[i] = b
+	}
+	return p
+}
+
+func TestMaxOpenFiles(t *testing.T) {
+	max := maxOpenFiles()
+	assert.GreaterOrEqual(t, max, minFilesPerProcess)
+	assert.LessOrEqual(t, max, maxFilesPerProcess)
+}
+
+func TestVFDCache(t *testing.T) {
+	t.Run("when more files than the limit are accessed", func(t *testing.T) {
+		dm, fo := testingNewVFDManager(t, 2)
+		for i := 0; i < 5; i++ {
+			err := dm.WritePage(common.Relation{RelFileNumber: common.RelFileNumber(i)}, ForkNumberMain, page.FirstPageID, testingPage(byte(i)), true)
+			assert.Nil(t, err)
+			assert.LessOrEqual(t, fo.vfds.openCount(), 2)
+		}
+		// the files closed by LRU are reopened transparently
+		for i := 0; i < 5; i++ {
+			p := page.NewPagePtr()
+			err := dm.ReadPage(common.Relation{RelFileNumber: common.RelFileNumber(i)}, ForkNumberMain, page.FirstPageID, p)
+			assert.Nil(t, err)
+			assert.True(t, bytes.Equal(testingPage(byte(i))[:], p[:]))
+			assert.LessOrEqual(t, fo.vfds.openCount(), 2)
+		}
+	})
+
+	t.Run("when the file is in use", func(t *testing.T) {
+		dm, fo := testingNewVFDManager(t, 1)
+		st, err := dm.opener.Open(common.Relation{RelFileNumber: 1}, ForkNumberMain, 0)
+		assert.Nil(t, err)
+		v := st.(*vfd)
+		_, err = fo.vfds.acquire(v)
+		assert.Nil(t, err)
+
+		// the file in use is not closed even if the limit is exceeded
+		_, err = dm.GetNPageID(common.Relation{RelFileNumber: 2}, ForkNumberMain)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, fo.vfds.openCount())
+
+		// after released, the file is closed by LRU
+		fo.vfds.release(v)
+		_, err = dm.GetNPageID(common.Relation{RelFileNumber: 3}, ForkNumberMain)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, fo.vfds.openCount())
+	})
+}
+
+func TestVFDSeek(t *testing.T) {
+	dm, fo := testingNewVFDManager(t, 1)
+	st, err := dm.opener.Open(common.Relation{RelFileNumber: 1}, ForkNumberMain, 0)
+	assert.Nil(t, err)
+	_, err = st.Write([]byte("abcdef"))
+	assert.Nil(t, err)
+
+	// the position is kept after the file is closed by LRU
+	_, err = dm.opener.Open(common.Relation{RelFileNumber: 2}, ForkNumberMain, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, fo.vfds.openCount())
+	pos, err := st.Seek(-2, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), pos)
+	got := make([]byte, 2)
+	_, err = st.Read(got)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("ef"), got)
+
+	pos, err = st.Seek(-6, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), pos)
+	_, err = st.Seek(-1, 0)
+	assert.NotNil(t, err)
+}
+
+func TestCloseRelation(t *testing.T) {
+	dm, fo := testingNewVFDManager(t, 10)
+	rel := common.Relation{RelFileNumber: 1}
+	for forkNum := ForkNumberMain; forkNum <= maxForkNum; forkNum++ {
+		err := dm.WritePage(rel, forkNum, page.FirstPageID, testingPage('a'), true)
+		assert.Nil(t, err)
+	}
+	err := dm.WritePage(common.Relation{RelFileNumber: 2}, ForkNumberMain, page.FirstPageID, testingPage('b'), true)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, fo.vfds.openCount())
+
+	err = dm.CloseRelation(rel)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, fo.vfds.openCount())
+
+	// the relation can be opened again
+	p := page.NewPagePtr()
+	err = dm.ReadPage(rel, ForkNumberFSM, page.FirstPageID, p)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(testingPage('a')[:], p[:]))
+}
+
+func TestUnlinkWithStaleVFD(t *testing.T) {
+	dm, fo := testingNewVFDManager(t, 10)
+	rel := common.Relation{RelFileNumber: 1}
+	err := dm.WritePage(rel, ForkNumberMain, page.FirstPageID, testingPage('a'), true)
+	assert.Nil(t, err)
+	path := fo.path(rel, ForkNumberMain, 0)
+
+	// the vfd got before unlink
+	st, err := fo.Open(rel, ForkNumberMain, 0)
+	assert.Nil(t, err)
+	// the vfd in use during unlink is closed at the end of the io
+	inUse, err := fo.Open(rel, ForkNumberFSM, 0)
+	assert.Nil(t, err)
+	_, err = fo.vfds.acquire(inUse.(*vfd))
+	assert.Nil(t, err)
+
+	err = dm.UnlinkRelation(rel)
+	assert.Nil(t, err)
+	fo.vfds.release(inUse.(*vfd))
+	assert.Equal(t, 0, fo.vfds.openCount())
+
+	// the io through the stale vfd fails instead of creating the file again
+	_, err = st.WriteAt(testingPage('b')[:], 0)
+	assert.NotNil(t, err)
+	_, err = st.ReadAt(make([]byte, page.PageSize), 0)
+	assert.NotNil(t, err)
+	_, err = inUse.Size()
+	assert.NotNil(t, err)
+	for _, p := range []string{path, fo.path(rel, ForkNumberFSM, 0)} {
+		_, err = os.Stat(p)
+		assert.True(t, os.IsNotExist(err), "%s: %v", p, err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	dm, fo := testingNewVFDManager(t, 10)
+	rel := common.Relation{RelFileNumber: 1}
+	err := dm.WritePage(rel, ForkNumberMain, page.FirstPageID, testingPage('a'), true)
+	assert.Nil(t, err)
+
+	err = dm.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, fo.vfds.openCount())
+	err = dm.ReadPage(rel, ForkNumberMain, page.FirstPageID, page.NewPagePtr())
+	assert.NotNil(t, err)
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	dm, fo := testingNewVFDManager(t, 3)
+	relNum := 8
+	pageNum := 4
+
+	var wg sync.WaitGroup
+	for i := 0; i < relNum; i++ {
+		wg.Add(1)
+		go func(rel common.Relation) {
+			defer wg.Done()
+			for j := 0; j < pageNum; j++ {
+				pageID, err := dm.ExtendPage(rel, ForkNumberMain, true)
+				assert.Nil(t, err)
+				err = dm.WritePage(rel, ForkNumberMain, pageID, testingPage(byte(rel.RelFileNumber)), true)
+				assert.Nil(t, err)
+				p := page.NewPagePtr()
+				err = dm.ReadPage(rel, ForkNumberMain, pageID, p)
+				assert.Nil(t, err)
+				assert.True(t, bytes.Equal(testingPage(byte(rel.RelFileNumber))[:], p[:]))
+			}
+		}(common.Relation{RelFileNumber: common.RelFileNumber(i)})
+	}
+	// close the relations concurrently. they are reopened transparently
+	for i := 0; i < relNum; i++ {
+		wg.Add(1)
+		go func(rel common.Relation) {
+			defer wg.Done()
+			assert.Nil(t, dm.CloseRelation(rel))
+		}(common.Relation{RelFileNumber: common.RelFileNumber(i)})
+	}
+	wg.Wait()
+
+	for i := 0; i < relNum; i++ {
+		last, err := dm.GetNPageID(common.Relation{RelFileNumber: common.RelFileNumber(i)}, ForkNumberMain)
+		assert.Nil(t, err)
+		assert.Equal(t, page.PageID(pageNum-1), last)
+	}
+	assert.LessOrEqual(t, fo.vfds.openCount(), 3)
+}
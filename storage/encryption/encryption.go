@@ -0,0 +1,214 @@
+/*
+Package encryption encrypts the pages written to disk (data-at-rest encryption).
+
+The pages are encrypted with AES-XTS when they are written out, and decrypted when they are read
+by the disk managers (the main disk manager and clog disk manager). So buffer manager and everything above see plain pages.
+
+XTS is the mode for disk encryption. It doesn't change the length of data, so the encrypted page fits in the page.
+(AES-GCM is not used for pages because it needs extra space for nonce and tag in each page.)
+XTS is deterministic for the same tweak, so the tweak must differ per location of page:
+the tweak is made of the kind of file (relation fork, clog, ...), the relation (relfilenumber) and the page id.
+otherwise the same page stored in the different relations is encrypted into the same ciphertext, and it can be seen
+from the files that the pages are equal.
+The database is not included so that the files can be copied as it is (ex: CREATE DATABASE).
+the relation copied into the new database keeps its relfilenumber, so the copy and the template share the tweaks
+and it can be seen whether the page of the copy still equals the one of the template. this is acceptable because
+they are equal when the copy is created anyway, and re-encrypting all files of the database on copy is expensive.
+
+The lsn at the head of relation page is not encrypted so that recovery can read it without the key.
+The clog page has no lsn, so the whole page is encrypted.
+WAL is not implemented in ppdb yet. When it is, the wal pages should be encrypted with their own kind in the same way.
+
+The page which is zero-filled on disk (ex: the hole of file) is not decrypted and returned as zero-filled page,
+because postgres treats it as new page.
+
+The keys are managed with two levels like the transparent data encryption proposals for postgres (see key.go):
+  - data encryption key (DEK): encrypts the pages. this is generated randomly and stored in data directory wrapped with KEK
+  - key encryption key (KEK): wraps DEK. this is supplied by KeyProvider and never stored in data directory
+
+postgres doesn't support data-at-rest encryption yet (there have been some proposals).
+*/
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// blockSize is the block size of AES
+const blockSize = aes.BlockSize
+
+// the kinds of file other than relation forks. relation forks use their fork number as kind
+const (
+	// KindClog is the kind of clog file
+	KindClog uint8 = 0x80
+)
+
+// Tweak identifies the location of the page encrypted
+// the same page is encrypted into the different ciphertext at the different location
+type Tweak [blockSize]byte
+
+// NewTweak returns the tweak of the page
+// kind is the kind of file (fork number of relation, KindClog, ...)
+// relFileNumber is the relation of the page. the file which doesn't belong to relation (ex: clog) passes 0
+func NewTweak(kind uint8, relFileNumber uint32, pageID uint32) Tweak {
+	var tw Tweak
+	binary.LittleEndian.PutUint32(tw[0:4], pageID)
+	tw[4] = kind
+	binary.LittleEndian.PutUint32(tw[8:12], relFileNumber)
+	return tw
+}
+
+// Cipher encrypts and decrypts the pages with AES-XTS
+// this is safe for concurrent use
+type Cipher struct {
+	// data encrypts the data blocks and tweak encrypts the tweak (key1 and key2 in XTS)
+	data  cipher.Block
+	tweak cipher.Block
+}
+
+// NewCipher initializes the cipher with the key
+// the key is the concatenation of 2 AES keys, so it must be 32 (AES-128-XTS) or 64 (AES-256-XTS) bytes
+func NewCipher(key []byte) (*Cipher, error) {
+	if len(key) != 32 && len(key) != 64 {
+		return nil, errors.Errorf("the key length must be 32 or 64: %d", len(key))
+	}
+	half := len(key) / 2
+	data, err := aes.NewCipher(key[:half])
+	if err != nil {
+		return nil, errors.Wrap(err, "aes.NewCipher failed")
+	}
+	tweak, err := aes.NewCipher(key[half:])
+	if err != nil {
+		return nil, errors.Wrap(err, "aes.NewCipher failed")
+	}
+	return &Cipher{data: data, tweak: tweak}, nil
+}
+
+// Encrypt encrypts src into dst with the tweak. dst and src may overlap entirely
+// the length must be at least the block size (16 bytes) and may not be the multiple of it (ciphertext stealing)
+// see IEEE 1619 (XTS-AES)
+func (c *Cipher) Encrypt(dst, src []byte, tw Tweak) error {
+	if err := checkLength(dst, src); err != nil {
+		return err
+	}
+	var t [blockSize]byte
+	c.tweak.Encrypt(t[:], tw[:])
+
+	full, rem := len(src)/blockSize, len(src)%blockSize
+	if rem != 0 {
+		// the last full block is used for ciphertext stealing
+		full--
+	}
+	for i := 0; i < full; i++ {
+		c.encryptBlock(dst[i*blockSize:(i+1)*blockSize], src[i*blockSize:(i+1)*blockSize], &t)
+		mulAlpha(&t)
+	}
+	if rem == 0 {
+		return nil
+	}
+	// ciphertext stealing: the last partial block steals the tail of the ciphertext of the previous block
+	last := full * blockSize
+	var cc, pp [blockSize]byte
+	c.encryptBlock(cc[:], src[last:last+blockSize], &t)
+	mulAlpha(&t)
+	copy(pp[:], src[last+blockSize:])
+	copy(pp[rem:], cc[rem:])
+	copy(dst[last+blockSize:], cc[:rem])
+	c.encryptBlock(dst[last:last+blockSize], pp[:], &t)
+	return nil
+}
+
+// Decrypt decrypts src into dst with the tweak. dst and src may overlap entirely
+func (c *Cipher) Decrypt(dst, src []byte, tw Tweak) error {
+	if err := checkLength(dst, src); err != nil {
+		return err
+	}
+	var t [blockSize]byte
+	c.tweak.Encrypt(t[:], tw[:])
+
+	full, rem := len(src)/blockSize, len(src)%blockSize
+	if rem != 0 {
+		full--
+	}
+	for i := 0; i < full; i++ {
+		c.decryptBlock(dst[i*blockSize:(i+1)*blockSize], src[i*blockSize:(i+1)*blockSize], &t)
+		mulAlpha(&t)
+	}
+	if rem == 0 {
+		return nil
+	}
+	// the tweaks are used in reverse order of encryption
+	last := full * blockSize
+	t2 := t
+	mulAlpha(&t2)
+	var pp, cc [blockSize]byte
+	c.decryptBlock(pp[:], src[last:last+blockSize], &t2)
+	copy(cc[:], src[last+blockSize:])
+	copy(cc[rem:], pp[rem:])
+	copy(dst[last+blockSize:], pp[:rem])
+	c.decryptBlock(dst[last:last+blockSize], cc[:], &t)
+	return nil
+}
+
+// checkLength checks the length of the buffers
+func checkLength(dst, src []byte) error {
+	if len(src) < blockSize {
+		return errors.Errorf("the data must be at least %d bytes: %d", blockSize, len(src))
+	}
+	if len(dst) < len(src) {
+		return errors.Errorf("the destination is shorter than the source: %d < %d", len(dst), len(src))
+	}
+	return nil
+}
+
+// encryptBlock encrypts the block: C = E(P xor T) xor T
+func (c *Cipher) encryptBlock(dst, src []byte, t *[blockSize]byte) {
+	var b [blockSize]byte
+	for i := range b {
+		b[i] = src[i] ^ t[i]
+	}
+	c.data.Encrypt(b[:], b[:])
+	for i := range b {
+		dst[i] = b[i] ^ t[i]
+	}
+}
+
+// decryptBlock decrypts the block: P = D(C xor T) xor T
+func (c *Cipher) decryptBlock(dst, src []byte, t *[blockSize]byte) {
+	var b [blockSize]byte
+	for i := range b {
+		b[i] = src[i] ^ t[i]
+	}
+	c.data.Decrypt(b[:], b[:])
+	for i := range b {
+		dst[i] = b[i] ^ t[i]
+	}
+}
+
+// mulAlpha multiplies the tweak by the primitive element alpha (x) in GF(2^128)
+// the tweak is little endian and the polynomial is x^128 + x^7 + x^2 + x + 1
+func mulAlpha(t *[blockSize]byte) {
+	carry := t[blockSize-1] >> 7
+	for i := blockSize - 1; i > 0; i-- {
+		t[i] = t[i]<<1 | t[i-1]>>7
+	}
+	t[0] <<= 1
+	if carry != 0 {
+		t[0] ^= 0x87
+	}
+}
+
+// IsZero returns whether the data is zero-filled
+// the zero-filled page on disk is not encrypted (ex: the hole of file), so it must not be decrypted
+func IsZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
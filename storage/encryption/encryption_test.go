@@ -0,0 +1,107 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testingKey returns the key 0x00, 0x01, ... 0x1f
+func testingKey() []byte {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	return key
+}
+
+func testingBytes(start, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(start + i)
+	}
+	return b
+}
+
+func TestEncrypt(t *testing.T) {
+	// the expected ciphertexts are generated with openssl (aes-128-xts)
+	tests := []struct {
+		name      string
+		plaintext []byte
+		expected  string
+	}{
+		{
+			name:      "the multiple of block size",
+			plaintext: testingBytes(0, 32),
+			expected:  "2bf8c8fa81c0608c4d79b36e5e7fccd706cd615c50185b71b9b3e7e6920db7f7",
+		},
+		{
+			name:      "ciphertext stealing",
+			plaintext: testingBytes(100, 45),
+			expected:  "13fb97a3456b837dc9926d5d4237838eb295a66a83306dc88be4fb415e890a1195d374a2590efa4aaa8ac4df2f",
+		},
+	}
+	c, err := NewCipher(testingKey())
+	assert.Nil(t, err)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tw := NewTweak(3, 0, 77)
+			got := make([]byte, len(tt.plaintext))
+			err := c.Encrypt(got, tt.plaintext, tw)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expected, hex.EncodeToString(got))
+
+			// decrypt in place
+			err = c.Decrypt(got, got, tw)
+			assert.Nil(t, err)
+			assert.True(t, bytes.Equal(tt.plaintext, got))
+		})
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key := make([]byte, 64)
+	copy(key, testingKey())
+	c, err := NewCipher(key)
+	assert.Nil(t, err)
+
+	for _, n := range []int{16, 17, 31, 100, 8184, 8192} {
+		plaintext := testingBytes(n, n)
+		tw := NewTweak(0, 1, uint32(n))
+		encrypted := make([]byte, n)
+		err := c.Encrypt(encrypted, plaintext, tw)
+		assert.Nil(t, err)
+		assert.False(t, bytes.Equal(plaintext, encrypted), "length %d", n)
+
+		// the different tweak results in the different ciphertext
+		other := make([]byte, n)
+		err = c.Encrypt(other, plaintext, NewTweak(1, 1, uint32(n)))
+		assert.Nil(t, err)
+		assert.False(t, bytes.Equal(encrypted, other), "length %d", n)
+		// the same page of the different relation too
+		err = c.Encrypt(other, plaintext, NewTweak(0, 2, uint32(n)))
+		assert.Nil(t, err)
+		assert.False(t, bytes.Equal(encrypted, other), "length %d", n)
+
+		got := make([]byte, n)
+		err = c.Decrypt(got, encrypted, tw)
+		assert.Nil(t, err)
+		assert.True(t, bytes.Equal(plaintext, got), "length %d", n)
+	}
+
+	// too short
+	err = c.Encrypt(make([]byte, 15), make([]byte, 15), NewTweak(0, 0, 0))
+	assert.NotNil(t, err)
+	// the destination is shorter
+	err = c.Encrypt(make([]byte, 16), make([]byte, 32), NewTweak(0, 0, 0))
+	assert.NotNil(t, err)
+}
+
+func TestNewCipher(t *testing.T) {
+	for _, n := range []int{0, 16, 48} {
+		_, err := NewCipher(make([]byte, n))
+		assert.NotNil(t, err)
+	}
+}
@@ -0,0 +1,229 @@
+/*
+This file manages the data encryption key (DEK).
+
+DEK is generated randomly when encryption is enabled on the cluster (Initialize),
+and stored in pg_cryptokeys directory under data directory wrapped (encrypted) with key encryption key (KEK).
+KEK is supplied by KeyProvider (ex: the key file on the other volume, kms) and never stored in data directory.
+
+KEK can be rotated offline by re-wrapping DEK with new KEK (RotateKey). The pages don't have to be re-encrypted.
+Rotating DEK itself requires re-encrypting all files, and it is not supported.
+
+The wrapped key file is laid out as below:
+  - magic (8 bytes)
+  - nonce of AES-GCM (12 bytes)
+  - DEK encrypted with AES-GCM and its tag (64 + 16 bytes)
+
+AES-GCM detects the wrong KEK, so the cluster is never opened with the wrong key.
+*/
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/pkg/errors"
+)
+
+const (
+	// keyDirName is the directory of the wrapped key under data directory
+	keyDirName = "pg_cryptokeys"
+	// keyFileName is the file name of the wrapped DEK
+	keyFileName = "dek"
+	// keyMagic is written at the beginning of the wrapped key file
+	keyMagic = "ppdbdek1"
+	// dekSize is the size of DEK. this is used for AES-256-XTS
+	dekSize = 64
+	// kekSize is the size of KEK. this is used for AES-256-GCM
+	kekSize = 32
+)
+
+// KeyProvider provides key encryption key (KEK)
+type KeyProvider interface {
+	// KEK returns the key encryption key. the key must be 32 bytes
+	KEK() ([]byte, error)
+}
+
+// StaticKeyProvider provides the key given. this is mainly for test
+type StaticKeyProvider []byte
+
+// KEK returns the key
+func (kp StaticKeyProvider) KEK() ([]byte, error) {
+	return kp, nil
+}
+
+// FileKeyProvider provides the key written in the file as hex string
+// the file should be located outside of data directory (ex: the other volume)
+type FileKeyProvider string
+
+// KEK reads the key from the file
+func (kp FileKeyProvider) KEK() ([]byte, error) {
+	b, err := os.ReadFile(string(kp))
+	if err != nil {
+		return nil, errors.Wrap(err, "os.ReadFile failed")
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, errors.Wrap(err, "hex.DecodeString failed")
+	}
+	return key, nil
+}
+
+// keyPath returns the path of the wrapped key file
+func keyPath(dd *common.DataDir) string {
+	return dd.Join(keyDirName, keyFileName)
+}
+
+// Enabled returns whether encryption is enabled on the cluster
+func Enabled(dd *common.DataDir) (bool, error) {
+	if _, err := os.Stat(keyPath(dd)); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "os.Stat failed")
+	}
+	return true, nil
+}
+
+// Initialize enables encryption on the cluster: generates DEK and stores it wrapped with KEK
+// this must be called before any file is written. if encryption has already been enabled, return error
+func Initialize(dd *common.DataDir, kp KeyProvider) (*Cipher, error) {
+	ok, err := Enabled(dd)
+	if err != nil {
+		return nil, errors.Wrap(err, "Enabled failed")
+	}
+	if ok {
+		return nil, errors.New("encryption has already been enabled")
+	}
+	dek := make([]byte, dekSize)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return nil, errors.Wrap(err, "rand.Read failed")
+	}
+	if err := os.MkdirAll(dd.Join(keyDirName), 0700); err != nil {
+		return nil, errors.Wrap(err, "os.MkdirAll failed")
+	}
+	if err := writeKey(dd, dek, kp); err != nil {
+		return nil, errors.Wrap(err, "writeKey failed")
+	}
+	return NewCipher(dek)
+}
+
+// Open reads DEK unwrapped with KEK and returns the cipher
+// if encryption is not enabled on the cluster, return error
+func Open(dd *common.DataDir, kp KeyProvider) (*Cipher, error) {
+	dek, err := readKey(dd, kp)
+	if err != nil {
+		return nil, errors.Wrap(err, "readKey failed")
+	}
+	return NewCipher(dek)
+}
+
+// RotateKey re-wraps DEK with the new KEK
+// this must be executed while the cluster is stopped (the caller holds data directory, so the server cannot open it)
+// the key file is replaced atomically, so the crash during rotation leaves the old or the new one
+func RotateKey(dd *common.DataDir, oldKP, newKP KeyProvider) error {
+	dek, err := readKey(dd, oldKP)
+	if err != nil {
+		return errors.Wrap(err, "readKey failed")
+	}
+	if err := writeKey(dd, dek, newKP); err != nil {
+		return errors.Wrap(err, "writeKey failed")
+	}
+	return nil
+}
+
+// newKEKCipher returns AES-GCM with KEK provided
+func newKEKCipher(kp KeyProvider) (cipher.AEAD, error) {
+	kek, err := kp.KEK()
+	if err != nil {
+		return nil, errors.Wrap(err, "KEK failed")
+	}
+	if len(kek) != kekSize {
+		return nil, errors.Errorf("the key encryption key must be %d bytes: %d", kekSize, len(kek))
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, errors.Wrap(err, "aes.NewCipher failed")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "cipher.NewGCM failed")
+	}
+	return aead, nil
+}
+
+// readKey reads the key file and unwraps DEK
+func readKey(dd *common.DataDir, kp KeyProvider) ([]byte, error) {
+	b, err := os.ReadFile(keyPath(dd))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.New("encryption is not enabled")
+		}
+		return nil, errors.Wrap(err, "os.ReadFile failed")
+	}
+	aead, err := newKEKCipher(kp)
+	if err != nil {
+		return nil, errors.Wrap(err, "newKEKCipher failed")
+	}
+	if len(b) != len(keyMagic)+aead.NonceSize()+dekSize+aead.Overhead() || string(b[:len(keyMagic)]) != keyMagic {
+		return nil, errors.New("the key file is broken")
+	}
+	nonce := b[len(keyMagic) : len(keyMagic)+aead.NonceSize()]
+	dek, err := aead.Open(nil, nonce, b[len(keyMagic)+aead.NonceSize():], []byte(keyMagic))
+	if err != nil {
+		return nil, errors.Wrap(err, "the key encryption key is wrong")
+	}
+	return dek, nil
+}
+
+// writeKey wraps DEK with KEK and writes the key file atomically
+// the temporary file is written and synced, then renamed to the key file
+func writeKey(dd *common.DataDir, dek []byte, kp KeyProvider) error {
+	aead, err := newKEKCipher(kp)
+	if err != nil {
+		return errors.Wrap(err, "newKEKCipher failed")
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return errors.Wrap(err, "rand.Read failed")
+	}
+	b := append([]byte(keyMagic), nonce...)
+	b = aead.Seal(b, nonce, dek, []byte(keyMagic))
+
+	path := keyPath(dd)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Wrap(err, "os.OpenFile failed")
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return errors.Wrap(err, "Write failed")
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return errors.Wrap(err, "Sync failed")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "Close failed")
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return errors.Wrap(err, "os.Rename failed")
+	}
+	// sync the directory so that the rename is persistent
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return errors.Wrap(err, "os.Open failed")
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		return errors.Wrap(err, "Sync failed")
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInitializeOpen(t *testing.T) {
+	dd := common.TestingNewDataDir(t)
+	kp := StaticKeyProvider(bytes.Repeat([]byte{'k'}, kekSize))
+
+	ok, err := Enabled(dd)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	_, err = Open(dd, kp)
+	assert.NotNil(t, err)
+
+	c, err := Initialize(dd, kp)
+	assert.Nil(t, err)
+	ok, err = Enabled(dd)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, err = Initialize(dd, kp)
+	assert.NotNil(t, err)
+
+	// the cipher opened encrypts the same as the one initialized
+	plaintext := testingBytes(0, 64)
+	expected := make([]byte, len(plaintext))
+	err = c.Encrypt(expected, plaintext, NewTweak(0, 1, 1))
+	assert.Nil(t, err)
+	opened, err := Open(dd, kp)
+	assert.Nil(t, err)
+	got := make([]byte, len(plaintext))
+	err = opened.Encrypt(got, plaintext, NewTweak(0, 1, 1))
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(expected, got))
+
+	// wrong key
+	_, err = Open(dd, StaticKeyProvider(bytes.Repeat([]byte{'x'}, kekSize)))
+	assert.NotNil(t, err)
+	// wrong key length
+	_, err = Open(dd, StaticKeyProvider([]byte{'k'}))
+	assert.NotNil(t, err)
+}
+
+func TestRotateKey(t *testing.T) {
+	dd := common.TestingNewDataDir(t)
+	oldKP := StaticKeyProvider(bytes.Repeat([]byte{'o'}, kekSize))
+	newKP := StaticKeyProvider(bytes.Repeat([]byte{'n'}, kekSize))
+	c, err := Initialize(dd, oldKP)
+	assert.Nil(t, err)
+
+	// rotation with the wrong key fails and the key file is kept
+	err = RotateKey(dd, newKP, newKP)
+	assert.NotNil(t, err)
+	_, err = Open(dd, oldKP)
+	assert.Nil(t, err)
+
+	err = RotateKey(dd, oldKP, newKP)
+	assert.Nil(t, err)
+	_, err = Open(dd, oldKP)
+	assert.NotNil(t, err)
+	opened, err := Open(dd, newKP)
+	assert.Nil(t, err)
+
+	// DEK is not changed, so the pages encrypted before rotation can be decrypted
+	plaintext := testingBytes(0, 64)
+	encrypted := make([]byte, len(plaintext))
+	err = c.Encrypt(encrypted, plaintext, NewTweak(0, 1, 1))
+	assert.Nil(t, err)
+	got := make([]byte, len(plaintext))
+	err = opened.Decrypt(got, encrypted, NewTweak(0, 1, 1))
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(plaintext, got))
+}
+
+func TestFileKeyProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kek")
+	key := bytes.Repeat([]byte{'k'}, kekSize)
+	err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600)
+	assert.Nil(t, err)
+	got, err := FileKeyProvider(path).KEK()
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(key, got))
+
+	_, err = FileKeyProvider(filepath.Join(t.TempDir(), "notexist")).KEK()
+	assert.NotNil(t, err)
+}
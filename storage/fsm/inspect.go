@@ -0,0 +1,33 @@
+package fsm
+
+import "github.com/HayatoShiba/ppdb/storage/page"
+
+// PageTree is the binary tree within fsm page
+// this is exported for inspecting fsm page (see /storage/inspect)
+type PageTree struct {
+	// Nodes are the free space sizes of all nodes in node index order. the first one is the root node
+	Nodes []uint8
+	// FirstLeaf is the node index of the first leaf node (slot 0)
+	FirstLeaf int
+}
+
+// GetPageTree returns the binary tree within fsm page
+// the nodes are copied, so the tree is not changed even if the page is modified
+func GetPageTree(p page.PagePtr) PageTree {
+	nodes := make([]uint8, nodeNum)
+	copy(nodes, p[rootNodeOffset:])
+	return PageTree{
+		Nodes:     nodes,
+		FirstLeaf: int(getNodeIndexFromSlot(firstSlot)),
+	}
+}
+
+// Root returns the free space size of the root node, which is the max within the page
+func (pt PageTree) Root() uint8 {
+	return pt.Nodes[rootNodeIndex]
+}
+
+// Leaves returns the free space sizes of the leaf nodes. the index of the slice is the fsm slot
+func (pt PageTree) Leaves() []uint8 {
+	return pt.Nodes[pt.FirstLeaf:]
+}
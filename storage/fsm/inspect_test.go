@@ -0,0 +1,27 @@
+package fsm
+
+import (
+	"testing"
+
+	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetPageTree(t *testing.T) {
+	p := page.NewPagePtr()
+	updateFreeSpaceSizeFromNodeIndex(p, getNodeIndexFromSlot(firstSlot), 10)
+	updateFreeSpaceSizeFromNodeIndex(p, getNodeIndexFromSlot(fsmSlot(3)), 20)
+	rebuildTree(p)
+
+	pt := GetPageTree(p)
+	assert.Equal(t, nodeNum, len(pt.Nodes))
+	assert.Equal(t, uint8(20), pt.Root())
+	leaves := pt.Leaves()
+	assert.Equal(t, uint8(10), leaves[0])
+	assert.Equal(t, uint8(0), leaves[1])
+	assert.Equal(t, uint8(20), leaves[3])
+
+	// the tree is copied
+	updateFreeSpaceSizeFromNodeIndex(p, rootNodeIndex, 30)
+	assert.Equal(t, uint8(20), pt.Root())
+}
@@ -40,6 +40,10 @@ for the insertion of tuple into page.
   - to update free space map, it has to bubble up the change to upper node/page in binary tree.
   - in postgres, maybe there are other conditions that update free space map in addition to vacuum
 
+- Truncate(): remove the free space of the relation's pages removed by truncation
+  - the slots of the removed pages are zeroed and the change is bubbled up to root node.
+  - the fsm pages which are not necessary anymore are removed from fsm file.
+
 ----
 
 note: It may be not appropriate to define manager for the operation of free space map because
@@ -58,6 +62,7 @@ package fsm
 import (
 	"github.com/HayatoShiba/ppdb/common"
 	"github.com/HayatoShiba/ppdb/storage/buffer"
+	"github.com/HayatoShiba/ppdb/storage/disk"
 	"github.com/HayatoShiba/ppdb/storage/page"
 	"github.com/pkg/errors"
 )
@@ -66,6 +71,7 @@ import (
 type Manager interface {
 	SearchPageIDWithFreeSpaceSize(rel common.Relation, size int) (page.PageID, error)
 	UpdateFSM(rel common.Relation, pageID page.PageID, size int) error
+	Truncate(rel common.Relation, nPages int) error
 }
 
 type ManagerImpl struct {
@@ -113,6 +119,7 @@ func (m *ManagerImpl) SearchPageIDWithFreeSpaceSize(rel common.Relation, size in
 	// at first, check the free space of root node of root fsm page.
 	// when it shows no enough free space, return invalid page id
 	if getFreeSpaceSizeFromNodeIndex(p, idx) < wanted {
+		m.bm.ReleaseBufferFSM(bufID, exclusive)
 		return page.InvalidPageID, nil
 	}
 
@@ -131,6 +138,7 @@ func (m *ManagerImpl) SearchPageIDWithFreeSpaceSize(rel common.Relation, size in
 		} else if getFreeSpaceSizeFromNodeIndex(p, leftIndex) >= wanted {
 			idx = leftIndex
 		} else {
+			m.bm.ReleaseBufferFSM(bufID, exclusive)
 			return page.InvalidPageID, errors.New("this cannot happen (probably)")
 		}
 
@@ -231,6 +239,8 @@ func (m *ManagerImpl) UpdateFSM(rel common.Relation, pageID page.PageID, size in
 	idx := getNodeIndexFromSlot(slot)
 	// update the free space size
 	updateFreeSpaceSizeFromNodeIndex(p, idx, updatedSize)
+	// mark the buffer dirty. otherwise the update is lost when the parent doesn't have to be updated
+	m.bm.MarkDirty(bufID)
 	// then run loop for bubbling up the update
 	for {
 		idx = getParentNode(idx)
@@ -291,3 +301,77 @@ func (m *ManagerImpl) UpdateFSM(rel common.Relation, pageID page.PageID, size in
 		m.bm.MarkDirty(bufID)
 	}
 }
+
+// Truncate removes the free space of the relation's pages from page id nPages, and truncates fsm file.
+// this is expected to be called before the relation is truncated to nPages pages.
+// the relation file itself is not truncated here. the caller truncates it with buffer.Manager.Truncate()
+//   - zero the slots of the removed pages in the bottom fsm page
+//   - bubble up the change: the slot of upper page is set to the root node of the child page, and the following slots are zeroed
+//   - truncate fsm file after the bottom fsm page (if the page has no slot remaining, it is removed too)
+//
+// see FreeSpaceMapPrepareTruncateRel() https://github.com/postgres/postgres/blob/bfcf1b34805f70df48eedeec237230d0cc1154a6/src/backend/storage/freespace/freespace.c
+func (m *ManagerImpl) Truncate(rel common.Relation, nPages int) error {
+	if nPages < 0 {
+		return errors.Errorf("the number of pages is negative: %d", nPages)
+	}
+	// the slot of the first removed page
+	addr, slot := getAddressFromRelationPageID(relationPageID(nPages))
+	fsmPageID := getFSMPageIDFromAddress(addr)
+
+	// if the bottom fsm page doesn't exist, the free space of the removed pages has never been recorded
+	// (when free space is recorded, the fsm file is extended up to the bottom fsm page. see ReadBufferFSM())
+	lastPageID, err := m.bm.GetNPageID(rel, disk.ForkNumberFSM)
+	if err != nil {
+		return errors.Wrap(err, "GetNPageID failed")
+	}
+	if lastPageID == page.InvalidPageID || page.PageID(fsmPageID) > lastPageID {
+		return nil
+	}
+
+	// if the first slot is removed, the whole bottom fsm page is removed, so doesn't need to update it
+	newNPages := int(fsmPageID)
+	var size freeSpaceSize
+	if slot != firstSlot {
+		size, err = m.truncatePage(rel, addr, slot, 0)
+		if err != nil {
+			return errors.Wrap(err, "truncatePage failed 1")
+		}
+		newNPages++
+	}
+	// bubble up the change to root page
+	for addr.treeLevel != treeLevelRoot {
+		parentAddr, parentSlot, ok := getParentAddress(addr)
+		if !ok {
+			return errors.Errorf("getParentAddress is unexpected: %v", addr)
+		}
+		size, err = m.truncatePage(rel, parentAddr, parentSlot, size)
+		if err != nil {
+			return errors.Wrap(err, "truncatePage failed 2")
+		}
+		addr = parentAddr
+	}
+
+	if err := m.bm.Truncate(rel, disk.ForkNumberFSM, newNPages); err != nil {
+		return errors.Wrap(err, "bm.Truncate failed")
+	}
+	return nil
+}
+
+// truncatePage sets the free space size of the slot in the fsm page and zeroes the following slots,
+// then rebuilds the tree within the page. this returns the root node of the page
+func (m *ManagerImpl) truncatePage(rel common.Relation, addr address, slot fsmSlot, size freeSpaceSize) (freeSpaceSize, error) {
+	exclusive := true
+	bufID, err := m.bm.ReadBufferFSM(rel, page.PageID(getFSMPageIDFromAddress(addr)), exclusive)
+	if err != nil {
+		return 0, errors.Wrap(err, "ReadBufferFSM failed")
+	}
+	p := m.bm.GetPage(bufID)
+	truncateSlots(p, slot)
+	if idx := getNodeIndexFromSlot(slot); int(idx) < nodeNum {
+		updateFreeSpaceSizeFromNodeIndex(p, idx, size)
+	}
+	root := rebuildTree(p)
+	m.bm.MarkDirty(bufID)
+	m.bm.ReleaseBufferFSM(bufID, exclusive)
+	return root, nil
+}
@@ -4,6 +4,8 @@ import (
 	"testing"
 
 	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/buffer"
+	"github.com/HayatoShiba/ppdb/storage/disk"
 	"github.com/HayatoShiba/ppdb/storage/page"
 	"github.com/stretchr/testify/assert"
 )
@@ -20,19 +22,19 @@ func TestUpdateFSM(t *testing.T) {
 	}{
 		{
 			name:   "pattern 1",
-			rel:    0,
+			rel:    common.Relation{RelFileNumber: 0},
 			pageID: 10,
 			size:   90,
 		},
 		{
 			name:   "pattern 2",
-			rel:    0,
+			rel:    common.Relation{RelFileNumber: 0},
 			pageID: 1000,
 			size:   8191,
 		},
 		{
 			name:   "pattern 2",
-			rel:    10,
+			rel:    common.Relation{RelFileNumber: 10},
 			pageID: 100,
 			size:   0,
 		},
@@ -45,12 +47,40 @@ func TestUpdateFSM(t *testing.T) {
 	}
 }
 
+func TestUpdateFSMMarksDirtyWithoutBubbleUp(t *testing.T) {
+	bm, err := buffer.TestingNewManager()
+	assert.Nil(t, err)
+	m := NewManager(bm)
+	template := common.DefaultDatabase
+	rel := common.Relation{Database: template, RelFileNumber: 10}
+
+	err = m.UpdateFSM(rel, page.PageID(10), 8000)
+	assert.Nil(t, err)
+	// the fsm pages of the template database are written out and clean after this
+	err = bm.CreateDatabase(common.Database(2), template)
+	assert.Nil(t, err)
+
+	// the parent is not changed because the sibling has more free space
+	err = m.UpdateFSM(rel, page.PageID(11), 100)
+	assert.Nil(t, err)
+
+	bottom := page.PageID(getFSMPageIDFromAddress(address{treeLevel: treeLevelBottom}))
+	found := false
+	for _, info := range bm.Snapshot() {
+		if info.IsUsed && info.Relation == rel && info.ForkNum == disk.ForkNumberFSM && info.PageID == bottom {
+			found = true
+			assert.True(t, info.IsDirty)
+		}
+	}
+	assert.True(t, found)
+}
+
 func TestSearchPageIDWithFreeSpaceSize(t *testing.T) {
 	t.Run("when no free space", func(t *testing.T) {
 		m, err := TestingNewManager()
 		assert.Nil(t, err)
 
-		pageID, err := m.SearchPageIDWithFreeSpaceSize(common.Relation(10), 100)
+		pageID, err := m.SearchPageIDWithFreeSpaceSize(common.Relation{RelFileNumber: 10}, 100)
 		assert.Nil(t, err)
 		assert.Equal(t, page.InvalidPageID, pageID)
 	})
@@ -58,7 +88,7 @@ func TestSearchPageIDWithFreeSpaceSize(t *testing.T) {
 		m, err := TestingNewManager()
 		assert.Nil(t, err)
 
-		rel := common.Relation(10)
+		rel := common.Relation{RelFileNumber: 10}
 		expectedPageID := page.PageID(0)
 		size := 99
 
@@ -73,7 +103,7 @@ func TestSearchPageIDWithFreeSpaceSize(t *testing.T) {
 		m, err := TestingNewManager()
 		assert.Nil(t, err)
 
-		rel := common.Relation(10)
+		rel := common.Relation{RelFileNumber: 10}
 		pid := page.PageID(0)
 		size := 99
 		err = m.UpdateFSM(rel, pid, size)
@@ -94,3 +124,99 @@ func TestSearchPageIDWithFreeSpaceSize(t *testing.T) {
 		assert.Equal(t, expectedPageID, pageID)
 	})
 }
+
+func TestTruncate(t *testing.T) {
+	t.Run("truncate within the bottom fsm page", func(t *testing.T) {
+		m, err := TestingNewManager()
+		assert.Nil(t, err)
+		rel := common.Relation{RelFileNumber: 10}
+
+		err = m.UpdateFSM(rel, page.PageID(10), 8000)
+		assert.Nil(t, err)
+		err = m.UpdateFSM(rel, page.PageID(100), 99)
+		assert.Nil(t, err)
+		err = m.UpdateFSM(rel, page.PageID(200), 8100)
+		assert.Nil(t, err)
+
+		err = m.Truncate(rel, 100)
+		assert.Nil(t, err)
+
+		// the free space of the removed pages is not found anymore
+		pageID, err := m.SearchPageIDWithFreeSpaceSize(rel, 8100)
+		assert.Nil(t, err)
+		assert.Equal(t, page.InvalidPageID, pageID)
+		pageID, err = m.SearchPageIDWithFreeSpaceSize(rel, 90)
+		assert.Nil(t, err)
+		assert.Equal(t, page.PageID(10), pageID)
+
+		// the bottom fsm page remains
+		last, err := m.(*ManagerImpl).bm.GetNPageID(rel, disk.ForkNumberFSM)
+		assert.Nil(t, err)
+		assert.Equal(t, page.PageID(getFSMPageIDFromAddress(address{treeLevel: treeLevelBottom})), last)
+	})
+	t.Run("truncate the whole bottom fsm page", func(t *testing.T) {
+		m, err := TestingNewManager()
+		assert.Nil(t, err)
+		rel := common.Relation{RelFileNumber: 10}
+
+		err = m.UpdateFSM(rel, page.PageID(10), 8000)
+		assert.Nil(t, err)
+		err = m.UpdateFSM(rel, page.PageID(leafNodeNum+5), 8100)
+		assert.Nil(t, err)
+
+		err = m.Truncate(rel, leafNodeNum)
+		assert.Nil(t, err)
+
+		pageID, err := m.SearchPageIDWithFreeSpaceSize(rel, 8100)
+		assert.Nil(t, err)
+		assert.Equal(t, page.InvalidPageID, pageID)
+		pageID, err = m.SearchPageIDWithFreeSpaceSize(rel, 8000)
+		assert.Nil(t, err)
+		assert.Equal(t, page.PageID(10), pageID)
+
+		// the second bottom fsm page is removed
+		last, err := m.(*ManagerImpl).bm.GetNPageID(rel, disk.ForkNumberFSM)
+		assert.Nil(t, err)
+		assert.Equal(t, page.PageID(getFSMPageIDFromAddress(address{treeLevel: treeLevelBottom})), last)
+
+		// the free space can be recorded again after truncation
+		err = m.UpdateFSM(rel, page.PageID(leafNodeNum+5), 8100)
+		assert.Nil(t, err)
+		pageID, err = m.SearchPageIDWithFreeSpaceSize(rel, 8100)
+		assert.Nil(t, err)
+		assert.Equal(t, page.PageID(leafNodeNum+5), pageID)
+	})
+	t.Run("truncate all pages", func(t *testing.T) {
+		m, err := TestingNewManager()
+		assert.Nil(t, err)
+		rel := common.Relation{RelFileNumber: 10}
+
+		err = m.UpdateFSM(rel, page.PageID(0), 8000)
+		assert.Nil(t, err)
+		err = m.Truncate(rel, 0)
+		assert.Nil(t, err)
+
+		pageID, err := m.SearchPageIDWithFreeSpaceSize(rel, 100)
+		assert.Nil(t, err)
+		assert.Equal(t, page.InvalidPageID, pageID)
+	})
+	t.Run("fsm has not been created", func(t *testing.T) {
+		m, err := TestingNewManager()
+		assert.Nil(t, err)
+		rel := common.Relation{RelFileNumber: 10}
+
+		err = m.Truncate(rel, 100)
+		assert.Nil(t, err)
+		// the fsm file is not extended by truncation
+		// (buffer storage has one page initially)
+		last, err := m.(*ManagerImpl).bm.GetNPageID(rel, disk.ForkNumberFSM)
+		assert.Nil(t, err)
+		assert.Equal(t, page.FirstPageID, last)
+	})
+	t.Run("negative", func(t *testing.T) {
+		m, err := TestingNewManager()
+		assert.Nil(t, err)
+		err = m.Truncate(common.Relation{RelFileNumber: 10}, -1)
+		assert.NotNil(t, err)
+	})
+}
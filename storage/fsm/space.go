@@ -45,3 +45,34 @@ func updateFreeSpaceSizeFromNodeIndex(p page.PagePtr, index nodeIndex, size free
 	offset := getByteOffsetFromNodeIndex(index)
 	p[offset] = byte(size)
 }
+
+// truncateSlots sets free space size of the slots from the slot to 0
+// the upper nodes are not updated. call rebuildTree() after this
+// see fsm_truncate_avail() https://github.com/postgres/postgres/blob/bfcf1b34805f70df48eedeec237230d0cc1154a6/src/backend/storage/freespace/fsmpage.c
+func truncateSlots(p page.PagePtr, slot fsmSlot) {
+	for idx := getNodeIndexFromSlot(slot); int(idx) < nodeNum; idx++ {
+		updateFreeSpaceSizeFromNodeIndex(p, idx, 0)
+	}
+}
+
+// rebuildTree recalculates all non-leaf nodes within page from the leaf nodes, and returns the root node
+// each non-leaf node is the max of its children
+// see fsm_rebuild_page() https://github.com/postgres/postgres/blob/bfcf1b34805f70df48eedeec237230d0cc1154a6/src/backend/storage/freespace/fsmpage.c
+func rebuildTree(p page.PagePtr) freeSpaceSize {
+	// go up from the last non-leaf node so that the children have been calculated before the parent
+	for idx := nodeIndex(nonLeafNodeNum); ; idx-- {
+		var size freeSpaceSize
+		for _, child := range []nodeIndex{getLeftChildNode(idx), getRightChildNode(idx)} {
+			if int(child) >= nodeNum {
+				continue
+			}
+			if s := getFreeSpaceSizeFromNodeIndex(p, child); s > size {
+				size = s
+			}
+		}
+		updateFreeSpaceSizeFromNodeIndex(p, idx, size)
+		if isRoot(idx) {
+			return size
+		}
+	}
+}
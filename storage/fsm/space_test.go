@@ -69,3 +69,24 @@ func TestGetFreeSpaceSizeFromNodeIndex(t *testing.T) {
 	got := getFreeSpaceSizeFromNodeIndex(p, nidx)
 	assert.Equal(t, expected, got)
 }
+
+func TestTruncateSlotsAndRebuildTree(t *testing.T) {
+	p := page.NewPagePtr()
+	sizes := map[fsmSlot]freeSpaceSize{
+		firstSlot:                10,
+		fsmSlot(100):             200,
+		fsmSlot(leafNodeNum - 2): 250,
+	}
+	for slot, size := range sizes {
+		updateFreeSpaceSizeFromNodeIndex(p, getNodeIndexFromSlot(slot), size)
+	}
+	assert.Equal(t, freeSpaceSize(250), rebuildTree(p))
+
+	truncateSlots(p, fsmSlot(101))
+	assert.Equal(t, freeSpaceSize(200), rebuildTree(p))
+	assert.Equal(t, freeSpaceSize(200), getFreeSpaceSizeFromNodeIndex(p, rootNodeIndex))
+	assert.Equal(t, freeSpaceSize(0), getFreeSpaceSizeFromNodeIndex(p, getNodeIndexFromSlot(fsmSlot(leafNodeNum-2))))
+
+	truncateSlots(p, firstSlot)
+	assert.Equal(t, freeSpaceSize(0), rebuildTree(p))
+}
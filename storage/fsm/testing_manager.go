@@ -41,3 +41,10 @@ func (mm *MockManager) UpdateFSM(rel common.Relation, pageID page.PageID, size i
 	}
 	return nil
 }
+
+func (mm *MockManager) Truncate(rel common.Relation, nPages int) error {
+	if mm.isErr {
+		return errors.New("mock errors")
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+/*
+Verification and rebuild of free space map (see /storage/amcheck)
+
+fsm is checked against the free space of the relation's pages given by the caller:
+  - each non-leaf node within fsm page equals the max of its children
+  - the slot of upper level fsm page equals the root node of the child fsm page
+  - the slot of bottom level fsm page equals the free space of the relation's page
+
+postgres doesn't verify fsm because fsm is allowed to be inaccurate (it is not WAL-logged and fixed lazily by search).
+so the inconsistencies found here are not always the corruption. rebuild fixes them.
+*/
+package fsm
+
+import (
+	"fmt"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/buffer"
+	"github.com/HayatoShiba/ppdb/storage/disk"
+	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/pkg/errors"
+)
+
+// Inconsistency is the inconsistency found in fsm
+type Inconsistency struct {
+	// PageID is fsm page id
+	PageID page.PageID
+	// Node is the node index within the fsm page. -1 when it is about the whole page
+	Node    int
+	Message string
+}
+
+// verifier walks fsm tree from the root page
+type verifier struct {
+	bm  *buffer.Manager
+	rel common.Relation
+	// lastPageID is the last fsm page id
+	lastPageID page.PageID
+	// freeSpaces is the free space size of the relation's pages. the index is relation's page id
+	freeSpaces []freeSpaceSize
+	found      []Inconsistency
+}
+
+// Verify checks fsm of the relation and returns the inconsistencies found
+// freeSpaces is the free space (bytes) of each relation's page. the index is relation's page id
+func Verify(bm *buffer.Manager, rel common.Relation, freeSpaces []int) ([]Inconsistency, error) {
+	sizes, err := convertFreeSpaces(freeSpaces)
+	if err != nil {
+		return nil, errors.Wrap(err, "convertFreeSpaces failed")
+	}
+	last, err := bm.GetNPageID(rel, disk.ForkNumberFSM)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetNPageID failed")
+	}
+	v := &verifier{
+		bm:         bm,
+		rel:        rel,
+		lastPageID: last,
+		freeSpaces: sizes,
+	}
+	if _, err := v.verifyPage(address{treeLevel: treeLevelRoot, logicalPageID: firstLogicalPageID}); err != nil {
+		return nil, errors.Wrap(err, "verifyPage failed")
+	}
+	return v.found, nil
+}
+
+// exists returns whether the fsm page exists
+func (v *verifier) exists(pid fsmPageID) bool {
+	return v.lastPageID != page.InvalidPageID && page.PageID(pid) <= v.lastPageID
+}
+
+// verifyPage checks the fsm page and its descendants, and returns the root node of the page
+func (v *verifier) verifyPage(addr address) (freeSpaceSize, error) {
+	pid := getFSMPageIDFromAddress(addr)
+	if !v.exists(pid) {
+		v.verifyMissingPage(addr)
+		return 0, nil
+	}
+	// copy the page so that the lock is not held while the children are checked
+	bufID, err := v.bm.ReadBufferFSM(v.rel, page.PageID(pid), false)
+	if err != nil {
+		return 0, errors.Wrap(err, "ReadBufferFSM failed")
+	}
+	p := page.NewPagePtr()
+	*p = *v.bm.GetPage(bufID)
+	v.bm.ReleaseBufferFSM(bufID, false)
+
+	for idx := rootNodeIndex; idx <= nodeIndex(nonLeafNodeNum); idx++ {
+		if expected := maxOfChildren(p, idx); getFreeSpaceSizeFromNodeIndex(p, idx) != expected {
+			v.add(pid, int(idx), "the node is %d, but the max of its children is %d", getFreeSpaceSizeFromNodeIndex(p, idx), expected)
+		}
+	}
+	for slot := firstSlot; int(getNodeIndexFromSlot(slot)) < nodeNum; slot++ {
+		var expected freeSpaceSize
+		if addr.treeLevel == treeLevelBottom {
+			rpid, _ := getRelationPageIDFromAddress(addr, slot)
+			if int(rpid) < len(v.freeSpaces) {
+				expected = v.freeSpaces[rpid]
+			}
+		} else {
+			child, _ := getChildAddress(addr, slot)
+			if v.exists(getFSMPageIDFromAddress(child)) || v.covers(child) {
+				expected, err = v.verifyPage(child)
+				if err != nil {
+					return 0, errors.Wrap(err, "verifyPage failed")
+				}
+			}
+		}
+		idx := getNodeIndexFromSlot(slot)
+		if got := getFreeSpaceSizeFromNodeIndex(p, idx); got != expected {
+			if addr.treeLevel == treeLevelBottom {
+				rpid, _ := getRelationPageIDFromAddress(addr, slot)
+				v.add(pid, int(idx), "the slot of relation page %d is %d, but the free space is %d", rpid, got, expected)
+			} else {
+				v.add(pid, int(idx), "the slot is %d, but the root of the child page is %d", got, expected)
+			}
+		}
+	}
+	return getFreeSpaceSizeFromNodeIndex(p, rootNodeIndex), nil
+}
+
+// verifyMissingPage checks that no relation's page covered by the missing fsm page has free space
+func (v *verifier) verifyMissingPage(addr address) {
+	if addr.treeLevel != treeLevelBottom {
+		for slot := firstSlot; int(getNodeIndexFromSlot(slot)) < nodeNum; slot++ {
+			child, _ := getChildAddress(addr, slot)
+			if !v.covers(child) {
+				return
+			}
+			v.verifyMissingPage(child)
+		}
+		return
+	}
+	for slot := firstSlot; int(getNodeIndexFromSlot(slot)) < nodeNum; slot++ {
+		rpid, _ := getRelationPageIDFromAddress(addr, slot)
+		if int(rpid) >= len(v.freeSpaces) {
+			return
+		}
+		if v.freeSpaces[rpid] != 0 {
+			v.add(getFSMPageIDFromAddress(addr), -1, "the fsm page doesn't exist, but relation page %d has free space", rpid)
+			return
+		}
+	}
+}
+
+// covers returns whether the fsm page covers any relation's page
+func (v *verifier) covers(addr address) bool {
+	return int(firstRelationPageID(addr)) < len(v.freeSpaces)
+}
+
+// add records the inconsistency
+func (v *verifier) add(pid fsmPageID, node int, format string, args ...interface{}) {
+	v.found = append(v.found, Inconsistency{
+		PageID:  page.PageID(pid),
+		Node:    node,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// maxOfChildren returns the max of the children of the non-leaf node
+func maxOfChildren(p page.PagePtr, idx nodeIndex) freeSpaceSize {
+	var size freeSpaceSize
+	for _, child := range []nodeIndex{getLeftChildNode(idx), getRightChildNode(idx)} {
+		if int(child) >= nodeNum {
+			continue
+		}
+		if s := getFreeSpaceSizeFromNodeIndex(p, child); s > size {
+			size = s
+		}
+	}
+	return size
+}
+
+// firstRelationPageID returns the first relation's page id covered by the fsm page
+func firstRelationPageID(addr address) relationPageID {
+	for addr.treeLevel != treeLevelBottom {
+		addr, _ = getChildAddress(addr, firstSlot)
+	}
+	rpid, _ := getRelationPageIDFromAddress(addr, firstSlot)
+	return rpid
+}
+
+// convertFreeSpaces converts the free space of the relation's pages into free space size
+func convertFreeSpaces(freeSpaces []int) ([]freeSpaceSize, error) {
+	sizes := make([]freeSpaceSize, len(freeSpaces))
+	for i, fs := range freeSpaces {
+		size, ok := convertToFreeSpaceSize(fs)
+		if !ok {
+			return nil, errors.Errorf("the free space of page %d is unexpected: %d", i, fs)
+		}
+		sizes[i] = size
+	}
+	return sizes, nil
+}
+
+// Rebuild rebuilds fsm of the relation from the free space of the relation's pages
+// freeSpaces is the free space (bytes) of each relation's page. the index is relation's page id
+// the fsm file is truncated at first, then all fsm pages are written again from the bottom level
+// see also the rebuild of fsm page in vacuum: FreeSpaceMapVacuum() in src/backend/storage/freespace/freespace.c
+func Rebuild(bm *buffer.Manager, rel common.Relation, freeSpaces []int) error {
+	sizes, err := convertFreeSpaces(freeSpaces)
+	if err != nil {
+		return errors.Wrap(err, "convertFreeSpaces failed")
+	}
+	if err := bm.Truncate(rel, disk.ForkNumberFSM, 0); err != nil {
+		return errors.Wrap(err, "Truncate failed")
+	}
+	if len(sizes) == 0 {
+		return nil
+	}
+	if _, err := rebuildPage(bm, rel, address{treeLevel: treeLevelRoot, logicalPageID: firstLogicalPageID}, sizes); err != nil {
+		return errors.Wrap(err, "rebuildPage failed")
+	}
+	return nil
+}
+
+// rebuildPage writes the fsm page and its descendants, and returns the root node of the page
+func rebuildPage(bm *buffer.Manager, rel common.Relation, addr address, sizes []freeSpaceSize) (freeSpaceSize, error) {
+	// the slots are calculated before the page is locked, because the children are written in the meantime
+	var slots []freeSpaceSize
+	for slot := firstSlot; int(getNodeIndexFromSlot(slot)) < nodeNum; slot++ {
+		if addr.treeLevel == treeLevelBottom {
+			rpid, _ := getRelationPageIDFromAddress(addr, slot)
+			if int(rpid) >= len(sizes) {
+				break
+			}
+			slots = append(slots, sizes[rpid])
+			continue
+		}
+		child, _ := getChildAddress(addr, slot)
+		if int(firstRelationPageID(child)) >= len(sizes) {
+			break
+		}
+		size, err := rebuildPage(bm, rel, child, sizes)
+		if err != nil {
+			return 0, errors.Wrap(err, "rebuildPage failed")
+		}
+		slots = append(slots, size)
+	}
+
+	exclusive := true
+	bufID, err := bm.ReadBufferFSM(rel, page.PageID(getFSMPageIDFromAddress(addr)), exclusive)
+	if err != nil {
+		return 0, errors.Wrap(err, "ReadBufferFSM failed")
+	}
+	p := bm.GetPage(bufID)
+	truncateSlots(p, firstSlot)
+	for slot, size := range slots {
+		updateFreeSpaceSizeFromNodeIndex(p, getNodeIndexFromSlot(fsmSlot(slot)), size)
+	}
+	root := rebuildTree(p)
+	bm.MarkDirty(bufID)
+	bm.ReleaseBufferFSM(bufID, exclusive)
+	return root, nil
+}
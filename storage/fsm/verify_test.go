@@ -0,0 +1,85 @@
+package fsm
+
+import (
+	"testing"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/buffer"
+	"github.com/HayatoShiba/ppdb/storage/disk"
+	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+	bm, err := buffer.TestingNewManager()
+	assert.Nil(t, err)
+	rel := common.Relation{RelFileNumber: 1}
+	freeSpaces := []int{100, 8000, 0, 3000}
+
+	t.Run("the fsm page doesn't exist", func(t *testing.T) {
+		err := bm.Truncate(rel, disk.ForkNumberFSM, 0)
+		assert.Nil(t, err)
+		found, err := Verify(bm, rel, freeSpaces)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(found))
+		assert.Equal(t, page.PageID(2), found[0].PageID)
+		assert.Equal(t, -1, found[0].Node)
+
+		// no free space to be recorded
+		found, err = Verify(bm, rel, []int{0, 0})
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(found))
+	})
+	t.Run("rebuild", func(t *testing.T) {
+		err := Rebuild(bm, rel, freeSpaces)
+		assert.Nil(t, err)
+		found, err := Verify(bm, rel, freeSpaces)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(found), "%v", found)
+
+		m := NewManager(bm)
+		pageID, err := m.SearchPageIDWithFreeSpaceSize(rel, 7000)
+		assert.Nil(t, err)
+		assert.Equal(t, page.PageID(1), pageID)
+	})
+	t.Run("the node is not the max of its children", func(t *testing.T) {
+		// the leaf is decreased, but the parents are not updated
+		m := NewManager(bm)
+		err := m.UpdateFSM(rel, page.PageID(1), 0)
+		assert.Nil(t, err)
+		found, err := Verify(bm, rel, freeSpaces)
+		assert.Nil(t, err)
+		assert.Less(t, 1, len(found))
+		for _, inc := range found {
+			assert.Equal(t, page.PageID(2), inc.PageID)
+		}
+		// the leaf of the relation page 1 is found
+		leaf := int(getNodeIndexFromSlot(fsmSlot(1)))
+		var nodes []int
+		for _, inc := range found {
+			nodes = append(nodes, inc.Node)
+		}
+		assert.Contains(t, nodes, leaf)
+	})
+	t.Run("the relation is truncated", func(t *testing.T) {
+		// the slot of the page removed must be 0
+		found, err := Verify(bm, rel, []int{100})
+		assert.Nil(t, err)
+		assert.NotEqual(t, 0, len(found))
+
+		err = Rebuild(bm, rel, nil)
+		assert.Nil(t, err)
+		npid, err := bm.GetNPageID(rel, disk.ForkNumberFSM)
+		assert.Nil(t, err)
+		assert.Equal(t, page.InvalidPageID, npid)
+		found, err = Verify(bm, rel, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(found))
+	})
+	t.Run("the free space is unexpected", func(t *testing.T) {
+		_, err := Verify(bm, rel, []int{page.PageSize + 1})
+		assert.NotNil(t, err)
+		err = Rebuild(bm, rel, []int{-1})
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,206 @@
+/*
+Package inspect decodes the pages of relation fork for debugging on-disk state.
+This is like pageinspect extension of postgres. see https://www.postgresql.org/docs/current/pageinspect.html
+
+The pages are decoded into PageInfo:
+  - page header: lsn, flags, lower, upper, special
+  - main/vm fork: every slot's offset, flag and size, and the item the slot points to
+  - fsm fork: the binary tree within the fsm page
+
+The pages can be read in two ways:
+  - InspectFork: read via disk manager. compressed and encrypted relation can be inspected
+  - InspectFile: read the segment file directly. the fork is detected with the file name
+
+The page may be broken, so the decoding must not panic: the slot which points to outside of page is reported without item.
+*/
+package inspect
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/disk"
+	"github.com/HayatoShiba/ppdb/storage/fsm"
+	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/pkg/errors"
+)
+
+// PageInfo is the page decoded
+type PageInfo struct {
+	PageID page.PageID
+	Fork   disk.ForkNumber
+	// New is whether the page is zero-filled (not initialized yet). the other fields are not set
+	New bool
+
+	// page header. lower, upper and special are not set for fsm page because fsm nodes are stored there
+	LSN     common.WALRecordPtr
+	Flags   uint16
+	Lower   uint16
+	Upper   uint16
+	Special uint16
+
+	// Slots is the slots of main/vm page
+	Slots []SlotInfo
+	// Corruption is the reason why the slots cannot be decoded. empty when the page header is valid
+	Corruption string
+
+	// FSM is the binary tree of fsm page
+	FSM *fsm.PageTree
+}
+
+// SlotInfo is the slot decoded
+type SlotInfo struct {
+	Index  page.SlotIndex
+	Offset int
+	Size   int
+	Flag   string
+	// Item is the item which the slot points to. nil when the slot is unused or points to outside of page
+	Item []byte
+}
+
+// FreeSpace returns the free space between lower and upper
+func (pi *PageInfo) FreeSpace() int {
+	if pi.Upper < pi.Lower {
+		return 0
+	}
+	return int(pi.Upper - pi.Lower)
+}
+
+// InspectPage decodes the page of the fork
+// the page is not referred after this returns (the items are copied)
+func InspectPage(pageID page.PageID, forkNum disk.ForkNumber, p page.PagePtr) *PageInfo {
+	pi := &PageInfo{
+		PageID: pageID,
+		Fork:   forkNum,
+	}
+	if *p == [page.PageSize]byte{} {
+		pi.New = true
+		return pi
+	}
+	pi.LSN = page.GetLSN(p)
+	pi.Flags = page.GetFlags(p)
+	if forkNum == disk.ForkNumberFSM {
+		pt := fsm.GetPageTree(p)
+		pi.FSM = &pt
+		return pi
+	}
+	pi.Lower = uint16(page.GetLowerOffset(p))
+	pi.Upper = uint16(page.GetUpperOffset(p))
+	pi.Special = uint16(page.GetSpecialSpaceOffset(p))
+	if reason := checkHeader(pi); reason != "" {
+		pi.Corruption = reason
+		return pi
+	}
+
+	nidx := page.GetNSlotIndex(p)
+	if nidx == page.InvalidSlotIndex {
+		return pi
+	}
+	for i := page.FirstSlotIndex; i <= nidx; i++ {
+		slot, err := page.GetSlot(p, i)
+		if err != nil {
+			// never happens because lower has been checked
+			pi.Corruption = err.Error()
+			return pi
+		}
+		si := SlotInfo{
+			Index:  i,
+			Offset: page.GetSlotItemOffset(slot),
+			Size:   page.GetSlotItemSize(slot),
+			Flag:   slotFlag(slot),
+		}
+		if !page.IsUnused(slot) && si.Offset+si.Size <= page.PageSize {
+			si.Item = append([]byte{}, p[si.Offset:si.Offset+si.Size]...)
+		}
+		pi.Slots = append(pi.Slots, si)
+	}
+	return pi
+}
+
+// checkHeader checks the offsets in page header so that the slots can be decoded safely
+// see PageIsVerifiedExtended() in src/backend/storage/page/bufpage.c
+func checkHeader(pi *PageInfo) string {
+	if int(pi.Lower) < page.HeaderSize || pi.Lower > pi.Upper || pi.Upper > pi.Special || int(pi.Special) > page.PageSize {
+		return "lower, upper or special is out of range"
+	}
+	return ""
+}
+
+// slotFlag returns the name of slot flag
+func slotFlag(s page.SlotPtr) string {
+	switch {
+	case page.IsNormal(s):
+		return "normal"
+	case page.IsRedirected(s):
+		return "redirected"
+	case page.IsDead(s):
+		return "dead"
+	default:
+		return "unused"
+	}
+}
+
+// inspectBatch is the number of pages read at once by InspectFork
+const inspectBatch = 64
+
+// InspectFork decodes all pages of the relation fork via disk manager
+func InspectFork(dm *disk.Manager, rel common.Relation, forkNum disk.ForkNumber) ([]*PageInfo, error) {
+	last, err := dm.GetNPageID(rel, forkNum)
+	if err != nil {
+		return nil, errors.Wrap(err, "GetNPageID failed")
+	}
+	if last == page.InvalidPageID {
+		return nil, nil
+	}
+	var infos []*PageInfo
+	for start := uint64(page.FirstPageID); start <= uint64(last); start += inspectBatch {
+		n := inspectBatch
+		if rest := uint64(last) - start + 1; rest < uint64(n) {
+			n = int(rest)
+		}
+		pages, err := dm.ReadPages(rel, forkNum, page.PageID(start), n)
+		if err != nil {
+			return nil, errors.Wrap(err, "ReadPages failed")
+		}
+		for i, p := range pages {
+			infos = append(infos, InspectPage(page.PageID(start)+page.PageID(i), forkNum, p))
+		}
+	}
+	return infos, nil
+}
+
+// InspectFile decodes all pages of the segment file of relation fork
+// the fork is detected with the file name (see disk.ParseSegmentFileName)
+// the page id is numbered from the beginning of the file, not the relation, because the pages per segment is unknown here.
+// the compressed or encrypted file cannot be decoded by this. use InspectFork instead
+func InspectFile(path string) ([]*PageInfo, error) {
+	_, forkNum, _, err := disk.ParseSegmentFileName(filepath.Base(path))
+	if err != nil {
+		return nil, errors.Wrap(err, "disk.ParseSegmentFileName failed")
+	}
+	if forkNum != disk.ForkNumberMain && forkNum != disk.ForkNumberFSM && forkNum != disk.ForkNumberVM {
+		return nil, errors.Errorf("the file of %s fork cannot be inspected directly", forkNum)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "os.Open failed")
+	}
+	defer f.Close()
+
+	var infos []*PageInfo
+	for pageID := page.FirstPageID; ; pageID++ {
+		p := page.NewPagePtr()
+		if _, err := io.ReadFull(f, p[:]); err != nil {
+			if err == io.EOF {
+				return infos, nil
+			}
+			if err == io.ErrUnexpectedEOF {
+				return nil, errors.Errorf("the last page is torn: page %d", pageID)
+			}
+			return nil, errors.Wrap(err, "io.ReadFull failed")
+		}
+		infos = append(infos, InspectPage(pageID, forkNum, p))
+	}
+}
@@ -0,0 +1,171 @@
+package inspect
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/disk"
+	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/stretchr/testify/assert"
+)
+
+// testingHeapPage returns the page which has 3 items and the second one is dead
+func testingHeapPage(t *testing.T) page.PagePtr {
+	p := page.NewPagePtr()
+	page.InitializePage(p, 0)
+	page.SetLSN(p, 10)
+	for _, item := range []string{"first", "second", "third"} {
+		err := page.AddItem(p, page.ItemPtr(item), page.InvalidSlotIndex)
+		assert.Nil(t, err)
+	}
+	slot, err := page.GetSlot(p, 1)
+	assert.Nil(t, err)
+	page.SetDead(slot)
+	return p
+}
+
+func TestInspectPage(t *testing.T) {
+	t.Run("heap page", func(t *testing.T) {
+		pi := InspectPage(page.PageID(3), disk.ForkNumberMain, testingHeapPage(t))
+		assert.False(t, pi.New)
+		assert.Equal(t, page.PageID(3), pi.PageID)
+		assert.Equal(t, common.WALRecordPtr(10), pi.LSN)
+		assert.Equal(t, uint16(page.HeaderSize+3*4), pi.Lower)
+		assert.Equal(t, uint16(page.PageSize-len("firstsecondthird")), pi.Upper)
+		assert.Equal(t, uint16(page.PageSize), pi.Special)
+		assert.Equal(t, "", pi.Corruption)
+		assert.Equal(t, 3, len(pi.Slots))
+		assert.Equal(t, "normal", pi.Slots[0].Flag)
+		assert.Equal(t, []byte("first"), pi.Slots[0].Item)
+		assert.Equal(t, page.PageSize-len("first"), pi.Slots[0].Offset)
+		assert.Equal(t, len("first"), pi.Slots[0].Size)
+		assert.Equal(t, "dead", pi.Slots[1].Flag)
+		assert.Equal(t, []byte("third"), pi.Slots[2].Item)
+	})
+	t.Run("zero-filled page", func(t *testing.T) {
+		pi := InspectPage(page.FirstPageID, disk.ForkNumberMain, page.NewPagePtr())
+		assert.True(t, pi.New)
+		assert.Nil(t, pi.Slots)
+	})
+	t.Run("page header is broken", func(t *testing.T) {
+		p := testingHeapPage(t)
+		page.SetLowerOffset(p, page.GetUpperOffset(p)+1)
+		pi := InspectPage(page.FirstPageID, disk.ForkNumberMain, p)
+		assert.NotEqual(t, "", pi.Corruption)
+		assert.Nil(t, pi.Slots)
+	})
+	t.Run("slot points to outside of page", func(t *testing.T) {
+		p := testingHeapPage(t)
+		// the item of the first slot is at the end of page, so the larger size exceeds the page
+		slot, err := page.GetSlot(p, 0)
+		assert.Nil(t, err)
+		slot[0] = 0xff
+		pi := InspectPage(page.FirstPageID, disk.ForkNumberMain, p)
+		assert.Equal(t, "", pi.Corruption)
+		assert.Nil(t, pi.Slots[0].Item)
+		assert.Equal(t, []byte("third"), pi.Slots[2].Item)
+	})
+	t.Run("fsm page", func(t *testing.T) {
+		p := page.NewPagePtr()
+		// root node
+		p[page.LowerOffsetOffset] = 5
+		pi := InspectPage(page.FirstPageID, disk.ForkNumberFSM, p)
+		assert.NotNil(t, pi.FSM)
+		assert.Equal(t, uint8(5), pi.FSM.Root())
+		assert.Nil(t, pi.Slots)
+	})
+}
+
+func TestInspectFork(t *testing.T) {
+	dm, err := disk.TestingNewBufferManager()
+	assert.Nil(t, err)
+	rel := common.Relation{RelFileNumber: 1}
+	err = dm.CreateRelation(rel)
+	assert.Nil(t, err)
+
+	infos, err := InspectFork(dm, rel, disk.ForkNumberMain)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(infos))
+
+	// the pages more than one batch
+	n := inspectBatch + 2
+	pages := make([]page.PagePtr, n)
+	for i := range pages {
+		pages[i] = page.NewPagePtr()
+	}
+	pages[n-1] = testingHeapPage(t)
+	err = dm.WritePages(rel, disk.ForkNumberMain, page.FirstPageID, pages, false)
+	assert.Nil(t, err)
+
+	infos, err = InspectFork(dm, rel, disk.ForkNumberMain)
+	assert.Nil(t, err)
+	assert.Equal(t, n, len(infos))
+	for i, pi := range infos {
+		assert.Equal(t, page.PageID(i), pi.PageID)
+	}
+	assert.True(t, infos[0].New)
+	assert.Equal(t, 3, len(infos[n-1].Slots))
+}
+
+func TestInspectFile(t *testing.T) {
+	dd := common.TestingNewDataDir(t)
+	dm, err := disk.NewManager(dd)
+	assert.Nil(t, err)
+	defer dm.Close()
+	rel := common.Relation{RelFileNumber: 1}
+	err = dm.CreateRelation(rel)
+	assert.Nil(t, err)
+	err = dm.WritePage(rel, disk.ForkNumberMain, page.FirstPageID, testingHeapPage(t), false)
+	assert.Nil(t, err)
+	fp := page.NewPagePtr()
+	fp[page.LowerOffsetOffset] = 7
+	err = dm.WritePage(rel, disk.ForkNumberFSM, page.FirstPageID, fp, false)
+	assert.Nil(t, err)
+
+	infos, err := InspectFile(dd.Join("base", "0", "1"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(infos))
+	assert.Equal(t, 3, len(infos[0].Slots))
+
+	infos, err = InspectFile(dd.Join("base", "0", "1_fsm"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(infos))
+	assert.Equal(t, uint8(7), infos[0].FSM.Root())
+
+	t.Run("torn page", func(t *testing.T) {
+		path := dd.Join("base", "0", "2")
+		err := os.WriteFile(path, make([]byte, page.PageSize+1), 0600)
+		assert.Nil(t, err)
+		_, err = InspectFile(path)
+		assert.NotNil(t, err)
+	})
+	t.Run("not relation file", func(t *testing.T) {
+		_, err := InspectFile(dd.Join("base", "0", "abc"))
+		assert.NotNil(t, err)
+	})
+}
+
+func TestPrint(t *testing.T) {
+	var buf bytes.Buffer
+	err := Print(&buf, InspectPage(page.FirstPageID, disk.ForkNumberMain, testingHeapPage(t)), true)
+	assert.Nil(t, err)
+	out := buf.String()
+	assert.Contains(t, out, "page 0 (main)")
+	assert.Contains(t, out, "lsn: 10")
+	assert.Contains(t, out, "slot 1: offset")
+	assert.Contains(t, out, "dead")
+	// hex dump of the item
+	assert.Contains(t, out, "|first|")
+
+	buf.Reset()
+	err = Print(&buf, InspectPage(page.FirstPageID, disk.ForkNumberMain, testingHeapPage(t)), false)
+	assert.Nil(t, err)
+	assert.NotContains(t, buf.String(), "|first|")
+
+	buf.Reset()
+	err = Print(&buf, InspectPage(page.FirstPageID, disk.ForkNumberMain, page.NewPagePtr()), true)
+	assert.Nil(t, err)
+	assert.Contains(t, buf.String(), "new page")
+}
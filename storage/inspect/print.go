@@ -0,0 +1,59 @@
+package inspect
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Print writes the page decoded in human-readable form
+// if items is true, the hex dumps of items are also written
+// for fsm page, only the root and the leaves which have free space are written
+func Print(w io.Writer, pi *PageInfo, items bool) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "page %d (%s)\n", pi.PageID, pi.Fork)
+	if pi.New {
+		fmt.Fprintln(bw, "  new page (zero-filled)")
+		return flush(bw)
+	}
+	if pi.FSM != nil {
+		fmt.Fprintf(bw, "  lsn: %d flags: 0x%04x\n", pi.LSN, pi.Flags)
+		fmt.Fprintf(bw, "  root: %d\n", pi.FSM.Root())
+		for slot, size := range pi.FSM.Leaves() {
+			if size != 0 {
+				fmt.Fprintf(bw, "  slot %d: %d\n", slot, size)
+			}
+		}
+		return flush(bw)
+	}
+	fmt.Fprintf(bw, "  lsn: %d flags: 0x%04x lower: %d upper: %d special: %d free space: %d\n",
+		pi.LSN, pi.Flags, pi.Lower, pi.Upper, pi.Special, pi.FreeSpace())
+	if pi.Corruption != "" {
+		fmt.Fprintf(bw, "  corrupted: %s\n", pi.Corruption)
+		return flush(bw)
+	}
+	for _, si := range pi.Slots {
+		fmt.Fprintf(bw, "  slot %d: offset %d size %d %s\n", si.Index, si.Offset, si.Size, si.Flag)
+		if si.Flag != "unused" && si.Item == nil {
+			fmt.Fprintln(bw, "    the item is out of page")
+		}
+		if items && len(si.Item) > 0 {
+			// indent hex dump under the slot
+			dump := strings.TrimSuffix(hex.Dump(si.Item), "\n")
+			fmt.Fprintf(bw, "    %s\n", strings.ReplaceAll(dump, "\n", "\n    "))
+		}
+	}
+	return flush(bw)
+}
+
+// flush flushes the buffered writer
+func flush(bw *bufio.Writer) error {
+	if err := bw.Flush(); err != nil {
+		return errors.Wrap(err, "Flush failed")
+	}
+	return nil
+}
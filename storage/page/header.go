@@ -98,6 +98,10 @@ const (
 
 	// lower offset exported for fsm and vm
 	LowerOffsetOffset = uint16(flagsOffset) + 2
+	// LSNSize is the size of lsn at the head of page. this is exported for encryption (lsn is not encrypted)
+	LSNSize = int(flagsOffset - lsnOffset)
+	// HeaderSize is the size of page header. the slot array starts here
+	HeaderSize = int(slotsOffset)
 )
 
 // GetLSN returns lsn
@@ -158,6 +162,11 @@ func SetSpecialSpaceOffset(p PagePtr, o offset) {
 // see https://github.com/postgres/postgres/blob/bfcf1b34805f70df48eedeec237230d0cc1154a6/src/include/storage/bufpage.h#L172-L186
 const (
 	allVisible = 0x01
+	// hasFreeSlots is the hint that the page may have unused slots. this is called PD_HAS_FREE_LINES in postgres
+	// when this is not set, AddItem doesn't scan the slots to find unused slot.
+	// this is set when the slot gets unused, and cleared when the scan finds no unused slot.
+	// so this can be set even if there is no unused slot, but must not be cleared while there is
+	hasFreeSlots = 0x02
 )
 
 // IsAllVisible is whether the flags allVisible is set
@@ -177,3 +186,21 @@ func ClearAllVisible(p PagePtr) {
 	flags := GetFlags(p)
 	SetFlags(p, flags&^allVisible)
 }
+
+// hasFreeSlotsHint is whether the flags hasFreeSlots is set
+func hasFreeSlotsHint(p PagePtr) bool {
+	flags := GetFlags(p)
+	return (flags & hasFreeSlots) != 0
+}
+
+// setHasFreeSlots sets hasFreeSlots bit
+func setHasFreeSlots(p PagePtr) {
+	flags := GetFlags(p)
+	SetFlags(p, flags|hasFreeSlots)
+}
+
+// clearHasFreeSlots clears hasFreeSlots bit
+func clearHasFreeSlots(p PagePtr) {
+	flags := GetFlags(p)
+	SetFlags(p, flags&^hasFreeSlots)
+}
@@ -4,6 +4,10 @@
 item-related interface is
 - GetItem(PagePtr, SlotIndex): gets item from page. the location of the item is calculated from SlotIndex's Slot
 - AddItem(PagePtr, ItemPtr, SlotIndex): adds item to the page. if the page does not have enough space, return error.
+- AddItemExtended(PagePtr, ItemPtr, SlotIndex, AddItemFlag): AddItem with the flags which change how the slot is decided.
+- InsertItemAt(PagePtr, ItemPtr, SlotIndex): inserts item at the slot index and shifts the following slots. this is for index page.
+- DeleteItemAt(PagePtr, SlotIndex): removes item and its slot, and shifts the following slots. this is for index page.
+- DeleteItems(PagePtr, []SlotIndex): removes the items from the page and compacts the page.
 */
 package page
 
@@ -38,24 +42,47 @@ func GetItem(page PagePtr, idx SlotIndex) (ItemPtr, error) {
 	return ItemPtr(ptr), nil
 }
 
+// AddItemFlag is the flags of AddItemExtended
+// this is like the flags of PageAddItemExtended() in postgres
+// see https://github.com/postgres/postgres/blob/bfcf1b34805f70df48eedeec237230d0cc1154a6/src/include/storage/bufpage.h
+type AddItemFlag uint8
+
+const (
+	// AddItemAppend always appends new slot even if there is unused slot
+	// index page needs this because the order of the slots is the order of the keys
+	AddItemAppend AddItemFlag = 1 << iota
+	// AddItemOverwrite replaces the item of the slot index passed in place when the size is the same as new item
+	// the slot which is used by the item of the different size cannot be overwritten
+	AddItemOverwrite
+)
+
+// AddItem adds item to the page. see AddItemExtended
+func AddItem(page PagePtr, item ItemPtr, si SlotIndex) error {
+	return AddItemExtended(page, item, si, 0)
+}
+
 /*
-AddItem adds item to the page
-AddItem does the following
+AddItemExtended adds item to the page
+AddItemExtended does the following
 - get slot index where the item will be inserted: find free slot or, when no free slot, extend new slot
 - generate slot data and insert it to the slot index.
 - insert item to the page
 - update page header
+with AddItemAppend, new slot is always extended without finding free slot
+with AddItemOverwrite, the item of the used slot is replaced in place and no space is consumed
 see https://github.com/postgres/postgres/blob/2cd2569c72b8920048e35c31c9be30a6170e1410/src/backend/storage/page/bufpage.c#L194
 */
-func AddItem(page PagePtr, item ItemPtr, si SlotIndex) error {
+func AddItemExtended(page PagePtr, item ItemPtr, si SlotIndex, flags AddItemFlag) error {
 	var slotExtended bool
 	var err error
 	slotIndex := si
 	// if invalid slot index is passed, find free slot or extend new slot
 	if slotIndex == InvalidSlotIndex {
-		slotIndex, err = findFreeSlot(page)
-		if err != nil {
-			return errors.Wrap(err, "findFreeSlot failed")
+		if flags&AddItemAppend == 0 {
+			slotIndex, err = findFreeSlot(page)
+			if err != nil {
+				return errors.Wrap(err, "findFreeSlot failed")
+			}
 		}
 		// if no free slot, just extend the slot
 		if slotIndex == InvalidSlotIndex {
@@ -67,8 +94,17 @@ func AddItem(page PagePtr, item ItemPtr, si SlotIndex) error {
 			slotExtended = true
 		}
 	}
-	// here, the slot is decided, so check space
 	size := len(item)
+	if flags&AddItemOverwrite != 0 && si != InvalidSlotIndex {
+		replaced, err := overwriteItem(page, item, si)
+		if err != nil {
+			return errors.Wrap(err, "overwriteItem failed")
+		}
+		if replaced {
+			return nil
+		}
+	}
+	// here, the slot is decided, so check space
 	if ok := hasEnoughFreeSpace(page, size, slotExtended); !ok {
 		return errors.Errorf("item size is larger than the free sapce. itemSize %d", size)
 	}
@@ -89,6 +125,29 @@ func AddItem(page PagePtr, item ItemPtr, si SlotIndex) error {
 	return nil
 }
 
+// overwriteItem replaces the item of the slot in place, and returns whether the item is replaced
+// if the slot is not used yet, the item is not replaced and new item should be added to the slot
+// see PageIndexTupleOverwrite() https://github.com/postgres/postgres/blob/2cd2569c72b8920048e35c31c9be30a6170e1410/src/backend/storage/page/bufpage.c
+func overwriteItem(page PagePtr, item ItemPtr, si SlotIndex) (bool, error) {
+	nidx := GetNSlotIndex(page)
+	if nidx == InvalidSlotIndex || si > nidx {
+		return false, nil
+	}
+	slot, err := GetSlot(page, si)
+	if err != nil {
+		return false, errors.Wrap(err, "GetSlot failed")
+	}
+	if IsUnused(slot) {
+		return false, nil
+	}
+	if int(getItemSize(slot)) != len(item) {
+		return false, errors.Errorf("the item size is different from the item of the slot %d: %d, %d", si, len(item), getItemSize(slot))
+	}
+	io := getItemOffset(slot)
+	copy(page[io:int(io)+len(item)], item)
+	return true, nil
+}
+
 // hasEnoughFreeSpace checks whether the page has enough free space to add the item
 // if slotExtended is true, then the slot will be extended to add the item, so consider the added slot size
 func hasEnoughFreeSpace(page PagePtr, itemSize int, slotExtended bool) bool {
@@ -99,3 +158,130 @@ func hasEnoughFreeSpace(page PagePtr, itemSize int, slotExtended bool) bool {
 	}
 	return freeSpace >= int(itemSize)
 }
+
+/*
+DeleteItems removes the items of the slots from the page, and compacts the page in one pass
+the slots are set unused (the slot index of the other items is not changed), so the trailing unused slots can be
+removed with TruncateUnusedSlots() after this.
+this is expected to be used by index vacuuming and heap pruning, which remove many items at once.
+if any slot is invalid (out of range or already unused), return error without modifying the page.
+the caller must hold cleanup lock because the items are moved (see CompactPage)
+
+postgres removes the slots too (the following slots are shifted), but ppdb keeps them
+see PageIndexMultiDelete() https://github.com/postgres/postgres/blob/2cd2569c72b8920048e35c31c9be30a6170e1410/src/backend/storage/page/bufpage.c
+*/
+func DeleteItems(page PagePtr, indexes []SlotIndex) error {
+	if len(indexes) == 0 {
+		return nil
+	}
+	nidx := GetNSlotIndex(page)
+	var deleted [MaxSlotIndex + 1]bool
+	for _, idx := range indexes {
+		if nidx == InvalidSlotIndex || idx > nidx {
+			return errors.Errorf("the slot is out of range: %d", idx)
+		}
+		slot, err := GetSlot(page, idx)
+		if err != nil {
+			return errors.Wrap(err, "GetSlot failed")
+		}
+		if IsUnused(slot) {
+			return errors.Errorf("the slot is already unused: %d", idx)
+		}
+		deleted[idx] = true
+	}
+	if err := compactPage(page, &deleted); err != nil {
+		return errors.Wrap(err, "compactPage failed")
+	}
+	return nil
+}
+
+/*
+InsertItemAt inserts item at the slot index, and the slot of the index and the following slots are shifted by one
+this is for index page, where the order of the slots is the order of the keys.
+don't use this for heap page because the slot index of the following items is changed (the index points to them)
+idx can be the next of the last slot, then the item is appended
+see PageAddItemExtended() https://github.com/postgres/postgres/blob/2cd2569c72b8920048e35c31c9be30a6170e1410/src/backend/storage/page/bufpage.c#L194
+*/
+func InsertItemAt(page PagePtr, item ItemPtr, idx SlotIndex) error {
+	// the number of slots allocated
+	var n SlotIndex
+	if nidx := GetNSlotIndex(page); nidx != InvalidSlotIndex {
+		n = nidx + 1
+	}
+	if idx > n {
+		return errors.Errorf("the slot index is out of range: %d, the number of slots %d", idx, n)
+	}
+	if n > MaxSlotIndex {
+		return errors.Errorf("slot cannot be extended anymore: %d", n)
+	}
+	size := len(item)
+	if ok := hasEnoughFreeSpace(page, size, true); !ok {
+		return errors.Errorf("item size is larger than the free sapce. itemSize %d", size)
+	}
+
+	// shift the slots. copy works like memmove
+	so := uint16(slotsOffset) + uint16(idx)*slotSize
+	lower := uint16(GetLowerOffset(page))
+	copy(page[so+slotSize:lower+slotSize], page[so:lower])
+
+	newUpperOffset := uint16(GetUpperOffset(page)) - uint16(size)
+	insertSlot(page, idx, itemOffset(newUpperOffset), itemSize(size))
+	copy(page[newUpperOffset:newUpperOffset+uint16(size)], item)
+	SetLowerOffset(page, offset(lower+slotSize))
+	SetUpperOffset(page, offset(newUpperOffset))
+	return nil
+}
+
+/*
+DeleteItemAt removes the item and its slot, and the following slots are shifted by one
+this is for index page like InsertItemAt. the space of the item is reclaimed at once by moving the items below it,
+so the caller must hold cleanup lock when the page is in shared buffer (see CompactPage)
+see PageIndexTupleDelete() https://github.com/postgres/postgres/blob/2cd2569c72b8920048e35c31c9be30a6170e1410/src/backend/storage/page/bufpage.c
+*/
+func DeleteItemAt(page PagePtr, idx SlotIndex) error {
+	nidx := GetNSlotIndex(page)
+	if nidx == InvalidSlotIndex || idx > nidx {
+		return errors.Errorf("the slot is out of range: %d", idx)
+	}
+	slot, err := GetSlot(page, idx)
+	if err != nil {
+		return errors.Wrap(err, "GetSlot failed")
+	}
+	upper := GetUpperOffset(page)
+	special := GetSpecialSpaceOffset(page)
+	// the unused slot has no storage even if the extent remains
+	var io itemOffset
+	var size itemSize
+	if !IsUnused(slot) {
+		io, size = getItemOffset(slot), getItemSize(slot)
+	}
+	if size > 0 && (offset(io) < upper || offset(io)+offset(size) > special) {
+		return errors.Errorf("the slot %d is corrupted: offset %d, size %d", idx, io, size)
+	}
+
+	// remove the slot. copy works like memmove
+	so := uint16(slotsOffset) + uint16(idx)*slotSize
+	lower := uint16(GetLowerOffset(page))
+	copy(page[so:lower-slotSize], page[so+slotSize:lower])
+	SetLowerOffset(page, offset(lower-slotSize))
+	if size == 0 {
+		return nil
+	}
+
+	// move the items below the removed item up, and update the slots pointing to them
+	copy(page[offset(upper)+offset(size):offset(io)+offset(size)], page[upper:io])
+	for i := FirstSlotIndex; nidx > 0 && i <= nidx-1; i++ {
+		s, err := GetSlot(page, i)
+		if err != nil {
+			return errors.Wrap(err, "GetSlot failed")
+		}
+		if IsUnused(s) || IsRedirected(s) || getItemSize(s) == 0 {
+			continue
+		}
+		if o := getItemOffset(s); o < io {
+			setItemOffset(s, o+itemOffset(size))
+		}
+	}
+	SetUpperOffset(page, upper+offset(size))
+	return nil
+}
@@ -2,6 +2,7 @@ package page
 
 import (
 	"bytes"
+	"encoding/binary"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -75,7 +76,7 @@ func TestAddItem(t *testing.T) {
 		// free up first slot
 		slot, err := GetSlot(page, FirstSlotIndex)
 		assert.Nil(t, err)
-		SetUnused(slot)
+		SetUnused(page, slot)
 		// then add item
 		expected := []byte{9, 10}
 		err = AddItem(page, expected, InvalidSlotIndex)
@@ -90,3 +91,217 @@ func TestAddItem(t *testing.T) {
 		assert.True(t, bytes.Equal([]byte(got), expected))
 	})
 }
+
+func TestDeleteItems(t *testing.T) {
+	t.Run("the items are removed and the page is compacted", func(t *testing.T) {
+		page := NewPagePtr()
+		InitializePage(page, 10)
+		for i := 0; i < 5; i++ {
+			err := AddItem(page, bytes.Repeat([]byte{byte(i)}, 4), InvalidSlotIndex)
+			assert.Nil(t, err)
+		}
+		before := CalculateFreeSpace(page)
+
+		err := DeleteItems(page, []SlotIndex{3, 1, 3})
+		assert.Nil(t, err)
+		assert.Equal(t, before+2*4, CalculateFreeSpace(page))
+		// the slot index of the other items is not changed
+		assert.Equal(t, SlotIndex(4), GetNSlotIndex(page))
+		for i := FirstSlotIndex; i <= GetNSlotIndex(page); i++ {
+			slot, err := GetSlot(page, i)
+			assert.Nil(t, err)
+			if i == 1 || i == 3 {
+				assert.True(t, IsUnused(slot))
+				assert.Equal(t, 0, GetSlotItemSize(slot))
+				continue
+			}
+			item, err := GetItem(page, i)
+			assert.Nil(t, err)
+			assert.Equal(t, bytes.Repeat([]byte{byte(i)}, 4), []byte(item))
+		}
+	})
+
+	t.Run("the page is not modified when the slot is invalid", func(t *testing.T) {
+		page := NewPagePtr()
+		InitializePage(page, 10)
+		for i := 0; i < 3; i++ {
+			err := AddItem(page, []byte{byte(i)}, InvalidSlotIndex)
+			assert.Nil(t, err)
+		}
+		slot, err := GetSlot(page, 2)
+		assert.Nil(t, err)
+		SetUnused(page, slot)
+		before := *page
+
+		// out of range
+		assert.NotNil(t, DeleteItems(page, []SlotIndex{0, 3}))
+		assert.Equal(t, before, *page)
+		// already unused
+		assert.NotNil(t, DeleteItems(page, []SlotIndex{0, 2}))
+		assert.Equal(t, before, *page)
+	})
+}
+
+func TestAddItemExtended(t *testing.T) {
+	t.Run("append even if there is unused slot", func(t *testing.T) {
+		page := NewPagePtr()
+		InitializePage(page, 10)
+		for i := 0; i < 2; i++ {
+			err := AddItem(page, []byte{byte(i)}, InvalidSlotIndex)
+			assert.Nil(t, err)
+		}
+		err := DeleteItems(page, []SlotIndex{0})
+		assert.Nil(t, err)
+
+		err = AddItemExtended(page, []byte{2}, InvalidSlotIndex, AddItemAppend)
+		assert.Nil(t, err)
+		assert.Equal(t, SlotIndex(2), GetNSlotIndex(page))
+		got, err := GetItem(page, 2)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{2}, []byte(got))
+
+		// the unused slot is still reused without the flag
+		err = AddItem(page, []byte{3}, InvalidSlotIndex)
+		assert.Nil(t, err)
+		got, err = GetItem(page, FirstSlotIndex)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{3}, []byte(got))
+	})
+}
+
+func TestAddItemFreeSlotsHint(t *testing.T) {
+	page := NewPagePtr()
+	InitializePage(page, 10)
+	for i := 0; i < 3; i++ {
+		err := AddItem(page, []byte{byte(i)}, InvalidSlotIndex)
+		assert.Nil(t, err)
+	}
+	assert.False(t, hasFreeSlotsHint(page))
+
+	// the slot which gets unused without the hint is not found
+	slot, err := GetSlot(page, 1)
+	assert.Nil(t, err)
+	binary.LittleEndian.PutUint32(slot[:], uint32(generateSlot(0, slotFlagUnused, 0)))
+	err = AddItem(page, []byte{3}, InvalidSlotIndex)
+	assert.Nil(t, err)
+	assert.Equal(t, SlotIndex(3), GetNSlotIndex(page))
+
+	// compaction makes the hint accurate, so the slot is found
+	err = CompactPage(page)
+	assert.Nil(t, err)
+	assert.True(t, hasFreeSlotsHint(page))
+	err = AddItem(page, []byte{4}, InvalidSlotIndex)
+	assert.Nil(t, err)
+	assert.Equal(t, SlotIndex(3), GetNSlotIndex(page))
+	got, err := GetItem(page, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{4}, []byte(got))
+
+	// the hint is cleared when the scan finds no unused slot
+	err = AddItem(page, []byte{5}, InvalidSlotIndex)
+	assert.Nil(t, err)
+	assert.Equal(t, SlotIndex(4), GetNSlotIndex(page))
+	assert.False(t, hasFreeSlotsHint(page))
+}
+
+// testingItems returns the items of the page in slot order. nil for unused slot
+func testingItems(t *testing.T, page PagePtr) [][]byte {
+	var items [][]byte
+	nidx := GetNSlotIndex(page)
+	for i := FirstSlotIndex; nidx != InvalidSlotIndex && i <= nidx; i++ {
+		slot, err := GetSlot(page, i)
+		assert.Nil(t, err)
+		if IsUnused(slot) {
+			items = append(items, nil)
+			continue
+		}
+		item, err := GetItem(page, i)
+		assert.Nil(t, err)
+		items = append(items, []byte(item))
+	}
+	return items
+}
+
+func TestInsertItemAt(t *testing.T) {
+	page := NewPagePtr()
+	InitializePage(page, 10)
+	assert.Nil(t, InsertItemAt(page, []byte("b"), 0))
+	// insert at the head
+	assert.Nil(t, InsertItemAt(page, []byte("a"), 0))
+	// append
+	assert.Nil(t, InsertItemAt(page, []byte("dd"), 2))
+	// insert in the middle
+	assert.Nil(t, InsertItemAt(page, []byte("c"), 2))
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("dd")}, testingItems(t, page))
+	assert.Equal(t, int(GetSpecialSpaceOffset(page))-4*slotSize-HeaderSize-5, CalculateFreeSpace(page))
+
+	// out of range
+	assert.NotNil(t, InsertItemAt(page, []byte("e"), 5))
+	// not enough space
+	assert.NotNil(t, InsertItemAt(page, make([]byte, CalculateFreeSpace(page)-slotSize+1), 0))
+	assert.Equal(t, 4, len(testingItems(t, page)))
+}
+
+func TestDeleteItemAt(t *testing.T) {
+	page := NewPagePtr()
+	InitializePage(page, 10)
+	for _, item := range []string{"a", "bb", "ccc", "dddd"} {
+		err := AddItem(page, []byte(item), InvalidSlotIndex)
+		assert.Nil(t, err)
+	}
+	before := CalculateFreeSpace(page)
+
+	// the items below the removed one are moved
+	assert.Nil(t, DeleteItemAt(page, 1))
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("ccc"), []byte("dddd")}, testingItems(t, page))
+	assert.Equal(t, before+slotSize+2, CalculateFreeSpace(page))
+
+	// the last one
+	assert.Nil(t, DeleteItemAt(page, 2))
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("ccc")}, testingItems(t, page))
+
+	// the unused slot has no storage
+	slot, err := GetSlot(page, 0)
+	assert.Nil(t, err)
+	SetUnused(page, slot)
+	before = CalculateFreeSpace(page)
+	assert.Nil(t, DeleteItemAt(page, 0))
+	assert.Equal(t, [][]byte{[]byte("ccc")}, testingItems(t, page))
+	assert.Equal(t, before+slotSize, CalculateFreeSpace(page))
+
+	assert.NotNil(t, DeleteItemAt(page, 1))
+	assert.Nil(t, DeleteItemAt(page, 0))
+	assert.Equal(t, InvalidSlotIndex, GetNSlotIndex(page))
+	// the item of the unused slot is left until compaction
+	assert.Equal(t, int(GetSpecialSpaceOffset(page))-HeaderSize-1, CalculateFreeSpace(page))
+	assert.NotNil(t, DeleteItemAt(page, 0))
+}
+
+func TestAddItemOverwrite(t *testing.T) {
+	page := NewPagePtr()
+	InitializePage(page, 10)
+	for _, item := range []string{"a", "bb"} {
+		err := AddItem(page, []byte(item), InvalidSlotIndex)
+		assert.Nil(t, err)
+	}
+	before := CalculateFreeSpace(page)
+
+	// replaced in place
+	err := AddItemExtended(page, []byte("xy"), 1, AddItemOverwrite)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("xy")}, testingItems(t, page))
+	assert.Equal(t, before, CalculateFreeSpace(page))
+
+	// the size is different
+	err = AddItemExtended(page, []byte("xyz"), 1, AddItemOverwrite)
+	assert.NotNil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("xy")}, testingItems(t, page))
+
+	// the unused slot is filled as usual
+	slot, err := GetSlot(page, 0)
+	assert.Nil(t, err)
+	SetUnused(page, slot)
+	err = AddItemExtended(page, []byte("zzz"), 0, AddItemOverwrite)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("zzz"), []byte("xy")}, testingItems(t, page))
+}
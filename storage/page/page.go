@@ -74,8 +74,9 @@ func IsInitialized(p PagePtr) bool {
 
 // CalculateFileOffset calculates the page's offset within the file
 // the page size is fixed (8KB) so that it is easy to calculate the offset
+// page id is widened before multiplied, otherwise the offset overflows uint32 beyond 4GB
 func CalculateFileOffset(pageID PageID) int64 {
-	return int64(pageID * PageSize)
+	return int64(pageID) * PageSize
 }
 
 // CalculateFreeSpace calculates free space within the page
@@ -102,45 +103,130 @@ slot index is called ctid in postgres, and you can confirm it like the query bel
 - SELECT s.ctid, s.* from sample s;
 - VACUUM;
 
-TODO: the logic in ppdb is not optimized, so fix this later
-ex: should consider whether the slots are sorted or not, and moves only tuples necessary for re-location
-the case slots are not sorted can happen after the page is compacted and freed slot is used when insert new tuple
+CompactPage moves tuples within the page, so the tuple other goroutines are reading by pointer (ItemPtr) can be broken.
+When the page is in shared buffer, the caller must hold cleanup lock (see buffer.LockBufferForCleanup), not just exclusive content lock.
+
+The algorithm is the same as postgres:
+  - collect the extents of the items which have storage (the slots which are not unused)
+  - if the extents are already packed at the end of page in offset order, nothing has to be done
+  - sort the extents by offset descending (the extents are usually sorted already because the items are appended downward)
+  - move the items toward the end of page in that order. the item which is already at the right place is not moved
+
+The items are moved in place from the highest offset, so the destination never overwrites the items not moved yet.
+The extents are collected into the fixed array, so this doesn't allocate.
+
 see https://github.com/postgres/postgres/blob/2cd2569c72b8920048e35c31c9be30a6170e1410/src/backend/storage/page/bufpage.c#L474
 see https://github.com/postgres/postgres/blob/2cd2569c72b8920048e35c31c9be30a6170e1410/src/backend/storage/page/bufpage.c#L682-L699
 */
 func CompactPage(page PagePtr) error {
-	// IMPORTANT: currently, scans/moves(compacts) all used tuples even when re-location is not necessary. this is not optimized
-	// reset upper offset
-	upperOffset := GetSpecialSpaceOffset(page)
-	var constructed []byte
+	return compactPage(page, nil)
+}
 
+// compactPage compacts the items within page. the items of the slots marked in deleted are removed at the same time
+// the page is not modified when error is returned
+func compactPage(page PagePtr, deleted *[MaxSlotIndex + 1]bool) error {
 	nidx := GetNSlotIndex(page)
 	if nidx == InvalidSlotIndex {
 		// no slot allocated, so just return
 		return nil
 	}
-	// run loop and construct and re-locate all tuples except unused tuples
-	for i := int(nidx); i >= int(FirstSlotIndex); i-- {
-		slot, err := GetSlot(page, SlotIndex(i))
+	upper := GetUpperOffset(page)
+	special := GetSpecialSpaceOffset(page)
+	if upper > special || int(special) > PageSize {
+		return errors.Errorf("the page header is corrupted: upper %d, special %d", upper, special)
+	}
+
+	var extents [MaxSlotIndex + 1]itemExtent
+	n := 0
+	totalSize := 0
+	presorted := true
+	hasUnused := false
+	for i := FirstSlotIndex; i <= nidx; i++ {
+		slot, err := GetSlot(page, i)
 		if err != nil {
 			return errors.Wrap(err, "GetSlot failed")
 		}
-		if IsUnused(slot) {
+		if IsUnused(slot) || (deleted != nil && deleted[i]) {
+			hasUnused = true
+			continue
+		}
+		e := itemExtent{index: i, offset: getItemOffset(slot), size: getItemSize(slot)}
+		if e.size == 0 {
+			// no storage (ex: redirected slot)
 			continue
 		}
-		// compact
-		io := getItemOffset(slot)
-		is := getItemSize(slot)
-		item := page[io : io+itemOffset(is)]
-		constructed = append(constructed, item...)
-
-		// update item offset of the slot
-		upperOffset = upperOffset - offset(is)
-		setItemOffset(slot, itemOffset(upperOffset))
+		if offset(e.offset) < upper || int(e.offset)+int(e.size) > int(special) {
+			return errors.Errorf("the slot %d is corrupted: offset %d, size %d", i, e.offset, e.size)
+		}
+		if n > 0 && e.offset > extents[n-1].offset {
+			presorted = false
+		}
+		extents[n] = e
+		n++
+		totalSize += int(e.size)
+	}
+	if totalSize > int(special-upper) {
+		return errors.Errorf("the items overlap: total size %d, space %d", totalSize, special-upper)
+	}
+	if deleted != nil {
+		for i := FirstSlotIndex; i <= nidx; i++ {
+			if deleted[i] {
+				slot, err := GetSlot(page, i)
+				if err != nil {
+					return errors.Wrap(err, "GetSlot failed")
+				}
+				clearSlot(slot)
+			}
+		}
 	}
-	// insert compacted items into the page
-	size := len(constructed)
-	copy(page[upperOffset:upperOffset+offset(size)], constructed)
-	SetUpperOffset(page, offset(upperOffset))
+	// all slots have been checked here, so the hint can be accurate like PageRepairFragmentation()
+	if hasUnused {
+		setHasFreeSlots(page)
+	} else {
+		clearHasFreeSlots(page)
+	}
+	// the sorted items which fill the space up to special have no hole between them
+	if presorted && totalSize == int(special-upper) {
+		return nil
+	}
+	if !presorted {
+		sortExtents(extents[:n])
+	}
+
+	newUpper := special
+	for _, e := range extents[:n] {
+		newUpper -= offset(e.size)
+		if itemOffset(newUpper) == e.offset {
+			continue
+		}
+		// copy works like memmove, so the overlap of the source and destination is ok
+		copy(page[newUpper:newUpper+offset(e.size)], page[e.offset:e.offset+itemOffset(e.size)])
+		slot, err := GetSlot(page, e.index)
+		if err != nil {
+			return errors.Wrap(err, "GetSlot failed")
+		}
+		setItemOffset(slot, itemOffset(newUpper))
+	}
+	SetUpperOffset(page, newUpper)
 	return nil
 }
+
+// itemExtent is the extent of the item within page. this is used to compact page
+type itemExtent struct {
+	index  SlotIndex
+	offset itemOffset
+	size   itemSize
+}
+
+// sortExtents sorts the extents by offset descending
+// insertion sort is used because the extents are almost sorted in most cases, and it doesn't allocate
+func sortExtents(extents []itemExtent) {
+	for i := 1; i < len(extents); i++ {
+		e := extents[i]
+		j := i
+		for ; j > 0 && extents[j-1].offset < e.offset; j-- {
+			extents[j] = extents[j-1]
+		}
+		extents[j] = e
+	}
+}
@@ -1,6 +1,7 @@
 package page
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -76,7 +77,7 @@ func TestCompactPage(t *testing.T) {
 	// free up second slot
 	slot, err := GetSlot(page, FirstSlotIndex+1)
 	assert.Nil(t, err)
-	SetUnused(slot)
+	SetUnused(page, slot)
 
 	// compact page
 	err = CompactPage(page)
@@ -96,7 +97,7 @@ func TestCompactPage(t *testing.T) {
 	// free up fourth slot
 	slot, err = GetSlot(page, FirstSlotIndex+3)
 	assert.Nil(t, err)
-	SetUnused(slot)
+	SetUnused(page, slot)
 
 	// compact page one more time
 	err = CompactPage(page)
@@ -107,3 +108,151 @@ func TestCompactPage(t *testing.T) {
 	// add one item and frees up one item, so the free space must not be changed
 	assert.Equal(t, int(expected), got)
 }
+
+// testingCompactablePage returns the page which has n items. the item of slot i is filled with byte i
+// the slots whose index is multiple of 3 are unused
+// if reused, the unused slots are filled again after compaction, so the items are not sorted by slot index
+func testingCompactablePage(t testing.TB, n int, size int, reused bool) PagePtr {
+	page := NewPagePtr()
+	InitializePage(page, 10)
+	for i := 0; i < n; i++ {
+		err := AddItem(page, bytes.Repeat([]byte{byte(i)}, size), InvalidSlotIndex)
+		assert.Nil(t, err)
+	}
+	for i := 0; i < n; i += 3 {
+		slot, err := GetSlot(page, SlotIndex(i))
+		assert.Nil(t, err)
+		SetUnused(page, slot)
+	}
+	if !reused {
+		return page
+	}
+	assert.Nil(t, CompactPage(page))
+	for i := 0; i < n; i += 3 {
+		err := AddItem(page, bytes.Repeat([]byte{byte(i)}, size), SlotIndex(i))
+		assert.Nil(t, err)
+	}
+	for i := 1; i < n; i += 3 {
+		slot, err := GetSlot(page, SlotIndex(i))
+		assert.Nil(t, err)
+		SetUnused(page, slot)
+	}
+	return page
+}
+
+func TestCompactPageKeepsItems(t *testing.T) {
+	for _, reused := range []bool{false, true} {
+		page := testingCompactablePage(t, 100, 7, reused)
+		before := CalculateFreeSpace(page)
+		err := CompactPage(page)
+		assert.Nil(t, err)
+
+		var used int
+		for i := FirstSlotIndex; i <= GetNSlotIndex(page); i++ {
+			slot, err := GetSlot(page, i)
+			assert.Nil(t, err)
+			if IsUnused(slot) {
+				continue
+			}
+			used++
+			item, err := GetItem(page, i)
+			assert.Nil(t, err)
+			assert.Equal(t, bytes.Repeat([]byte{byte(i)}, 7), []byte(item), "slot %d", i)
+		}
+		// the items are packed at the end of page
+		assert.Equal(t, int(GetSpecialSpaceOffset(page))-used*7, int(GetUpperOffset(page)))
+		assert.Less(t, before, CalculateFreeSpace(page))
+
+		// the page already compacted is not changed
+		compacted := *page
+		err = CompactPage(page)
+		assert.Nil(t, err)
+		assert.Equal(t, compacted, *page)
+	}
+}
+
+func TestCompactPageCorrupted(t *testing.T) {
+	page := testingCompactablePage(t, 10, 7, false)
+	slot, err := GetSlot(page, 1)
+	assert.Nil(t, err)
+	// the item beyond special space
+	setItemOffset(slot, itemOffset(GetSpecialSpaceOffset(page)-3))
+	assert.NotNil(t, CompactPage(page))
+
+	// the item below upper
+	setItemOffset(slot, itemOffset(GetUpperOffset(page)-1))
+	assert.NotNil(t, CompactPage(page))
+}
+
+func TestCompactPageNoAllocation(t *testing.T) {
+	template := testingCompactablePage(t, 300, 8, true)
+	page := NewPagePtr()
+	allocs := testing.AllocsPerRun(10, func() {
+		*page = *template
+		if err := CompactPage(page); err != nil {
+			t.Fatal(err)
+		}
+	})
+	assert.Equal(t, float64(0), allocs)
+}
+
+func BenchmarkCompactPage(b *testing.B) {
+	tests := []struct {
+		name   string
+		reused bool
+		// compacted is whether the page has been compacted already
+		compacted bool
+	}{
+		{name: "sorted"},
+		{name: "not sorted", reused: true},
+		{name: "already compacted", compacted: true},
+	}
+	for _, tt := range tests {
+		b.Run(tt.name, func(b *testing.B) {
+			// hundreds of small tuples
+			template := testingCompactablePage(b, 400, 12, tt.reused)
+			if tt.compacted {
+				assert.Nil(b, CompactPage(template))
+			}
+			page := NewPagePtr()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// the copy of page is included in the result
+				*page = *template
+				if err := CompactPage(page); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestCalculateFileOffset(t *testing.T) {
+	tests := []struct {
+		name     string
+		pageID   PageID
+		expected int64
+	}{
+		{
+			name:     "first page",
+			pageID:   FirstPageID,
+			expected: 0,
+		},
+		{
+			name:     "page beyond 4GB",
+			pageID:   PageID(600000),
+			expected: 600000 * PageSize,
+		},
+		{
+			name:     "max page",
+			pageID:   MaxPageID,
+			expected: int64(MaxPageID) * PageSize,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, CalculateFileOffset(tt.pageID))
+		})
+	}
+}
@@ -82,6 +82,18 @@ func getFlag(s SlotPtr) slotFlag {
 	return slotFlag(flag)
 }
 
+// GetSlotItemOffset returns the byte offset of the item which the slot points to
+// this is exported for inspecting page (see /storage/inspect)
+func GetSlotItemOffset(s SlotPtr) int {
+	return int(getItemOffset(s))
+}
+
+// GetSlotItemSize returns the byte size of the item which the slot points to
+// this is exported for inspecting page (see /storage/inspect)
+func GetSlotItemSize(s SlotPtr) int {
+	return int(getItemSize(s))
+}
+
 // IsUnused checks whether the page slot is used
 func IsUnused(s SlotPtr) bool {
 	return getFlag(s) == slotFlagUnused
@@ -89,13 +101,21 @@ func IsUnused(s SlotPtr) bool {
 
 // SetUnused sets flag to unused
 // this is expected to be used mainly when vacuum frees up tuple
-func SetUnused(s SlotPtr) {
+// the slot must be within the page. the page is marked so that AddItem can reuse the slot (see hasFreeSlots)
+func SetUnused(page PagePtr, s SlotPtr) {
+	setHasFreeSlots(page)
 	slot := convertSlot(s)
 	var mask uint32 = (1 << 15) | (1 << 16)
 	newSlot := uint32(slot) & ^mask
 	binary.LittleEndian.PutUint32(s[:], newSlot)
 }
 
+// clearSlot sets flag to unused and clears item offset and size
+// this is used when the item is removed, so the slot doesn't point to the space reused by the other items
+func clearSlot(s SlotPtr) {
+	binary.LittleEndian.PutUint32(s[:], uint32(generateSlot(0, slotFlagUnused, 0)))
+}
+
 // IsNormal checks whether the page slot is normal
 func IsNormal(s SlotPtr) bool {
 	return getFlag(s) == slotFlagNormal
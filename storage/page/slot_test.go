@@ -90,8 +90,11 @@ func TestSlotUnused(t *testing.T) {
 	slot := convertSlotPtr(s)
 	assert.False(t, IsUnused(slot))
 
-	SetUnused(slot)
+	page := NewPagePtr()
+	SetUnused(page, slot)
 	assert.True(t, IsUnused(slot))
+	// the page is marked as it may have unused slot
+	assert.True(t, hasFreeSlotsHint(page))
 }
 
 func TestSlotNormal(t *testing.T) {
@@ -42,10 +42,16 @@ func insertSlot(page PagePtr, si SlotIndex, offset itemOffset, size itemSize) {
 }
 
 // findFreeSlot finds unused slot
+// the slots are scanned only when the page may have unused slot. if the scan finds nothing, the hint is cleared
+// see https://github.com/postgres/postgres/blob/2cd2569c72b8920048e35c31c9be30a6170e1410/src/backend/storage/page/bufpage.c#L194
 func findFreeSlot(page PagePtr) (SlotIndex, error) {
+	if !hasFreeSlotsHint(page) {
+		return InvalidSlotIndex, nil
+	}
 	nidx := GetNSlotIndex(page)
 	// when there is no slot, return invalid
 	if nidx == InvalidSlotIndex {
+		clearHasFreeSlots(page)
 		return InvalidSlotIndex, nil
 	}
 	for i := FirstSlotIndex; i <= nidx; i++ {
@@ -57,6 +63,7 @@ func findFreeSlot(page PagePtr) (SlotIndex, error) {
 			return i, nil
 		}
 	}
+	clearHasFreeSlots(page)
 	return InvalidSlotIndex, nil
 }
 
@@ -85,3 +92,26 @@ func GetNSlotIndex(page PagePtr) SlotIndex {
 	}
 	return si - 1
 }
+
+// TruncateUnusedSlots removes the trailing unused slots by lowering lower offset, and returns the number of slots removed
+// the slots in the middle are not removed because the slot index of the following items must not be changed
+// postgres leaves one slot on heap page even if all slots are unused, but ppdb removes all of them
+// see PageTruncateLinePointerArray() https://github.com/postgres/postgres/blob/2cd2569c72b8920048e35c31c9be30a6170e1410/src/backend/storage/page/bufpage.c
+func TruncateUnusedSlots(page PagePtr) int {
+	nidx := GetNSlotIndex(page)
+	if nidx == InvalidSlotIndex {
+		return 0
+	}
+	var n int
+	for i := int(nidx); i >= int(FirstSlotIndex); i-- {
+		slot, err := GetSlot(page, SlotIndex(i))
+		if err != nil || !IsUnused(slot) {
+			break
+		}
+		n++
+	}
+	if n > 0 {
+		SetLowerOffset(page, GetLowerOffset(page)-offset(n*slotSize))
+	}
+	return n
+}
@@ -18,3 +18,33 @@ func TestGetSlot(t *testing.T) {
 	var expected uint32 = 0x148014
 	assert.Equal(t, expected, uint32(convertSlot(got)))
 }
+
+func TestTruncateUnusedSlots(t *testing.T) {
+	page := NewPagePtr()
+	InitializePage(page, 10)
+	assert.Equal(t, 0, TruncateUnusedSlots(page))
+
+	for i := 0; i < 4; i++ {
+		err := AddItem(page, []byte{byte(i)}, InvalidSlotIndex)
+		assert.Nil(t, err)
+	}
+	// nothing is truncated when the last slot is used
+	err := DeleteItems(page, []SlotIndex{1})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, TruncateUnusedSlots(page))
+	assert.Equal(t, SlotIndex(3), GetNSlotIndex(page))
+
+	// the trailing unused slots are truncated, but the slot in the middle is not
+	err = DeleteItems(page, []SlotIndex{2, 3})
+	assert.Nil(t, err)
+	lower := GetLowerOffset(page)
+	assert.Equal(t, 3, TruncateUnusedSlots(page))
+	assert.Equal(t, FirstSlotIndex, GetNSlotIndex(page))
+	assert.Equal(t, lower-offset(3*slotSize), GetLowerOffset(page))
+
+	// all slots are truncated
+	err = DeleteItems(page, []SlotIndex{0})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, TruncateUnusedSlots(page))
+	assert.Equal(t, InvalidSlotIndex, GetNSlotIndex(page))
+}
@@ -83,6 +83,7 @@ func (bm *bufferManager) getState(txID txid.TxID) (state, error) {
 	// get the id of buffer which stores the page
 	bufID, err := bm.readPage(pageID, false)
 	if err != nil {
+		bm.RUnlock()
 		return stateInProgress, errors.Wrap(err, "readPage failed")
 	}
 
@@ -107,6 +108,7 @@ func (bm *bufferManager) updateState(txID txid.TxID, st state) error {
 
 	bufID, err := bm.readPage(pageID, true)
 	if err != nil {
+		bm.Unlock()
 		return errors.Wrap(err, "readPage failed")
 	}
 
@@ -120,6 +122,7 @@ func (bm *bufferManager) updateState(txID txid.TxID, st state) error {
 
 // readPage seaches buffer. If the page is not found, then fetch it from disk and return the buffer id.
 // buffer lock is expected to be held when this function is called.
+// the buffer lock is held when this function returns even if it fails, so the caller must release it
 // see https://github.com/postgres/postgres/blob/5ca3645cb3fb4b8b359ea560f6a1a230ea59c8bc/src/backend/access/transam/slru.c#L496
 func (bm *bufferManager) readPage(pageID page.PageID, exclusive bool) (bufferID, error) {
 	// actually, in postgres, search page is executed with reader lock
@@ -151,9 +154,7 @@ func (bm *bufferManager) readPage(pageID page.PageID, exclusive bool) (bufferID,
 	} else {
 		bm.RUnlock()
 	}
-	if err := bm.dm.readPage(pageID, page.PagePtr(bm.buffers[victimID])); err != nil {
-		return invalidBufferID, errors.Wrap(err, "readPage failed")
-	}
+	err := bm.dm.readPage(pageID, page.PagePtr(bm.buffers[victimID]))
 
 	// at first, re-acquire the whole buffer lock. then release per buffer lock
 	if exclusive {
@@ -162,6 +163,11 @@ func (bm *bufferManager) readPage(pageID page.PageID, exclusive bool) (bufferID,
 		bm.RLock()
 	}
 	bm.descriptors[victimID].Unlock()
+	if err != nil {
+		// the buffer has been overwritten partially, so it doesn't store any page
+		bm.descriptors[victimID].status = bufferStatusEmpty
+		return invalidBufferID, errors.Wrap(err, "readPage failed")
+	}
 	bm.descriptors[victimID].status = bufferStatusUsed
 	bm.descriptors[victimID].pageID = pageID
 	// update lru count of the buffer
@@ -186,6 +192,7 @@ func (bm *bufferManager) searchPage(pageID page.PageID) bufferID {
 
 // flushPage flushes page into disk
 // the whole buffer exclusive lock is expected to be held when this function is called
+// the whole buffer lock is held when this function returns even if it fails
 // reader lockで良いのでは？
 // see https://github.com/postgres/postgres/blob/5ca3645cb3fb4b8b359ea560f6a1a230ea59c8bc/src/backend/access/transam/slru.c#L540
 func (bm *bufferManager) flushPage(bufID bufferID, exclusive bool) error {
@@ -204,9 +211,7 @@ func (bm *bufferManager) flushPage(bufID bufferID, exclusive bool) error {
 	} else {
 		bm.RUnlock()
 	}
-	if err := bm.dm.writePage(bm.descriptors[bufID].pageID, page.PagePtr(bm.buffers[bufID])); err != nil {
-		return errors.Wrap(err, "dm.writePage failed")
-	}
+	err := bm.dm.writePage(bm.descriptors[bufID].pageID, page.PagePtr(bm.buffers[bufID]))
 	// at first, re-acquire the whole buffer lock. then release per buffer lock
 	if exclusive {
 		bm.Lock()
@@ -214,6 +219,11 @@ func (bm *bufferManager) flushPage(bufID bufferID, exclusive bool) error {
 		bm.RLock()
 	}
 	bm.descriptors[bufID].Unlock()
+	if err != nil {
+		// the buffer is still dirty, so it will be written out again when evicted
+		bm.descriptors[bufID].status = bufferStatusUsed
+		return errors.Wrap(err, "dm.writePage failed")
+	}
 	bm.descriptors[bufID].dirty = false
 	bm.descriptors[bufID].status = bufferStatusUsed
 	return nil
@@ -3,7 +3,9 @@ package clog
 import (
 	"testing"
 
+	"github.com/HayatoShiba/ppdb/storage/disk"
 	"github.com/HayatoShiba/ppdb/transaction/txid"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -42,3 +44,52 @@ func TestGetStateUpdateState(t *testing.T) {
 		})
 	}
 }
+
+func TestBufferManagerWithFault(t *testing.T) {
+	t.Run("when read fails", func(t *testing.T) {
+		fi := disk.NewFaultInjector()
+		bm := newBufferManager(TestingNewFaultDiskManager(fi))
+
+		fi.FailRead(1)
+		_, err := bm.getState(0)
+		assert.Equal(t, disk.ErrInjected, errors.Cause(err))
+		fi.FailRead(1)
+		err = bm.updateState(0, stateCommitted)
+		assert.Equal(t, disk.ErrInjected, errors.Cause(err))
+
+		// the locks have been released, so the page can be read again
+		err = bm.updateState(0, stateCommitted)
+		assert.Nil(t, err)
+		got, err := bm.getState(0)
+		assert.Nil(t, err)
+		assert.Equal(t, stateCommitted, got)
+	})
+	t.Run("when the victim buffer fails to be written out", func(t *testing.T) {
+		fi := disk.NewFaultInjector()
+		bm := newBufferManager(TestingNewFaultDiskManager(fi))
+
+		// make all buffers dirty
+		for i := 0; i < bufferNum; i++ {
+			err := bm.updateState(txid.TxID(i*clogNumPerPage), stateCommitted)
+			assert.Nil(t, err)
+		}
+
+		fi.FailWrite(1)
+		next := txid.TxID(bufferNum * clogNumPerPage)
+		err := bm.updateState(next, stateAborted)
+		assert.Equal(t, disk.ErrInjected, errors.Cause(err))
+		for _, desc := range bm.descriptors {
+			assert.Equal(t, bufferStatusUsed, desc.status)
+			assert.True(t, desc.dirty)
+		}
+
+		err = bm.updateState(next, stateAborted)
+		assert.Nil(t, err)
+		// the state of the evicted page is read from disk
+		for i := 0; i < bufferNum; i++ {
+			got, err := bm.getState(txid.TxID(i * clogNumPerPage))
+			assert.Nil(t, err)
+			assert.Equal(t, stateCommitted, got)
+		}
+	})
+}
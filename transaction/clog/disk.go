@@ -4,45 +4,60 @@ package clog
 import (
 	"io"
 	"os"
+	"path/filepath"
 
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/disk"
+	"github.com/HayatoShiba/ppdb/storage/encryption"
 	"github.com/HayatoShiba/ppdb/storage/page"
 	"github.com/pkg/errors"
 )
 
-var (
-	// the file path of clog
-	dir  = "pg_xact"
-	path = "/clog"
+const (
+	// the file path of clog under data directory
+	dirName  = "pg_xact"
+	fileName = "clog"
 )
 
 // diskManager manages clog file
 type diskManager struct {
-	fd *os.File
+	// st is the clog file. this is storage interface so that the faults can be injected in test
+	st disk.Storage
+	// cipher encrypts the pages. if nil, the pages are not encrypted
+	cipher *encryption.Cipher
 }
 
 // newDiskManager initializes the clog disk manager
-func newDiskManager() (*diskManager, error) {
-	// check whether the directory already exists
-	if _, err := os.Stat(dir); !os.IsExist(err) {
-		if err := os.MkdirAll(dir, 0700); err != nil {
-			return nil, errors.Wrap(err, "os.MkdirAll failed")
-		}
+// the clog file is located under the data directory
+// if the cipher is given, the pages are encrypted
+func newDiskManager(dd *common.DataDir, c *encryption.Cipher) (*diskManager, error) {
+	dir := dd.Join(dirName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "os.MkdirAll failed")
 	}
 
-	// TODO: refactor file path
-	fd, err := os.OpenFile(dir+path, os.O_RDWR|os.O_CREATE, 0700)
+	fd, err := os.OpenFile(filepath.Join(dir, fileName), os.O_RDWR|os.O_CREATE, 0700)
 	if err != nil {
 		return nil, errors.Wrap(err, "os.OpenFile failed")
 	}
 	return &diskManager{
-		fd: fd,
+		st:     disk.NewFileStorage(fd),
+		cipher: c,
 	}, nil
 }
 
 // writePage writes page out to disk
 // see https://github.com/postgres/postgres/blob/5ca3645cb3fb4b8b359ea560f6a1a230ea59c8bc/src/backend/access/transam/slru.c#L757
 func (dm *diskManager) writePage(pageID page.PageID, p page.PagePtr) error {
-	n, err := dm.fd.WriteAt(p[:], page.CalculateFileOffset(pageID))
+	if dm.cipher != nil {
+		// clog page has no lsn, so the whole page is encrypted
+		ep := page.NewPagePtr()
+		if err := dm.cipher.Encrypt(ep[:], p[:], encryption.NewTweak(encryption.KindClog, 0, uint32(pageID))); err != nil {
+			return errors.Wrap(err, "Encrypt failed")
+		}
+		p = ep
+	}
+	n, err := dm.st.WriteAt(p[:], page.CalculateFileOffset(pageID))
 	if err != nil {
 		return errors.Wrap(err, "WriteAt failed")
 	}
@@ -55,29 +70,37 @@ func (dm *diskManager) writePage(pageID page.PageID, p page.PagePtr) error {
 }
 
 // readPage reads page from disk
+// if the page is beyond the end of file, the file is extended up to the page and zero-filled page is returned.
+// note: the page torn at the end of file (by crash during extension) is also overwritten with zero-filled page
 func (dm *diskManager) readPage(pageID page.PageID, p page.PagePtr) error {
-	n, err := dm.fd.ReadAt(p[:], page.CalculateFileOffset(pageID))
-	if err != nil {
-		// if file is EOF, extend it
-		if err == io.EOF {
-			// when the file has already been extend to the max page id, it cannot be extended anymore
-			for {
-				pid, err := dm.extendPage()
-				if err != nil {
-					return errors.Wrap(err, "extendPage failed")
-				}
-				if pid == pageID {
-					// return zero-filled page
-					p = page.NewPagePtr()
-					return nil
-				}
+	n, err := dm.st.ReadAt(p[:], page.CalculateFileOffset(pageID))
+	if err == nil {
+		if n != page.PageSize {
+			return errors.Errorf("ReadAt failed to read the whole page: %d", n)
+		}
+		// the zero-filled page has never been written, so it is not encrypted
+		if dm.cipher != nil && !encryption.IsZero(p[:]) {
+			if err := dm.cipher.Decrypt(p[:], p[:], encryption.NewTweak(encryption.KindClog, 0, uint32(pageID))); err != nil {
+				return errors.Wrap(err, "Decrypt failed")
 			}
 		}
+		return nil
+	}
+	// the error other than EOF (ex: short read in the middle of file) must not extend the file
+	if err != io.EOF {
 		return errors.Wrap(err, "ReadAt failed")
 	}
-	if n != page.PageSize {
-		return errors.Errorf("ReadAt failed to read the whole page: %d", n)
+	for {
+		pid, err := dm.extendPage()
+		if err != nil {
+			return errors.Wrap(err, "extendPage failed")
+		}
+		if pid >= pageID {
+			break
+		}
 	}
+	// return zero-filled page. the page may have been partially read into p
+	*p = [page.PageSize]byte{}
 	return nil
 }
 
@@ -102,11 +125,10 @@ func (dm *diskManager) extendPage() (page.PageID, error) {
 
 // GetNPageID returns the last PageID of the file
 func (dm *diskManager) getNPageID() (page.PageID, error) {
-	fi, err := dm.fd.Stat()
+	size, err := dm.st.Size()
 	if err != nil {
-		return page.InvalidPageID, errors.Wrap(err, "f.Stat failed")
+		return page.InvalidPageID, errors.Wrap(err, "Size failed")
 	}
-	size := fi.Size()
 	if size == 0 {
 		return page.InvalidPageID, nil
 	}
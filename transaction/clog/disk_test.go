@@ -2,9 +2,15 @@ package clog
 
 import (
 	"bytes"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/disk"
+	"github.com/HayatoShiba/ppdb/storage/encryption"
 	"github.com/HayatoShiba/ppdb/storage/page"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -23,3 +29,75 @@ func TestWriteReadPage(t *testing.T) {
 	assert.Nil(t, err)
 	assert.True(t, bytes.Equal(got[:], expected[:]))
 }
+
+func TestEncryptedWriteReadPage(t *testing.T) {
+	c, err := encryption.NewCipher(bytes.Repeat([]byte{'k'}, 64))
+	assert.Nil(t, err)
+	dd := common.TestingNewDataDir(t)
+	dm, err := newDiskManager(dd, c)
+	assert.Nil(t, err)
+	defer dm.st.Close()
+
+	expected := &[page.PageSize]byte{'g', 'a'}
+	err = dm.writePage(page.PageID(1), expected)
+	assert.Nil(t, err)
+	got := page.NewPagePtr()
+	err = dm.readPage(page.PageID(1), got)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(expected[:], got[:]))
+	// the page before it is the hole of file
+	err = dm.readPage(page.FirstPageID, got)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(page.NewPagePtr()[:], got[:]))
+
+	// the whole page is encrypted on disk
+	raw, err := os.ReadFile(filepath.Join(dd.Join(dirName), fileName))
+	assert.Nil(t, err)
+	assert.False(t, bytes.Equal(expected[:], raw[page.PageSize:2*page.PageSize]))
+	assert.NotEqual(t, expected[0], raw[page.PageSize])
+}
+
+func TestReadPageWithFault(t *testing.T) {
+	t.Run("when the page is beyond the end of file", func(t *testing.T) {
+		dm := TestingNewFaultDiskManager(disk.NewFaultInjector())
+
+		// the file is extended up to the page and zero-filled page is returned
+		p := &[page.PageSize]byte{'g', 'a'}
+		err := dm.readPage(page.PageID(3), p)
+		assert.Nil(t, err)
+		assert.True(t, bytes.Equal(page.NewPagePtr()[:], p[:]))
+		npid, err := dm.getNPageID()
+		assert.Nil(t, err)
+		assert.Equal(t, page.PageID(3), npid)
+	})
+	t.Run("when read is short", func(t *testing.T) {
+		fi := disk.NewFaultInjector()
+		dm := TestingNewFaultDiskManager(fi)
+		err := dm.writePage(page.FirstPageID, &[page.PageSize]byte{'g', 'a'})
+		assert.Nil(t, err)
+
+		// the short read must not be treated as the end of file
+		fi.ShortRead(1, 10)
+		err = dm.readPage(page.FirstPageID, page.NewPagePtr())
+		assert.NotNil(t, err)
+		npid, err := dm.getNPageID()
+		assert.Nil(t, err)
+		assert.Equal(t, page.FirstPageID, npid)
+
+		p := page.NewPagePtr()
+		err = dm.readPage(page.FirstPageID, p)
+		assert.Nil(t, err)
+		assert.Equal(t, byte('g'), p[0])
+	})
+	t.Run("when extension fails", func(t *testing.T) {
+		fi := disk.NewFaultInjector()
+		dm := TestingNewFaultDiskManager(fi)
+
+		fi.FailWrite(1)
+		err := dm.readPage(page.FirstPageID, page.NewPagePtr())
+		assert.Equal(t, disk.ErrInjected, errors.Cause(err))
+		npid, err := dm.getNPageID()
+		assert.Nil(t, err)
+		assert.Equal(t, page.InvalidPageID, npid)
+	})
+}
@@ -39,6 +39,8 @@ see https://github.com/postgres/postgres/blob/75f49221c22286104f032827359783aa5f
 package clog
 
 import (
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/encryption"
 	"github.com/HayatoShiba/ppdb/transaction/txid"
 	"github.com/pkg/errors"
 )
@@ -55,9 +57,21 @@ type ManagerImpl struct {
 	*bufferManager
 }
 
+// Options is options of clog manager
+type Options struct {
+	// Cipher encrypts the clog pages written out. if nil, the pages are not encrypted
+	Cipher *encryption.Cipher
+}
+
 // NewManager initializes manager
-func NewManager() (Manager, error) {
-	dm, err := newDiskManager()
+// the clog file is located under the data directory
+func NewManager(dd *common.DataDir) (Manager, error) {
+	return NewManagerWithOptions(dd, Options{})
+}
+
+// NewManagerWithOptions initializes manager with the options
+func NewManagerWithOptions(dd *common.DataDir, opts Options) (Manager, error) {
+	dm, err := newDiskManager(dd, opts.Cipher)
 	if err != nil {
 		return nil, errors.Wrap(err, "newDiskManager failed")
 	}
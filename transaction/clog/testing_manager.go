@@ -3,12 +3,20 @@ package clog
 import (
 	"testing"
 
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/storage/disk"
 	"github.com/pkg/errors"
 )
 
 func TestingNewDiskManager(t *testing.T) (*diskManager, error) {
-	dir = t.TempDir()
-	return newDiskManager()
+	dm, err := newDiskManager(common.TestingNewDataDir(t), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "newDiskManager failed")
+	}
+	t.Cleanup(func() {
+		dm.st.Close()
+	})
+	return dm, nil
 }
 
 func TestingNewBufferManager(t *testing.T) (*bufferManager, error) {
@@ -19,6 +27,13 @@ func TestingNewBufferManager(t *testing.T) (*bufferManager, error) {
 	return newBufferManager(dm), nil
 }
 
+// TestingNewFaultDiskManager initializes disk manager on the buffer storage which injects the faults scheduled by the injector
+func TestingNewFaultDiskManager(fi *disk.FaultInjector) *diskManager {
+	return &diskManager{
+		st: disk.NewFaultStorage(disk.NewBufferStorage(0), fi),
+	}
+}
+
 // TestingNewManager initializes manager
 func TestingNewManager(t *testing.T) (Manager, error) {
 	bm, err := TestingNewBufferManager(t)
@@ -32,20 +32,32 @@ continue the execution (probably). (TODO: see heap update function for understan
 package transaction
 
 import (
+	"sync"
+
+	"github.com/HayatoShiba/ppdb/common"
 	"github.com/HayatoShiba/ppdb/transaction/clog"
 	"github.com/HayatoShiba/ppdb/transaction/txid"
+	"github.com/pkg/errors"
 )
 
 type Manager struct {
 	// if it isn't necessary to be exported, fix this later.
 	Tm *txid.Manager
 	Cm clog.Manager
+	// Rs creates/removes relation files. see storage.go
+	Rs RelationStorage
+
+	// mu protects leftovers
+	mu sync.Mutex
+	// leftovers is the relation files which failed to be removed by pending deletes
+	leftovers []common.Relation
 }
 
-func NewManager(tm *txid.Manager, cm clog.Manager) *Manager {
+func NewManager(tm *txid.Manager, cm clog.Manager, rs RelationStorage) *Manager {
 	return &Manager{
 		Tm: tm,
 		Cm: cm,
+		Rs: rs,
 	}
 }
 
@@ -57,15 +69,27 @@ func (m *Manager) Begin() *Tx {
 }
 
 // Commit commits transaction
-func (m *Manager) Commit(tx Tx) {
+// after the commit is recorded, the relation files dropped in the transaction are removed.
+// the removal doesn't fail the commit. the files which fail to be removed are recorded as leftover (see storage.go)
+func (m *Manager) Commit(tx *Tx) error {
 	// store transaction state to clog
-	m.Cm.SetStateCommitted(tx.ID())
+	if err := m.Cm.SetStateCommitted(tx.ID()); err != nil {
+		return errors.Wrap(err, "SetStateCommitted failed")
+	}
 	tx.SetState(StateCommitted)
+	m.doPendingDeletes(tx, true)
+	return nil
 }
 
 // Abort aborts transaction
-func (m *Manager) Abort(tx Tx) {
+// after the abort is recorded, the relation files created in the transaction are removed.
+// same as Commit(), the removal doesn't fail the abort
+func (m *Manager) Abort(tx *Tx) error {
 	// store transaction state to clog
-	m.Cm.SetStateAborted(tx.ID())
+	if err := m.Cm.SetStateAborted(tx.ID()); err != nil {
+		return errors.Wrap(err, "SetStateAborted failed")
+	}
 	tx.SetState(StateAborted)
+	m.doPendingDeletes(tx, false)
+	return nil
 }
@@ -0,0 +1,93 @@
+/*
+Pending deletes of relation files.
+
+The relation file has to be created/removed consistently with the transaction outcome:
+  - the file created in the transaction must be removed when the transaction is aborted
+  - the file dropped in the transaction must remain until the transaction is committed,
+    because the transaction may be aborted and then the relation is still alive
+
+So the removal is deferred. the files to be removed are registered on the transaction (pending deletes)
+with when they should be removed (at commit or at abort), and they are removed at the end of the transaction.
+  - CreateRelation(): create the file, and register it to be removed at abort
+  - DropRelation(): register the file to be removed at commit. nothing is removed here
+  - Commit()/Abort(): remove the files registered for the outcome
+
+the removal happens after the outcome is recorded to clog, so it cannot make the transaction fail.
+when the removal fails, the file is just left and recorded as leftover (postgres reports it as WARNING).
+the leftover files can be listed with LeftoverRelations() and removed later.
+
+ppdb doesn't implement wal yet, so the pending deletes are lost when crash happens before the end of transaction.
+postgres records them in the commit/abort wal record and removes the files on recovery.
+see https://github.com/postgres/postgres/blob/20432f8731404d2cef2a155144aca5ab3ae98e95/src/backend/catalog/storage.c
+*/
+package transaction
+
+import (
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/pkg/errors"
+)
+
+// RelationStorage creates/removes relation files. buffer.Manager implements this
+type RelationStorage interface {
+	CreateRelation(rel common.Relation) error
+	UnlinkRelation(rel common.Relation) error
+}
+
+// pendingDelete is relation file to be removed at the end of transaction
+// see https://github.com/postgres/postgres/blob/20432f8731404d2cef2a155144aca5ab3ae98e95/src/backend/catalog/storage.c
+type pendingDelete struct {
+	rel common.Relation
+	// atCommit is true if the file is removed at commit, false if removed at abort
+	atCommit bool
+}
+
+// CreateRelation creates the relation file in the transaction
+// if the transaction is aborted, the file is removed
+// see RelationCreateStorage() https://github.com/postgres/postgres/blob/20432f8731404d2cef2a155144aca5ab3ae98e95/src/backend/catalog/storage.c
+func (m *Manager) CreateRelation(tx *Tx, rel common.Relation) error {
+	if IsCompleted(tx.State()) {
+		return errors.Errorf("the transaction has been completed: %d", tx.ID())
+	}
+	if err := m.Rs.CreateRelation(rel); err != nil {
+		return errors.Wrap(err, "Rs.CreateRelation failed")
+	}
+	tx.pendingDeletes = append(tx.pendingDeletes, pendingDelete{rel: rel, atCommit: false})
+	return nil
+}
+
+// DropRelation drops the relation file in the transaction
+// the file is not removed until the transaction is committed
+// see RelationDropStorage() https://github.com/postgres/postgres/blob/20432f8731404d2cef2a155144aca5ab3ae98e95/src/backend/catalog/storage.c
+func (m *Manager) DropRelation(tx *Tx, rel common.Relation) error {
+	if IsCompleted(tx.State()) {
+		return errors.Errorf("the transaction has been completed: %d", tx.ID())
+	}
+	tx.pendingDeletes = append(tx.pendingDeletes, pendingDelete{rel: rel, atCommit: true})
+	return nil
+}
+
+// doPendingDeletes removes the relation files registered for the transaction outcome
+// the pending deletes for the other outcome are just discarded
+// even if the removal fails, the following files are removed. the files which fail to be removed are recorded as leftover
+// see smgrDoPendingDeletes() https://github.com/postgres/postgres/blob/20432f8731404d2cef2a155144aca5ab3ae98e95/src/backend/catalog/storage.c
+func (m *Manager) doPendingDeletes(tx *Tx, isCommit bool) {
+	for _, pd := range tx.pendingDeletes {
+		if pd.atCommit != isCommit {
+			continue
+		}
+		if err := m.Rs.UnlinkRelation(pd.rel); err != nil {
+			m.mu.Lock()
+			m.leftovers = append(m.leftovers, pd.rel)
+			m.mu.Unlock()
+		}
+	}
+	tx.pendingDeletes = nil
+}
+
+// LeftoverRelations returns the relation files which failed to be removed at the end of transaction
+// the files are not used by anyone, so they can be removed with UnlinkRelation()
+func (m *Manager) LeftoverRelations() []common.Relation {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]common.Relation(nil), m.leftovers...)
+}
@@ -0,0 +1,128 @@
+package transaction
+
+import (
+	"testing"
+
+	"github.com/HayatoShiba/ppdb/common"
+	"github.com/HayatoShiba/ppdb/transaction/clog"
+	"github.com/HayatoShiba/ppdb/transaction/txid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// testingStorage records the relation files which exist
+type testingStorage struct {
+	files map[common.Relation]bool
+	// failUnlink makes UnlinkRelation fail
+	failUnlink bool
+}
+
+func (ts *testingStorage) CreateRelation(rel common.Relation) error {
+	if ts.files[rel] {
+		return errors.New("already exists")
+	}
+	ts.files[rel] = true
+	return nil
+}
+
+func (ts *testingStorage) UnlinkRelation(rel common.Relation) error {
+	if ts.failUnlink {
+		return errors.New("unlink failed")
+	}
+	delete(ts.files, rel)
+	return nil
+}
+
+func testingNewManager(t *testing.T) (*Manager, *testingStorage) {
+	cm, err := clog.TestingNewManager(t)
+	assert.Nil(t, err)
+	ts := &testingStorage{files: make(map[common.Relation]bool)}
+	return NewManager(txid.NewManager(), cm, ts), ts
+}
+
+func TestPendingDeletes(t *testing.T) {
+	t.Run("created relation is removed at abort", func(t *testing.T) {
+		m, ts := testingNewManager(t)
+		tx := m.Begin()
+		err := m.CreateRelation(tx, common.Relation{RelFileNumber: 1})
+		assert.Nil(t, err)
+		assert.True(t, ts.files[common.Relation{RelFileNumber: 1}])
+
+		err = m.Abort(tx)
+		assert.Nil(t, err)
+		assert.Equal(t, State(StateAborted), tx.State())
+		assert.False(t, ts.files[common.Relation{RelFileNumber: 1}])
+	})
+	t.Run("created relation remains at commit", func(t *testing.T) {
+		m, ts := testingNewManager(t)
+		tx := m.Begin()
+		err := m.CreateRelation(tx, common.Relation{RelFileNumber: 1})
+		assert.Nil(t, err)
+
+		err = m.Commit(tx)
+		assert.Nil(t, err)
+		assert.Equal(t, State(StateCommitted), tx.State())
+		assert.True(t, ts.files[common.Relation{RelFileNumber: 1}])
+	})
+	t.Run("dropped relation is removed only at commit", func(t *testing.T) {
+		m, ts := testingNewManager(t)
+		ts.files[common.Relation{RelFileNumber: 1}] = true
+
+		tx := m.Begin()
+		err := m.DropRelation(tx, common.Relation{RelFileNumber: 1})
+		assert.Nil(t, err)
+		assert.True(t, ts.files[common.Relation{RelFileNumber: 1}])
+		err = m.Abort(tx)
+		assert.Nil(t, err)
+		assert.True(t, ts.files[common.Relation{RelFileNumber: 1}])
+
+		tx = m.Begin()
+		err = m.DropRelation(tx, common.Relation{RelFileNumber: 1})
+		assert.Nil(t, err)
+		err = m.Commit(tx)
+		assert.Nil(t, err)
+		assert.False(t, ts.files[common.Relation{RelFileNumber: 1}])
+	})
+	t.Run("relation created and dropped in the same transaction", func(t *testing.T) {
+		m, ts := testingNewManager(t)
+		tx := m.Begin()
+		err := m.CreateRelation(tx, common.Relation{RelFileNumber: 1})
+		assert.Nil(t, err)
+		err = m.DropRelation(tx, common.Relation{RelFileNumber: 1})
+		assert.Nil(t, err)
+
+		err = m.Commit(tx)
+		assert.Nil(t, err)
+		assert.False(t, ts.files[common.Relation{RelFileNumber: 1}])
+	})
+	t.Run("removal failure doesn't fail commit", func(t *testing.T) {
+		m, ts := testingNewManager(t)
+		ts.files[common.Relation{RelFileNumber: 1}] = true
+		ts.failUnlink = true
+
+		tx := m.Begin()
+		err := m.DropRelation(tx, common.Relation{RelFileNumber: 1})
+		assert.Nil(t, err)
+		err = m.Commit(tx)
+		assert.Nil(t, err)
+		assert.Equal(t, State(StateCommitted), tx.State())
+		committed, err := m.Cm.IsTxCommitted(tx.ID())
+		assert.Nil(t, err)
+		assert.True(t, committed)
+
+		// the file is left and recorded
+		assert.True(t, ts.files[common.Relation{RelFileNumber: 1}])
+		assert.Equal(t, []common.Relation{{RelFileNumber: 1}}, m.LeftoverRelations())
+	})
+	t.Run("completed transaction", func(t *testing.T) {
+		m, _ := testingNewManager(t)
+		tx := m.Begin()
+		err := m.Commit(tx)
+		assert.Nil(t, err)
+
+		err = m.CreateRelation(tx, common.Relation{RelFileNumber: 1})
+		assert.NotNil(t, err)
+		err = m.DropRelation(tx, common.Relation{RelFileNumber: 1})
+		assert.NotNil(t, err)
+	})
+}
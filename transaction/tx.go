@@ -6,6 +6,8 @@ import "github.com/HayatoShiba/ppdb/transaction/txid"
 type Tx struct {
 	id    txid.TxID
 	state State
+	// pendingDeletes is the relation files removed at the end of transaction. see storage.go
+	pendingDeletes []pendingDelete
 }
 
 // NewTransaction initializes transaction